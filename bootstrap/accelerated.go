@@ -0,0 +1,204 @@
+package bootstrap
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
+	kb "github.com/libp2p/go-libp2p-kbucket"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// acceleratedRetry 是遍历没有找到节点时重试的间隔, 刚启动时路由表可能还是空的
+const acceleratedRetry = time.Minute
+
+// acceleratedConfig 是加速DHT的设置. 启用后定期遍历整个DHT, 在内存中保存所有可达的节点
+type acceleratedConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Interval 是遍历的间隔
+	Interval time.Duration `yaml:"interval"`
+	// MaxPeers 是每次遍历最多查询的节点数量, 应大于网络中DHT服务端的数量
+	MaxPeers int `yaml:"max_peers"`
+}
+
+// validate 检查加速DHT设置
+func (c acceleratedConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Interval <= 0 || c.MaxPeers <= 0 {
+		return fmt.Errorf("加速DHT设置错误: interval %s, max_peers %d", c.Interval, c.MaxPeers)
+	}
+	return nil
+}
+
+// fullRoutingTable 是整个DHT网络的路由表, 与go-libp2p-kad-dht的fullrt类似.
+// 查找时直接请求离目标最近的节点, 不需要逐跳查询; 遍历发现的节点同时尝试加入DHT路由表,
+// 填满离本节点较近的桶, 回应其他节点的查询时更完整
+type fullRoutingTable struct {
+	h        host.Host
+	idht     *dht.IpfsDHT
+	protocol protocol.ID
+	cfg      acceleratedConfig
+	crawler  *crawler
+
+	mu       sync.RWMutex
+	peers    map[peer.ID]peer.AddrInfo
+	updated  time.Time
+	duration time.Duration
+	added    int
+}
+
+func newFullRoutingTable(h host.Host, idht *dht.IpfsDHT, protocolID protocol.ID, cfg acceleratedConfig) *fullRoutingTable {
+	// 只使用遍历, 不保存快照
+	c := newCrawler(h, idht, protocolID, crawlConfig{Interval: cfg.Interval, MaxPeers: cfg.MaxPeers}, "")
+	return &fullRoutingTable{h: h, idht: idht, protocol: protocolID, cfg: cfg, crawler: c, peers: make(map[peer.ID]peer.AddrInfo)}
+}
+
+// run 立即遍历一次, 之后每Interval遍历一次
+func (rt *fullRoutingTable) run(ctx context.Context) {
+	for {
+		delay := rt.cfg.Interval
+		if rt.refresh(ctx) == 0 {
+			delay = acceleratedRetry
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// refresh 遍历DHT并替换路由表, 返回可达的节点数量. 没有找到节点时保留原来的路由表
+func (rt *fullRoutingTable) refresh(ctx context.Context) int {
+	snapshot := rt.crawler.crawl(ctx)
+	if ctx.Err() != nil {
+		return 0
+	}
+	peers := make(map[peer.ID]peer.AddrInfo, snapshot.Reachable)
+	for _, p := range snapshot.Peers {
+		if !p.Reachable {
+			continue
+		}
+		id, e := peer.Decode(p.ID)
+		if e != nil {
+			continue
+		}
+		info := peer.AddrInfo{ID: id}
+		for _, s := range p.Addrs {
+			if addr, e := ma.NewMultiaddr(s); e == nil {
+				info.Addrs = append(info.Addrs, addr)
+			}
+		}
+		peers[id] = info
+	}
+	if len(peers) == 0 {
+		return 0
+	}
+	// 加入DHT路由表的节点需要地址, 有效期到下次遍历
+	added := 0
+	for id, info := range peers {
+		rt.h.Peerstore().AddAddrs(id, info.Addrs, rt.cfg.Interval*2)
+		if ok, _ := rt.idht.RoutingTable().TryAddPeer(id, false, true); ok {
+			added++
+		}
+	}
+	duration := time.Since(snapshot.Started)
+	rt.mu.Lock()
+	rt.peers, rt.updated, rt.duration, rt.added = peers, time.Now(), duration, added
+	rt.mu.Unlock()
+	logger.Infow("加速DHT遍历完成", "reachable", len(peers), "added", added, "duration", duration.Truncate(time.Second))
+	return len(peers)
+}
+
+// ready 判断是否已经完成遍历
+func (rt *fullRoutingTable) ready() bool {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+	return len(rt.peers) > 0
+}
+
+// findPeer 返回路由表中节点的地址
+func (rt *fullRoutingTable) findPeer(id peer.ID) (peer.AddrInfo, bool) {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+	info, ok := rt.peers[id]
+	return info, ok
+}
+
+// closest 返回路由表中离key最近的count个节点
+func (rt *fullRoutingTable) closest(key []byte, count int) []peer.AddrInfo {
+	rt.mu.RLock()
+	ids := make([]peer.ID, 0, len(rt.peers))
+	for id := range rt.peers {
+		ids = append(ids, id)
+	}
+	rt.mu.RUnlock()
+	ids = kb.SortClosestPeers(ids, kb.ConvertKey(string(key)))
+	if len(ids) > count {
+		ids = ids[:count]
+	}
+	infos := make([]peer.AddrInfo, 0, len(ids))
+	for _, id := range ids {
+		info, _ := rt.findPeer(id)
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// findProviders 同时向离CID最近的k个节点请求提供者, 找到limit个或所有节点回应后返回
+func (rt *fullRoutingTable) findProviders(ctx context.Context, key cid.Cid, limit int) []peer.AddrInfo {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	hash := key.Hash()
+	closest := rt.closest(hash, defaultBucketSize)
+	found := make(chan []*peer.AddrInfo, len(closest))
+	for _, info := range closest {
+		go func(info peer.AddrInfo) {
+			rt.h.Peerstore().AddAddrs(info.ID, info.Addrs, connectTimeout)
+			qctx, qcancel := context.WithTimeout(ctx, connectTimeout)
+			defer qcancel()
+			resp, e := sendDHTMessage(qctx, rt.h, rt.protocol, info.ID, pb.NewMessage(pb.Message_GET_PROVIDERS, hash, 0))
+			if e != nil {
+				found <- nil
+				return
+			}
+			found <- pb.PBPeersToPeerInfos(resp.ProviderPeers)
+		}(info)
+	}
+	seen := make(map[peer.ID]bool)
+	var providers []peer.AddrInfo
+	for range closest {
+		for _, p := range <-found {
+			if seen[p.ID] {
+				continue
+			}
+			seen[p.ID] = true
+			providers = append(providers, *p)
+			if len(providers) >= limit {
+				return providers
+			}
+		}
+	}
+	return providers
+}
+
+// status 返回路由表的节点数量和最近一次遍历的时间
+func (rt *fullRoutingTable) status() interface{} {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+	return map[string]interface{}{
+		"peers":    len(rt.peers),
+		"added":    rt.added,
+		"updated":  rt.updated,
+		"duration": rt.duration.Truncate(time.Second).String(),
+	}
+}
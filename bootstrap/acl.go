@@ -0,0 +1,300 @@
+package bootstrap
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"gopkg.in/yaml.v2"
+)
+
+// defaultACLFile 是数据目录中默认的访问控制列表文件
+const defaultACLFile = "acl.yaml"
+
+// aclPath 返回访问控制列表文件路径, 未设置时使用数据目录下的acl.yaml
+func aclPath(cfg *Config, dir string) string {
+	if cfg.ACLFile != "" {
+		return cfg.ACLFile
+	}
+	return filepath.Join(dir, defaultACLFile)
+}
+
+// aclLists 是访问控制列表文件的内容, 条目是节点ID, IP或CIDR
+type aclLists struct {
+	Allow []string `yaml:"allow" json:"allow"`
+	Deny  []string `yaml:"deny" json:"deny"`
+}
+
+// aclRules 是解析后的列表
+type aclRules struct {
+	allowPeers map[peer.ID]bool
+	denyPeers  map[peer.ID]bool
+	allowNets  []*net.IPNet
+	denyNets   []*net.IPNet
+}
+
+// parseACLEntry 解析一个条目: 节点ID, IP或CIDR
+func parseACLEntry(entry string) (peer.ID, *net.IPNet, error) {
+	if strings.Contains(entry, "/") {
+		_, ipNet, e := net.ParseCIDR(entry)
+		if e != nil {
+			return "", nil, fmt.Errorf("CIDR错误 %s: %w", entry, e)
+		}
+		return "", ipNet, nil
+	}
+	if ip := net.ParseIP(entry); ip != nil {
+		bits := 128
+		if ip.To4() != nil {
+			ip = ip.To4()
+			bits = 32
+		}
+		return "", &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	id, e := peer.Decode(entry)
+	if e != nil {
+		return "", nil, fmt.Errorf("不是节点ID, IP或CIDR: %s", entry)
+	}
+	return id, nil, nil
+}
+
+// compile 解析列表
+func (l aclLists) compile() (*aclRules, error) {
+	rules := &aclRules{allowPeers: make(map[peer.ID]bool), denyPeers: make(map[peer.ID]bool)}
+	for _, list := range []struct {
+		entries []string
+		peers   map[peer.ID]bool
+		nets    *[]*net.IPNet
+	}{
+		{l.Allow, rules.allowPeers, &rules.allowNets},
+		{l.Deny, rules.denyPeers, &rules.denyNets},
+	} {
+		for _, entry := range list.entries {
+			id, ipNet, e := parseACLEntry(entry)
+			if e != nil {
+				return nil, e
+			}
+			if ipNet != nil {
+				*list.nets = append(*list.nets, ipNet)
+			} else {
+				list.peers[id] = true
+			}
+		}
+	}
+	return rules, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// accessList 按节点ID, IP和CIDR允许或拒绝连接.
+// 在允许列表中的节点或地址总是允许, 否则在拒绝列表中时拒绝, 都不在时允许.
+// 只允许列表中的节点时, 在拒绝列表中加入0.0.0.0/0和::/0.
+type accessList struct {
+	path string
+
+	mu    sync.RWMutex
+	lists aclLists
+	rules *aclRules
+
+	rejected uint64
+}
+
+// loadAccessList 读取访问控制列表文件, 文件不存在时列表为空
+func loadAccessList(path string) (*accessList, error) {
+	a := &accessList{path: path}
+	if e := a.load(); e != nil {
+		return nil, e
+	}
+	return a, nil
+}
+
+// load 重新读取文件
+func (a *accessList) load() error {
+	var lists aclLists
+	b, e := ioutil.ReadFile(a.path)
+	if e != nil && !os.IsNotExist(e) {
+		return e
+	}
+	if e == nil {
+		if e := yaml.UnmarshalStrict(b, &lists); e != nil {
+			return fmt.Errorf("访问控制列表格式错误 %s: %w", a.path, e)
+		}
+	}
+	rules, e := lists.compile()
+	if e != nil {
+		return e
+	}
+	a.mu.Lock()
+	a.lists, a.rules = lists, rules
+	a.mu.Unlock()
+	return nil
+}
+
+// Lists 返回当前的列表
+func (a *accessList) Lists() aclLists {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return aclLists{Allow: append([]string(nil), a.lists.Allow...), Deny: append([]string(nil), a.lists.Deny...)}
+}
+
+// Update 在列表中加入和删除条目并保存到文件, list是allow或deny
+func (a *accessList) Update(list string, add, remove []string) (aclLists, error) {
+	for _, entry := range add {
+		if _, _, e := parseACLEntry(entry); e != nil {
+			return aclLists{}, e
+		}
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	lists := aclLists{Allow: a.lists.Allow, Deny: a.lists.Deny}
+	var target *[]string
+	switch list {
+	case "allow":
+		target = &lists.Allow
+	case "deny":
+		target = &lists.Deny
+	default:
+		return aclLists{}, fmt.Errorf("列表只能是allow或deny: %s", list)
+	}
+	*target = updateEntries(*target, add, remove)
+	rules, e := lists.compile()
+	if e != nil {
+		return aclLists{}, e
+	}
+	b, e := yaml.Marshal(&lists)
+	if e != nil {
+		return aclLists{}, e
+	}
+	if e := os.MkdirAll(filepath.Dir(a.path), 0700); e != nil {
+		return aclLists{}, e
+	}
+	if e := ioutil.WriteFile(a.path, b, 0600); e != nil {
+		return aclLists{}, e
+	}
+	a.lists, a.rules = lists, rules
+	return lists, nil
+}
+
+// updateEntries 返回加入add并删除remove后的条目, 去重并排序
+func updateEntries(entries, add, remove []string) []string {
+	set := make(map[string]bool, len(entries)+len(add))
+	for _, entry := range entries {
+		set[entry] = true
+	}
+	for _, entry := range add {
+		set[entry] = true
+	}
+	for _, entry := range remove {
+		delete(set, entry)
+	}
+	out := make([]string, 0, len(set))
+	for entry := range set {
+		out = append(out, entry)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// allowPeer 判断节点ID, 不在任何列表中时返回ok为false
+func (r *aclRules) allowPeer(p peer.ID) (allowed, ok bool) {
+	if r.allowPeers[p] {
+		return true, true
+	}
+	if r.denyPeers[p] {
+		return false, true
+	}
+	return false, false
+}
+
+// allowIP 判断IP, 不在任何列表中时返回ok为false
+func (r *aclRules) allowIP(ip net.IP) (allowed, ok bool) {
+	if ip == nil {
+		return false, false
+	}
+	if containsIP(r.allowNets, ip) {
+		return true, true
+	}
+	if containsIP(r.denyNets, ip) {
+		return false, true
+	}
+	return false, false
+}
+
+// allow 判断节点ID和地址, p为空表示还不知道节点ID(如刚接受连接时)
+func (a *accessList) allow(p peer.ID, addr ma.Multiaddr) bool {
+	a.mu.RLock()
+	rules := a.rules
+	a.mu.RUnlock()
+	if p != "" {
+		if allowed, ok := rules.allowPeer(p); ok {
+			return a.count(allowed)
+		}
+	}
+	var ip net.IP
+	if addr != nil {
+		ip, _ = manet.ToIP(addr)
+	}
+	if allowed, ok := rules.allowIP(ip); ok {
+		// 还不知道节点ID时, 被拒绝的地址可能属于允许列表中的节点, 等加密握手后再判断
+		if !allowed && p == "" && len(rules.allowPeers) > 0 {
+			return true
+		}
+		return a.count(allowed)
+	}
+	return true
+}
+
+// allowedIP 判断IP是否在允许列表中
+func (a *accessList) allowedIP(ip net.IP) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return containsIP(a.rules.allowNets, ip)
+}
+
+func (a *accessList) count(allowed bool) bool {
+	if !allowed {
+		atomic.AddUint64(&a.rejected, 1)
+	}
+	return allowed
+}
+
+// closeDenied 关闭被拒绝的已有连接, 返回关闭的连接数量
+func (a *accessList) closeDenied(n network.Network) int {
+	closed := 0
+	for _, c := range n.Conns() {
+		if !a.allow(c.RemotePeer(), c.RemoteMultiaddr()) {
+			_ = c.Close()
+			closed++
+		}
+	}
+	return closed
+}
+
+// status 返回列表大小和拒绝次数
+func (a *accessList) status() interface{} {
+	a.mu.RLock()
+	allow, deny := len(a.lists.Allow), len(a.lists.Deny)
+	a.mu.RUnlock()
+	return map[string]interface{}{
+		"file":     a.path,
+		"allow":    allow,
+		"deny":     deny,
+		"rejected": atomic.LoadUint64(&a.rejected),
+	}
+}
@@ -0,0 +1,16 @@
+package bootstrap
+
+import (
+	basichost "github.com/libp2p/go-libp2p/p2p/host/basic"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// addrsFactories 依次处理节点宣告的地址, 多个功能都修改地址时组合使用
+type addrsFactories []basichost.AddrsFactory
+
+func (fs addrsFactories) apply(addrs []ma.Multiaddr) []ma.Multiaddr {
+	for _, f := range fs {
+		addrs = f(addrs)
+	}
+	return addrs
+}
@@ -0,0 +1,514 @@
+package bootstrap
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	ma "github.com/multiformats/go-multiaddr"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// defaultAdminSocket 是数据目录中默认的管理接口socket
+const defaultAdminSocket = "admin.sock"
+
+// adminRequestMax 是管理接口请求体的大小上限
+const adminRequestMax = 4096
+
+// 管理接口读取请求和写入响应的超时, 写入超时要留出DHT查询的时间
+const (
+	adminReadHeaderTimeout = time.Second * 10
+	adminReadTimeout       = time.Second * 30
+	adminWriteTimeout      = dhtQueryTimeout + time.Second*10
+)
+
+// adminSocketPath 返回unix:<路径>地址的socket路径, 相对路径在数据目录中. 不是unix地址时返回空
+func adminSocketPath(addr, dir string) string {
+	if !strings.HasPrefix(addr, "unix:") {
+		return ""
+	}
+	path := strings.TrimPrefix(addr, "unix:")
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	return path
+}
+
+// adminListen 监听管理接口地址: unix:<路径>(相对路径在数据目录中)或主机:端口.
+// 没有同时启用认证和TLS时主机只能是本机地址
+func adminListen(addr, dir string, c adminConfig) (net.Listener, string, error) {
+	if path := adminSocketPath(addr, dir); path != "" {
+		// 删除上次运行留下的socket
+		if e := os.Remove(path); e != nil && !os.IsNotExist(e) {
+			return nil, "", e
+		}
+		l, e := net.Listen("unix", path)
+		if e != nil {
+			return nil, "", e
+		}
+		if e := os.Chmod(path, 0600); e != nil {
+			_ = l.Close()
+			return nil, "", e
+		}
+		return l, "unix:" + path, nil
+	}
+	if e := c.checkAddr(addr); e != nil {
+		return nil, "", e
+	}
+	l, e := net.Listen("tcp", addr)
+	return l, addr, e
+}
+
+// checkLoopbackAddr 检查主机:端口是否是本机地址
+func checkLoopbackAddr(addr string) error {
+	host, _, e := net.SplitHostPort(addr)
+	if e != nil {
+		return fmt.Errorf("地址错误 %s: %w", addr, e)
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("只能监听本机地址(127.0.0.1, ::1或localhost): %s", addr)
+	}
+	return nil
+}
+
+// startAdmin 按设置启动管理接口和gRPC控制接口, 返回的函数在退出时关闭, 管理接口最多等待cfg.ShutdownTimeout处理完请求
+func startAdmin(cfg *Config, dir string, control *controlServer) (func(), error) {
+	if e := cfg.Admin.validate(); e != nil {
+		return nil, e
+	}
+	adminTLS, e := cfg.Admin.serverTLS()
+	if e != nil {
+		return nil, e
+	}
+	var token string
+	if cfg.Admin.Auth && (cfg.AdminAddr != "" || cfg.GRPCAddr != "") {
+		if token, e = loadAdminToken(dir); e != nil {
+			return nil, fmt.Errorf("读取管理接口token出错: %w", e)
+		}
+	}
+	var stops []func()
+	stop := func() {
+		for _, fn := range stops {
+			fn()
+		}
+	}
+	if cfg.AdminAddr != "" {
+		l, addr, e := adminListen(cfg.AdminAddr, dir, cfg.Admin)
+		if e != nil {
+			return nil, fmt.Errorf("管理接口监听出错: %w", e)
+		}
+		// unix socket由文件权限保护, 只在TCP上使用TLS
+		if adminTLS != nil && adminSocketPath(cfg.AdminAddr, dir) == "" {
+			l = tls.NewListener(l, adminTLS)
+		}
+		server := control.admin.serve(l, addr, token)
+		stops = append(stops, func() {
+			ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+			defer cancel()
+			if e := server.Shutdown(ctx); e != nil {
+				logger.Warnw("关闭管理接口出错", "error", e)
+			}
+		})
+	}
+	if cfg.GRPCAddr != "" {
+		l, addr, e := adminListen(cfg.GRPCAddr, dir, cfg.Admin)
+		if e != nil {
+			stop()
+			return nil, fmt.Errorf("gRPC控制接口监听出错: %w", e)
+		}
+		var opts []grpc.ServerOption
+		if token != "" {
+			opts = grpcTokenOptions(token)
+		}
+		if adminTLS != nil && adminSocketPath(cfg.GRPCAddr, dir) == "" {
+			opts = append(opts, grpc.Creds(credentials.NewTLS(adminTLS)))
+		}
+		server := serveControl(l, addr, control, opts...)
+		stops = append(stops, server.Stop)
+	}
+	return stop, nil
+}
+
+// adminAPI 是管理接口: 查看本节点, 连接和状态, 连接和断开节点, DHT查询
+type adminAPI struct {
+	h      host.Host
+	status *statusRegistry
+	acl    *accessList
+	bans   *banList
+	scorer *peerScorer
+	idht   *dht.IpfsDHT
+	// fullrt 不为nil时DHT查询优先使用加速DHT
+	fullrt    *fullRoutingTable
+	dashboard *dashboard
+	// relay 不为nil时提供中继用量
+	relay *relayTraffic
+	// routes 是提供管理接口后的路由, 多网络模式下在其中加入其他网络
+	routes *http.ServeMux
+}
+
+func (a *adminAPI) handler() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/id", a.id)
+	mux.HandleFunc("/v1/peers", a.peers)
+	mux.HandleFunc("/v1/latency", a.latency)
+	mux.HandleFunc("/v1/connect", a.connect)
+	mux.HandleFunc("/v1/disconnect", a.disconnect)
+	mux.HandleFunc("/v1/stats", a.stats)
+	mux.HandleFunc("/v1/acl", a.accessList)
+	mux.HandleFunc("/v1/bans", a.listBans)
+	mux.HandleFunc("/v1/ban", a.ban)
+	mux.HandleFunc("/v1/unban", a.unban)
+	mux.HandleFunc("/v1/dht/findpeer", a.findPeer)
+	mux.HandleFunc("/v1/dht/findprovs", a.findProviders)
+	mux.HandleFunc("/v1/dashboard", a.dashboardStatus)
+	mux.HandleFunc("/v1/relay", a.relayTraffic)
+	mux.HandleFunc("/v1/peerstore", a.peerstore)
+	return mux
+}
+
+// serve 在监听地址上提供管理接口, token不为空时要求请求带有token. 返回的服务用Shutdown关闭
+func (a *adminAPI) serve(l net.Listener, addr, token string) *http.Server {
+	a.routes = a.handler()
+	var handler http.Handler = a.routes
+	if token != "" {
+		handler = requireToken(token, handler)
+	}
+	// 控制台页面不需要token, 页面中输入token后请求 /v1/dashboard
+	root := http.NewServeMux()
+	root.Handle("/", handler)
+	root.HandleFunc("/dashboard", serveDashboardPage)
+	server := &http.Server{
+		Handler:           root,
+		ReadHeaderTimeout: adminReadHeaderTimeout,
+		ReadTimeout:       adminReadTimeout,
+		WriteTimeout:      adminWriteTimeout,
+	}
+	go func() {
+		logger.Infow("管理接口地址", "addr", addr)
+		if e := server.Serve(l); e != nil && e != http.ErrServerClosed {
+			logger.Errorw("管理接口出错", "error", e)
+		}
+	}()
+	return server
+}
+
+// adminID 是 /v1/id 的内容
+type adminID struct {
+	ID    string   `json:"id"`
+	Addrs []string `json:"addrs"`
+}
+
+func (a *adminAPI) id(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAdminError(w, http.StatusMethodNotAllowed, errors.New("只支持GET"))
+		return
+	}
+	addrs, e := a.ownAddrs()
+	if e != nil {
+		writeAdminError(w, http.StatusInternalServerError, e)
+		return
+	}
+	writeAdminJSON(w, adminID{ID: a.h.ID().String(), Addrs: addrs})
+}
+
+// ownAddrs 返回本节点带/p2p的完整地址
+func (a *adminAPI) ownAddrs() ([]string, error) {
+	addrs, e := peer.AddrInfoToP2pAddrs(&peer.AddrInfo{ID: a.h.ID(), Addrs: a.h.Addrs()})
+	if e != nil {
+		return nil, e
+	}
+	out := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		out = append(out, addr.String())
+	}
+	return out, nil
+}
+
+// adminPeer 是 /v1/peers 中的一个节点
+type adminPeer struct {
+	ID           string   `json:"id"`
+	Addrs        []string `json:"addrs"`
+	Directions   []string `json:"directions"`
+	Protocols    []string `json:"protocols"`
+	AgentVersion string   `json:"agent_version,omitempty"`
+}
+
+func (a *adminAPI) peers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAdminError(w, http.StatusMethodNotAllowed, errors.New("只支持GET"))
+		return
+	}
+	writeAdminJSON(w, a.listPeers())
+}
+
+// listPeers 返回已连接的节点
+func (a *adminAPI) listPeers() []adminPeer {
+	n := a.h.Network()
+	list := make([]adminPeer, 0, len(n.Peers()))
+	for _, p := range n.Peers() {
+		item := adminPeer{ID: p.String()}
+		for _, c := range n.ConnsToPeer(p) {
+			item.Addrs = append(item.Addrs, c.RemoteMultiaddr().String())
+			item.Directions = append(item.Directions, c.Stat().Direction.String())
+		}
+		protocols, _ := a.h.Peerstore().GetProtocols(p)
+		item.Protocols = protocol.ConvertToStrings(protocols)
+		if v, e := a.h.Peerstore().Get(p, "AgentVersion"); e == nil {
+			item.AgentVersion, _ = v.(string)
+		}
+		list = append(list, item)
+	}
+	return list
+}
+
+// latency 返回已连接节点最近ping的延迟统计, 没有启用节点评分时返回404
+func (a *adminAPI) latency(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAdminError(w, http.StatusMethodNotAllowed, errors.New("只支持GET"))
+		return
+	}
+	if a.scorer == nil {
+		writeAdminError(w, http.StatusNotFound, errors.New("没有启用节点评分(-score-interval为0), 不ping节点"))
+		return
+	}
+	writeAdminJSON(w, a.scorer.latencies())
+}
+
+// adminConnectRequest 是 /v1/connect 的请求, addr是带/p2p的multiaddr, 可以是/dnsaddr
+type adminConnectRequest struct {
+	Addr string `json:"addr"`
+}
+
+func (a *adminAPI) connect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeAdminError(w, http.StatusMethodNotAllowed, errors.New("只支持POST"))
+		return
+	}
+	var req adminConnectRequest
+	if e := json.NewDecoder(http.MaxBytesReader(w, r.Body, adminRequestMax)).Decode(&req); e != nil {
+		writeAdminError(w, http.StatusBadRequest, e)
+		return
+	}
+	connected, e := a.connectAddr(r.Context(), req.Addr)
+	if e != nil {
+		writeAdminError(w, adminErrorStatus(e, http.StatusBadGateway), e)
+		return
+	}
+	writeAdminJSON(w, map[string][]string{"connected": connected})
+}
+
+// connectAddr 解析地址并连接, 返回连接的节点ID. 地址错误时返回adminRequestError
+func (a *adminAPI) connectAddr(ctx context.Context, s string) ([]string, error) {
+	addr, e := ma.NewMultiaddr(s)
+	if e != nil {
+		return nil, adminRequestError{e}
+	}
+	ctx, cancel := context.WithTimeout(ctx, connectTimeout)
+	defer cancel()
+	addrs, e := resolveAddr(ctx, addr, 0)
+	if e != nil {
+		return nil, e
+	}
+	addrInfos, e := peer.AddrInfosFromP2pAddrs(addrs...)
+	if e != nil {
+		return nil, adminRequestError{e}
+	}
+	connected := make([]string, 0, len(addrInfos))
+	for _, addrInfo := range addrInfos {
+		if e := a.h.Connect(ctx, addrInfo); e != nil {
+			return nil, e
+		}
+		connected = append(connected, addrInfo.ID.String())
+	}
+	logger.Infow("管理接口连接节点", "peers", connected)
+	return connected, nil
+}
+
+// adminDisconnectRequest 是 /v1/disconnect 的请求
+type adminDisconnectRequest struct {
+	Peer string `json:"peer"`
+}
+
+func (a *adminAPI) disconnect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeAdminError(w, http.StatusMethodNotAllowed, errors.New("只支持POST"))
+		return
+	}
+	var req adminDisconnectRequest
+	if e := json.NewDecoder(http.MaxBytesReader(w, r.Body, adminRequestMax)).Decode(&req); e != nil {
+		writeAdminError(w, http.StatusBadRequest, e)
+		return
+	}
+	closed, e := a.disconnectPeer(req.Peer)
+	if e != nil {
+		writeAdminError(w, adminErrorStatus(e, http.StatusInternalServerError), e)
+		return
+	}
+	writeAdminJSON(w, map[string]int{"closed": closed})
+}
+
+// disconnectPeer 断开与节点的所有连接, 返回关闭的连接数量. 节点ID错误时返回adminRequestError
+func (a *adminAPI) disconnectPeer(s string) (int, error) {
+	id, e := peer.Decode(s)
+	if e != nil {
+		return 0, adminRequestError{e}
+	}
+	conns := len(a.h.Network().ConnsToPeer(id))
+	if e := a.h.Network().ClosePeer(id); e != nil {
+		return 0, e
+	}
+	logger.Infow("管理接口断开节点", "peer", id, "conns", conns)
+	return conns, nil
+}
+
+// adminRequestError 是请求内容错误
+type adminRequestError struct {
+	error
+}
+
+// isAdminRequestError 检查是否是请求内容错误
+func isAdminRequestError(e error) bool {
+	var re adminRequestError
+	return errors.As(e, &re)
+}
+
+// adminErrorStatus 返回错误对应的HTTP状态, 请求内容错误是400, 其他是status
+func adminErrorStatus(e error, status int) int {
+	if isAdminRequestError(e) {
+		return http.StatusBadRequest
+	}
+	return status
+}
+
+// stats 返回与 /status 相同的节点状态
+func (a *adminAPI) stats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAdminError(w, http.StatusMethodNotAllowed, errors.New("只支持GET"))
+		return
+	}
+	writeAdminJSON(w, a.status.snapshot())
+}
+
+// adminACLRequest 是 POST /v1/acl 的请求, list是allow或deny
+type adminACLRequest struct {
+	List   string   `json:"list"`
+	Add    []string `json:"add"`
+	Remove []string `json:"remove"`
+}
+
+// accessList 查看(GET)或修改(POST)访问控制列表, 修改后保存到文件并关闭被拒绝的连接
+func (a *adminAPI) accessList(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeAdminJSON(w, a.acl.Lists())
+	case http.MethodPost:
+		var req adminACLRequest
+		if e := json.NewDecoder(http.MaxBytesReader(w, r.Body, adminRequestMax)).Decode(&req); e != nil {
+			writeAdminError(w, http.StatusBadRequest, e)
+			return
+		}
+		lists, e := a.acl.Update(req.List, req.Add, req.Remove)
+		if e != nil {
+			writeAdminError(w, http.StatusBadRequest, e)
+			return
+		}
+		closed := a.acl.closeDenied(a.h.Network())
+		logger.Infow("管理接口修改访问控制列表", "list", req.List, "add", req.Add, "remove", req.Remove, "closed", closed)
+		writeAdminJSON(w, map[string]interface{}{"allow": lists.Allow, "deny": lists.Deny, "closed": closed})
+	default:
+		writeAdminError(w, http.StatusMethodNotAllowed, errors.New("只支持GET和POST"))
+	}
+}
+
+func (a *adminAPI) listBans(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAdminError(w, http.StatusMethodNotAllowed, errors.New("只支持GET"))
+		return
+	}
+	writeAdminJSON(w, a.bans.List())
+}
+
+// adminBanRequest 是 /v1/ban 和 /v1/unban 的请求, target是节点ID, IP或CIDR, ttl如24h
+type adminBanRequest struct {
+	Target string `json:"target"`
+	TTL    string `json:"ttl,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// ban 封禁节点或地址并关闭已有连接
+func (a *adminAPI) ban(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeAdminError(w, http.StatusMethodNotAllowed, errors.New("只支持POST"))
+		return
+	}
+	var req adminBanRequest
+	if e := json.NewDecoder(http.MaxBytesReader(w, r.Body, adminRequestMax)).Decode(&req); e != nil {
+		writeAdminError(w, http.StatusBadRequest, e)
+		return
+	}
+	ttl, e := time.ParseDuration(req.TTL)
+	if e != nil {
+		writeAdminError(w, http.StatusBadRequest, fmt.Errorf("封禁时间错误: %w", e))
+		return
+	}
+	entry, e := a.bans.Ban(req.Target, ttl, req.Reason)
+	if e != nil {
+		writeAdminError(w, http.StatusBadRequest, e)
+		return
+	}
+	closed := a.bans.closeBanned(a.h.Network())
+	logger.Infow("管理接口封禁", "target", entry.Target, "until", entry.Until, "reason", entry.Reason, "closed", closed)
+	writeAdminJSON(w, map[string]interface{}{"target": entry.Target, "until": entry.Until, "closed": closed})
+}
+
+func (a *adminAPI) unban(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeAdminError(w, http.StatusMethodNotAllowed, errors.New("只支持POST"))
+		return
+	}
+	var req adminBanRequest
+	if e := json.NewDecoder(http.MaxBytesReader(w, r.Body, adminRequestMax)).Decode(&req); e != nil {
+		writeAdminError(w, http.StatusBadRequest, e)
+		return
+	}
+	found, e := a.bans.Unban(req.Target)
+	if e != nil {
+		writeAdminError(w, http.StatusInternalServerError, e)
+		return
+	}
+	if !found {
+		writeAdminError(w, http.StatusNotFound, fmt.Errorf("没有封禁 %s", req.Target))
+		return
+	}
+	logger.Infow("管理接口解除封禁", "target", req.Target)
+	writeAdminJSON(w, map[string]string{"target": req.Target})
+}
+
+func writeAdminJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if e := json.NewEncoder(w).Encode(v); e != nil {
+		logger.Warnw("输出管理接口结果出错", "error", e)
+	}
+}
+
+func writeAdminError(w http.ResponseWriter, status int, e error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": e.Error()})
+}
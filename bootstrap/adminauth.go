@@ -0,0 +1,154 @@
+package bootstrap
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+// adminTokenFile 是数据目录中管理接口的token文件, 第一次运行时生成
+const adminTokenFile = "admin.token"
+
+// adminConfig 是管理接口和gRPC控制接口的认证和TLS设置
+type adminConfig struct {
+	// Auth 要求请求带有token: HTTP使用Authorization: Bearer <token>, gRPC使用authorization元数据
+	Auth bool `yaml:"auth"`
+	// TLSCert, TLSKey 是TCP监听使用的证书和私钥(PEM), 为空时不使用TLS
+	TLSCert string `yaml:"tls_cert"`
+	TLSKey  string `yaml:"tls_key"`
+}
+
+// validate 检查TLS设置
+func (c adminConfig) validate() error {
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		return errors.New("admin-tls-cert和admin-tls-key需要同时设置")
+	}
+	return nil
+}
+
+// checkAddr 检查管理接口地址. 只有同时启用认证和TLS时才能监听其他主机可以访问的地址
+func (c adminConfig) checkAddr(addr string) error {
+	if strings.HasPrefix(addr, "unix:") {
+		return nil
+	}
+	if c.Auth && c.TLSCert != "" {
+		return nil
+	}
+	if e := checkLoopbackAddr(addr); e != nil {
+		return fmt.Errorf("%w. 监听其他地址需要启用认证和TLS", e)
+	}
+	return nil
+}
+
+// serverTLS 读取证书, 没有设置时返回nil
+func (c adminConfig) serverTLS() (*tls.Config, error) {
+	if c.TLSCert == "" {
+		return nil, nil
+	}
+	cert, e := tls.LoadX509KeyPair(c.TLSCert, c.TLSKey)
+	if e != nil {
+		return nil, fmt.Errorf("读取管理接口证书出错: %w", e)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+}
+
+// clientTLS 返回信任系统根证书和管理接口证书的客户端设置, 用于自签名证书
+func (c adminConfig) clientTLS() (*tls.Config, error) {
+	pool, e := x509.SystemCertPool()
+	if e != nil {
+		pool = x509.NewCertPool()
+	}
+	b, e := ioutil.ReadFile(c.TLSCert)
+	if e != nil {
+		return nil, e
+	}
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("管理接口证书格式错误: %s", c.TLSCert)
+	}
+	return &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}, nil
+}
+
+// loadAdminToken 读取数据目录中的token, 不存在时生成
+func loadAdminToken(dir string) (string, error) {
+	path := filepath.Join(dir, adminTokenFile)
+	b, e := ioutil.ReadFile(path)
+	if e == nil {
+		token := strings.TrimSpace(string(b))
+		if token == "" {
+			return "", fmt.Errorf("管理接口token文件为空: %s", path)
+		}
+		return token, nil
+	}
+	if !os.IsNotExist(e) {
+		return "", e
+	}
+	key := make([]byte, 32)
+	if _, e := rand.Read(key); e != nil {
+		return "", e
+	}
+	token := hex.EncodeToString(key)
+	if e := ioutil.WriteFile(path, []byte(token+"\n"), 0600); e != nil {
+		return "", e
+	}
+	logger.Infow("已生成管理接口token", "path", path)
+	return token, nil
+}
+
+// validToken 以固定时间比较token
+func validToken(got, token string) bool {
+	return subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+// requireToken 拒绝没有正确token的HTTP请求
+func requireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !validToken(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "), token) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeAdminError(w, http.StatusUnauthorized, errors.New("token错误"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// grpcTokenOptions 返回检查gRPC请求token的拦截器
+func grpcTokenOptions(token string) []grpc.ServerOption {
+	check := func(ctx context.Context) error {
+		md, _ := metadata.FromIncomingContext(ctx)
+		for _, v := range md.Get("authorization") {
+			if validToken(strings.TrimPrefix(v, "Bearer "), token) {
+				return nil
+			}
+		}
+		return grpcstatus.Error(codes.Unauthenticated, "token错误")
+	}
+	return []grpc.ServerOption{
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if e := check(ctx); e != nil {
+				return nil, e
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if e := check(ss.Context()); e != nil {
+				return e
+			}
+			return handler(srv, ss)
+		}),
+	}
+}
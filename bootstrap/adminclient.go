@@ -0,0 +1,372 @@
+package bootstrap
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// adminClient 通过管理接口访问运行中的节点
+type adminClient struct {
+	base   string
+	token  string
+	client http.Client
+}
+
+// newAdminClient 按设置的管理接口地址创建客户端, unix地址的相对路径在数据目录中.
+// 启用认证时读取数据目录中的token, 在TCP上设置了证书时使用TLS并信任该证书.
+// 多网络模式下管理接口由第一个网络提供, 按-network访问 /networks/<名称>/ 下的接口
+func newAdminClient(cfg *Config) (*adminClient, error) {
+	var prefix string
+	if len(cfg.Networks) > 0 {
+		names, cfgs, e := cfg.networkConfigs()
+		if e != nil {
+			return nil, e
+		}
+		network := names[0]
+		if cfg.adminNetwork != "" {
+			network = ""
+			for _, name := range names {
+				if name == cfg.adminNetwork {
+					network = name
+				}
+			}
+			if network == "" {
+				return nil, fmt.Errorf("配置中没有网络 %s", cfg.adminNetwork)
+			}
+		}
+		prefix = "/" + networkDirName + "/" + network
+		cfg = cfgs[0]
+	} else if cfg.adminNetwork != "" {
+		return nil, errors.New("没有设置networks, 不能使用-network")
+	}
+	if cfg.AdminAddr == "" {
+		return nil, errors.New("没有设置-admin-addr, 无法访问运行中的节点")
+	}
+	dir, e := cfg.dataDir()
+	if e != nil {
+		return nil, e
+	}
+	c := &adminClient{base: "http://" + cfg.AdminAddr + prefix, client: http.Client{Timeout: connectTimeout + time.Second*5}}
+	if cfg.Admin.Auth {
+		b, e := ioutil.ReadFile(filepath.Join(dir, adminTokenFile))
+		if e != nil {
+			return nil, fmt.Errorf("读取管理接口token出错, 节点是否已启动: %w", e)
+		}
+		c.token = strings.TrimSpace(string(b))
+	}
+	if path := adminSocketPath(cfg.AdminAddr, dir); path != "" {
+		c.base = "http://admin" + prefix
+		var dialer net.Dialer
+		c.client.Transport = &http.Transport{DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", path)
+		}}
+	} else if cfg.Admin.TLSCert != "" {
+		tlsConfig, e := cfg.Admin.clientTLS()
+		if e != nil {
+			return nil, e
+		}
+		c.base = "https://" + cfg.AdminAddr + prefix
+		c.client.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+	}
+	return c, nil
+}
+
+// get 请求path并解析JSON结果到out
+func (c *adminClient) get(path string, out interface{}) error {
+	req, e := http.NewRequest(http.MethodGet, c.base+path, nil)
+	if e != nil {
+		return e
+	}
+	return c.do(req, out)
+}
+
+// post 以JSON发送body到path并解析JSON结果到out
+func (c *adminClient) post(path string, body, out interface{}) error {
+	b, e := json.Marshal(body)
+	if e != nil {
+		return e
+	}
+	req, e := http.NewRequest(http.MethodPost, c.base+path, bytes.NewReader(b))
+	if e != nil {
+		return e
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return c.do(req, out)
+}
+
+func (c *adminClient) do(req *http.Request, out interface{}) error {
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, e := c.client.Do(req)
+	if e != nil {
+		return e
+	}
+	return decodeAdminResponse(resp, out)
+}
+
+// decodeAdminResponse 解析结果, 出错时返回管理接口的错误信息
+func decodeAdminResponse(resp *http.Response, out interface{}) error {
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var failed struct {
+			Error string `json:"error"`
+		}
+		if e := json.NewDecoder(resp.Body).Decode(&failed); e != nil || failed.Error == "" {
+			return errors.New(resp.Status)
+		}
+		return errors.New(failed.Error)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// runningNodeID 从运行中节点的管理接口读取节点ID和地址
+func runningNodeID(cfg *Config) (*adminID, error) {
+	c, e := newAdminClient(cfg)
+	if e != nil {
+		return nil, e
+	}
+	var id adminID
+	if e := c.get("/v1/id", &id); e != nil {
+		return nil, e
+	}
+	return &id, nil
+}
+
+// runAdminPeers 通过管理接口列出已连接的节点, 每行输出节点ID, 连接地址和方向, 客户端版本
+func runAdminPeers(cfg *Config) int {
+	c, e := newAdminClient(cfg)
+	if e != nil {
+		logger.Errorw("创建管理接口客户端出错", "error", e)
+		return 1
+	}
+	var list []adminPeer
+	if e := c.get("/v1/peers", &list); e != nil {
+		logger.Errorw("查询节点出错", "error", e)
+		return 1
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, p := range list {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", p.ID, strings.Join(p.Addrs, ","), strings.Join(p.Directions, ","), p.AgentVersion)
+	}
+	_ = tw.Flush()
+	return 0
+}
+
+// runLatency 通过管理接口列出已连接节点最近ping的延迟, 每行输出节点ID, 最近, 最小, 平均, 最大延迟和失败次数
+func runLatency(args []string) int {
+	fs := flag.NewFlagSet("latency", flag.ExitOnError)
+	cfg, e := commandConfig(fs, args)
+	if e != nil {
+		logger.Errorw("读取设置出错", "error", e)
+		return 1
+	}
+	c, e := newAdminClient(cfg)
+	if e != nil {
+		logger.Errorw("创建管理接口客户端出错", "error", e)
+		return 1
+	}
+	var list []peerLatency
+	if e := c.get("/v1/latency", &list); e != nil {
+		logger.Errorw("查询延迟出错", "error", e)
+		return 1
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "PEER\tLAST\tMIN\tAVG\tMAX\tFAILURES")
+	for _, l := range list {
+		fmt.Fprintf(tw, "%s\t%.1fms\t%.1fms\t%.1fms\t%.1fms\t%d/%d\n", l.Peer, l.LastMs, l.MinMs, l.AvgMs, l.MaxMs, l.Failures, l.Samples+l.Failures)
+	}
+	_ = tw.Flush()
+	return 0
+}
+
+// runConnect 让运行中的节点连接指定地址
+func runConnect(args []string) int {
+	fs := flag.NewFlagSet("connect", flag.ExitOnError)
+	cfg, e := commandConfig(fs, args)
+	if e != nil {
+		logger.Errorw("读取设置出错", "error", e)
+		return 1
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "用法: bootstrap connect [参数] <multiaddr>")
+		return 2
+	}
+	c, e := newAdminClient(cfg)
+	if e != nil {
+		logger.Errorw("创建管理接口客户端出错", "error", e)
+		return 1
+	}
+	var out struct {
+		Connected []string `json:"connected"`
+	}
+	if e := c.post("/v1/connect", adminConnectRequest{Addr: fs.Arg(0)}, &out); e != nil {
+		logger.Errorw("连接出错", "error", e)
+		return 1
+	}
+	for _, id := range out.Connected {
+		fmt.Println(id)
+	}
+	return 0
+}
+
+// runDisconnect 让运行中的节点断开与指定节点的连接
+func runDisconnect(args []string) int {
+	fs := flag.NewFlagSet("disconnect", flag.ExitOnError)
+	cfg, e := commandConfig(fs, args)
+	if e != nil {
+		logger.Errorw("读取设置出错", "error", e)
+		return 1
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "用法: bootstrap disconnect [参数] <节点ID>")
+		return 2
+	}
+	c, e := newAdminClient(cfg)
+	if e != nil {
+		logger.Errorw("创建管理接口客户端出错", "error", e)
+		return 1
+	}
+	var out struct {
+		Closed int `json:"closed"`
+	}
+	if e := c.post("/v1/disconnect", adminDisconnectRequest{Peer: fs.Arg(0)}, &out); e != nil {
+		logger.Errorw("断开出错", "error", e)
+		return 1
+	}
+	fmt.Println("已关闭连接", out.Closed)
+	return 0
+}
+
+// runStats 输出运行中节点的状态
+func runStats(args []string) int {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	cfg, e := commandConfig(fs, args)
+	if e != nil {
+		logger.Errorw("读取设置出错", "error", e)
+		return 1
+	}
+	c, e := newAdminClient(cfg)
+	if e != nil {
+		logger.Errorw("创建管理接口客户端出错", "error", e)
+		return 1
+	}
+	var stats map[string]interface{}
+	if e := c.get("/v1/stats", &stats); e != nil {
+		logger.Errorw("查询状态出错", "error", e)
+		return 1
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if e := encoder.Encode(stats); e != nil {
+		logger.Errorw("输出状态出错", "error", e)
+		return 1
+	}
+	return 0
+}
+
+// runBan 通过管理接口封禁节点ID, IP或CIDR: bootstrap ban <目标> [-ttl 24h] [-reason 原因]
+func runBan(args []string) int {
+	fs := flag.NewFlagSet("ban", flag.ExitOnError)
+	ttl := fs.Duration("ttl", time.Hour*24, "封禁时间, 到期后自动解除")
+	reason := fs.String("reason", "", "封禁原因")
+	cfg, e := commandConfig(fs, args)
+	if e != nil {
+		logger.Errorw("读取设置出错", "error", e)
+		return 1
+	}
+	usage := "用法: bootstrap ban <节点ID|IP|CIDR> [-ttl 24h] [-reason 原因]"
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+	// 参数可以写在目标之后
+	target := fs.Arg(0)
+	if e := fs.Parse(fs.Args()[1:]); e != nil || fs.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+	return banTarget(cfg, target, *ttl, *reason)
+}
+
+func banTarget(cfg *Config, target string, ttl time.Duration, reason string) int {
+	c, e := newAdminClient(cfg)
+	if e != nil {
+		logger.Errorw("创建管理接口客户端出错", "error", e)
+		return 1
+	}
+	var out struct {
+		Until  time.Time `json:"until"`
+		Closed int       `json:"closed"`
+	}
+	if e := c.post("/v1/ban", adminBanRequest{Target: target, TTL: ttl.String(), Reason: reason}, &out); e != nil {
+		logger.Errorw("封禁出错", "error", e)
+		return 1
+	}
+	fmt.Println("已封禁", target, "到", out.Until.Local().Format(time.RFC3339), "关闭连接", out.Closed)
+	return 0
+}
+
+// runUnban 通过管理接口解除封禁
+func runUnban(args []string) int {
+	fs := flag.NewFlagSet("unban", flag.ExitOnError)
+	cfg, e := commandConfig(fs, args)
+	if e != nil {
+		logger.Errorw("读取设置出错", "error", e)
+		return 1
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "用法: bootstrap unban [参数] <节点ID|IP|CIDR>")
+		return 2
+	}
+	c, e := newAdminClient(cfg)
+	if e != nil {
+		logger.Errorw("创建管理接口客户端出错", "error", e)
+		return 1
+	}
+	var out map[string]string
+	if e := c.post("/v1/unban", adminBanRequest{Target: fs.Arg(0)}, &out); e != nil {
+		logger.Errorw("解除封禁出错", "error", e)
+		return 1
+	}
+	fmt.Println("已解除封禁", fs.Arg(0))
+	return 0
+}
+
+// runBans 列出封禁, 每行输出目标, 到期时间和原因, 同步来的封禁最后是来源节点
+func runBans(args []string) int {
+	fs := flag.NewFlagSet("bans", flag.ExitOnError)
+	cfg, e := commandConfig(fs, args)
+	if e != nil {
+		logger.Errorw("读取设置出错", "error", e)
+		return 1
+	}
+	c, e := newAdminClient(cfg)
+	if e != nil {
+		logger.Errorw("创建管理接口客户端出错", "error", e)
+		return 1
+	}
+	var list []banEntry
+	if e := c.get("/v1/bans", &list); e != nil {
+		logger.Errorw("查询封禁出错", "error", e)
+		return 1
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, entry := range list {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", entry.Target, entry.Until.Local().Format(time.RFC3339), entry.Reason, entry.Origin)
+	}
+	_ = tw.Flush()
+	return 0
+}
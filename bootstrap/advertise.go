@@ -0,0 +1,167 @@
+package bootstrap
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/discovery"
+	"github.com/libp2p/go-libp2p/core/peer"
+	drouting "github.com/libp2p/go-libp2p/p2p/discovery/routing"
+	dutil "github.com/libp2p/go-libp2p/p2p/discovery/util"
+)
+
+// advertiseRetry 是宣告失败(如路由表为空)后重试的间隔
+const advertiseRetry = time.Minute
+
+// findPeersMax 是 /find-peers 默认和最多返回的节点数量
+const findPeersMax = 100
+
+// findPeersTimeout 是 /find-peers 查询DHT的最长时间
+const findPeersTimeout = time.Second * 30
+
+// checkAdvertise 检查要宣告的名称空间: 不能为空或重复. find需要宣告名称空间
+func checkAdvertise(namespaces []string, find bool) error {
+	if find && len(namespaces) == 0 {
+		return errors.New("advertise-find需要设置advertise")
+	}
+	seen := make(map[string]bool)
+	for _, ns := range namespaces {
+		if ns == "" {
+			return errors.New("宣告的名称空间不能为空")
+		}
+		if seen[ns] {
+			return fmt.Errorf("宣告的名称空间重复: %s", ns)
+		}
+		seen[ns] = true
+	}
+	return nil
+}
+
+// advertiseState 是一个名称空间的宣告状态
+type advertiseState struct {
+	Last  time.Time `json:"last,omitempty"`
+	TTL   string    `json:"ttl,omitempty"`
+	Count int       `json:"count"`
+	Error string    `json:"error,omitempty"`
+}
+
+// advertiser 在DHT上宣告本节点提供名称空间对应的服务, 应用按服务名称找到引导节点, 不必写死地址.
+// 宣告在有效期快到时重新发布.
+type advertiser struct {
+	discovery  *drouting.RoutingDiscovery
+	namespaces []string
+
+	mu    sync.Mutex
+	state map[string]*advertiseState
+}
+
+func newAdvertiser(idht *dht.IpfsDHT, namespaces []string) *advertiser {
+	a := &advertiser{discovery: drouting.NewRoutingDiscovery(idht), namespaces: namespaces, state: make(map[string]*advertiseState)}
+	for _, ns := range namespaces {
+		a.state[ns] = &advertiseState{}
+	}
+	return a
+}
+
+// run 为每个名称空间宣告, ctx取消时停止
+func (a *advertiser) run(ctx context.Context) {
+	for _, ns := range a.namespaces {
+		go a.advertise(ctx, ns)
+	}
+}
+
+func (a *advertiser) advertise(ctx context.Context, ns string) {
+	for {
+		ttl, e := a.discovery.Advertise(ctx, ns)
+		wait := advertiseRetry
+		a.mu.Lock()
+		s := a.state[ns]
+		if e != nil {
+			s.Error = e.Error()
+		} else {
+			s.Error = ""
+			s.Last = time.Now()
+			s.TTL = ttl.String()
+			s.Count++
+			// 在有效期结束前重新宣告
+			wait = ttl * 7 / 8
+		}
+		a.mu.Unlock()
+		if e != nil && ctx.Err() == nil {
+			logger.Warnw("宣告名称空间出错, 稍后重试", "namespace", ns, "error", e)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// advertised 判断是否宣告了名称空间
+func (a *advertiser) advertised(ns string) bool {
+	_, ok := a.state[ns]
+	return ok
+}
+
+// status 返回每个名称空间的宣告状态
+func (a *advertiser) status() interface{} {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	out := make(map[string]advertiseState, len(a.state))
+	for ns, s := range a.state {
+		out[ns] = *s
+	}
+	return out
+}
+
+// findPeersHandler 通过DHT查找提供名称空间的节点, 只回答本节点宣告的名称空间.
+// ?ns=<名称空间>&limit=N
+func (a *advertiser) findPeersHandler(w http.ResponseWriter, r *http.Request) {
+	ns := r.URL.Query().Get("ns")
+	if !a.advertised(ns) {
+		http.Error(w, "没有宣告的名称空间", http.StatusNotFound)
+		return
+	}
+	limit := findPeersMax
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, e := strconv.Atoi(v)
+		if e != nil || n <= 0 {
+			http.Error(w, "limit错误", http.StatusBadRequest)
+			return
+		}
+		if n < limit {
+			limit = n
+		}
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), findPeersTimeout)
+	defer cancel()
+	found, e := dutil.FindPeers(ctx, a.discovery, ns, discovery.Limit(limit))
+	if e != nil {
+		http.Error(w, e.Error(), http.StatusBadGateway)
+		return
+	}
+	peers := make([]map[string]interface{}, 0, len(found))
+	for _, info := range found {
+		addrs, e := peer.AddrInfoToP2pAddrs(&info)
+		if e != nil {
+			continue
+		}
+		list := make([]string, 0, len(addrs))
+		for _, addr := range addrs {
+			list = append(list, addr.String())
+		}
+		peers = append(peers, map[string]interface{}{"id": info.ID.String(), "addrs": list})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if e := json.NewEncoder(w).Encode(map[string]interface{}{"namespace": ns, "peers": peers}); e != nil {
+		logger.Warnw("输出查找结果出错", "error", e)
+	}
+}
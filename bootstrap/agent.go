@@ -0,0 +1,22 @@
+package bootstrap
+
+import (
+	"fmt"
+	"unicode"
+)
+
+// agentVersionMax 是identify客户端版本的长度上限
+const agentVersionMax = 256
+
+// checkAgentVersion 检查identify客户端版本, 如mynet-bootstrap/1.3.0. 为空时使用libp2p默认的版本
+func checkAgentVersion(v string) error {
+	if len(v) > agentVersionMax {
+		return fmt.Errorf("客户端版本超过%d字节", agentVersionMax)
+	}
+	for _, r := range v {
+		if r > unicode.MaxASCII || !unicode.IsPrint(r) {
+			return fmt.Errorf("客户端版本只能包括可打印的ASCII字符: %q", v)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package bootstrap
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	basichost "github.com/libp2p/go-libp2p/p2p/host/basic"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// announceFilter 处理宣告的地址: announce不为空时代替检测到的地址, 否则去掉内网和本机地址(allowPrivate时保留),
+// 之后去掉no_announce匹配的地址
+type announceFilter struct {
+	allowPrivate bool
+	announce     []ma.Multiaddr
+	exclude      []ma.Multiaddr
+	nets         []*net.IPNet
+}
+
+// newAnnounceFilter 解析宣告设置, 不需要处理时返回nil.
+// noAnnounce 可以是multiaddr(去掉以其开头的地址)或CIDR, 如10.0.0.0/8.
+func newAnnounceFilter(announce, noAnnounce []string, allowPrivate bool) (basichost.AddrsFactory, error) {
+	if len(announce) == 0 && len(noAnnounce) == 0 && allowPrivate {
+		return nil, nil
+	}
+	f := &announceFilter{allowPrivate: allowPrivate}
+	for _, v := range announce {
+		addr, e := ma.NewMultiaddr(v)
+		if e != nil {
+			return nil, fmt.Errorf("宣告地址错误 %s: %w", v, e)
+		}
+		f.announce = append(f.announce, addr)
+	}
+	for _, v := range noAnnounce {
+		if !strings.HasPrefix(v, "/") {
+			_, ipNet, e := net.ParseCIDR(v)
+			if e != nil {
+				return nil, fmt.Errorf("不宣告的网段错误 %s: %w", v, e)
+			}
+			f.nets = append(f.nets, ipNet)
+			continue
+		}
+		addr, e := ma.NewMultiaddr(v)
+		if e != nil {
+			return nil, fmt.Errorf("不宣告的地址错误 %s: %w", v, e)
+		}
+		f.exclude = append(f.exclude, addr)
+	}
+	return f.apply, nil
+}
+
+func (f *announceFilter) apply(addrs []ma.Multiaddr) []ma.Multiaddr {
+	detected := len(f.announce) == 0
+	if !detected {
+		addrs = f.announce
+	}
+	filtered := make([]ma.Multiaddr, 0, len(addrs))
+	for _, addr := range addrs {
+		if detected && !f.allowPrivate && !routableAddr(addr) {
+			continue
+		}
+		if !f.excluded(addr) {
+			filtered = append(filtered, addr)
+		}
+	}
+	return filtered
+}
+
+// excluded 判断地址是否匹配no_announce
+func (f *announceFilter) excluded(addr ma.Multiaddr) bool {
+	s := addr.String()
+	for _, prefix := range f.exclude {
+		if s == prefix.String() || strings.HasPrefix(s, prefix.String()+"/") {
+			return true
+		}
+	}
+	if len(f.nets) == 0 {
+		return false
+	}
+	ip, e := manet.ToIP(addr)
+	if e != nil {
+		return false
+	}
+	for _, ipNet := range f.nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// routableAddr 判断地址是否可以从公网连接, 域名地址总是保留
+func routableAddr(addr ma.Multiaddr) bool {
+	switch addr.Protocols()[0].Code {
+	case ma.P_DNS, ma.P_DNS4, ma.P_DNS6, ma.P_DNSADDR:
+		return true
+	}
+	return manet.IsPublicAddr(addr)
+}
@@ -0,0 +1,42 @@
+package bootstrap
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+)
+
+// autonatConfig 是AutoNAT服务的设置. 公网节点为其他节点回拨, 告诉对方是否可以从外部连接.
+type autonatConfig struct {
+	// Service 为其他节点提供AutoNAT回拨
+	Service bool `yaml:"service"`
+	// GlobalLimit 是每个间隔内回拨的总次数上限, 0表示不限制
+	GlobalLimit int `yaml:"global_limit"`
+	// PeerLimit 是每个间隔内为同一节点回拨的次数上限
+	PeerLimit int `yaml:"peer_limit"`
+	// Interval 是限制的统计间隔
+	Interval time.Duration `yaml:"interval"`
+}
+
+// validate 检查AutoNAT服务设置
+func (c autonatConfig) validate() error {
+	if !c.Service {
+		return nil
+	}
+	if c.GlobalLimit < 0 || c.PeerLimit <= 0 || c.Interval <= 0 {
+		return fmt.Errorf("AutoNAT服务限制错误: global_limit %d, peer_limit %d, interval %s", c.GlobalLimit, c.PeerLimit, c.Interval)
+	}
+	return nil
+}
+
+// options 返回启用AutoNAT服务的选项
+func (c autonatConfig) options() []libp2p.Option {
+	if !c.Service {
+		return nil
+	}
+	return []libp2p.Option{
+		libp2p.EnableNATService(),
+		libp2p.AutoNATServiceRateLimit(c.GlobalLimit, c.PeerLimit, c.Interval),
+	}
+}
@@ -0,0 +1,279 @@
+package bootstrap
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// banFileName 是数据目录中保存封禁列表的文件
+const banFileName = "bans.json"
+
+// banCleanInterval 是清理过期封禁的间隔
+const banCleanInterval = time.Minute
+
+// banEntry 是一条封禁, Target是节点ID, IP或CIDR.
+// Origin和Signature是从其他可信节点同步的封禁的来源节点ID和签名, 本节点的封禁为空
+type banEntry struct {
+	Target    string    `json:"target"`
+	Until     time.Time `json:"until"`
+	Reason    string    `json:"reason,omitempty"`
+	Origin    string    `json:"origin,omitempty"`
+	Signature []byte    `json:"signature,omitempty"`
+}
+
+// bannedNet 是被封禁的网段
+type bannedNet struct {
+	ipNet *net.IPNet
+	until time.Time
+}
+
+// banList 是有期限的封禁列表, 由连接过滤器执行, 保存在数据目录中, 到期后自动解除.
+// 长期的封禁使用访问控制列表.
+type banList struct {
+	path string
+
+	mu      sync.RWMutex
+	entries map[string]banEntry
+	peers   map[peer.ID]time.Time
+	nets    []bannedNet
+
+	rejected uint64
+	// changed 在本节点增加封禁时收到通知, 用于立即同步
+	changed chan struct{}
+}
+
+// loadBanList 读取封禁列表, 文件不存在时列表为空, 已过期的封禁被忽略
+func loadBanList(dir string) (*banList, error) {
+	b := &banList{path: filepath.Join(dir, banFileName), entries: make(map[string]banEntry), changed: make(chan struct{}, 1)}
+	data, e := ioutil.ReadFile(b.path)
+	if e != nil && !os.IsNotExist(e) {
+		return nil, e
+	}
+	if e == nil {
+		var entries []banEntry
+		if e := json.Unmarshal(data, &entries); e != nil {
+			return nil, fmt.Errorf("封禁列表格式错误 %s: %w", b.path, e)
+		}
+		now := time.Now()
+		for _, entry := range entries {
+			if entry.Until.After(now) {
+				b.entries[entry.Target] = entry
+			}
+		}
+	}
+	if e := b.compile(); e != nil {
+		return nil, e
+	}
+	return b, nil
+}
+
+// compile 从entries生成查询用的索引, 调用时持有写锁或还没有共享
+func (b *banList) compile() error {
+	peers := make(map[peer.ID]time.Time)
+	var nets []bannedNet
+	for _, entry := range b.entries {
+		id, ipNet, e := parseACLEntry(entry.Target)
+		if e != nil {
+			return e
+		}
+		if ipNet != nil {
+			nets = append(nets, bannedNet{ipNet: ipNet, until: entry.Until})
+		} else {
+			peers[id] = entry.Until
+		}
+	}
+	b.peers, b.nets = peers, nets
+	return nil
+}
+
+// save 把未过期的封禁写入文件
+func (b *banList) save() error {
+	list := make([]banEntry, 0, len(b.entries))
+	for _, entry := range b.entries {
+		list = append(list, entry)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Target < list[j].Target })
+	data, e := json.MarshalIndent(list, "", "  ")
+	if e != nil {
+		return e
+	}
+	tmp := b.path + ".tmp"
+	if e := ioutil.WriteFile(tmp, data, 0600); e != nil {
+		return e
+	}
+	return os.Rename(tmp, b.path)
+}
+
+// Ban 封禁target到ttl之后, 已封禁时更新期限
+func (b *banList) Ban(target string, ttl time.Duration, reason string) (banEntry, error) {
+	if ttl <= 0 {
+		return banEntry{}, fmt.Errorf("封禁时间必须大于0: %s", ttl)
+	}
+	if _, _, e := parseACLEntry(target); e != nil {
+		return banEntry{}, e
+	}
+	entry := banEntry{Target: target, Until: time.Now().Add(ttl).Truncate(time.Second), Reason: reason}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.entries[target] = entry
+	if e := b.compile(); e != nil {
+		return banEntry{}, e
+	}
+	select {
+	case b.changed <- struct{}{}:
+	default:
+	}
+	return entry, b.save()
+}
+
+// merge 加入从其他节点同步的封禁, 签名已经验证. 没有这条封禁或期限更晚时替换, 返回替换的数量
+func (b *banList) merge(entries []banEntry) (int, error) {
+	now := time.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	merged := 0
+	for _, entry := range entries {
+		if !entry.Until.After(now) {
+			continue
+		}
+		if _, _, e := parseACLEntry(entry.Target); e != nil {
+			continue
+		}
+		if existing, ok := b.entries[entry.Target]; ok && !entry.Until.After(existing.Until) {
+			continue
+		}
+		b.entries[entry.Target] = entry
+		merged++
+	}
+	if merged == 0 {
+		return 0, nil
+	}
+	if e := b.compile(); e != nil {
+		return merged, e
+	}
+	return merged, b.save()
+}
+
+// Unban 解除封禁, 返回是否有这条封禁
+func (b *banList) Unban(target string) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.entries[target]; !ok {
+		return false, nil
+	}
+	delete(b.entries, target)
+	if e := b.compile(); e != nil {
+		return true, e
+	}
+	return true, b.save()
+}
+
+// List 返回未过期的封禁, 按到期时间排序
+func (b *banList) List() []banEntry {
+	now := time.Now()
+	b.mu.RLock()
+	list := make([]banEntry, 0, len(b.entries))
+	for _, entry := range b.entries {
+		if entry.Until.After(now) {
+			list = append(list, entry)
+		}
+	}
+	b.mu.RUnlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Until.Before(list[j].Until) })
+	return list
+}
+
+// banned 判断节点ID或地址是否被封禁, p为空或addr为nil时不判断对应部分
+func (b *banList) banned(p peer.ID, addr ma.Multiaddr) bool {
+	now := time.Now()
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if until, ok := b.peers[p]; ok && p != "" && until.After(now) {
+		atomic.AddUint64(&b.rejected, 1)
+		return true
+	}
+	if addr == nil || len(b.nets) == 0 {
+		return false
+	}
+	ip, e := manet.ToIP(addr)
+	if e != nil {
+		return false
+	}
+	for _, n := range b.nets {
+		if n.until.After(now) && n.ipNet.Contains(ip) {
+			atomic.AddUint64(&b.rejected, 1)
+			return true
+		}
+	}
+	return false
+}
+
+// closeBanned 关闭被封禁的已有连接, 返回关闭的连接数量
+func (b *banList) closeBanned(n network.Network) int {
+	closed := 0
+	for _, c := range n.Conns() {
+		if b.banned(c.RemotePeer(), c.RemoteMultiaddr()) {
+			_ = c.Close()
+			closed++
+		}
+	}
+	return closed
+}
+
+// run 定期删除过期的封禁并保存
+func (b *banList) run(ctx context.Context) {
+	ticker := time.NewTicker(banCleanInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			b.mu.Lock()
+			expired := 0
+			for target, entry := range b.entries {
+				if !entry.Until.After(now) {
+					delete(b.entries, target)
+					expired++
+				}
+			}
+			var e error
+			if expired > 0 {
+				if e = b.compile(); e == nil {
+					e = b.save()
+				}
+			}
+			b.mu.Unlock()
+			if e != nil {
+				logger.Warnw("保存封禁列表出错", "error", e)
+			} else if expired > 0 {
+				logger.Infow("封禁已到期", "count", expired)
+			}
+		}
+	}
+}
+
+// status 返回封禁数量和拒绝次数
+func (b *banList) status() interface{} {
+	b.mu.RLock()
+	count := len(b.entries)
+	b.mu.RUnlock()
+	return map[string]interface{}{
+		"bans":     count,
+		"rejected": atomic.LoadUint64(&b.rejected),
+	}
+}
@@ -0,0 +1,183 @@
+package bootstrap
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	flow "github.com/libp2p/go-flow-metrics"
+	"github.com/libp2p/go-libp2p/core/metrics"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// bandwidthCounter 在总流量之外按连接方向分别统计流量.
+// 入站连接上的流量是本节点为其他节点提供的服务, 出站连接上的流量是本节点自己的需求.
+// libp2p报告流量时只有节点ID, 没有流和连接, 所以按节点区分: 与节点有多个连接时都按第一个连接的方向统计.
+type bandwidthCounter struct {
+	*metrics.BandwidthCounter
+
+	mu  sync.RWMutex
+	net network.Network
+
+	inboundIn, inboundOut   flow.Meter
+	outboundIn, outboundOut flow.Meter
+}
+
+func newBandwidthCounter() *bandwidthCounter {
+	return &bandwidthCounter{BandwidthCounter: metrics.NewBandwidthCounter()}
+}
+
+// setNetwork 设置用于判断连接方向的网络, 主机创建后调用
+func (bwc *bandwidthCounter) setNetwork(n network.Network) {
+	bwc.mu.Lock()
+	bwc.net = n
+	bwc.mu.Unlock()
+}
+
+// direction 返回与节点之间连接的方向, 多个连接时取第一个, 不一定是传输这些数据的连接
+func (bwc *bandwidthCounter) direction(p peer.ID) network.Direction {
+	bwc.mu.RLock()
+	n := bwc.net
+	bwc.mu.RUnlock()
+	if n == nil {
+		return network.DirUnknown
+	}
+	conns := n.ConnsToPeer(p)
+	if len(conns) == 0 {
+		return network.DirUnknown
+	}
+	return conns[0].Stat().Direction
+}
+
+func (bwc *bandwidthCounter) LogSentMessageStream(size int64, proto protocol.ID, p peer.ID) {
+	bwc.BandwidthCounter.LogSentMessageStream(size, proto, p)
+	switch bwc.direction(p) {
+	case network.DirInbound:
+		bwc.inboundOut.Mark(uint64(size))
+	case network.DirOutbound:
+		bwc.outboundOut.Mark(uint64(size))
+	}
+}
+
+func (bwc *bandwidthCounter) LogRecvMessageStream(size int64, proto protocol.ID, p peer.ID) {
+	bwc.BandwidthCounter.LogRecvMessageStream(size, proto, p)
+	switch bwc.direction(p) {
+	case network.DirInbound:
+		bwc.inboundIn.Mark(uint64(size))
+	case network.DirOutbound:
+		bwc.outboundIn.Mark(uint64(size))
+	}
+}
+
+// GetBandwidthByDirection 返回入站连接和出站连接上的流量
+func (bwc *bandwidthCounter) GetBandwidthByDirection() (inbound, outbound metrics.Stats) {
+	return meterStats(&bwc.inboundIn, &bwc.inboundOut), meterStats(&bwc.outboundIn, &bwc.outboundOut)
+}
+
+func meterStats(in, out *flow.Meter) metrics.Stats {
+	inSnapshot := in.Snapshot()
+	outSnapshot := out.Snapshot()
+	return metrics.Stats{
+		TotalIn:  int64(inSnapshot.Total),
+		TotalOut: int64(outSnapshot.Total),
+		RateIn:   inSnapshot.Rate,
+		RateOut:  outSnapshot.Rate,
+	}
+}
+
+// bandwidthStatus 是流量统计在 /status 中的形式
+type bandwidthStatus struct {
+	TotalIn  int64   `json:"total_in"`
+	TotalOut int64   `json:"total_out"`
+	RateIn   float64 `json:"rate_in"`
+	RateOut  float64 `json:"rate_out"`
+}
+
+func newBandwidthStatus(s metrics.Stats) bandwidthStatus {
+	return bandwidthStatus{TotalIn: s.TotalIn, TotalOut: s.TotalOut, RateIn: s.RateIn, RateOut: s.RateOut}
+}
+
+// status 返回总流量和按方向区分的流量
+func (bwc *bandwidthCounter) status() interface{} {
+	inbound, outbound := bwc.GetBandwidthByDirection()
+	return map[string]bandwidthStatus{
+		"total":    newBandwidthStatus(bwc.GetBandwidthTotals()),
+		"inbound":  newBandwidthStatus(inbound),
+		"outbound": newBandwidthStatus(outbound),
+	}
+}
+
+// bandwidthTrimInterval 是清理空闲节点和协议流量统计的间隔, 超过此时间没有流量的节点不再保留
+const bandwidthTrimInterval = time.Hour
+
+// defaultBandwidthPeers 是 /bandwidth 默认返回的节点数量
+const defaultBandwidthPeers = 100
+
+// run 定期清理空闲的统计, 避免断开的节点一直占用内存
+func (bwc *bandwidthCounter) run(ctx context.Context) {
+	ticker := time.NewTicker(bandwidthTrimInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			bwc.TrimIdle(time.Now().Add(-bandwidthTrimInterval))
+		}
+	}
+}
+
+// peerBandwidth 是一个节点的流量
+type peerBandwidth struct {
+	ID string `json:"id"`
+	bandwidthStatus
+}
+
+// bandwidthDump 是 /bandwidth 的内容
+type bandwidthDump struct {
+	Total     bandwidthStatus            `json:"total"`
+	Protocols map[string]bandwidthStatus `json:"protocols"`
+	// Peers 按总流量从大到小排列
+	Peers []peerBandwidth `json:"peers"`
+}
+
+// dump 返回按协议和按节点的流量, 最多返回limit个节点
+func (bwc *bandwidthCounter) dump(limit int) bandwidthDump {
+	d := bandwidthDump{Total: newBandwidthStatus(bwc.GetBandwidthTotals()), Protocols: make(map[string]bandwidthStatus)}
+	for proto, stats := range bwc.GetBandwidthByProtocol() {
+		d.Protocols[string(proto)] = newBandwidthStatus(stats)
+	}
+	for p, stats := range bwc.GetBandwidthByPeer() {
+		d.Peers = append(d.Peers, peerBandwidth{ID: p.String(), bandwidthStatus: newBandwidthStatus(stats)})
+	}
+	sort.Slice(d.Peers, func(i, j int) bool {
+		return d.Peers[i].TotalIn+d.Peers[i].TotalOut > d.Peers[j].TotalIn+d.Peers[j].TotalOut
+	})
+	if len(d.Peers) > limit {
+		d.Peers = d.Peers[:limit]
+	}
+	return d
+}
+
+// ServeHTTP 以JSON形式提供按协议和按节点的流量, ?peers=N 设置返回的节点数量
+func (bwc *bandwidthCounter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	limit := defaultBandwidthPeers
+	if v := r.URL.Query().Get("peers"); v != "" {
+		n, e := strconv.Atoi(v)
+		if e != nil || n < 0 {
+			http.Error(w, "peers参数错误", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if e := json.NewEncoder(w).Encode(bwc.dump(limit)); e != nil {
+		logger.Warnw("输出流量出错", "error", e)
+	}
+}
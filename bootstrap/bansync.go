@@ -0,0 +1,286 @@
+package bootstrap
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// banSyncProtocol 是可信节点之间同步封禁的协议.
+// 发起方打开流后发送自己的封禁, 关闭写入后读取对方的封禁, 双方合并收到的封禁.
+const banSyncProtocol protocol.ID = "/bootstrap/bans/1.0.0"
+
+// banSyncTimeout 是一次同步的超时时间
+const banSyncTimeout = time.Second * 30
+
+// banSyncConfig 是封禁同步的设置. 可信节点互相推送和拉取有期限的封禁,
+// 每条封禁由设置它的节点签名, 经过其他节点转发时仍然可以验证来源
+type banSyncConfig struct {
+	// Peers 是可信节点的节点ID或带/p2p的地址, 只接受这些节点签名的封禁
+	Peers []string `yaml:"peers"`
+	// Interval 是与每个可信节点同步的间隔, 本节点增加封禁时立即同步
+	Interval time.Duration `yaml:"interval"`
+}
+
+// validate 检查封禁同步设置
+func (c banSyncConfig) validate() error {
+	if len(c.Peers) == 0 {
+		return nil
+	}
+	if c.Interval <= 0 {
+		return fmt.Errorf("封禁同步间隔必须大于0: %s", c.Interval)
+	}
+	_, e := c.trusted()
+	return e
+}
+
+// trusted 解析可信节点
+func (c banSyncConfig) trusted() ([]peer.AddrInfo, error) {
+	trusted, e := parsePeerRefs(c.Peers)
+	if e != nil {
+		return nil, fmt.Errorf("封禁同步可信节点错误: %w", e)
+	}
+	return trusted, nil
+}
+
+// banSyncMessage 是双方发送的封禁列表
+type banSyncMessage struct {
+	Entries []banEntry `json:"entries"`
+}
+
+// banSigningData 返回封禁签名的内容, 包括协议, 来源, 目标, 期限和原因
+func banSigningData(origin string, entry banEntry) []byte {
+	return []byte(fmt.Sprintf("%s\n%s\n%s\n%d\n%s", banSyncProtocol, origin, entry.Target, entry.Until.Unix(), entry.Reason))
+}
+
+// banSyncer 与可信节点同步封禁
+type banSyncer struct {
+	h       host.Host
+	bans    *banList
+	cfg     banSyncConfig
+	peers   []peer.AddrInfo
+	allow   map[peer.ID]bool
+	limiter *messageLimiter
+
+	mu      sync.Mutex
+	lastErr map[peer.ID]string
+	synced  map[peer.ID]time.Time
+
+	received uint64
+	merged   uint64
+	invalid  uint64
+}
+
+func newBanSyncer(h host.Host, bans *banList, cfg banSyncConfig, peers []peer.AddrInfo, limiter *messageLimiter) *banSyncer {
+	allow := make(map[peer.ID]bool, len(peers))
+	for _, p := range peers {
+		allow[p.ID] = true
+	}
+	return &banSyncer{h: h, bans: bans, cfg: cfg, peers: peers, allow: allow, limiter: limiter, lastErr: make(map[peer.ID]string), synced: make(map[peer.ID]time.Time)}
+}
+
+// protect 保护可信节点的连接, 并把配置的地址加入地址簿
+func (s *banSyncer) protect(protector *peerProtector) {
+	for _, p := range s.peers {
+		if len(p.Addrs) > 0 {
+			s.h.Peerstore().AddAddrs(p.ID, p.Addrs, s.cfg.Interval*2)
+		}
+		protector.Protect(p.ID, protectTagBanSync)
+	}
+}
+
+// outgoing 返回要发送的封禁: 本节点的封禁用本节点的私钥签名, 同步来的封禁原样转发
+func (s *banSyncer) outgoing() ([]banEntry, error) {
+	key := s.h.Peerstore().PrivKey(s.h.ID())
+	if key == nil {
+		return nil, errors.New("没有本节点的私钥")
+	}
+	self := s.h.ID().String()
+	list := s.bans.List()
+	for i, entry := range list {
+		if entry.Origin != "" {
+			continue
+		}
+		signature, e := key.Sign(banSigningData(self, entry))
+		if e != nil {
+			return nil, e
+		}
+		list[i].Origin, list[i].Signature = self, signature
+	}
+	return list, nil
+}
+
+// verify 返回签名正确且来源是可信节点的封禁, 本节点签名的封禁已经有了, 不再加入
+func (s *banSyncer) verify(entries []banEntry) []banEntry {
+	var valid []banEntry
+	for _, entry := range entries {
+		origin, e := peer.Decode(entry.Origin)
+		if e == nil && origin == s.h.ID() {
+			continue
+		}
+		if e != nil || !s.allow[origin] {
+			atomic.AddUint64(&s.invalid, 1)
+			continue
+		}
+		pub, e := origin.ExtractPublicKey()
+		if e != nil {
+			pub = s.h.Peerstore().PubKey(origin)
+		}
+		if pub == nil {
+			atomic.AddUint64(&s.invalid, 1)
+			continue
+		}
+		if ok, e := pub.Verify(banSigningData(entry.Origin, entry), entry.Signature); e != nil || !ok {
+			atomic.AddUint64(&s.invalid, 1)
+			continue
+		}
+		valid = append(valid, entry)
+	}
+	return valid
+}
+
+// apply 验证并合并收到的封禁, 关闭新封禁的已有连接
+func (s *banSyncer) apply(from peer.ID, entries []banEntry) {
+	atomic.AddUint64(&s.received, uint64(len(entries)))
+	merged, e := s.bans.merge(s.verify(entries))
+	if e != nil {
+		logger.Warnw("保存同步的封禁出错", "peer", from, "error", e)
+	}
+	if merged == 0 {
+		return
+	}
+	atomic.AddUint64(&s.merged, uint64(merged))
+	closed := s.bans.closeBanned(s.h.Network())
+	logger.Infow("同步封禁", "peer", from, "merged", merged, "closed", closed)
+}
+
+func (s *banSyncer) handle(st network.Stream) {
+	remote := st.Conn().RemotePeer()
+	if !s.allow[remote] {
+		logger.Warnw("拒绝非可信节点同步封禁", "peer", remote)
+		_ = st.Reset()
+		return
+	}
+	_ = st.SetDeadline(time.Now().Add(banSyncTimeout))
+	var msg banSyncMessage
+	if e := json.NewDecoder(s.limiter.Reader(st)).Decode(&msg); e != nil {
+		logger.Warnw("读取同步的封禁出错", "peer", remote, "error", e)
+		_ = st.Reset()
+		return
+	}
+	entries, e := s.outgoing()
+	if e != nil {
+		_ = st.Reset()
+		return
+	}
+	if e := json.NewEncoder(st).Encode(banSyncMessage{Entries: entries}); e != nil {
+		_ = st.Reset()
+		return
+	}
+	_ = st.Close()
+	s.apply(remote, msg.Entries)
+	s.record(remote, nil)
+}
+
+// sync 与一个可信节点交换封禁
+func (s *banSyncer) sync(ctx context.Context, id peer.ID) error {
+	ctx, cancel := context.WithTimeout(ctx, banSyncTimeout)
+	defer cancel()
+	entries, e := s.outgoing()
+	if e != nil {
+		return e
+	}
+	if e := s.h.Connect(ctx, peer.AddrInfo{ID: id}); e != nil {
+		return e
+	}
+	st, e := s.h.NewStream(ctx, id, banSyncProtocol)
+	if e != nil {
+		return e
+	}
+	defer st.Close()
+	_ = st.SetDeadline(time.Now().Add(banSyncTimeout))
+	if e := json.NewEncoder(st).Encode(banSyncMessage{Entries: entries}); e != nil {
+		_ = st.Reset()
+		return e
+	}
+	if e := st.CloseWrite(); e != nil {
+		_ = st.Reset()
+		return e
+	}
+	var msg banSyncMessage
+	if e := json.NewDecoder(s.limiter.Reader(st)).Decode(&msg); e != nil {
+		_ = st.Reset()
+		return e
+	}
+	s.apply(id, msg.Entries)
+	return nil
+}
+
+// syncAll 同时与所有可信节点同步
+func (s *banSyncer) syncAll(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, p := range s.peers {
+		wg.Add(1)
+		go func(id peer.ID) {
+			defer wg.Done()
+			e := s.sync(ctx, id)
+			if e != nil && ctx.Err() == nil {
+				logger.Warnw("同步封禁出错", "peer", id, "error", e)
+			}
+			s.record(id, e)
+		}(p.ID)
+	}
+	wg.Wait()
+}
+
+// record 记录与节点最近一次同步的结果
+func (s *banSyncer) record(id peer.ID, e error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e != nil {
+		s.lastErr[id] = e.Error()
+		return
+	}
+	delete(s.lastErr, id)
+	s.synced[id] = time.Now()
+}
+
+// run 立即同步一次, 之后每Interval或本节点增加封禁时同步
+func (s *banSyncer) run(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		s.syncAll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.bans.changed:
+		}
+	}
+}
+
+// status 返回每个可信节点最近一次同步的时间和错误, 以及收到, 合并和签名无效的封禁数量
+func (s *banSyncer) status() interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	peers := make(map[string]interface{}, len(s.peers))
+	for _, p := range s.peers {
+		peers[p.ID.String()] = map[string]interface{}{"synced": s.synced[p.ID], "error": s.lastErr[p.ID]}
+	}
+	return map[string]interface{}{
+		"peers":    peers,
+		"received": atomic.LoadUint64(&s.received),
+		"merged":   atomic.LoadUint64(&s.merged),
+		"invalid":  atomic.LoadUint64(&s.invalid),
+	}
+}
@@ -0,0 +1,378 @@
+package bootstrap
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/pnet"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	swarm "github.com/libp2p/go-libp2p/p2p/net/swarm"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// benchConfig 是性能测试的设置
+type benchConfig struct {
+	// Handshakes 是每种传输建立连接的次数, 每次连接后断开
+	Handshakes int
+	// Conns 是同时建立连接的临时节点数量
+	Conns int
+	// Hold 是同时连接后等待的时间, 之后统计仍然保持的连接
+	Hold time.Duration
+	// Queries 和 Concurrency 是FIND_NODE请求的总数和同时进行的数量
+	Queries     int
+	Concurrency int
+	Timeout     time.Duration
+}
+
+// latencyStats 是一组延迟的统计
+type latencyStats struct {
+	samples  []time.Duration
+	failures int
+	errors   map[string]int
+	elapsed  time.Duration
+}
+
+func (s *latencyStats) add(d time.Duration, e error) {
+	if e != nil {
+		s.failures++
+		if s.errors == nil {
+			s.errors = make(map[string]int)
+		}
+		s.errors[e.Error()]++
+		return
+	}
+	s.samples = append(s.samples, d)
+}
+
+// percentile 返回第p百分位的延迟, 使用最近秩法
+func (s *latencyStats) percentile(p float64) time.Duration {
+	if len(s.samples) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), s.samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	i := int(p/100*float64(len(sorted))+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
+
+// write 输出一行统计: 成功, 失败, 最小, p50, p90, p99, 最大, 每秒完成数量
+func (s *latencyStats) write(w io.Writer, name string) {
+	rate := 0.0
+	if s.elapsed > 0 {
+		rate = float64(len(s.samples)) / s.elapsed.Seconds()
+	}
+	fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\t%s\t%s\t%s\t%.1f/s\n", name, len(s.samples), s.failures,
+		benchRound(s.percentile(0)), benchRound(s.percentile(50)), benchRound(s.percentile(90)), benchRound(s.percentile(99)), benchRound(s.percentile(100)), rate)
+}
+
+// writeErrors 输出出现最多的几种错误
+func (s *latencyStats) writeErrors(w io.Writer, name string) {
+	type count struct {
+		e string
+		n int
+	}
+	counts := make([]count, 0, len(s.errors))
+	for e, n := range s.errors {
+		counts = append(counts, count{e, n})
+	}
+	sort.Slice(counts, func(i, j int) bool { return counts[i].n > counts[j].n })
+	for i, c := range counts {
+		if i == 3 {
+			break
+		}
+		fmt.Fprintf(w, "  %s 失败 %d 次: %s\n", name, c.n, c.e)
+	}
+}
+
+func benchRound(d time.Duration) time.Duration {
+	return d.Round(time.Microsecond * 100)
+}
+
+// runBench 测试节点的握手延迟, 同时连接的能力和DHT查询延迟, 用于评估引导节点需要的硬件
+func runBench(args []string) int {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	target := fs.String("target", "", "测试的节点地址(带/p2p/节点ID或是/dnsaddr), 为空时测试本机运行中的节点")
+	var bc benchConfig
+	fs.IntVar(&bc.Handshakes, "handshakes", 50, "每种传输建立连接的次数")
+	fs.IntVar(&bc.Conns, "conns", 100, "同时建立连接的临时节点数量, 0表示不测试")
+	fs.DurationVar(&bc.Hold, "hold", time.Second*5, "同时连接后等待的时间, 之后统计仍然保持的连接")
+	fs.IntVar(&bc.Queries, "queries", 200, "FIND_NODE请求的数量, 0表示不测试")
+	fs.IntVar(&bc.Concurrency, "concurrency", 10, "同时进行的FIND_NODE请求数量")
+	fs.DurationVar(&bc.Timeout, "timeout", time.Second*10, "每次连接和请求的超时时间")
+	cfg, e := commandConfig(fs, args)
+	if e != nil {
+		logger.Errorw("读取设置出错", "error", e)
+		return 1
+	}
+	if bc.Handshakes < 0 || bc.Conns < 0 || bc.Queries < 0 || bc.Concurrency < 1 || bc.Timeout <= 0 {
+		logger.Errorw("测试次数不能小于0, concurrency至少为1, timeout必须大于0", "handshakes", bc.Handshakes, "conns", bc.Conns, "queries", bc.Queries, "concurrency", bc.Concurrency, "timeout", bc.Timeout)
+		return 2
+	}
+	var psk pnet.PSK
+	if cfg.SwarmKey != "" {
+		if psk, e = loadSwarmKey(cfg.SwarmKey); e != nil {
+			logger.Errorw("读取私有网络密钥出错", "error", e)
+			return 1
+		}
+	}
+	ctx := context.Background()
+	info, e := benchTarget(ctx, cfg, *target, bc.Timeout)
+	if e != nil {
+		logger.Errorw("读取测试目标出错", "error", e)
+		return 1
+	}
+	set := cfg.transportSet(cfg.Transports.QUIC && len(psk) == 0, psk, nil, nil)
+	set.WS = true
+	options := append([]libp2p.Option{libp2p.NoListenAddrs}, transportOptions(set)...)
+	if e := bench(ctx, os.Stdout, info, options, cfg.DHT.protocolID(), bc); e != nil {
+		logger.Errorw("测试出错", "error", e)
+		return 1
+	}
+	return 0
+}
+
+// benchTarget 返回测试的节点. 没有指定地址时通过管理接口读取运行中的节点, 本机地址优先
+func benchTarget(ctx context.Context, cfg *Config, target string, timeout time.Duration) (peer.AddrInfo, error) {
+	var addrs []ma.Multiaddr
+	if target != "" {
+		addr, e := ma.NewMultiaddr(target)
+		if e != nil {
+			return peer.AddrInfo{}, fmt.Errorf("地址格式错误: %w", e)
+		}
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		if addrs, e = resolveAddr(ctx, addr, 0); e != nil {
+			return peer.AddrInfo{}, fmt.Errorf("解析地址出错: %w", e)
+		}
+	} else {
+		id, e := runningNodeID(cfg)
+		if e != nil {
+			return peer.AddrInfo{}, fmt.Errorf("读取运行中的节点出错, 用 -target 指定节点地址: %w", e)
+		}
+		for _, s := range id.Addrs {
+			if addr, e := ma.NewMultiaddr(s); e == nil {
+				addrs = append(addrs, addr)
+			}
+		}
+		// 宣告的地址中可能没有本机地址, 从监听地址补充
+		if _, listen, e := localAddrs(cfg); e == nil {
+			p2p := ma.StringCast("/p2p/" + id.ID)
+			for _, addr := range listen {
+				addrs = append(addrs, addr.Encapsulate(p2p))
+			}
+		}
+	}
+	infos, e := peer.AddrInfosFromP2pAddrs(addrs...)
+	if e != nil {
+		return peer.AddrInfo{}, e
+	}
+	if len(infos) != 1 {
+		return peer.AddrInfo{}, fmt.Errorf("地址中有%d个节点, 只能测试一个节点", len(infos))
+	}
+	info := infos[0]
+	if target == "" {
+		var loopback []ma.Multiaddr
+		for _, addr := range info.Addrs {
+			if manet.IsIPLoopback(addr) {
+				loopback = append(loopback, addr)
+			}
+		}
+		if len(loopback) > 0 {
+			info.Addrs = loopback
+		}
+	}
+	return info, nil
+}
+
+// bench 依次测试握手延迟, 同时连接和DHT查询, 输出结果
+func bench(ctx context.Context, w io.Writer, info peer.AddrInfo, options []libp2p.Option, proto protocol.ID, bc benchConfig) error {
+	h, e := libp2p.New(options...)
+	if e != nil {
+		return e
+	}
+	defer h.Close()
+	fmt.Fprintln(w, "===== 性能测试 =====")
+	fmt.Fprintln(w, "节点:", info.ID)
+
+	transports := make(map[string][]ma.Multiaddr)
+	var names []string
+	for _, addr := range info.Addrs {
+		name := addrTransport(addr)
+		if _, ok := transports[name]; !ok {
+			names = append(names, name)
+		}
+		transports[name] = append(transports[name], addr)
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		return errors.New("节点没有可以连接的地址")
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "测试\t成功\t失败\t最小\tP50\tP90\tP99\t最大\t速率")
+	var failed []func()
+	for _, name := range names {
+		name := name
+		stats := benchHandshakes(ctx, h, peer.AddrInfo{ID: info.ID, Addrs: transports[name]}, bc)
+		stats.write(tw, "握手 "+name)
+		failed = append(failed, func() { stats.writeErrors(w, "握手 "+name) })
+	}
+	var held, conns int
+	if bc.Conns > 0 {
+		stats, remaining, e := benchConns(ctx, info, options, bc)
+		if e != nil {
+			return e
+		}
+		stats.write(tw, fmt.Sprint("同时连接 ", bc.Conns))
+		failed = append(failed, func() { stats.writeErrors(w, "同时连接") })
+		held, conns = remaining, len(stats.samples)
+	}
+	if bc.Queries > 0 {
+		stats, e := benchQueries(ctx, h, info, proto, bc)
+		if e != nil {
+			return e
+		}
+		stats.write(tw, "FIND_NODE")
+		failed = append(failed, func() { stats.writeErrors(w, "FIND_NODE") })
+	}
+	_ = tw.Flush()
+	if bc.Conns > 0 {
+		fmt.Fprintf(w, "同时连接: 成功 %d 个, %s 后仍然保持 %d 个\n", conns, bc.Hold, held)
+	}
+	for _, fn := range failed {
+		fn()
+	}
+	return nil
+}
+
+// benchHandshakes 多次连接并断开, 统计从拨号到完成安全握手和多路复用协商的时间
+func benchHandshakes(ctx context.Context, h host.Host, info peer.AddrInfo, bc benchConfig) *latencyStats {
+	stats := &latencyStats{}
+	start := time.Now()
+	for i := 0; i < bc.Handshakes; i++ {
+		_ = h.Network().ClosePeer(info.ID)
+		h.Peerstore().ClearAddrs(info.ID)
+		clearDialBackoff(h, info.ID)
+		stats.add(timedConnect(ctx, h, info, bc.Timeout))
+	}
+	_ = h.Network().ClosePeer(info.ID)
+	stats.elapsed = time.Since(start)
+	return stats
+}
+
+// clearDialBackoff 清除拨号失败后的退避, 否则之后的拨号会直接失败
+func clearDialBackoff(h host.Host, id peer.ID) {
+	if s, ok := h.Network().(*swarm.Swarm); ok {
+		s.Backoff().Clear(id)
+	}
+}
+
+// timedConnect 连接节点并返回用时
+func timedConnect(ctx context.Context, h host.Host, info peer.AddrInfo, timeout time.Duration) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	start := time.Now()
+	e := h.Connect(ctx, info)
+	return time.Since(start), e
+}
+
+// benchConns 用多个临时节点同时连接, 返回握手延迟和等待bc.Hold后仍然保持的连接数量.
+// 节点的连接管理器和资源限制可能关闭部分连接
+func benchConns(ctx context.Context, info peer.AddrInfo, options []libp2p.Option, bc benchConfig) (*latencyStats, int, error) {
+	hosts := make([]host.Host, 0, bc.Conns)
+	defer func() {
+		for _, h := range hosts {
+			_ = h.Close()
+		}
+	}()
+	for i := 0; i < bc.Conns; i++ {
+		h, e := libp2p.New(options...)
+		if e != nil {
+			return nil, 0, fmt.Errorf("创建临时节点出错: %w", e)
+		}
+		hosts = append(hosts, h)
+	}
+	stats := &latencyStats{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	start := time.Now()
+	for _, h := range hosts {
+		wg.Add(1)
+		go func(h host.Host) {
+			defer wg.Done()
+			d, e := timedConnect(ctx, h, info, bc.Timeout)
+			mu.Lock()
+			stats.add(d, e)
+			mu.Unlock()
+		}(h)
+	}
+	wg.Wait()
+	stats.elapsed = time.Since(start)
+	select {
+	case <-ctx.Done():
+		return nil, 0, ctx.Err()
+	case <-time.After(bc.Hold):
+	}
+	held := 0
+	for _, h := range hosts {
+		if h.Network().Connectedness(info.ID) == network.Connected {
+			held++
+		}
+	}
+	return stats, held, nil
+}
+
+// benchQueries 向节点发送FIND_NODE请求, 统计每次请求的延迟. 请求直接发给节点, 不进行DHT查找
+func benchQueries(ctx context.Context, h host.Host, info peer.AddrInfo, proto protocol.ID, bc benchConfig) (*latencyStats, error) {
+	clearDialBackoff(h, info.ID)
+	if _, e := timedConnect(ctx, h, info, bc.Timeout); e != nil {
+		return nil, fmt.Errorf("连接节点出错: %w", e)
+	}
+	stats := &latencyStats{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	jobs := make(chan struct{})
+	start := time.Now()
+	for i := 0; i < bc.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range jobs {
+				qctx, cancel := context.WithTimeout(ctx, bc.Timeout)
+				begin := time.Now()
+				_, e := requestFindNode(qctx, h, proto, info.ID)
+				d := time.Since(begin)
+				cancel()
+				mu.Lock()
+				stats.add(d, e)
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := 0; i < bc.Queries; i++ {
+		jobs <- struct{}{}
+	}
+	close(jobs)
+	wg.Wait()
+	stats.elapsed = time.Since(start)
+	return stats, nil
+}
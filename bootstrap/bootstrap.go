@@ -0,0 +1,282 @@
+package bootstrap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	madns "github.com/multiformats/go-multiaddr-dns"
+)
+
+// defaultBootstrapPeers 是默认的引导节点
+var defaultBootstrapPeers = []string{
+	"/ip4/104.131.131.82/tcp/4001/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ",
+}
+
+// bootstrapFileName 是引导节点列表文件名, 每行一个地址, #开头的行为注释
+const bootstrapFileName = "bootstrap.txt"
+
+// readBootstrapFile 读取引导节点列表文件, 文件不存在时返回空列表
+func readBootstrapFile(path string) ([]string, error) {
+	b, e := ioutil.ReadFile(path)
+	if os.IsNotExist(e) {
+		return nil, nil
+	}
+	if e != nil {
+		return nil, e
+	}
+	var addrs []string
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		addrs = append(addrs, line)
+	}
+	return addrs, nil
+}
+
+// parseAddrInfos 解析节点地址, 同一节点的多个地址合并
+func parseAddrInfos(addrs []string) ([]peer.AddrInfo, error) {
+	multiAddrs := make([]multiaddr.Multiaddr, 0, len(addrs))
+	for _, addr := range addrs {
+		multiAddr, e := multiaddr.NewMultiaddr(addr)
+		if e != nil {
+			return nil, e
+		}
+		multiAddrs = append(multiAddrs, multiAddr)
+	}
+	return peer.AddrInfosFromP2pAddrs(multiAddrs...)
+}
+
+// parsePeerRefs 解析节点ID或带/p2p的地址, 只有节点ID时没有地址
+func parsePeerRefs(values []string) ([]peer.AddrInfo, error) {
+	infos := make([]peer.AddrInfo, 0, len(values))
+	for _, v := range values {
+		if !strings.HasPrefix(v, "/") {
+			id, e := peer.Decode(v)
+			if e != nil {
+				return nil, fmt.Errorf("节点ID错误 %s: %w", v, e)
+			}
+			infos = append(infos, peer.AddrInfo{ID: id})
+			continue
+		}
+		parsed, e := parseAddrInfos([]string{v})
+		if e != nil {
+			return nil, fmt.Errorf("地址错误 %s: %w", v, e)
+		}
+		infos = append(infos, parsed...)
+	}
+	return infos, nil
+}
+
+// connectTiers 按层级连接引导节点.
+// 同一层级的节点并行连接, 每个节点单独超时, 已连接数量不少于minPeers时不再连接下一层级.
+// 每个节点的连接结果输出日志并记录到dials. 返回成功连接的节点数量.
+func connectTiers(ctx context.Context, h host.Host, tiers [][]peer.AddrInfo, minPeers int, timeout time.Duration, dials *dialStats) int {
+	connected := 0
+	for i, tier := range tiers {
+		n := connectAll(ctx, h, tier, timeout, dials)
+		connected += n
+		logger.Infow("连接引导节点层级", "tier", i+1, "connected", n, "total", len(tier))
+		if connected >= minPeers || ctx.Err() != nil {
+			break
+		}
+	}
+	return connected
+}
+
+// connectAll 并行连接节点, 返回成功连接的数量.
+// dials不为nil时连接的是引导节点, 输出每个节点的结果并记录.
+func connectAll(ctx context.Context, h host.Host, addrInfos []peer.AddrInfo, timeout time.Duration, dials *dialStats) int {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	connected := 0
+	for _, addrInfo := range addrInfos {
+		wg.Add(1)
+		go func(addrInfo peer.AddrInfo) {
+			defer wg.Done()
+			start := time.Now()
+			e := connectPeer(ctx, h, addrInfo, timeout)
+			if dials != nil {
+				dials.record(addrInfo.ID, time.Since(start), e)
+			}
+			if e != nil {
+				if dials != nil {
+					logger.Warnw("连接引导节点失败", "peer", addrInfo.ID, "error", e)
+				} else {
+					logger.Debugw("连接节点失败", "peer", addrInfo.ID, "error", e)
+				}
+				return
+			}
+			if dials != nil {
+				logger.Infow("已连接引导节点", "peer", addrInfo.ID, "elapsed", time.Since(start).Truncate(time.Millisecond))
+			}
+			mu.Lock()
+			connected++
+			mu.Unlock()
+		}(addrInfo)
+	}
+	wg.Wait()
+	return connected
+}
+
+// dnsaddrMaxDepth 是递归解析dnsaddr的最大层数
+const dnsaddrMaxDepth = 4
+
+// resolveTiers 解析各层级中的/dnsaddr, /dns4, /dns6和/dns地址, 解析失败的地址输出日志后忽略.
+// 格式错误的地址保持原样, 由parseTiers报告.
+func resolveTiers(ctx context.Context, tiers [][]string, timeout time.Duration) [][]string {
+	resolved := make([][]string, 0, len(tiers))
+	for _, tier := range tiers {
+		var addrs []string
+		for _, addr := range tier {
+			multiAddr, e := multiaddr.NewMultiaddr(addr)
+			if e != nil || !madns.Matches(multiAddr) {
+				addrs = append(addrs, addr)
+				continue
+			}
+			rc, rcCancel := context.WithTimeout(ctx, timeout)
+			list, e := resolveAddr(rc, multiAddr, 0)
+			rcCancel()
+			if e != nil {
+				logger.Warnw("解析引导节点地址出错", "addr", addr, "error", e)
+				continue
+			}
+			for _, a := range list {
+				if _, e := a.ValueForProtocol(multiaddr.P_P2P); e != nil {
+					logger.Debugw("忽略没有节点ID的解析结果", "addr", addr, "resolved", a)
+					continue
+				}
+				addrs = append(addrs, a.String())
+			}
+		}
+		resolved = append(resolved, addrs)
+	}
+	return resolved
+}
+
+// resolveAddr 解析地址, dnsaddr的TXT记录中还是dnsaddr时继续解析
+func resolveAddr(ctx context.Context, addr multiaddr.Multiaddr, depth int) ([]multiaddr.Multiaddr, error) {
+	if !madns.Matches(addr) {
+		return []multiaddr.Multiaddr{addr}, nil
+	}
+	if depth >= dnsaddrMaxDepth {
+		return nil, fmt.Errorf("dnsaddr超过%d层", dnsaddrMaxDepth)
+	}
+	resolved, e := madns.Resolve(ctx, addr)
+	if e != nil {
+		return nil, e
+	}
+	if len(resolved) == 0 {
+		return nil, errors.New("没有解析结果")
+	}
+	var list []multiaddr.Multiaddr
+	for _, r := range resolved {
+		sub, e := resolveAddr(ctx, r, depth+1)
+		if e != nil {
+			return nil, e
+		}
+		list = append(list, sub...)
+	}
+	return list, nil
+}
+
+// parseTiers 解析各层级的引导节点地址
+func parseTiers(tiers [][]string) ([][]peer.AddrInfo, error) {
+	parsed := make([][]peer.AddrInfo, 0, len(tiers))
+	for _, tier := range tiers {
+		addrInfos, e := parseAddrInfos(tier)
+		if e != nil {
+			return nil, e
+		}
+		parsed = append(parsed, addrInfos)
+	}
+	return parsed, nil
+}
+
+// addBootstrapPeers 把引导节点地址加入地址簿并保护其连接
+func addBootstrapPeers(h host.Host, protector *peerProtector, addrInfos []peer.AddrInfo, ttl time.Duration) {
+	for _, addrInfo := range addrInfos {
+		h.Peerstore().AddAddrs(addrInfo.ID, addrInfo.Addrs, ttl)
+		protector.Protect(addrInfo.ID, protectTagBootstrap)
+	}
+}
+
+// checkBootstrapQuorum 检查启动成功要求连接的引导节点数量
+func checkBootstrapQuorum(quorum int) error {
+	if quorum < 1 {
+		return fmt.Errorf("bootstrap_quorum至少为1: %d", quorum)
+	}
+	return nil
+}
+
+// dialResult 是一个引导节点的连接结果
+type dialResult struct {
+	Successes uint64    `json:"successes"`
+	Failures  uint64    `json:"failures"`
+	LastDial  time.Time `json:"last_dial"`
+	Elapsed   string    `json:"elapsed"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// dialStats 按节点记录连接引导节点的结果, 用于 /status 和 /metrics
+type dialStats struct {
+	mu    sync.Mutex
+	peers map[peer.ID]*dialResult
+}
+
+func newDialStats() *dialStats {
+	return &dialStats{peers: make(map[peer.ID]*dialResult)}
+}
+
+func (d *dialStats) record(p peer.ID, elapsed time.Duration, e error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	r, ok := d.peers[p]
+	if !ok {
+		r = &dialResult{}
+		d.peers[p] = r
+	}
+	r.LastDial = time.Now()
+	r.Elapsed = elapsed.Truncate(time.Millisecond).String()
+	if e != nil {
+		r.Failures++
+		r.LastError = e.Error()
+	} else {
+		r.Successes++
+		r.LastError = ""
+	}
+}
+
+// counts 返回按节点的成功和失败次数
+func (d *dialStats) counts() (successes, failures map[string]uint64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	successes = make(map[string]uint64, len(d.peers))
+	failures = make(map[string]uint64, len(d.peers))
+	for p, r := range d.peers {
+		successes[p.String()] = r.Successes
+		failures[p.String()] = r.Failures
+	}
+	return successes, failures
+}
+
+// status 返回每个引导节点的连接结果
+func (d *dialStats) status() interface{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s := make(map[string]dialResult, len(d.peers))
+	for p, r := range d.peers {
+		s[p.String()] = *r
+	}
+	return s
+}
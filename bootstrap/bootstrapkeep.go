@@ -0,0 +1,179 @@
+package bootstrap
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// checkBootstrapRetry 检查重新连接引导节点的退避时间
+func checkBootstrapRetry(min, max time.Duration) error {
+	if min <= 0 || max < min {
+		return fmt.Errorf("引导节点重试间隔错误: 最短 %s, 最长 %s", min, max)
+	}
+	return nil
+}
+
+// bootstrapKeeper 在没有连接到足够的引导节点时按指数退避重新连接,
+// 包括启动时连接失败和之后与引导节点的连接断开.
+type bootstrapKeeper struct {
+	h        host.Host
+	min, max time.Duration
+	dials    *dialStats
+	// onConnected 在重新连接到引导节点后调用
+	onConnected func()
+	trigger     chan struct{}
+
+	mu        sync.Mutex
+	tiers     [][]peer.AddrInfo
+	ids       map[peer.ID]bool
+	minPeers  int
+	quorum    int
+	timeout   time.Duration
+	attempts  uint64
+	failures  uint64
+	backoff   time.Duration
+	nextRetry time.Time
+}
+
+func newBootstrapKeeper(h host.Host, min, max time.Duration, dials *dialStats, onConnected func()) *bootstrapKeeper {
+	return &bootstrapKeeper{h: h, min: min, max: max, dials: dials, onConnected: onConnected, trigger: make(chan struct{}, 1)}
+}
+
+// setTiers 设置引导节点和要求连接的数量, 重新加载配置时更新
+func (k *bootstrapKeeper) setTiers(tiers [][]peer.AddrInfo, minPeers, quorum int, timeout time.Duration) {
+	ids := make(map[peer.ID]bool)
+	for _, tier := range tiers {
+		for _, addrInfo := range tier {
+			ids[addrInfo.ID] = true
+		}
+	}
+	k.mu.Lock()
+	k.tiers, k.ids, k.minPeers, k.quorum, k.timeout = tiers, ids, minPeers, quorum, timeout
+	k.mu.Unlock()
+	k.check()
+}
+
+// connected 返回已连接的引导节点数量和要求的数量, 要求的数量不超过引导节点总数
+func (k *bootstrapKeeper) connected() (int, int) {
+	k.mu.Lock()
+	ids, quorum := k.ids, k.quorum
+	k.mu.Unlock()
+	n := 0
+	for id := range ids {
+		if k.h.Network().Connectedness(id) == network.Connected {
+			n++
+		}
+	}
+	if quorum > len(ids) {
+		quorum = len(ids)
+	}
+	return n, quorum
+}
+
+// check 在已连接的引导节点不足时开始重新连接
+func (k *bootstrapKeeper) check() {
+	if n, want := k.connected(); n < want {
+		select {
+		case k.trigger <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (k *bootstrapKeeper) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-k.trigger:
+			k.reconnect(ctx)
+		}
+	}
+}
+
+// reconnect 按指数退避连接引导节点, 直到已连接的数量足够
+func (k *bootstrapKeeper) reconnect(ctx context.Context) {
+	backoff := k.min
+	for {
+		n, want := k.connected()
+		if n >= want {
+			k.mu.Lock()
+			k.backoff, k.nextRetry = 0, time.Time{}
+			k.mu.Unlock()
+			return
+		}
+		k.mu.Lock()
+		k.backoff, k.nextRetry = backoff, time.Now().Add(backoff)
+		k.mu.Unlock()
+		logger.Warnw("已连接的引导节点不足, 等待后重新连接", "connected", n, "want", want, "backoff", backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		k.mu.Lock()
+		tiers, minPeers, timeout := k.tiers, k.minPeers, k.timeout
+		if minPeers < k.quorum {
+			minPeers = k.quorum
+		}
+		k.attempts++
+		k.mu.Unlock()
+		if connectTiers(ctx, k.h, tiers, minPeers, timeout, k.dials) > 0 {
+			logger.Infow("已重新连接引导节点")
+			if k.onConnected != nil {
+				k.onConnected()
+			}
+			continue
+		}
+		k.mu.Lock()
+		k.failures++
+		k.mu.Unlock()
+		if backoff *= 2; backoff > k.max {
+			backoff = k.max
+		}
+	}
+}
+
+func (k *bootstrapKeeper) Connected(network.Network, network.Conn) {}
+
+// Disconnected 在与引导节点的所有连接断开后检查是否需要重新连接
+func (k *bootstrapKeeper) Disconnected(n network.Network, c network.Conn) {
+	p := c.RemotePeer()
+	k.mu.Lock()
+	bootstrap := k.ids[p]
+	k.mu.Unlock()
+	if bootstrap && n.Connectedness(p) != network.Connected {
+		k.check()
+	}
+}
+
+func (k *bootstrapKeeper) Listen(network.Network, ma.Multiaddr)         {}
+func (k *bootstrapKeeper) ListenClose(network.Network, ma.Multiaddr)    {}
+func (k *bootstrapKeeper) OpenedStream(network.Network, network.Stream) {}
+func (k *bootstrapKeeper) ClosedStream(network.Network, network.Stream) {}
+
+// status 返回已连接的引导节点数量和重试情况
+func (k *bootstrapKeeper) status() interface{} {
+	n, want := k.connected()
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	s := map[string]interface{}{
+		"connected": n,
+		"want":      want,
+		"attempts":  k.attempts,
+		"failures":  k.failures,
+	}
+	if !k.nextRetry.IsZero() {
+		s["backoff"] = k.backoff.String()
+		s["next_retry"] = k.nextRetry
+	}
+	return s
+}
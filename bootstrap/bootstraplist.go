@@ -0,0 +1,64 @@
+package bootstrap
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// bootstrapListMaxAge 是 /bootstrap 允许客户端缓存的秒数
+const bootstrapListMaxAge = "60"
+
+// bootstrapList 是 /bootstrap 的内容, addrs是可以直接用作引导节点的完整地址(带/p2p)
+type bootstrapList struct {
+	Addrs []string `json:"addrs"`
+}
+
+// bootstrapListHandler 以JSON形式提供本节点当前的公网地址, 客户端可以通过HTTP(S)获取最新的引导节点列表.
+// cluster为true时同时包括已连接的引导节点的公网地址. 不包括中继地址.
+func bootstrapListHandler(h host.Host, protector *peerProtector, cluster bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "只支持GET", http.StatusMethodNotAllowed)
+			return
+		}
+		list := bootstrapList{Addrs: dialableAddrs(h.ID(), h.Addrs())}
+		if cluster {
+			for _, id := range protector.tagged(protectTagBootstrap) {
+				if h.Network().Connectedness(id) != network.Connected {
+					continue
+				}
+				list.Addrs = append(list.Addrs, dialableAddrs(id, h.Peerstore().Addrs(id))...)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age="+bootstrapListMaxAge)
+		// 允许浏览器中的应用(如js-libp2p)跨域获取
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		if e := json.NewEncoder(w).Encode(list); e != nil {
+			logger.Warnw("输出引导节点列表出错", "error", e)
+		}
+	}
+}
+
+// dialableAddrs 返回节点带/p2p的公网地址, 去掉中继地址, 按字符串排序
+func dialableAddrs(id peer.ID, addrs []ma.Multiaddr) []string {
+	var out []string
+	for _, addr := range publicAddrs(addrs) {
+		if _, e := addr.ValueForProtocol(ma.P_CIRCUIT); e == nil {
+			continue
+		}
+		full, e := peer.AddrInfoToP2pAddrs(&peer.AddrInfo{ID: id, Addrs: []ma.Multiaddr{addr}})
+		if e != nil || len(full) == 0 {
+			continue
+		}
+		out = append(out, full[0].String())
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,211 @@
+// Package bootstraptest 在一个进程中运行引导节点和多个客户端, 用于验证节点发现.
+// 所有节点使用内存中的私钥, 随机端口和内存存储, 只在本机地址上监听.
+package bootstraptest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/alx696/go-libp2p-bootstrap/bootstrap"
+	"github.com/libp2p/go-libp2p"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	routing "github.com/libp2p/go-libp2p/core/routing"
+)
+
+// listenAddr 是测试节点的监听地址, 端口由系统分配
+const listenAddr = "/ip4/127.0.0.1/tcp/0"
+
+// Config 返回适合测试的引导节点设置: 数据目录为dir, 作为第一个节点运行, 只用TCP, 内存存储,
+// 不启用端口映射, 管理接口和HTTP服务, 宣告本机地址.
+func Config(dir string) *bootstrap.Config {
+	cfg := bootstrap.DefaultConfig()
+	cfg.DataDir = dir
+	cfg.Genesis = true
+	cfg.Listen = []string{listenAddr}
+	cfg.Transports.QUIC = false
+	cfg.AnnouncePrivate = true
+	cfg.UPnP = false
+	cfg.NATPMP = false
+	cfg.AdminAddr = ""
+	cfg.Peerstore = "memory"
+	cfg.DHT.Datastore = "memory"
+	cfg.PeerLogInterval = 0
+	cfg.StartupRetry = 0
+	cfg.ShutdownTimeout = time.Second * 5
+	return cfg
+}
+
+// Network 是一个引导节点和连接它的客户端, 客户端以DHT服务器模式运行, 可以通过引导节点互相发现.
+// Nodes 是AddNode启动的以Bootstrap为引导节点的节点
+type Network struct {
+	Bootstrap *bootstrap.Node
+	Clients   []host.Host
+	DHTs      []*dht.IpfsDHT
+	Nodes     []*bootstrap.Node
+
+	dir    string
+	cancel context.CancelFunc
+}
+
+// Start 启动引导节点和n个客户端. 客户端连接引导节点并启动DHT, 返回前等待引导节点的路由表包括所有客户端.
+// 出错时关闭已启动的节点. 使用后调用Close.
+func Start(ctx context.Context, n int, opts ...bootstrap.Option) (*Network, error) {
+	dir, e := ioutil.TempDir("", "bootstraptest")
+	if e != nil {
+		return nil, e
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	nw := &Network{dir: dir, cancel: cancel}
+	key, _, e := crypto.GenerateEd25519Key(nil)
+	if e != nil {
+		nw.Close()
+		return nil, e
+	}
+	node, e := bootstrap.New(Config(dir), append([]bootstrap.Option{bootstrap.WithIdentity(key)}, opts...)...)
+	if e != nil {
+		nw.Close()
+		return nil, e
+	}
+	if e := node.Start(ctx); e != nil {
+		nw.Close()
+		return nil, fmt.Errorf("启动引导节点出错: %w", e)
+	}
+	nw.Bootstrap = node
+	for i := 0; i < n; i++ {
+		if e := nw.addClient(ctx); e != nil {
+			nw.Close()
+			return nil, fmt.Errorf("启动客户端%d出错: %w", i, e)
+		}
+	}
+	if e := nw.waitRoutingTable(ctx, n); e != nil {
+		nw.Close()
+		return nil, e
+	}
+	return nw, nil
+}
+
+// addClient 启动一个客户端, 连接引导节点并启动DHT
+func (nw *Network) addClient(ctx context.Context) error {
+	var idht *dht.IpfsDHT
+	h, e := libp2p.New(
+		libp2p.ListenAddrStrings(listenAddr),
+		libp2p.Routing(func(h host.Host) (routing.PeerRouting, error) {
+			var e error
+			idht, e = dht.New(ctx, h, dht.Mode(dht.ModeServer))
+			return idht, e
+		}),
+	)
+	if e != nil {
+		return e
+	}
+	nw.Clients = append(nw.Clients, h)
+	nw.DHTs = append(nw.DHTs, idht)
+	if e := h.Connect(ctx, nw.Bootstrap.AddrInfo()); e != nil {
+		return e
+	}
+	return idht.Bootstrap(ctx)
+}
+
+// AddNode 启动一个以Bootstrap为引导节点的节点, 断开后很快重新连接. opts在测试设置之后应用, 如bootstrap.WithNetSim. 节点在Close时关闭
+func (nw *Network) AddNode(ctx context.Context, opts ...bootstrap.Option) (*bootstrap.Node, error) {
+	info := nw.Bootstrap.AddrInfo()
+	addrs, e := peer.AddrInfoToP2pAddrs(&info)
+	if e != nil {
+		return nil, e
+	}
+	cfg := Config(filepath.Join(nw.dir, fmt.Sprint("node", len(nw.Nodes))))
+	cfg.Genesis = false
+	// 与引导节点断开后尽快重新连接
+	cfg.BootstrapRetryMin = time.Millisecond * 50
+	cfg.BootstrapRetryMax = time.Millisecond * 500
+	for _, addr := range addrs {
+		cfg.Bootstrap = append(cfg.Bootstrap, addr.String())
+	}
+	key, _, e := crypto.GenerateEd25519Key(nil)
+	if e != nil {
+		return nil, e
+	}
+	node, e := bootstrap.New(cfg, append([]bootstrap.Option{bootstrap.WithIdentity(key)}, opts...)...)
+	if e != nil {
+		return nil, e
+	}
+	if e := node.Start(ctx); e != nil {
+		return nil, fmt.Errorf("启动节点出错: %w", e)
+	}
+	nw.Nodes = append(nw.Nodes, node)
+	return node, nil
+}
+
+// waitRoutingTable 等待引导节点的路由表中至少有n个节点
+func (nw *Network) waitRoutingTable(ctx context.Context, n int) error {
+	ticker := time.NewTicker(time.Millisecond * 50)
+	defer ticker.Stop()
+	for nw.Bootstrap.DHT().RoutingTable().Size() < n {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("等待引导节点的路由表出错, 当前%d个节点: %w", nw.Bootstrap.DHT().RoutingTable().Size(), ctx.Err())
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// Discover 检查每个客户端都能通过DHT找到其他所有客户端的地址
+func (nw *Network) Discover(ctx context.Context) error {
+	for i, idht := range nw.DHTs {
+		for j, other := range nw.Clients {
+			if i == j {
+				continue
+			}
+			info, e := idht.FindPeer(ctx, other.ID())
+			if e != nil {
+				return fmt.Errorf("客户端%d找不到客户端%d: %w", i, j, e)
+			}
+			// 已经连接时DHT返回地址簿中的地址, identify完成前入站连接的节点还没有地址
+			if len(info.Addrs) == 0 && nw.Clients[i].Network().Connectedness(other.ID()) != network.Connected {
+				return fmt.Errorf("客户端%d找到的客户端%d没有地址", i, j)
+			}
+		}
+	}
+	return nil
+}
+
+// Close 关闭客户端和引导节点并删除临时数据目录
+func (nw *Network) Close() error {
+	var errs []error
+	for _, node := range nw.Nodes {
+		if e := node.Stop(); e != nil {
+			errs = append(errs, e)
+		}
+	}
+	for i, h := range nw.Clients {
+		if e := nw.DHTs[i].Close(); e != nil {
+			errs = append(errs, e)
+		}
+		if e := h.Close(); e != nil {
+			errs = append(errs, e)
+		}
+	}
+	if nw.Bootstrap != nil {
+		if e := nw.Bootstrap.Stop(); e != nil {
+			errs = append(errs, e)
+		}
+	}
+	nw.cancel()
+	if e := os.RemoveAll(nw.dir); e != nil {
+		errs = append(errs, e)
+	}
+	if len(errs) > 0 {
+		return errors.New(fmt.Sprint("关闭测试网络出错: ", errs))
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package bootstraptest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alx696/go-libp2p-bootstrap/bootstrap"
+	"github.com/libp2p/go-libp2p/core/network"
+)
+
+func TestNetworkDiscover(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	nw, e := Start(ctx, 3)
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer func() {
+		if e := nw.Close(); e != nil {
+			t.Error(e)
+		}
+	}()
+	if e := nw.Discover(ctx); e != nil {
+		t.Fatal(e)
+	}
+	// 通过DHT找到的地址可以连接
+	for i, h := range nw.Clients {
+		for j, other := range nw.Clients {
+			if i == j {
+				continue
+			}
+			if e := h.Connect(ctx, h.Peerstore().PeerInfo(other.ID())); e != nil {
+				t.Fatalf("客户端%d连接客户端%d出错: %v", i, j, e)
+			}
+			if h.Network().Connectedness(other.ID()) != network.Connected {
+				t.Fatalf("客户端%d没有连接客户端%d", i, j)
+			}
+		}
+	}
+}
+
+func TestNetworkChurn(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	nw, e := Start(ctx, 0)
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer func() {
+		if e := nw.Close(); e != nil {
+			t.Error(e)
+		}
+	}()
+	// 记录引导节点收到的连接, 断开后节点重新连接时再次收到
+	connected := make(chan struct{}, 100)
+	nw.Bootstrap.Host().Network().Notify(&network.NotifyBundle{ConnectedF: func(network.Network, network.Conn) {
+		connected <- struct{}{}
+	}})
+	node, e := nw.AddNode(ctx, bootstrap.WithNetSim(bootstrap.NetSimConfig{RejectSetup: 0.3, Churn: time.Millisecond * 300, Seed: 1}))
+	if e != nil {
+		t.Fatal(e)
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case <-connected:
+		case <-ctx.Done():
+			t.Fatalf("第%d次连接前超时", i+1)
+		}
+	}
+	// 重新连接后刷新路由表, 引导节点仍在节点的路由表中
+	id := nw.Bootstrap.Host().ID()
+	for node.Host().Network().Connectedness(id) != network.Connected || node.DHT().RoutingTable().Find(id) == "" {
+		select {
+		case <-ctx.Done():
+			t.Fatal("节点没有重新连接引导节点")
+		case <-time.After(time.Millisecond * 50):
+		}
+	}
+}
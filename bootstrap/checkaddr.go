@@ -0,0 +1,164 @@
+package bootstrap
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/pnet"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/multiformats/go-multiaddr"
+	madns "github.com/multiformats/go-multiaddr-dns"
+)
+
+// checkAddr 检查节点地址格式是否正确并能连接, 输出连接和identify信息
+func checkAddr(ctx context.Context, addr string, timeout time.Duration, psk pnet.PSK) error {
+	multiAddr, e := multiaddr.NewMultiaddr(addr)
+	if e != nil {
+		return fmt.Errorf("地址格式错误: %w", e)
+	}
+	if _, e = multiAddr.ValueForProtocol(multiaddr.P_P2P); e != nil {
+		return fmt.Errorf("地址缺少/p2p/节点ID: %w", e)
+	}
+
+	// 解析DNS
+	rc, rcCancel := context.WithTimeout(ctx, timeout)
+	defer rcCancel()
+	resolved, e := madns.Resolve(rc, multiAddr)
+	if e != nil {
+		return fmt.Errorf("解析地址出错: %w", e)
+	}
+	if len(resolved) == 0 {
+		return fmt.Errorf("地址没有解析结果")
+	}
+	addrInfos, e := peer.AddrInfosFromP2pAddrs(resolved...)
+	if e != nil {
+		return e
+	}
+	if len(addrInfos) != 1 {
+		return fmt.Errorf("地址解析出%d个节点", len(addrInfos))
+	}
+	addrInfo := addrInfos[0]
+	fmt.Println("节点:", addrInfo.ID)
+	fmt.Println("地址:", addrInfo.Addrs)
+
+	// 使用临时身份, 不监听. 依次只用一种安全协议连接, 以确定对方支持的协议
+	var h host.Host
+	var sub event.Subscription
+	var security string
+	for _, candidate := range checkSecurity {
+		set := candidate.set
+		if len(psk) > 0 {
+			set.PSK = psk
+			set.QUIC = false
+		}
+		h, e = libp2p.New(append([]libp2p.Option{libp2p.NoListenAddrs}, transportOptions(set)...)...)
+		if e != nil {
+			return e
+		}
+		// 连接前订阅, 以免错过identify结果
+		sub, e = h.EventBus().Subscribe([]interface{}{
+			new(event.EvtPeerIdentificationCompleted),
+			new(event.EvtPeerIdentificationFailed),
+		})
+		if e != nil {
+			_ = h.Close()
+			return e
+		}
+		start := time.Now()
+		e = connectPeer(ctx, h, addrInfo, timeout)
+		if e == nil {
+			fmt.Println("连接耗时:", time.Since(start))
+			security = candidate.name
+			break
+		}
+		_ = sub.Close()
+		_ = h.Close()
+	}
+	if e != nil {
+		return fmt.Errorf("连接失败: %w", e)
+	}
+	defer h.Close()
+	defer sub.Close()
+
+	for _, c := range h.Network().ConnsToPeer(addrInfo.ID) {
+		fmt.Println("连接:", c.RemoteMultiaddr(), "传输:", connTransport(c), "安全:", connSecurity(c, security))
+	}
+
+	// 等待identify完成
+	wait := time.NewTimer(timeout)
+	defer wait.Stop()
+	for {
+		select {
+		case <-wait.C:
+			return fmt.Errorf("等待identify超时")
+		case evt := <-sub.Out():
+			switch evt := evt.(type) {
+			case event.EvtPeerIdentificationCompleted:
+				if evt.Peer != addrInfo.ID {
+					continue
+				}
+				agentVersion, _ := h.Peerstore().Get(evt.Peer, "AgentVersion")
+				protocolVersion, _ := h.Peerstore().Get(evt.Peer, "ProtocolVersion")
+				protocols, _ := h.Peerstore().GetProtocols(evt.Peer)
+				fmt.Println("AgentVersion:", agentVersion)
+				fmt.Println("ProtocolVersion:", protocolVersion)
+				fmt.Println("协议:", strings.Join(protocol.ConvertToStrings(protocols), " "))
+				return nil
+			case event.EvtPeerIdentificationFailed:
+				if evt.Peer != addrInfo.ID {
+					continue
+				}
+				return fmt.Errorf("identify失败: %w", evt.Reason)
+			}
+		}
+	}
+}
+
+// connTransport 返回连接地址中的传输协议, 如tcp, quic
+func connTransport(c network.Conn) string {
+	var names []string
+	for _, p := range c.RemoteMultiaddr().Protocols() {
+		switch p.Code {
+		case multiaddr.P_IP4, multiaddr.P_IP6, multiaddr.P_DNS4, multiaddr.P_DNS6, multiaddr.P_P2P:
+		default:
+			names = append(names, p.Name)
+		}
+	}
+	return strings.Join(names, "/")
+}
+
+// checkSecurity 是检查地址时依次尝试的安全协议.
+// 连接没有暴露协商结果, 所以每次只启用一种.
+var checkSecurity = []struct {
+	name string
+	set  transportSet
+}{
+	{"TLS 1.3", transportSet{TCP: true, QUIC: true, WS: true, TLS: true}},
+	{"Noise", transportSet{TCP: true, WS: true, Noise: true}},
+}
+
+// connSecurity 返回连接的安全协议, QUIC内置TLS 1.3
+func connSecurity(c network.Conn, security string) string {
+	if _, e := c.RemoteMultiaddr().ValueForProtocol(multiaddr.P_QUIC_V1); e == nil {
+		return "TLS 1.3 (QUIC)"
+	}
+	return security
+}
+
+// runCheckAddr 执行地址检查并返回退出码
+func runCheckAddr(ctx context.Context, addr string, psk pnet.PSK) int {
+	e := checkAddr(ctx, addr, connectTimeout, psk)
+	if e != nil {
+		logger.Errorw("检查地址失败", "addr", addr, "error", e)
+		return 1
+	}
+	logger.Infow("检查地址成功", "addr", addr)
+	return 0
+}
@@ -0,0 +1,207 @@
+package bootstrap
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// command 是一个子命令
+type command struct {
+	usage string
+	run   func(args []string) int
+}
+
+// commands 是所有子命令, 没有指定子命令时运行run
+var commands = map[string]command{
+	"run":           {"运行引导节点(默认)", runNode},
+	"keygen":        {"生成新的私钥", runKeygen},
+	"key":           {"管理私钥: rotate, export, import", runKey},
+	"id":            {"输出节点ID和地址, 不启动节点", runID},
+	"peers":         {"查询运行中的节点已连接的节点", runPeers},
+	"latency":       {"查询运行中的节点到已连接节点的ping延迟", runLatency},
+	"connect":       {"让运行中的节点连接指定地址", runConnect},
+	"disconnect":    {"让运行中的节点断开与指定节点的连接", runDisconnect},
+	"stats":         {"输出运行中的节点的状态", runStats},
+	"ban":           {"封禁节点ID, IP或CIDR, 到期后自动解除", runBan},
+	"unban":         {"解除封禁", runUnban},
+	"bans":          {"列出封禁", runBans},
+	"routing-table": {"查询运行中的节点的DHT路由表", runRoutingTable},
+	"dht":           {"通过运行中的节点的DHT查询: findpeer, findprovs", runDHT},
+	"peerstore":     {"导出和导入地址簿: export, import", runPeerstore},
+	"diag":          {"诊断网络: nat", runDiag},
+	"bench":         {"测试节点的握手延迟, 同时连接和DHT查询性能", runBench},
+	"dnsaddr":       {"输出本节点公网地址的dnsaddr TXT记录", runDNSAddr},
+	"validate":      {"检查设置并输出生效的配置, 不启动节点", runValidate},
+	"service":       {"管理Windows服务: install, uninstall, start, stop", runServiceCommand},
+}
+
+// Main 运行命令行程序, args不包括程序名, 返回退出码
+func Main(args []string) int {
+	if runAsService(args) {
+		return 0
+	}
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return runNode(args)
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintln(os.Stderr, "未知的子命令", args[0])
+		printCommands()
+		return 2
+	}
+	return cmd.run(args[1:])
+}
+
+// printCommands 输出子命令列表
+func printCommands() {
+	fmt.Fprintln(os.Stderr, "用法: bootstrap [run|keygen|key|id|dnsaddr|peers|latency|connect|disconnect|stats|ban|unban|bans|routing-table|dht|peerstore|diag|bench|validate|service] [参数]")
+	for _, name := range []string{"run", "keygen", "key", "id", "dnsaddr", "peers", "latency", "connect", "disconnect", "stats", "ban", "unban", "bans", "routing-table", "dht", "peerstore", "diag", "bench", "validate", "service"} {
+		fmt.Fprintf(os.Stderr, "  %-14s %s\n", name, commands[name].usage)
+	}
+}
+
+// commandConfig 按与run相同的优先级读取子命令的设置: 命令行参数, 环境变量, 配置文件, 预设
+func commandConfig(fs *flag.FlagSet, args []string) (*Config, error) {
+	cfg, e := baseConfig(configPathFromArgs(args), args)
+	if e != nil {
+		return nil, e
+	}
+	fs.String("config", "", "YAML配置文件路径")
+	fs.StringVar(&cfg.adminNetwork, "network", "", "多网络模式下通过管理接口访问的网络名称, 默认为第一个网络")
+	cfg.registerFlags(fs)
+	if e := fs.Parse(args); e != nil {
+		return nil, e
+	}
+	return cfg, nil
+}
+
+// runKeygen 在私钥文件路径生成新的私钥并输出节点ID
+func runKeygen(args []string) int {
+	fs := flag.NewFlagSet("keygen", flag.ExitOnError)
+	force := fs.Bool("force", false, "覆盖已有的私钥文件, 节点ID会改变")
+	cfg, e := commandConfig(fs, args)
+	if e == nil {
+		e = requireFileKeystore(cfg)
+	}
+	if e != nil {
+		logger.Errorw("读取设置出错", "error", e)
+		return 1
+	}
+	dir, e := cfg.dataDir()
+	if e != nil {
+		logger.Errorw("读取数据目录出错", "error", e)
+		return 1
+	}
+	path := keyPath(cfg, dir)
+	if _, e := os.Stat(path); !os.IsNotExist(e) && !*force {
+		logger.Errorw("私钥文件已存在, 使用-force覆盖", "path", path)
+		return 1
+	}
+	if e := os.MkdirAll(dir, 0700); e != nil {
+		logger.Errorw("创建数据目录出错", "error", e)
+		return 1
+	}
+	privateKey, e := generatePrivateKey(cfg.KeyType, cfg.KeyBits)
+	if e == nil {
+		e = savePrivateKey(path, privateKey, cfg.KeyEncrypt)
+	}
+	if e != nil {
+		logger.Errorw("生成私钥出错", "error", e)
+		return 1
+	}
+	id, e := peer.IDFromPrivateKey(privateKey)
+	if e != nil {
+		logger.Errorw("读取节点ID出错", "error", e)
+		return 1
+	}
+	logger.Infow("私钥已保存", "path", path)
+	fmt.Println(id.String())
+	return 0
+}
+
+// runID 输出节点ID和监听地址, 不启动节点. 私钥文件不存在时生成
+func runID(args []string) int {
+	fs := flag.NewFlagSet("id", flag.ExitOnError)
+	offline := fs.Bool("offline", false, "不访问运行中的节点, 只输出监听地址")
+	cfg, e := commandConfig(fs, args)
+	if e != nil {
+		logger.Errorw("读取设置出错", "error", e)
+		return 1
+	}
+	if !*offline && cfg.AdminAddr != "" {
+		if running, e := runningNodeID(cfg); e == nil {
+			fmt.Println(running.ID)
+			for _, a := range running.Addrs {
+				fmt.Println(a)
+			}
+			return 0
+		}
+	}
+	id, addrs, e := localAddrs(cfg)
+	if e != nil {
+		logger.Errorw("读取地址出错", "error", e)
+		return 1
+	}
+	fmt.Println(id.String())
+	p2pAddr, e := ma.NewMultiaddr("/p2p/" + id.String())
+	if e != nil {
+		logger.Errorw("节点ID错误", "error", e)
+		return 1
+	}
+	for _, a := range addrs {
+		fmt.Println(a.Encapsulate(p2pAddr))
+	}
+	return 0
+}
+
+// localAddrs 返回节点ID和监听地址, 0.0.0.0和::展开为各网络接口的地址. 私钥文件不存在时生成
+func localAddrs(cfg *Config) (peer.ID, []ma.Multiaddr, error) {
+	dir, e := cfg.dataDir()
+	if e != nil {
+		return "", nil, e
+	}
+	privateKey, e := nodePrivateKey(cfg, dir)
+	if e != nil {
+		return "", nil, e
+	}
+	id, e := peer.IDFromPrivateKey(privateKey)
+	if e != nil {
+		return "", nil, e
+	}
+	var interfaceIP net.IP
+	if cfg.Interface != "" {
+		interfaceIP, e = interfaceAddr(cfg.Interface)
+		if e != nil {
+			return "", nil, e
+		}
+	}
+	listenAddrs, e := cfg.listenAddrs(cfg.listenIPs(interfaceIP), cfg.Transports.QUIC, cfg.enableWebRTC(nil))
+	if e != nil {
+		return "", nil, e
+	}
+	ifaceAddrs, e := manet.InterfaceMultiaddrs()
+	if e != nil {
+		return "", nil, e
+	}
+	var addrs []ma.Multiaddr
+	for _, addr := range listenAddrs {
+		listenAddr, e := ma.NewMultiaddr(addr)
+		if e != nil {
+			return "", nil, e
+		}
+		// 0.0.0.0展开为各网络接口的地址
+		resolved, e := manet.ResolveUnspecifiedAddress(listenAddr, ifaceAddrs)
+		if e != nil {
+			resolved = []ma.Multiaddr{listenAddr}
+		}
+		addrs = append(addrs, resolved...)
+	}
+	return id, addrs, nil
+}
@@ -0,0 +1,291 @@
+package bootstrap
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// clusterProtocol 是集群中的引导节点互相交换状态的协议.
+// 发送方打开流后发送一个clusterReport JSON并关闭流, 只接受集群成员的报告.
+const clusterProtocol protocol.ID = "/bootstrap/cluster/1.0.0"
+
+// protectTagCluster 是集群成员的保护标签
+const protectTagCluster = "cluster"
+
+// clusterReportExpiry 是报告的有效期(间隔的倍数), 超过后不再使用该成员的节点
+const clusterReportExpiry = 3
+
+// clusterConfig 是集群模式的设置. 集群中的引导节点定期互相发送健康的节点, 中继负载和可达性,
+// 每个节点向客户端提供节点时同时使用其他成员的节点, 客户端从任一节点都能得到分布更广的最新节点
+type clusterConfig struct {
+	// Peers 是其他成员的节点ID或带/p2p的地址, 只有节点ID时使用地址簿中的地址(如引导节点)
+	Peers []string `yaml:"peers"`
+	// Interval 是发送报告的间隔
+	Interval time.Duration `yaml:"interval"`
+	// MaxPeers 是每次报告最多包括的健康节点数量
+	MaxPeers int `yaml:"max_peers"`
+}
+
+// validate 检查集群设置
+func (c clusterConfig) validate() error {
+	if len(c.Peers) == 0 {
+		return nil
+	}
+	if c.Interval <= 0 || c.MaxPeers <= 0 {
+		return fmt.Errorf("集群设置错误: interval %s, max_peers %d", c.Interval, c.MaxPeers)
+	}
+	_, e := c.members()
+	return e
+}
+
+// members 解析集群成员
+func (c clusterConfig) members() ([]peer.AddrInfo, error) {
+	members, e := parsePeerRefs(c.Peers)
+	if e != nil {
+		return nil, fmt.Errorf("集群成员错误: %w", e)
+	}
+	return members, nil
+}
+
+// clusterReport 是成员发送的状态
+type clusterReport struct {
+	// Peers 是发送方连接的健康节点, 只包括公网地址
+	Peers []peer.AddrInfo `json:"peers"`
+	// Addrs 是发送方自己的公网地址
+	Addrs []string `json:"addrs"`
+	// Connections 是发送方的连接数量, RelayCircuits 是正在中继的电路数量
+	Connections   int `json:"connections"`
+	RelayCircuits int `json:"relay_circuits"`
+	// Reachability 是发送方的AutoNAT可达性
+	Reachability string `json:"reachability"`
+}
+
+// clusterMember 是成员最近的报告
+type clusterMember struct {
+	report   clusterReport
+	received time.Time
+}
+
+// cluster 定期向成员发送本节点的报告, 保存成员的报告
+type cluster struct {
+	h            host.Host
+	cfg          clusterConfig
+	members      []peer.AddrInfo
+	allow        map[peer.ID]bool
+	scorer       *peerScorer
+	reachability *reachabilityTracker
+	limiter      *messageLimiter
+
+	mu       sync.RWMutex
+	reports  map[peer.ID]*clusterMember
+	sent     uint64
+	failures uint64
+}
+
+func newCluster(h host.Host, cfg clusterConfig, members []peer.AddrInfo, scorer *peerScorer, reachability *reachabilityTracker, limiter *messageLimiter) *cluster {
+	allow := make(map[peer.ID]bool, len(members))
+	for _, m := range members {
+		allow[m.ID] = true
+	}
+	return &cluster{h: h, cfg: cfg, members: members, allow: allow, scorer: scorer, reachability: reachability, limiter: limiter, reports: make(map[peer.ID]*clusterMember)}
+}
+
+// protect 保护成员的连接, 并把配置的地址加入地址簿
+func (c *cluster) protect(protector *peerProtector) {
+	for _, m := range c.members {
+		if len(m.Addrs) > 0 {
+			c.h.Peerstore().AddAddrs(m.ID, m.Addrs, c.cfg.Interval*clusterReportExpiry)
+		}
+		protector.Protect(m.ID, protectTagCluster)
+	}
+}
+
+func (c *cluster) handle(s network.Stream) {
+	remote := s.Conn().RemotePeer()
+	if !c.allow[remote] {
+		logger.Warnw("拒绝非集群成员的报告", "peer", remote)
+		_ = s.Reset()
+		return
+	}
+	_ = s.SetReadDeadline(time.Now().Add(peerExchangeTimeout))
+	var report clusterReport
+	if e := json.NewDecoder(c.limiter.Reader(s)).Decode(&report); e != nil {
+		logger.Warnw("读取集群报告出错", "peer", remote, "error", e)
+		_ = s.Reset()
+		return
+	}
+	_ = s.Close()
+	// 不使用成员报告中包括本节点和成员自己的节点
+	peers := report.Peers[:0]
+	for _, p := range report.Peers {
+		if p.ID != c.h.ID() && !c.allow[p.ID] && len(p.Addrs) > 0 {
+			peers = append(peers, p)
+		}
+	}
+	report.Peers = peers
+	c.mu.Lock()
+	c.reports[remote] = &clusterMember{report: report, received: time.Now()}
+	c.mu.Unlock()
+}
+
+func (c *cluster) run(ctx context.Context) {
+	ticker := time.NewTicker(c.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		c.broadcast(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// broadcast 同时向所有成员发送本节点的报告
+func (c *cluster) broadcast(ctx context.Context) {
+	report := c.report()
+	var wg sync.WaitGroup
+	for _, m := range c.members {
+		wg.Add(1)
+		go func(m peer.AddrInfo) {
+			defer wg.Done()
+			e := c.send(ctx, m, report)
+			c.mu.Lock()
+			if e != nil {
+				c.failures++
+			} else {
+				c.sent++
+			}
+			c.mu.Unlock()
+			if e != nil && ctx.Err() == nil {
+				logger.Warnw("发送集群报告出错", "peer", m.ID, "error", e)
+			}
+		}(m)
+	}
+	wg.Wait()
+}
+
+func (c *cluster) send(ctx context.Context, m peer.AddrInfo, report clusterReport) error {
+	ctx, cancel := context.WithTimeout(ctx, connectTimeout)
+	defer cancel()
+	if e := c.h.Connect(ctx, peer.AddrInfo{ID: m.ID}); e != nil {
+		return e
+	}
+	s, e := c.h.NewStream(ctx, m.ID, clusterProtocol)
+	if e != nil {
+		return e
+	}
+	_ = s.SetWriteDeadline(time.Now().Add(peerExchangeTimeout))
+	if e := json.NewEncoder(s).Encode(report); e != nil {
+		_ = s.Reset()
+		return e
+	}
+	return s.Close()
+}
+
+// report 生成本节点的报告: 随机选择评分不低于0的已连接节点, 不包括成员
+func (c *cluster) report() clusterReport {
+	n := c.h.Network()
+	peers := n.Peers()
+	rand.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+	report := clusterReport{
+		Peers:         make([]peer.AddrInfo, 0, c.cfg.MaxPeers),
+		Addrs:         dialableAddrs(c.h.ID(), c.h.Addrs()),
+		Connections:   len(n.Conns()),
+		RelayCircuits: relayStreams(n) / 2,
+		Reachability:  c.reachability.Get().String(),
+	}
+	for _, id := range peers {
+		if len(report.Peers) >= c.cfg.MaxPeers {
+			break
+		}
+		if c.allow[id] || n.Connectedness(id) != network.Connected || (c.scorer != nil && !c.scorer.healthy(id)) {
+			continue
+		}
+		addrs := publicAddrs(c.h.Peerstore().Addrs(id))
+		if len(addrs) == 0 {
+			continue
+		}
+		report.Peers = append(report.Peers, peer.AddrInfo{ID: id, Addrs: addrs})
+	}
+	return report
+}
+
+// current 返回没有过期的报告, 按中继电路数量从少到多排序
+func (c *cluster) current() []clusterMemberReport {
+	expiry := c.cfg.Interval * clusterReportExpiry
+	c.mu.RLock()
+	list := make([]clusterMemberReport, 0, len(c.reports))
+	for id, m := range c.reports {
+		if time.Since(m.received) > expiry {
+			continue
+		}
+		list = append(list, clusterMemberReport{ID: id, report: m.report})
+	}
+	c.mu.RUnlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].report.RelayCircuits < list[j].report.RelayCircuits })
+	return list
+}
+
+// clusterMemberReport 是一个成员的报告
+type clusterMemberReport struct {
+	ID     peer.ID
+	report clusterReport
+}
+
+// candidates 返回成员报告中的健康节点和公网可达的成员自己, 成员按中继负载从低到高排在前面.
+// 用于向客户端提供节点, 不包括exclude
+func (c *cluster) candidates(exclude peer.ID) (members, peers []peer.AddrInfo) {
+	seen := map[peer.ID]bool{exclude: true}
+	for _, m := range c.current() {
+		if m.report.Reachability == network.ReachabilityPublic.String() && len(m.report.Addrs) > 0 && !seen[m.ID] {
+			if infos, e := parseAddrInfos(m.report.Addrs); e == nil && len(infos) > 0 {
+				members = append(members, infos[0])
+				seen[m.ID] = true
+			}
+		}
+		for _, p := range m.report.Peers {
+			if !seen[p.ID] {
+				seen[p.ID] = true
+				peers = append(peers, p)
+			}
+		}
+	}
+	return members, peers
+}
+
+// status 返回每个成员最近一次报告的时间和内容
+func (c *cluster) status() interface{} {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	members := make(map[string]interface{}, len(c.members))
+	for _, m := range c.members {
+		r, ok := c.reports[m.ID]
+		if !ok {
+			members[m.ID.String()] = nil
+			continue
+		}
+		members[m.ID.String()] = map[string]interface{}{
+			"received":       r.received,
+			"peers":          len(r.report.Peers),
+			"connections":    r.report.Connections,
+			"relay_circuits": r.report.RelayCircuits,
+			"reachability":   r.report.Reachability,
+		}
+	}
+	return map[string]interface{}{
+		"members":  members,
+		"sent":     c.sent,
+		"failures": c.failures,
+	}
+}
@@ -0,0 +1,63 @@
+package bootstrap
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestConfigPrecedence 检查设置的优先级: 命令行参数 > 环境变量 > 配置文件 > 预设 > 默认值
+func TestConfigPrecedence(t *testing.T) {
+	defaults := DefaultConfig().ConnMgr.LowWater
+	tests := []struct {
+		name    string
+		profile bool
+		file    bool
+		env     bool
+		flag    bool
+		want    int
+	}{
+		{name: "默认值", want: defaults},
+		{name: "预设", profile: true, want: 1000},
+		{name: "配置文件优先于预设", profile: true, file: true, want: 50},
+		{name: "环境变量优先于配置文件", profile: true, file: true, env: true, want: 60},
+		{name: "命令行参数优先于环境变量", profile: true, file: true, env: true, flag: true, want: 70},
+		{name: "命令行参数优先于配置文件", file: true, flag: true, want: 70},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var args []string
+			if tt.profile {
+				args = append(args, "-profile", "public-bootstrap")
+			}
+			if tt.file {
+				path := filepath.Join(t.TempDir(), "bootstrap.yaml")
+				if e := ioutil.WriteFile(path, []byte("connmgr:\n  low_water: 50\n"), 0600); e != nil {
+					t.Fatal(e)
+				}
+				args = append(args, "-config", path)
+			}
+			if tt.env {
+				t.Setenv(envName("connmgr-low"), "60")
+			} else if v, ok := os.LookupEnv(envName("connmgr-low")); ok {
+				t.Skipf("已设置环境变量%s=%s", envName("connmgr-low"), v)
+			}
+			if tt.flag {
+				args = append(args, "-connmgr-low", "70")
+			}
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			cfg, e := commandConfig(fs, args)
+			if e != nil {
+				t.Fatal(e)
+			}
+			if cfg.ConnMgr.LowWater != tt.want {
+				t.Fatalf("low_water %d, 应为 %d", cfg.ConnMgr.LowWater, tt.want)
+			}
+			if tt.profile && cfg.Profile != "public-bootstrap" {
+				t.Fatalf("预设 %q, 应为 public-bootstrap", cfg.Profile)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package bootstrap
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/pnet"
+	noise "github.com/libp2p/go-libp2p/p2p/security/noise"
+	libp2ptls "github.com/libp2p/go-libp2p/p2p/security/tls"
+	libp2pquic "github.com/libp2p/go-libp2p/p2p/transport/quic"
+	websocket "github.com/libp2p/go-libp2p/p2p/transport/websocket"
+	webtransport "github.com/libp2p/go-libp2p/p2p/transport/webtransport"
+	"golang.org/x/net/proxy"
+)
+
+// connectTimeout 是连接单个节点的超时时间
+const connectTimeout = time.Second * 16
+
+// transportSet 是启用的传输和安全协议
+type transportSet struct {
+	TCP  bool
+	QUIC bool
+	// TCPReusePort 和 QUICReusePort 为false时出站连接不从监听端口拨号
+	TCPReusePort  bool
+	QUICReusePort bool
+	WS            bool
+	// WebTransport 与QUIC共用UDP端口, 证书哈希(/certhash)由libp2p加入节点地址
+	WebTransport bool
+	// WebRTC 与QUIC共用端口时从QUIC的UDP端口收发
+	WebRTC bool
+	TLS    bool
+	Noise  bool
+	// PSK 不为空时只与持有同一密钥的节点通信, QUIC不支持私有网络
+	PSK pnet.PSK
+	// Muxers 为空时使用libp2p默认的多路复用器
+	Muxers muxerConfig
+	// Proxy 不为nil时TCP的出站连接通过SOCKS5代理建立
+	Proxy proxy.ContextDialer
+	// Onion 不为nil时通过Tor的SOCKS端口连接/onion3地址
+	Onion proxy.ContextDialer
+}
+
+// transportOptions 返回节点使用的传输和安全协议, 至少要启用一种传输.
+// WebSocket可以单独启用, 供浏览器中的js-libp2p连接.
+// 同时启用TLS和Noise时优先使用TLS, 对方只支持Noise(如较早的rust-libp2p和js-libp2p)时使用Noise.
+// QUIC内置TLS 1.3, 不受安全协议设置影响.
+func transportOptions(t transportSet) []libp2p.Option {
+	var options []libp2p.Option
+	if len(t.PSK) > 0 {
+		options = append(options, libp2p.PrivateNetwork(t.PSK))
+	}
+	if t.TLS {
+		// support TLS connections
+		options = append(options, libp2p.Security(libp2ptls.ID, libp2ptls.New))
+	}
+	if t.Noise {
+		options = append(options, libp2p.Security(noise.ID, noise.New))
+	}
+	options = append(options, t.Muxers.options()...)
+	if t.QUIC {
+		// support QUIC - experimental
+		// 无状态重置密钥和令牌密钥由身份私钥派生, 重启后保持不变.
+		options = append(options, libp2p.Transport(libp2pquic.NewTransport), quicReuseOption(t.QUICReusePort))
+	}
+	if t.TCP && t.Proxy != nil {
+		options = append(options, libp2p.Transport(newProxyTCPTransport(t.Proxy)))
+	} else if t.TCP {
+		options = append(options, tcpTransportOption(t.TCPReusePort))
+	}
+	if t.WS {
+		options = append(options, libp2p.Transport(websocket.New))
+	}
+	if t.WebTransport {
+		options = append(options, libp2p.Transport(webtransport.New))
+	}
+	if t.WebRTC {
+		options = append(options, libp2p.Transport(newWebRTCTransport))
+	}
+	if t.Onion != nil {
+		options = append(options, libp2p.Transport(newOnionTransport(t.Onion)))
+	}
+	return options
+}
+
+// loadSwarmKey 读取私有网络密钥文件(swarm.key, 与go-ipfs相同的格式)
+func loadSwarmKey(path string) (pnet.PSK, error) {
+	f, e := os.Open(path)
+	if e != nil {
+		return nil, e
+	}
+	defer f.Close()
+	return pnet.DecodeV1PSK(f)
+}
+
+// connectPeer 在超时时间内连接节点
+func connectPeer(ctx context.Context, h host.Host, addrInfo peer.AddrInfo, timeout time.Duration) error {
+	lc, lcCancel := context.WithTimeout(ctx, timeout)
+	defer lcCancel()
+	return h.Connect(lc, addrInfo)
+}
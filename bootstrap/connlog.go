@@ -0,0 +1,72 @@
+package bootstrap
+
+import (
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// connLog 是连接日志的子系统 bootstrap/conn, 可以单独设置级别, 如-log-level error,bootstrap=info,bootstrap/conn=warn
+var connLog = logging.Logger("bootstrap/conn")
+
+// connLogger 记录连接, 断开, identify结果和本机地址变化
+type connLogger struct {
+	h host.Host
+}
+
+// subscribe 注册网络通知和事件总线订阅
+func (l *connLogger) subscribe(subs *subscriptions) error {
+	l.h.Network().Notify(l)
+	if e := subs.Subscribe(l.h.EventBus(), new(event.EvtPeerIdentificationCompleted), l.identified); e != nil {
+		return e
+	}
+	if e := subs.Subscribe(l.h.EventBus(), new(event.EvtPeerIdentificationFailed), l.identifyFailed); e != nil {
+		return e
+	}
+	return subs.Subscribe(l.h.EventBus(), new(event.EvtLocalAddressesUpdated), l.addressesUpdated)
+}
+
+func (l *connLogger) Connected(_ network.Network, c network.Conn) {
+	connLog.Infow("连接", "peer", c.RemotePeer(), "direction", c.Stat().Direction.String(), "addr", c.RemoteMultiaddr())
+}
+
+func (l *connLogger) Disconnected(_ network.Network, c network.Conn) {
+	connLog.Infow("断开连接", "peer", c.RemotePeer(), "direction", c.Stat().Direction.String(), "addr", c.RemoteMultiaddr(),
+		"duration", time.Since(c.Stat().Opened).Truncate(time.Second).String())
+}
+
+func (l *connLogger) Listen(network.Network, ma.Multiaddr)         {}
+func (l *connLogger) ListenClose(network.Network, ma.Multiaddr)    {}
+func (l *connLogger) OpenedStream(network.Network, network.Stream) {}
+func (l *connLogger) ClosedStream(network.Network, network.Stream) {}
+
+func (l *connLogger) identified(evt interface{}) {
+	p := evt.(event.EvtPeerIdentificationCompleted).Peer
+	agent, _ := l.h.Peerstore().Get(p, "AgentVersion")
+	protocols, _ := l.h.Peerstore().GetProtocols(p)
+	connLog.Infow("identify完成", "peer", p, "agent", agent, "protocols", protocols)
+}
+
+func (l *connLogger) identifyFailed(evt interface{}) {
+	failed := evt.(event.EvtPeerIdentificationFailed)
+	connLog.Debugw("identify失败", "peer", failed.Peer, "error", failed.Reason)
+}
+
+func (l *connLogger) addressesUpdated(evt interface{}) {
+	updated := evt.(event.EvtLocalAddressesUpdated)
+	var current, added, removed []string
+	for _, a := range updated.Current {
+		current = append(current, a.Address.String())
+		if a.Action == event.Added {
+			added = append(added, a.Address.String())
+		}
+	}
+	for _, a := range updated.Removed {
+		removed = append(removed, a.Address.String())
+	}
+	logger.Infow("本机地址变化", "current", current, "added", added, "removed", removed)
+}
@@ -0,0 +1,232 @@
+package bootstrap
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	coreconnmgr "github.com/libp2p/go-libp2p/core/connmgr"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	connmgr "github.com/libp2p/go-libp2p/p2p/net/connmgr"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// reloadableConnMgr 是可以在运行中修改水位线的连接管理器.
+// BasicConnMgr创建后不能修改设置, 修改时创建新的BasicConnMgr, 并把连接, 标签, 衰减标签和保护迁移过去.
+type reloadableConnMgr struct {
+	mu        sync.RWMutex
+	cm        *connmgr.BasicConnMgr
+	net       network.Network
+	protected map[peer.ID]map[string]struct{}
+	decaying  map[string]*reloadableDecayingTag
+}
+
+var (
+	_ coreconnmgr.ConnManager = (*reloadableConnMgr)(nil)
+	_ coreconnmgr.Decayer     = (*reloadableConnMgr)(nil)
+)
+
+func newReloadableConnMgr(low, high int, grace time.Duration) (*reloadableConnMgr, error) {
+	cm, e := connmgr.NewConnManager(low, high, connmgr.WithGracePeriod(grace))
+	if e != nil {
+		return nil, e
+	}
+	return &reloadableConnMgr{
+		cm:        cm,
+		protected: make(map[peer.ID]map[string]struct{}),
+		decaying:  make(map[string]*reloadableDecayingTag),
+	}, nil
+}
+
+func (r *reloadableConnMgr) current() *connmgr.BasicConnMgr {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cm
+}
+
+// Reload 使用新的水位线和宽限期
+func (r *reloadableConnMgr) Reload(low, high int, grace time.Duration) error {
+	next, e := connmgr.NewConnManager(low, high, connmgr.WithGracePeriod(grace))
+	if e != nil {
+		return e
+	}
+
+	r.mu.Lock()
+	prev := r.cm
+	for id, tags := range r.protected {
+		for tag := range tags {
+			next.Protect(id, tag)
+		}
+	}
+	for name, t := range r.decaying {
+		tag, e := next.RegisterDecayingTag(name, t.interval, t.decayFn, t.bumpFn)
+		if e != nil {
+			logger.Warnw("迁移衰减标签出错", "tag", name, "error", e)
+			continue
+		}
+		t.tag = tag
+	}
+	if r.net != nil {
+		notifee := next.Notifee()
+		for _, c := range r.net.Conns() {
+			notifee.Connected(r.net, c)
+		}
+		for _, id := range r.net.Peers() {
+			info := prev.GetTagInfo(id)
+			if info == nil {
+				continue
+			}
+			// GetTagInfo中也有衰减标签的当前值, 在新的连接管理器中重新累加
+			for tag, v := range info.Tags {
+				if t, ok := r.decaying[tag]; ok {
+					_ = t.tag.Bump(id, v)
+				} else {
+					next.TagPeer(id, tag, v)
+				}
+			}
+		}
+	}
+	r.cm = next
+	r.mu.Unlock()
+
+	return prev.Close()
+}
+
+func (r *reloadableConnMgr) TagPeer(p peer.ID, tag string, v int) {
+	r.current().TagPeer(p, tag, v)
+}
+
+func (r *reloadableConnMgr) UntagPeer(p peer.ID, tag string) {
+	r.current().UntagPeer(p, tag)
+}
+
+func (r *reloadableConnMgr) UpsertTag(p peer.ID, tag string, upsert func(int) int) {
+	r.current().UpsertTag(p, tag, upsert)
+}
+
+func (r *reloadableConnMgr) GetTagInfo(p peer.ID) *coreconnmgr.TagInfo {
+	return r.current().GetTagInfo(p)
+}
+
+func (r *reloadableConnMgr) TrimOpenConns(ctx context.Context) {
+	r.current().TrimOpenConns(ctx)
+}
+
+func (r *reloadableConnMgr) CheckLimit(l coreconnmgr.GetConnLimiter) error {
+	return r.current().CheckLimit(l)
+}
+
+func (r *reloadableConnMgr) Notifee() network.Notifiee {
+	return (*reloadableNotifee)(r)
+}
+
+func (r *reloadableConnMgr) Protect(id peer.ID, tag string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	tags, ok := r.protected[id]
+	if !ok {
+		tags = make(map[string]struct{})
+		r.protected[id] = tags
+	}
+	tags[tag] = struct{}{}
+	r.cm.Protect(id, tag)
+}
+
+func (r *reloadableConnMgr) Unprotect(id peer.ID, tag string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.protected[id], tag)
+	if len(r.protected[id]) == 0 {
+		delete(r.protected, id)
+	}
+	return r.cm.Unprotect(id, tag)
+}
+
+func (r *reloadableConnMgr) IsProtected(id peer.ID, tag string) bool {
+	return r.current().IsProtected(id, tag)
+}
+
+// Info 返回当前连接管理器的状态
+func (r *reloadableConnMgr) Info() connmgr.CMInfo {
+	return r.current().GetInfo()
+}
+
+func (r *reloadableConnMgr) Close() error {
+	return r.current().Close()
+}
+
+// RegisterDecayingTag 注册衰减标签, 修改水位线后在新的连接管理器中继续使用
+func (r *reloadableConnMgr) RegisterDecayingTag(name string, interval time.Duration, decayFn coreconnmgr.DecayFn, bumpFn coreconnmgr.BumpFn) (coreconnmgr.DecayingTag, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	tag, e := r.cm.RegisterDecayingTag(name, interval, decayFn, bumpFn)
+	if e != nil {
+		return nil, e
+	}
+	t := &reloadableDecayingTag{r: r, name: name, interval: interval, decayFn: decayFn, bumpFn: bumpFn, tag: tag}
+	r.decaying[name] = t
+	return t, nil
+}
+
+// reloadableDecayingTag 把操作转发给当前连接管理器中的衰减标签
+type reloadableDecayingTag struct {
+	r        *reloadableConnMgr
+	name     string
+	interval time.Duration
+	decayFn  coreconnmgr.DecayFn
+	bumpFn   coreconnmgr.BumpFn
+	// tag 由r.mu保护
+	tag coreconnmgr.DecayingTag
+}
+
+func (t *reloadableDecayingTag) current() coreconnmgr.DecayingTag {
+	t.r.mu.RLock()
+	defer t.r.mu.RUnlock()
+	return t.tag
+}
+
+func (t *reloadableDecayingTag) Name() string {
+	return t.name
+}
+
+func (t *reloadableDecayingTag) Interval() time.Duration {
+	return t.current().Interval()
+}
+
+func (t *reloadableDecayingTag) Bump(p peer.ID, delta int) error {
+	return t.current().Bump(p, delta)
+}
+
+func (t *reloadableDecayingTag) Remove(p peer.ID) error {
+	return t.current().Remove(p)
+}
+
+func (t *reloadableDecayingTag) Close() error {
+	t.r.mu.Lock()
+	delete(t.r.decaying, t.name)
+	tag := t.tag
+	t.r.mu.Unlock()
+	return tag.Close()
+}
+
+// reloadableNotifee 把网络通知转发给当前的连接管理器
+type reloadableNotifee reloadableConnMgr
+
+func (n *reloadableNotifee) Connected(net network.Network, c network.Conn) {
+	r := (*reloadableConnMgr)(n)
+	r.mu.Lock()
+	r.net = net
+	cm := r.cm
+	r.mu.Unlock()
+	cm.Notifee().Connected(net, c)
+}
+
+func (n *reloadableNotifee) Disconnected(net network.Network, c network.Conn) {
+	(*reloadableConnMgr)(n).current().Notifee().Disconnected(net, c)
+}
+
+func (n *reloadableNotifee) Listen(network.Network, ma.Multiaddr)         {}
+func (n *reloadableNotifee) ListenClose(network.Network, ma.Multiaddr)    {}
+func (n *reloadableNotifee) OpenedStream(network.Network, network.Stream) {}
+func (n *reloadableNotifee) ClosedStream(network.Network, network.Stream) {}
@@ -0,0 +1,197 @@
+package bootstrap
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/connmgr"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// 连接管理器的标签, 权重越高越晚被修剪
+const (
+	tagRelayClient = "relay-client"
+	tagDHTPeer     = "dht-peer"
+	// 衰减标签: 最近回应过DHT查询或使用了中继的节点, 一段时间没有使用后权重逐渐降到0
+	tagDHTUseful = "dht-useful"
+	tagRelayUsed = "relay-used"
+)
+
+// tagInterval 是更新连接标签的间隔
+const tagInterval = time.Minute
+
+// usefulMaxBumps 是衰减标签最多累加的次数, 权重上限为useful_weight的倍数
+const usefulMaxBumps = 5
+
+// peerTagger 定期给中继客户端和路由表中的节点打上标签, 连接管理器修剪时优先保留权重高的节点.
+// 与-protect不同, 有标签的节点在连接数量过多时仍可能被修剪.
+type peerTagger struct {
+	h    host.Host
+	idht *dht.IpfsDHT
+
+	// 衰减标签, 连接管理器不支持或useful_weight为0时为nil
+	dhtUseful connmgr.DecayingTag
+	relayUsed connmgr.DecayingTag
+	lastTag   time.Time
+
+	mu           sync.Mutex
+	relayWeight  int
+	dhtWeight    int
+	usefulWeight int
+	relay        int
+	dht          int
+	useful       int
+	relayActive  int
+}
+
+func newPeerTagger(h host.Host, idht *dht.IpfsDHT) *peerTagger {
+	return &peerTagger{h: h, idht: idht}
+}
+
+// setWeights 设置标签权重, 0表示不打标签, 重新加载配置时更新
+func (t *peerTagger) setWeights(relayWeight, dhtWeight, usefulWeight int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.relayWeight = relayWeight
+	t.dhtWeight = dhtWeight
+	t.usefulWeight = usefulWeight
+}
+
+// registerDecaying 注册衰减标签, 权重每halfLife减半. 权重上限在注册时确定, 重新加载配置时不修改
+func (t *peerTagger) registerDecaying(halfLife time.Duration) error {
+	t.mu.Lock()
+	max := t.usefulWeight * usefulMaxBumps
+	t.mu.Unlock()
+	decayer, ok := connmgr.SupportsDecay(t.h.ConnManager())
+	if !ok || max <= 0 || halfLife <= 0 {
+		return nil
+	}
+	decay := connmgr.DecayLinear(math.Pow(0.5, float64(tagInterval)/float64(halfLife)))
+	bump := connmgr.BumpSumBounded(0, max)
+	var e error
+	if t.dhtUseful, e = decayer.RegisterDecayingTag(tagDHTUseful, tagInterval, decay, bump); e != nil {
+		return e
+	}
+	if t.relayUsed, e = decayer.RegisterDecayingTag(tagRelayUsed, tagInterval, decay, bump); e != nil {
+		_ = t.dhtUseful.Close()
+		t.dhtUseful = nil
+		return e
+	}
+	t.lastTag = time.Now()
+	return nil
+}
+
+func (t *peerTagger) run(ctx context.Context) {
+	ticker := time.NewTicker(tagInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t.tag()
+		}
+	}
+}
+
+// tag 按当前的连接更新标签
+func (t *peerTagger) tag() {
+	t.mu.Lock()
+	relayWeight, dhtWeight, usefulWeight := t.relayWeight, t.dhtWeight, t.usefulWeight
+	t.mu.Unlock()
+	useful, relayActive := t.bumpUseful(usefulWeight)
+
+	cm := t.h.ConnManager()
+	inTable := make(map[peer.ID]bool)
+	for _, p := range t.idht.RoutingTable().ListPeers() {
+		inTable[p] = true
+	}
+	relay, dhtPeers := 0, 0
+	for _, p := range t.h.Network().Peers() {
+		if relayWeight > 0 && t.isRelayClient(p) {
+			cm.TagPeer(p, tagRelayClient, relayWeight)
+			relay++
+		} else {
+			cm.UntagPeer(p, tagRelayClient)
+		}
+		if dhtWeight > 0 && inTable[p] {
+			cm.TagPeer(p, tagDHTPeer, dhtWeight)
+			dhtPeers++
+		} else {
+			cm.UntagPeer(p, tagDHTPeer)
+		}
+	}
+	t.mu.Lock()
+	t.relay, t.dht = relay, dhtPeers
+	t.useful, t.relayActive = useful, relayActive
+	t.mu.Unlock()
+}
+
+// bumpUseful 累加上次更新后回应过DHT查询的节点和正在使用中继的节点的衰减标签, 返回累加的节点数量
+func (t *peerTagger) bumpUseful(weight int) (int, int) {
+	if t.dhtUseful == nil || weight <= 0 {
+		return 0, 0
+	}
+	since := t.lastTag
+	t.lastTag = time.Now()
+	useful, relayActive := 0, 0
+	for _, info := range t.idht.RoutingTable().GetPeerInfos() {
+		if info.LastSuccessfulOutboundQueryAt.After(since) || info.LastUsefulAt.After(since) {
+			if t.dhtUseful.Bump(info.Id, weight) == nil {
+				useful++
+			}
+		}
+	}
+	for _, p := range t.h.Network().Peers() {
+		if t.hasRelayStream(p) && t.relayUsed.Bump(p, weight) == nil {
+			relayActive++
+		}
+	}
+	return useful, relayActive
+}
+
+// hasRelayStream 判断节点是否有中继协议的流, 即正在使用本节点的中继
+func (t *peerTagger) hasRelayStream(p peer.ID) bool {
+	for _, c := range t.h.Network().ConnsToPeer(p) {
+		for _, s := range c.GetStreams() {
+			if isRelayProtocol(s.Protocol()) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isRelayClient 判断节点是否通过本节点中继: 有中继协议的流, 或宣告了经过本节点的中继地址
+func (t *peerTagger) isRelayClient(p peer.ID) bool {
+	if t.hasRelayStream(p) {
+		return true
+	}
+	self := t.h.ID().String()
+	for _, addr := range t.h.Peerstore().Addrs(p) {
+		if _, e := addr.ValueForProtocol(ma.P_CIRCUIT); e != nil {
+			continue
+		}
+		if relay, e := addr.ValueForProtocol(ma.P_P2P); e == nil && relay == self {
+			return true
+		}
+	}
+	return false
+}
+
+// status 返回标签权重和有标签的节点数量
+func (t *peerTagger) status() interface{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return map[string]interface{}{
+		tagRelayClient: map[string]int{"weight": t.relayWeight, "peers": t.relay},
+		tagDHTPeer:     map[string]int{"weight": t.dhtWeight, "peers": t.dht},
+		tagDHTUseful:   map[string]int{"bump": t.usefulWeight, "bumped": t.useful},
+		tagRelayUsed:   map[string]int{"bump": t.usefulWeight, "bumped": t.relayActive},
+	}
+}
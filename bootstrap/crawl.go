@@ -0,0 +1,323 @@
+package bootstrap
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// crawlDirName 是数据目录中保存网络快照的目录
+const crawlDirName = "crawl"
+
+// crawlWorkers 是同时查询的节点数量
+const crawlWorkers = 16
+
+// crawlConfig 是遍历DHT的设置
+type crawlConfig struct {
+	// Interval 是遍历间隔, 0表示不遍历
+	Interval time.Duration `yaml:"interval"`
+	// MaxPeers 是每次遍历最多查询的节点数量
+	MaxPeers int `yaml:"max_peers"`
+	// Format 是快照格式: json或csv
+	Format string `yaml:"format"`
+}
+
+// validate 检查遍历设置
+func (c crawlConfig) validate() error {
+	if c.Interval == 0 {
+		return nil
+	}
+	if c.Interval < 0 || c.MaxPeers <= 0 {
+		return fmt.Errorf("遍历设置错误: interval %s, max_peers %d", c.Interval, c.MaxPeers)
+	}
+	if c.Format != "json" && c.Format != "csv" {
+		return fmt.Errorf("不支持的快照格式 %s, 可用的有: json, csv", c.Format)
+	}
+	return nil
+}
+
+// crawledPeer 是快照中的一个节点
+type crawledPeer struct {
+	ID           string   `json:"id"`
+	Reachable    bool     `json:"reachable"`
+	AgentVersion string   `json:"agent_version,omitempty"`
+	Transports   []string `json:"transports"`
+	Addrs        []string `json:"addrs"`
+	Error        string   `json:"error,omitempty"`
+}
+
+// crawlSnapshot 是一次遍历的结果
+type crawlSnapshot struct {
+	Started   time.Time     `json:"started"`
+	Duration  string        `json:"duration"`
+	Peers     []crawledPeer `json:"peers"`
+	Reachable int           `json:"reachable"`
+}
+
+// crawler 定期从路由表中的节点开始, 用FIND_NODE遍历DHT, 记录节点的可达性, 客户端版本和传输
+type crawler struct {
+	h        host.Host
+	idht     *dht.IpfsDHT
+	protocol protocol.ID
+	cfg      crawlConfig
+	dir      string
+
+	mu   sync.Mutex
+	last *crawlSnapshot
+	path string
+}
+
+func newCrawler(h host.Host, idht *dht.IpfsDHT, protocolID protocol.ID, cfg crawlConfig, dir string) *crawler {
+	return &crawler{h: h, idht: idht, protocol: protocolID, cfg: cfg, dir: filepath.Join(dir, crawlDirName)}
+}
+
+func (c *crawler) run(ctx context.Context) {
+	ticker := time.NewTicker(c.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			snapshot := c.crawl(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			path, e := c.save(snapshot)
+			if e != nil {
+				logger.Warnw("保存网络快照出错", "error", e)
+				continue
+			}
+			logger.Infow("遍历DHT完成", "peers", len(snapshot.Peers), "reachable", snapshot.Reachable, "duration", snapshot.Duration, "path", path)
+			c.mu.Lock()
+			c.last = snapshot
+			c.path = path
+			c.mu.Unlock()
+		}
+	}
+}
+
+// crawl 遍历一次DHT
+func (c *crawler) crawl(ctx context.Context) *crawlSnapshot {
+	started := time.Now()
+	var mu sync.Mutex
+	seen := make(map[peer.ID]bool)
+	results := make(map[peer.ID]crawledPeer)
+	queue := make(chan peer.AddrInfo, c.cfg.MaxPeers)
+	var pending sync.WaitGroup
+
+	// enqueue 加入未查询过的节点, 达到上限后忽略
+	enqueue := func(addrInfo peer.AddrInfo) {
+		mu.Lock()
+		defer mu.Unlock()
+		if seen[addrInfo.ID] || addrInfo.ID == c.h.ID() || len(seen) >= c.cfg.MaxPeers {
+			return
+		}
+		seen[addrInfo.ID] = true
+		pending.Add(1)
+		queue <- addrInfo
+	}
+	for _, id := range c.idht.RoutingTable().ListPeers() {
+		enqueue(c.h.Peerstore().PeerInfo(id))
+	}
+
+	for i := 0; i < crawlWorkers; i++ {
+		go func() {
+			for addrInfo := range queue {
+				result, found := c.query(ctx, addrInfo)
+				mu.Lock()
+				results[addrInfo.ID] = result
+				mu.Unlock()
+				for _, p := range found {
+					enqueue(p)
+				}
+				pending.Done()
+			}
+		}()
+	}
+	pending.Wait()
+	close(queue)
+
+	snapshot := &crawlSnapshot{Started: started, Duration: time.Since(started).Truncate(time.Second).String()}
+	for _, p := range results {
+		snapshot.Peers = append(snapshot.Peers, p)
+		if p.Reachable {
+			snapshot.Reachable++
+		}
+	}
+	sort.Slice(snapshot.Peers, func(i, j int) bool { return snapshot.Peers[i].ID < snapshot.Peers[j].ID })
+	return snapshot
+}
+
+// query 连接节点并发送FIND_NODE, 返回节点信息和对方路由表中的节点.
+// 遍历时建立的连接在查询后关闭, 避免占用连接管理器的名额.
+func (c *crawler) query(ctx context.Context, addrInfo peer.AddrInfo) (crawledPeer, []peer.AddrInfo) {
+	result := crawledPeer{ID: addrInfo.ID.String()}
+	wasConnected := c.h.Network().Connectedness(addrInfo.ID) == network.Connected
+	qc, qcCancel := context.WithTimeout(ctx, connectTimeout)
+	defer qcCancel()
+
+	var found []peer.AddrInfo
+	e := c.h.Connect(qc, addrInfo)
+	if e == nil {
+		found, e = c.findNode(qc, addrInfo.ID)
+	}
+	if e != nil {
+		result.Error = e.Error()
+	} else {
+		result.Reachable = true
+	}
+	if v, e := c.h.Peerstore().Get(addrInfo.ID, "AgentVersion"); e == nil {
+		result.AgentVersion, _ = v.(string)
+	}
+	addrs := c.h.Peerstore().Addrs(addrInfo.ID)
+	if len(addrs) == 0 {
+		addrs = addrInfo.Addrs
+	}
+	transports := make(map[string]bool)
+	for _, addr := range addrs {
+		result.Addrs = append(result.Addrs, addr.String())
+		transports[addrTransport(addr)] = true
+	}
+	for t := range transports {
+		result.Transports = append(result.Transports, t)
+	}
+	sort.Strings(result.Transports)
+	if !wasConnected {
+		_ = c.h.Network().ClosePeer(addrInfo.ID)
+	}
+	return result, found
+}
+
+// findNode 向对方查询一个随机键附近的节点
+func (c *crawler) findNode(ctx context.Context, id peer.ID) ([]peer.AddrInfo, error) {
+	found, e := requestFindNode(ctx, c.h, c.protocol, id)
+	if e != nil {
+		return nil, e
+	}
+	for _, p := range found {
+		c.h.Peerstore().AddAddrs(p.ID, p.Addrs, peerstore.TempAddrTTL)
+	}
+	return found, nil
+}
+
+// requestFindNode 发送一次FIND_NODE请求, 查询随机键附近的节点, 不经过本节点的DHT
+func requestFindNode(ctx context.Context, h host.Host, proto protocol.ID, id peer.ID) ([]peer.AddrInfo, error) {
+	key := make([]byte, 32)
+	if _, e := rand.Read(key); e != nil {
+		return nil, e
+	}
+	resp, e := sendDHTMessage(ctx, h, proto, id, pb.NewMessage(pb.Message_FIND_NODE, key, 0))
+	if e != nil {
+		return nil, e
+	}
+	var found []peer.AddrInfo
+	for _, p := range pb.PBPeersToPeerInfos(resp.CloserPeers) {
+		found = append(found, *p)
+	}
+	return found, nil
+}
+
+// sendDHTMessage 在新的流上发送一个DHT请求并读取回应
+func sendDHTMessage(ctx context.Context, h host.Host, proto protocol.ID, id peer.ID, req *pb.Message) (*pb.Message, error) {
+	s, e := h.NewStream(ctx, id, proto)
+	if e != nil {
+		return nil, e
+	}
+	defer s.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = s.SetDeadline(deadline)
+	}
+	b, e := req.Marshal()
+	if e != nil {
+		return nil, e
+	}
+	if e := writeDelimited(s, b); e != nil {
+		_ = s.Reset()
+		return nil, e
+	}
+	b, e = readDelimited(bufio.NewReader(s), network.MessageSizeMax)
+	if e != nil {
+		_ = s.Reset()
+		return nil, e
+	}
+	resp := &pb.Message{}
+	if e := resp.Unmarshal(b); e != nil {
+		return nil, e
+	}
+	return resp, nil
+}
+
+// addrTransport 返回地址使用的传输, 如tcp, quic-v1, webtransport, ws, p2p-circuit
+func addrTransport(addr ma.Multiaddr) string {
+	transport := ""
+	for _, p := range addr.Protocols() {
+		switch p.Code {
+		case ma.P_TCP, ma.P_UDP, ma.P_QUIC, ma.P_QUIC_V1:
+			transport = p.Name
+		case ma.P_WEBTRANSPORT, ma.P_WEBRTC_DIRECT, ma.P_WS, ma.P_WSS, ma.P_CIRCUIT:
+			return p.Name
+		}
+	}
+	return transport
+}
+
+// save 把快照保存到数据目录, 返回文件路径
+func (c *crawler) save(snapshot *crawlSnapshot) (string, error) {
+	if e := os.MkdirAll(c.dir, 0700); e != nil {
+		return "", e
+	}
+	path := filepath.Join(c.dir, snapshot.Started.UTC().Format("20060102T150405Z")+"."+c.cfg.Format)
+	f, e := os.Create(path)
+	if e != nil {
+		return "", e
+	}
+	defer f.Close()
+	if c.cfg.Format == "json" {
+		encoder := json.NewEncoder(f)
+		encoder.SetIndent("", "  ")
+		return path, encoder.Encode(snapshot)
+	}
+	w := csv.NewWriter(f)
+	_ = w.Write([]string{"id", "reachable", "agent_version", "transports", "addrs", "error"})
+	for _, p := range snapshot.Peers {
+		_ = w.Write([]string{p.ID, strconv.FormatBool(p.Reachable), p.AgentVersion, strings.Join(p.Transports, " "), strings.Join(p.Addrs, " "), p.Error})
+	}
+	w.Flush()
+	return path, w.Error()
+}
+
+// status 返回最近一次遍历的结果
+func (c *crawler) status() interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.last == nil {
+		return nil
+	}
+	return map[string]interface{}{
+		"started":   c.last.Started,
+		"duration":  c.last.Duration,
+		"peers":     len(c.last.Peers),
+		"reachable": c.last.Reachable,
+		"snapshot":  c.path,
+	}
+}
@@ -0,0 +1,86 @@
+package bootstrap
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// daemonEnv 标记由-daemon启动的后台进程, 避免再次进入后台
+const daemonEnv = envPrefix + "DAEMON_CHILD"
+
+// startDaemon 在后台重新运行当前命令并返回子进程的PID.
+// 子进程脱离终端, 标准输入为/dev/null, 标准输出和标准错误追加到logFile.
+func startDaemon(logFile string) (int, error) {
+	if logFile == "" {
+		return 0, errors.New("-daemon需要设置-log-file, 否则日志会丢失")
+	}
+	exe, e := os.Executable()
+	if e != nil {
+		return 0, e
+	}
+	if e := os.MkdirAll(filepath.Dir(logFile), 0700); e != nil {
+		return 0, e
+	}
+	out, e := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if e != nil {
+		return 0, fmt.Errorf("打开日志文件出错: %w", e)
+	}
+	defer out.Close()
+	in, e := os.Open(os.DevNull)
+	if e != nil {
+		return 0, e
+	}
+	defer in.Close()
+	attr := &os.ProcAttr{
+		Env:   append(os.Environ(), daemonEnv+"=1"),
+		Files: []*os.File{in, out, out},
+		Sys:   daemonSysProcAttr(),
+	}
+	p, e := os.StartProcess(exe, os.Args, attr)
+	if e != nil {
+		return 0, fmt.Errorf("启动后台进程出错: %w", e)
+	}
+	pid := p.Pid
+	_ = p.Release()
+	return pid, nil
+}
+
+// isDaemonChild 判断当前进程是否是-daemon启动的后台进程
+func isDaemonChild() bool {
+	return os.Getenv(daemonEnv) == "1"
+}
+
+// writePIDFile 写入当前进程的PID, 文件中的进程仍在运行时返回错误
+func writePIDFile(path string) error {
+	if b, e := ioutil.ReadFile(path); e == nil {
+		if pid, e := strconv.Atoi(strings.TrimSpace(string(b))); e == nil && pid != os.Getpid() && processAlive(pid) {
+			return fmt.Errorf("PID文件 %s 中的进程 %d 仍在运行", path, pid)
+		}
+	} else if !os.IsNotExist(e) {
+		return e
+	}
+	if e := os.MkdirAll(filepath.Dir(path), 0700); e != nil {
+		return e
+	}
+	return ioutil.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+}
+
+// removePIDFile 在退出时删除PID文件, 文件已被其他进程改写时不删除
+func removePIDFile(path string) error {
+	b, e := ioutil.ReadFile(path)
+	if e != nil {
+		if os.IsNotExist(e) {
+			return nil
+		}
+		return e
+	}
+	if strings.TrimSpace(string(b)) != strconv.Itoa(os.Getpid()) {
+		return nil
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package bootstrap
+
+import (
+	"syscall"
+)
+
+// daemonSysProcAttr 让后台进程在新的会话中运行, 脱离终端
+func daemonSysProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setsid: true}
+}
+
+// processAlive 判断进程是否存在
+func processAlive(pid int) bool {
+	e := syscall.Kill(pid, 0)
+	return e == nil || e == syscall.EPERM
+}
@@ -0,0 +1,36 @@
+//go:build windows
+// +build windows
+
+package bootstrap
+
+import (
+	"syscall"
+)
+
+// 后台进程脱离控制台
+const (
+	createNewProcessGroup = 0x00000200
+	detachedProcess       = 0x00000008
+)
+
+// stillActive 是进程仍在运行时GetExitCodeProcess返回的值
+const stillActive = 259
+
+// daemonSysProcAttr 让后台进程不依附于当前控制台
+func daemonSysProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{CreationFlags: createNewProcessGroup | detachedProcess, HideWindow: true}
+}
+
+// processAlive 判断进程是否存在
+func processAlive(pid int) bool {
+	h, e := syscall.OpenProcess(syscall.PROCESS_QUERY_INFORMATION, false, uint32(pid))
+	if e != nil {
+		return false
+	}
+	defer syscall.CloseHandle(h)
+	var code uint32
+	if e := syscall.GetExitCodeProcess(h, &code); e != nil {
+		return false
+	}
+	return code == stillActive
+}
@@ -0,0 +1,166 @@
+package bootstrap
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/host"
+)
+
+// dashboardPage 是管理接口 /dashboard 的页面. 页面本身不包括数据, 数据通过带token的 /v1/dashboard 获取
+//
+//go:embed dashboard.html
+var dashboardPage []byte
+
+// dashboardEvents 是控制台保留的最近事件数量
+const dashboardEvents = 50
+
+// dashboardPeers 是控制台最多列出的节点数量
+const dashboardPeers = 200
+
+// dashboard 汇总控制台需要的数据: 连接, 传输, 地理分布, 流量, 中继, 路由表和最近的事件
+type dashboard struct {
+	h            host.Host
+	idht         *dht.IpfsDHT
+	bwc          *bandwidthCounter
+	geo          *geoIP
+	reachability *reachabilityTracker
+	relay        *relayMetrics
+
+	mu     sync.Mutex
+	recent []nodeEvent
+}
+
+func newDashboard(h host.Host, idht *dht.IpfsDHT, bwc *bandwidthCounter, geo *geoIP, reachability *reachabilityTracker, relay *relayMetrics) *dashboard {
+	return &dashboard{h: h, idht: idht, bwc: bwc, geo: geo, reachability: reachability, relay: relay}
+}
+
+// run 保留最近的事件
+func (d *dashboard) run(ctx context.Context, hub *eventHub) {
+	sub := hub.Subscribe(eventStreamBuffer)
+	defer sub.Close()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case evt := <-sub.C:
+			d.mu.Lock()
+			d.recent = append(d.recent, evt)
+			if len(d.recent) > dashboardEvents {
+				d.recent = d.recent[len(d.recent)-dashboardEvents:]
+			}
+			d.mu.Unlock()
+		}
+	}
+}
+
+// dashboardPeer 是控制台中的一个已连接节点
+type dashboardPeer struct {
+	ID           string `json:"id"`
+	Addr         string `json:"addr"`
+	Transport    string `json:"transport"`
+	Direction    string `json:"direction"`
+	AgentVersion string `json:"agent_version,omitempty"`
+	Country      string `json:"country,omitempty"`
+	Opened       string `json:"opened"`
+}
+
+// dashboardData 是 /v1/dashboard 的内容
+type dashboardData struct {
+	Time         time.Time         `json:"time"`
+	ID           string            `json:"id"`
+	Reachability string            `json:"reachability"`
+	Peers        int               `json:"peers"`
+	Conns        int               `json:"conns"`
+	RoutingTable int               `json:"routing_table"`
+	Transports   map[string]int    `json:"transports"`
+	Directions   map[string]int    `json:"directions"`
+	Countries    map[string]uint64 `json:"countries,omitempty"`
+	ASNs         map[string]uint64 `json:"asns,omitempty"`
+	Bandwidth    bandwidthStatus   `json:"bandwidth"`
+	Relay        map[string]int    `json:"relay"`
+	PeerList     []dashboardPeer   `json:"peer_list"`
+	Events       []nodeEvent       `json:"events"`
+}
+
+func (d *dashboard) data() dashboardData {
+	n := d.h.Network()
+	result := dashboardData{
+		Time:         time.Now(),
+		ID:           d.h.ID().String(),
+		Reachability: d.reachability.Get().String(),
+		Peers:        len(n.Peers()),
+		RoutingTable: d.idht.RoutingTable().Size(),
+		Transports:   make(map[string]int),
+		Directions:   make(map[string]int),
+		Bandwidth:    newBandwidthStatus(d.bwc.GetBandwidthTotals()),
+		PeerList:     []dashboardPeer{},
+	}
+	streams := relayStreams(n)
+	result.Relay = map[string]int{"streams": streams, "circuits": streams / 2}
+	if d.relay != nil {
+		reservations, circuits := d.relay.active()
+		result.Relay["reservations"], result.Relay["circuits"] = int(reservations), int(circuits)
+	}
+	if d.geo != nil {
+		result.Countries, result.ASNs = d.geo.distribution(n)
+	}
+	conns := n.Conns()
+	result.Conns = len(conns)
+	// 最近建立的连接排在前面
+	sort.Slice(conns, func(i, j int) bool { return conns[i].Stat().Opened.After(conns[j].Stat().Opened) })
+	for _, c := range conns {
+		transport, direction := connTransport(c), c.Stat().Direction.String()
+		result.Transports[transport]++
+		result.Directions[direction]++
+		if len(result.PeerList) >= dashboardPeers {
+			continue
+		}
+		p := dashboardPeer{ID: c.RemotePeer().String(), Addr: c.RemoteMultiaddr().String(), Transport: transport, Direction: direction, Opened: time.Since(c.Stat().Opened).Truncate(time.Second).String()}
+		if v, e := d.h.Peerstore().Get(c.RemotePeer(), "AgentVersion"); e == nil {
+			p.AgentVersion, _ = v.(string)
+		}
+		if d.geo != nil {
+			p.Country = d.geo.lookup(c.RemoteMultiaddr()).Country
+		}
+		result.PeerList = append(result.PeerList, p)
+	}
+	d.mu.Lock()
+	result.Events = make([]nodeEvent, len(d.recent))
+	copy(result.Events, d.recent)
+	d.mu.Unlock()
+	return result
+}
+
+// dashboardStatus 返回控制台的数据
+func (a *adminAPI) dashboardStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAdminError(w, http.StatusMethodNotAllowed, errors.New("只支持GET"))
+		return
+	}
+	if a.dashboard == nil {
+		writeAdminError(w, http.StatusNotFound, errors.New("控制台没有启用"))
+		return
+	}
+	writeAdminJSON(w, a.dashboard.data())
+}
+
+// serveDashboardPage 输出控制台页面
+func serveDashboardPage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "只支持GET", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	// 页面只请求同一来源的管理接口
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'")
+	w.Header().Set("X-Frame-Options", "DENY")
+	_, _ = w.Write(dashboardPage)
+}
@@ -0,0 +1,144 @@
+package bootstrap
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	badger "github.com/ipfs/go-ds-badger"
+	leveldb "github.com/ipfs/go-ds-leveldb"
+	pebble "github.com/ipfs/go-ds-pebble"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// datastoreKinds 是DHT记录和地址簿可以使用的存储
+var datastoreKinds = []string{"leveldb", "badger", "pebble", "memory"}
+
+// datastoreConfig 是保存在磁盘上的存储的维护设置, DHT记录和地址簿共用
+type datastoreConfig struct {
+	// CompactionInterval 是leveldb整理整个数据库的间隔, 回收删除的记录占用的空间, 0表示只由leveldb自己整理
+	CompactionInterval time.Duration `yaml:"compaction_interval"`
+	// BadgerGCInterval 是badger回收值日志的间隔, BadgerGCDiscardRatio 是值日志文件中可以丢弃的比例超过多少时重写
+	BadgerGCInterval     time.Duration `yaml:"badger_gc_interval"`
+	BadgerGCDiscardRatio float64       `yaml:"badger_gc_discard_ratio"`
+	// PebbleCacheSize 是pebble块缓存的大小(字节), 0表示使用pebble的默认值. pebble在后台自己整理
+	PebbleCacheSize int64 `yaml:"pebble_cache_size"`
+}
+
+// validate 检查kinds中用到的存储的维护设置, 没有用到的存储的设置不检查
+func (c datastoreConfig) validate(kinds ...string) error {
+	used := make(map[string]bool, len(kinds))
+	for _, k := range kinds {
+		used[k] = true
+	}
+	if used["leveldb"] && c.CompactionInterval < 0 {
+		return fmt.Errorf("leveldb整理间隔不能小于0: %s", c.CompactionInterval)
+	}
+	if used["badger"] {
+		if c.BadgerGCInterval <= 0 {
+			return fmt.Errorf("badger回收间隔必须大于0: %s", c.BadgerGCInterval)
+		}
+		if c.BadgerGCDiscardRatio <= 0 || c.BadgerGCDiscardRatio >= 1 {
+			return fmt.Errorf("badger回收比例必须在0和1之间: %v", c.BadgerGCDiscardRatio)
+		}
+	}
+	if used["pebble"] && c.PebbleCacheSize < 0 {
+		return fmt.Errorf("pebble缓存大小不能小于0: %d", c.PebbleCacheSize)
+	}
+	return nil
+}
+
+// checkDatastore 检查存储类型, what是用途, 用于错误信息
+func checkDatastore(what, kind string) error {
+	for _, k := range datastoreKinds {
+		if kind == k {
+			return nil
+		}
+	}
+	return fmt.Errorf("不支持的%s %s, 可用的有: %s", what, kind, strings.Join(datastoreKinds, ", "))
+}
+
+// datastorePath 返回数据目录中存储的目录. leveldb使用name, 保持与之前的版本相同, 其他存储加上类型,
+// 修改存储类型时不会打开另一种存储的文件
+func datastorePath(dir, name, kind string) string {
+	if kind == "leveldb" {
+		return filepath.Join(dir, name)
+	}
+	return filepath.Join(dir, name+"."+kind)
+}
+
+// compactingDatastore 定期整理leveldb, 关闭时先停止整理
+type compactingDatastore struct {
+	*leveldb.Datastore
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+func (d *compactingDatastore) run(interval time.Duration) {
+	defer close(d.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+		}
+		start := time.Now()
+		if e := d.DB.CompactRange(util.Range{}); e != nil {
+			logger.Warnw("整理leveldb出错", "error", e)
+			continue
+		}
+		logger.Debugw("整理leveldb", "duration", time.Since(start))
+	}
+}
+
+func (d *compactingDatastore) Close() error {
+	d.once.Do(func() { close(d.stop) })
+	<-d.done
+	return d.Datastore.Close()
+}
+
+// openDatastore 打开dir下name目录中的存储, memory返回内存存储
+func openDatastore(kind, dir, name string, cfg datastoreConfig) (ds.Batching, error) {
+	if kind == "memory" {
+		return dssync.MutexWrap(ds.NewMapDatastore()), nil
+	}
+	if e := os.MkdirAll(dir, 0700); e != nil {
+		return nil, e
+	}
+	path := datastorePath(dir, name, kind)
+	if kind == "badger" {
+		opts := badger.DefaultOptions
+		opts.GcInterval = cfg.BadgerGCInterval
+		opts.GcDiscardRatio = cfg.BadgerGCDiscardRatio
+		store, e := badger.NewDatastore(path, &opts)
+		if e != nil {
+			return nil, e
+		}
+		return store, nil
+	}
+	if kind == "pebble" {
+		store, e := pebble.NewDatastore(path, pebble.WithCacheSize(cfg.PebbleCacheSize))
+		if e != nil {
+			return nil, e
+		}
+		return store, nil
+	}
+	store, e := leveldb.NewDatastore(path, nil)
+	if e != nil {
+		return nil, e
+	}
+	if cfg.CompactionInterval <= 0 {
+		return store, nil
+	}
+	d := &compactingDatastore{Datastore: store, stop: make(chan struct{}), done: make(chan struct{})}
+	go d.run(cfg.CompactionInterval)
+	return d, nil
+}
@@ -0,0 +1,129 @@
+package bootstrap
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	record "github.com/libp2p/go-libp2p-record"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// dhtDatastoreDir 是数据目录中保存DHT记录的目录
+const dhtDatastoreDir = "dht"
+
+// defaultBucketSize 是kad-dht默认的桶大小, /ipfs协议只能使用此值
+const defaultBucketSize = 20
+
+// dhtModes 是支持的DHT模式
+var dhtModes = map[string]dht.ModeOpt{
+	"server":      dht.ModeServer,
+	"client":      dht.ModeClient,
+	"auto":        dht.ModeAuto,
+	"auto-server": dht.ModeAutoServer,
+}
+
+// dhtConfig 是DHT的设置
+type dhtConfig struct {
+	// Mode 默认为server: auto模式在NAT后会变成客户端, 不能作为引导节点
+	Mode string `yaml:"mode"`
+	// ProtocolPrefix 是DHT协议前缀, 为空时使用/ipfs, 与公共IPFS DHT共享路由表
+	ProtocolPrefix string `yaml:"protocol_prefix"`
+	// Datastore 是DHT记录(提供者, 值)的存储: leveldb和badger保存在数据目录中, 重启后保留; memory重启后丢失
+	Datastore string `yaml:"datastore"`
+	// RefreshInterval 是定期刷新路由表的间隔
+	RefreshInterval time.Duration `yaml:"refresh_interval"`
+	// RebootstrapBelow 是路由表节点数量下限, 低于时重新连接引导节点, 0表示不检查
+	RebootstrapBelow int `yaml:"rebootstrap_below"`
+	// BucketSize 是路由表每个桶的节点数量(k), 公共IPFS DHT必须为20.
+	// Concurrency 是每次查询同时请求的节点数量(alpha), Resiliency 是查询结束前必须回应的最近节点数量(beta).
+	BucketSize  int `yaml:"bucket_size"`
+	Concurrency int `yaml:"concurrency"`
+	Resiliency  int `yaml:"resiliency"`
+	// RefreshQueryTimeout 是刷新路由表时每次查询的超时, LatencyTolerance 是加入路由表的节点的最大延迟
+	RefreshQueryTimeout time.Duration `yaml:"refresh_query_timeout"`
+	LatencyTolerance    time.Duration `yaml:"latency_tolerance"`
+	// Accelerated 是加速DHT: 遍历整个网络, 查找时直接请求最近的节点
+	Accelerated acceleratedConfig `yaml:"accelerated"`
+	// Namespaces 是应用自己的记录命名空间, 需要设置协议前缀
+	Namespaces []recordNamespaceConfig `yaml:"namespaces"`
+}
+
+// dhtModeNames 返回所有DHT模式的名称
+func dhtModeNames() []string {
+	names := make([]string, 0, len(dhtModes))
+	for name := range dhtModes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// validate 检查DHT设置
+func (c dhtConfig) validate() error {
+	if _, ok := dhtModes[c.Mode]; !ok {
+		return fmt.Errorf("不支持的DHT模式 %s", c.Mode)
+	}
+	if c.ProtocolPrefix != "" && !strings.HasPrefix(c.ProtocolPrefix, "/") {
+		return fmt.Errorf("DHT协议前缀必须以/开头: %s", c.ProtocolPrefix)
+	}
+	if e := checkDatastore("DHT存储", c.Datastore); e != nil {
+		return e
+	}
+	if c.RefreshInterval <= 0 {
+		return fmt.Errorf("DHT刷新间隔必须大于0: %s", c.RefreshInterval)
+	}
+	if c.BucketSize <= 0 || c.Concurrency <= 0 || c.Resiliency <= 0 {
+		return fmt.Errorf("DHT桶大小, 并发数和回应数必须大于0: bucket_size %d, concurrency %d, resiliency %d", c.BucketSize, c.Concurrency, c.Resiliency)
+	}
+	if c.ProtocolPrefix == "" && c.BucketSize != defaultBucketSize {
+		return fmt.Errorf("公共IPFS DHT的桶大小必须为%d, 修改桶大小需要设置协议前缀: %d", defaultBucketSize, c.BucketSize)
+	}
+	if c.RefreshQueryTimeout <= 0 || c.LatencyTolerance <= 0 {
+		return fmt.Errorf("DHT刷新查询超时和延迟容忍必须大于0: refresh_query_timeout %s, latency_tolerance %s", c.RefreshQueryTimeout, c.LatencyTolerance)
+	}
+	if e := validateRecordNamespaces(c.Namespaces, c.ProtocolPrefix); e != nil {
+		return e
+	}
+	return c.Accelerated.validate()
+}
+
+// protocolID 返回DHT协议, 如/ipfs/kad/1.0.0
+func (c dhtConfig) protocolID() protocol.ID {
+	prefix := dht.DefaultPrefix
+	if c.ProtocolPrefix != "" {
+		prefix = protocol.ID(c.ProtocolPrefix)
+	}
+	return prefix + "/kad/1.0.0"
+}
+
+// openDatastore 打开dir下的DHT存储, 内存存储与DHT默认使用的相同. 统计记录数量时需要访问存储
+func (c dhtConfig) openDatastore(dir string, cfg datastoreConfig) (ds.Batching, error) {
+	return openDatastore(c.Datastore, dir, dhtDatastoreDir, cfg)
+}
+
+// options 返回创建DHT的选项, store为nil时使用内存存储, validators是pk和ipns以外的记录命名空间
+func (c dhtConfig) options(store ds.Batching, validators map[string]record.Validator) []dht.Option {
+	options := []dht.Option{
+		dht.Mode(dhtModes[c.Mode]),
+		dht.RoutingTableRefreshPeriod(c.RefreshInterval),
+		dht.RoutingTableRefreshQueryTimeout(c.RefreshQueryTimeout),
+		dht.RoutingTableLatencyTolerance(c.LatencyTolerance),
+		dht.BucketSize(c.BucketSize),
+		dht.Concurrency(c.Concurrency),
+		dht.Resiliency(c.Resiliency),
+	}
+	if store != nil {
+		options = append(options, dht.Datastore(store))
+	}
+	if c.ProtocolPrefix != "" {
+		options = append(options, dht.ProtocolPrefix(protocol.ID(c.ProtocolPrefix)))
+	}
+	for name, v := range validators {
+		options = append(options, dht.NamespacedValidator(name, v))
+	}
+	return options
+}
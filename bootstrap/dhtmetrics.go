@@ -0,0 +1,159 @@
+package bootstrap
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	dhtmetrics "github.com/libp2p/go-libp2p-kad-dht/metrics"
+	kb "github.com/libp2p/go-libp2p-kbucket"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+)
+
+// dhtRecordsInterval 是统计DHT存储中记录数量的最短间隔, 遍历存储较慢, 不在每次抓取时进行
+const dhtRecordsInterval = time.Minute
+
+// dhtProvidersPrefix 是DHT存储中提供者记录的前缀, 其他记录是PUT_VALUE保存的值
+const dhtProvidersPrefix = "/providers/"
+
+// dhtReceivedView 按消息类型和DHT实例统计收到的请求, 数据由DHT通过OpenCensus记录
+var dhtReceivedView = &view.View{
+	Name:        "bootstrap/dht/received_messages",
+	Measure:     dhtmetrics.ReceivedMessages,
+	TagKeys:     []tag.Key{dhtmetrics.KeyMessageType, dhtmetrics.KeyInstanceID},
+	Aggregation: view.Count(),
+}
+
+var registerDHTView sync.Once
+
+// dhtMetrics 提供DHT的指标: 收到的各类请求, 存储的记录, 路由表每个桶的节点数量和运行模式
+type dhtMetrics struct {
+	idht  *dht.IpfsDHT
+	store ds.Datastore
+	// instance 与DHT记录指标时的instance_id相同, 区分同一进程中的多个DHT
+	instance string
+
+	mu        sync.Mutex
+	counted   time.Time
+	providers uint64
+	values    uint64
+}
+
+func newDHTMetrics(idht *dht.IpfsDHT, store ds.Datastore) *dhtMetrics {
+	registerDHTView.Do(func() {
+		if e := view.Register(dhtReceivedView); e != nil {
+			logger.Warnw("注册DHT指标出错", "error", e)
+		}
+	})
+	return &dhtMetrics{idht: idht, store: store, instance: fmt.Sprintf("%p", idht)}
+}
+
+// received 返回按消息类型统计的收到的请求数量
+func (m *dhtMetrics) received() map[string]uint64 {
+	counts := make(map[string]uint64)
+	rows, e := view.RetrieveData(dhtReceivedView.Name)
+	if e != nil {
+		return counts
+	}
+	for _, row := range rows {
+		var kind, instance string
+		for _, t := range row.Tags {
+			switch t.Key {
+			case dhtmetrics.KeyMessageType:
+				kind = t.Value
+			case dhtmetrics.KeyInstanceID:
+				instance = t.Value
+			}
+		}
+		if instance != m.instance {
+			continue
+		}
+		if data, ok := row.Data.(*view.CountData); ok {
+			counts[kind] += uint64(data.Value)
+		}
+	}
+	return counts
+}
+
+// records 返回存储的提供者记录和值记录数量, 每dhtRecordsInterval最多统计一次
+func (m *dhtMetrics) records() (providers, values uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if time.Since(m.counted) < dhtRecordsInterval {
+		return m.providers, m.values
+	}
+	results, e := m.store.Query(context.Background(), query.Query{KeysOnly: true})
+	if e != nil {
+		logger.Warnw("统计DHT记录出错", "error", e)
+		return m.providers, m.values
+	}
+	defer results.Close()
+	providers, values = 0, 0
+	for r := range results.Next() {
+		if r.Error != nil {
+			logger.Warnw("统计DHT记录出错", "error", r.Error)
+			return m.providers, m.values
+		}
+		if strings.HasPrefix(r.Key, dhtProvidersPrefix) {
+			providers++
+		} else {
+			values++
+		}
+	}
+	m.counted, m.providers, m.values = time.Now(), providers, values
+	return providers, values
+}
+
+// routingTableBuckets 返回路由表中按与本节点的公共前缀长度统计的节点数量
+func routingTableBuckets(idht *dht.IpfsDHT) map[int]int {
+	self := kb.ConvertPeerID(idht.PeerID())
+	counts := make(map[int]int)
+	for _, p := range idht.RoutingTable().ListPeers() {
+		counts[kb.CommonPrefixLen(self, kb.ConvertPeerID(p))]++
+	}
+	return counts
+}
+
+func (m *dhtMetrics) buckets() map[string]uint64 {
+	counts := make(map[string]uint64)
+	for cpl, n := range routingTableBuckets(m.idht) {
+		counts[strconv.Itoa(cpl)] = uint64(n)
+	}
+	return counts
+}
+
+// mode 返回DHT当前的运行模式
+func (m *dhtMetrics) mode() string {
+	if m.idht.Mode() == dht.ModeServer {
+		return "server"
+	}
+	return "client"
+}
+
+func (m *dhtMetrics) writeMetrics(w io.Writer) {
+	writeLabeledMetric(w, "bootstrap_dht_received_messages_total", "counter", "按消息类型统计的收到的DHT请求数量", "type", m.received())
+	providers, values := m.records()
+	writeMetric(w, "bootstrap_dht_provider_records", "gauge", "存储的提供者记录数量", providers)
+	writeMetric(w, "bootstrap_dht_value_records", "gauge", "存储的值记录(公钥, IPNS等)数量", values)
+	writeLabeledMetric(w, "bootstrap_dht_bucket_peers", "gauge", "路由表中按公共前缀长度统计的节点数量", "cpl", m.buckets())
+	writeLabeledMetric(w, "bootstrap_dht_mode", "gauge", "DHT运行模式, 当前模式为1", "mode", map[string]uint64{m.mode(): 1})
+}
+
+// status 返回DHT的统计, 用于 /status
+func (m *dhtMetrics) status() interface{} {
+	providers, values := m.records()
+	return map[string]interface{}{
+		"mode":      m.mode(),
+		"received":  m.received(),
+		"providers": providers,
+		"values":    values,
+	}
+}
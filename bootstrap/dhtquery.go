@@ -0,0 +1,207 @@
+package bootstrap
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+)
+
+// dhtQueryTimeout 是管理接口中一次DHT查询的最长时间
+const dhtQueryTimeout = time.Minute
+
+// dhtProvidersLimit 是findprovs默认最多返回的提供者数量
+const dhtProvidersLimit = 20
+
+// dhtCommands 是dht的子命令
+var dhtCommands = map[string]func(args []string) int{
+	"findpeer":  runDHTFindPeer,
+	"findprovs": runDHTFindProvs,
+}
+
+// adminDHTRequest 是 /v1/dht/findpeer 和 /v1/dht/findprovs 的请求, limit只用于findprovs, 0表示默认数量
+type adminDHTRequest struct {
+	Peer  string `json:"peer,omitempty"`
+	CID   string `json:"cid,omitempty"`
+	Limit int    `json:"limit,omitempty"`
+}
+
+// adminDHTPeer 是DHT查询找到的一个节点
+type adminDHTPeer struct {
+	ID    string   `json:"id"`
+	Addrs []string `json:"addrs"`
+}
+
+// adminDHTResult 是DHT查询的结果, elapsed是查询用时, source是dht或accelerated(加速DHT)
+type adminDHTResult struct {
+	Peers   []adminDHTPeer `json:"peers"`
+	Elapsed string         `json:"elapsed"`
+	Source  string         `json:"source"`
+}
+
+func newAdminDHTPeer(info peer.AddrInfo) adminDHTPeer {
+	p := adminDHTPeer{ID: info.ID.String(), Addrs: make([]string, 0, len(info.Addrs))}
+	for _, addr := range info.Addrs {
+		p.Addrs = append(p.Addrs, addr.String())
+	}
+	return p
+}
+
+// decodeDHTRequest 读取DHT查询的请求, 出错时已输出错误
+func decodeDHTRequest(w http.ResponseWriter, r *http.Request) (*adminDHTRequest, bool) {
+	if r.Method != http.MethodPost {
+		writeAdminError(w, http.StatusMethodNotAllowed, errors.New("只支持POST"))
+		return nil, false
+	}
+	var req adminDHTRequest
+	if e := json.NewDecoder(http.MaxBytesReader(w, r.Body, adminRequestMax)).Decode(&req); e != nil {
+		writeAdminError(w, http.StatusBadRequest, e)
+		return nil, false
+	}
+	return &req, true
+}
+
+// findPeer 通过DHT查找节点的地址
+func (a *adminAPI) findPeer(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeDHTRequest(w, r)
+	if !ok {
+		return
+	}
+	id, e := peer.Decode(req.Peer)
+	if e != nil {
+		writeAdminError(w, http.StatusBadRequest, e)
+		return
+	}
+	if a.fullrt != nil {
+		start := time.Now()
+		if info, ok := a.fullrt.findPeer(id); ok {
+			writeAdminJSON(w, adminDHTResult{Peers: []adminDHTPeer{newAdminDHTPeer(info)}, Elapsed: time.Since(start).String(), Source: "accelerated"})
+			return
+		}
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), dhtQueryTimeout)
+	defer cancel()
+	start := time.Now()
+	info, e := a.idht.FindPeer(ctx, id)
+	elapsed := time.Since(start)
+	if errors.Is(e, routing.ErrNotFound) {
+		writeAdminError(w, http.StatusNotFound, fmt.Errorf("没有找到节点 %s, 用时 %s", id, elapsed.Round(time.Millisecond)))
+		return
+	}
+	if e != nil {
+		writeAdminError(w, http.StatusBadGateway, e)
+		return
+	}
+	logger.Infow("管理接口查找节点", "peer", id, "addrs", len(info.Addrs), "elapsed", elapsed)
+	writeAdminJSON(w, adminDHTResult{Peers: []adminDHTPeer{newAdminDHTPeer(info)}, Elapsed: elapsed.String(), Source: "dht"})
+}
+
+// findProviders 通过DHT查找内容的提供者, 找到limit个或超时后返回
+func (a *adminAPI) findProviders(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeDHTRequest(w, r)
+	if !ok {
+		return
+	}
+	key, e := cid.Decode(req.CID)
+	if e != nil {
+		writeAdminError(w, http.StatusBadRequest, e)
+		return
+	}
+	limit := req.Limit
+	if limit <= 0 {
+		limit = dhtProvidersLimit
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), dhtQueryTimeout)
+	defer cancel()
+	start := time.Now()
+	result := adminDHTResult{Peers: []adminDHTPeer{}, Source: "dht"}
+	if a.fullrt != nil && a.fullrt.ready() {
+		result.Source = "accelerated"
+		for _, info := range a.fullrt.findProviders(ctx, key, limit) {
+			result.Peers = append(result.Peers, newAdminDHTPeer(info))
+		}
+	} else {
+		for info := range a.idht.FindProvidersAsync(ctx, key, limit) {
+			result.Peers = append(result.Peers, newAdminDHTPeer(info))
+		}
+	}
+	elapsed := time.Since(start)
+	result.Elapsed = elapsed.String()
+	logger.Infow("管理接口查找提供者", "cid", key, "providers", len(result.Peers), "elapsed", elapsed, "source", result.Source)
+	writeAdminJSON(w, result)
+}
+
+// runDHT 通过运行中节点的DHT查询
+func runDHT(args []string) int {
+	if len(args) == 0 || dhtCommands[args[0]] == nil {
+		fmt.Fprintln(os.Stderr, "用法: bootstrap dht [findpeer|findprovs] [参数]")
+		return 2
+	}
+	return dhtCommands[args[0]](args[1:])
+}
+
+// runDHTFindPeer 查找节点的地址: bootstrap dht findpeer <节点ID>
+func runDHTFindPeer(args []string) int {
+	fs := flag.NewFlagSet("dht findpeer", flag.ExitOnError)
+	cfg, e := commandConfig(fs, args)
+	if e != nil {
+		logger.Errorw("读取设置出错", "error", e)
+		return 1
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "用法: bootstrap dht findpeer [参数] <节点ID>")
+		return 2
+	}
+	return queryDHT(cfg, "/v1/dht/findpeer", adminDHTRequest{Peer: fs.Arg(0)})
+}
+
+// runDHTFindProvs 查找内容的提供者: bootstrap dht findprovs [-n 20] <CID>
+func runDHTFindProvs(args []string) int {
+	fs := flag.NewFlagSet("dht findprovs", flag.ExitOnError)
+	limit := fs.Int("n", dhtProvidersLimit, "最多返回的提供者数量")
+	cfg, e := commandConfig(fs, args)
+	if e != nil {
+		logger.Errorw("读取设置出错", "error", e)
+		return 1
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "用法: bootstrap dht findprovs [-n 20] <CID>")
+		return 2
+	}
+	return queryDHT(cfg, "/v1/dht/findprovs", adminDHTRequest{CID: fs.Arg(0), Limit: *limit})
+}
+
+// queryDHT 请求管理接口查询并输出结果, 每行输出节点ID和地址, 最后输出数量和用时
+func queryDHT(cfg *Config, path string, req adminDHTRequest) int {
+	c, e := newAdminClient(cfg)
+	if e != nil {
+		logger.Errorw("创建管理接口客户端出错", "error", e)
+		return 1
+	}
+	c.client.Timeout = dhtQueryTimeout + time.Second*5
+	var result adminDHTResult
+	if e := c.post(path, req, &result); e != nil {
+		logger.Errorw("DHT查询出错", "error", e)
+		return 1
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, p := range result.Peers {
+		fmt.Fprintf(tw, "%s\t%s\n", p.ID, strings.Join(p.Addrs, ","))
+	}
+	_ = tw.Flush()
+	logger.Infow("DHT查询完成", "peers", len(result.Peers), "elapsed", result.Elapsed, "source", result.Source)
+	if len(result.Peers) == 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,243 @@
+package bootstrap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	record "github.com/libp2p/go-libp2p-record"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// recordSigningDomain 是签名记录的签名内容前缀, 避免与其他签名混用
+const recordSigningDomain = "/bootstrap/dht-record/1.0.0\n"
+
+// recordNamespaceTypes 是配置中可以使用的记录类型
+var recordNamespaceTypes = []string{"signed"}
+
+// recordNamespaceConfig 是DHT中应用自己的一种记录. 键为 /<Name>/<节点ID>[/<名称>],
+// 值是SignRecord生成的签名记录, 只有键中的节点可以写入, 序号大的记录替换序号小的
+type recordNamespaceConfig struct {
+	// Name 是命名空间, 即键的第一段, 不能是pk和ipns
+	Name string `yaml:"name"`
+	// Type 是记录类型, 当前只有signed
+	Type string `yaml:"type"`
+	// MaxTTL 是记录的最长有效期, 过期时间更晚的记录被拒绝, 0表示不限制.
+	// DHT最多保存记录36小时, 需要更长时写入者应定期重新写入
+	MaxTTL time.Duration `yaml:"max_ttl"`
+	// MaxSize 是记录中值的最大字节数, 0表示不限制
+	MaxSize int `yaml:"max_size"`
+}
+
+// validate 检查记录命名空间设置
+func (c recordNamespaceConfig) validate() error {
+	if c.Name == "" || strings.Contains(c.Name, "/") {
+		return fmt.Errorf("记录命名空间错误: %q", c.Name)
+	}
+	if c.Name == "pk" || c.Name == "ipns" {
+		return fmt.Errorf("记录命名空间%s由DHT使用", c.Name)
+	}
+	if c.Type != "signed" {
+		return fmt.Errorf("不支持的记录类型 %s, 可用的有: %s", c.Type, strings.Join(recordNamespaceTypes, ", "))
+	}
+	if c.MaxTTL < 0 || c.MaxSize < 0 {
+		return fmt.Errorf("记录命名空间%s的max_ttl和max_size不能小于0", c.Name)
+	}
+	return nil
+}
+
+// validateRecordNamespaces 检查命名空间没有重复. 公共IPFS DHT只允许pk和ipns, 需要设置协议前缀
+func validateRecordNamespaces(namespaces []recordNamespaceConfig, protocolPrefix string) error {
+	if len(namespaces) > 0 && protocolPrefix == "" {
+		return errors.New("公共IPFS DHT不能加入记录命名空间, 需要设置DHT协议前缀")
+	}
+	names := make(map[string]bool)
+	for _, ns := range namespaces {
+		if e := ns.validate(); e != nil {
+			return e
+		}
+		if names[ns.Name] {
+			return fmt.Errorf("记录命名空间%s重复", ns.Name)
+		}
+		names[ns.Name] = true
+	}
+	return nil
+}
+
+// SignedRecord 是signed类型命名空间中的记录
+type SignedRecord struct {
+	// Value 是应用的数据
+	Value []byte `json:"value"`
+	// Seq 是序号, 同一个键的新记录必须使用更大的序号
+	Seq uint64 `json:"seq"`
+	// Expires 是过期时间, 过期的记录被拒绝
+	Expires time.Time `json:"expires"`
+	// PublicKey 是写入者的公钥, 节点ID中包含公钥(如ed25519)时为空
+	PublicKey []byte `json:"public_key,omitempty"`
+	// Signature 是写入者对键, 值, 序号和过期时间的签名
+	Signature []byte `json:"signature"`
+}
+
+// recordSigningData 返回签名记录的签名内容
+func recordSigningData(key string, r *SignedRecord) []byte {
+	var b bytes.Buffer
+	b.WriteString(recordSigningDomain)
+	b.WriteString(key)
+	b.WriteByte('\n')
+	var n [8]byte
+	binary.BigEndian.PutUint64(n[:], r.Seq)
+	b.Write(n[:])
+	binary.BigEndian.PutUint64(n[:], uint64(r.Expires.UnixNano()))
+	b.Write(n[:])
+	b.Write(r.Value)
+	return b.Bytes()
+}
+
+// SignRecord 用私钥签名signed类型命名空间中的记录, 返回写入DHT(PutValue)的值.
+// key是完整的键, 如 /myapp/<节点ID>, 其中的节点ID必须是私钥对应的节点ID
+func SignRecord(priv crypto.PrivKey, key string, value []byte, seq uint64, ttl time.Duration) ([]byte, error) {
+	r := &SignedRecord{Value: value, Seq: seq, Expires: time.Now().Add(ttl).UTC()}
+	id, e := peer.IDFromPrivateKey(priv)
+	if e != nil {
+		return nil, e
+	}
+	if _, e := id.ExtractPublicKey(); e != nil {
+		if r.PublicKey, e = crypto.MarshalPublicKey(priv.GetPublic()); e != nil {
+			return nil, e
+		}
+	}
+	if owner, e := recordOwner(key); e != nil || owner != id {
+		return nil, fmt.Errorf("键%s不属于节点%s", key, id)
+	}
+	if r.Signature, e = priv.Sign(recordSigningData(key, r)); e != nil {
+		return nil, e
+	}
+	return json.Marshal(r)
+}
+
+// recordOwner 返回键 /<命名空间>/<节点ID>[/<名称>] 中的节点ID
+func recordOwner(key string) (peer.ID, error) {
+	_, path, e := record.SplitKey(key)
+	if e != nil {
+		return "", e
+	}
+	return peer.Decode(strings.SplitN(path, "/", 2)[0])
+}
+
+// signedRecordValidator 验证signed类型命名空间中的记录
+type signedRecordValidator struct {
+	cfg recordNamespaceConfig
+}
+
+// parse 解析并验证记录
+func (v signedRecordValidator) parse(key string, value []byte) (*SignedRecord, error) {
+	owner, e := recordOwner(key)
+	if e != nil {
+		return nil, fmt.Errorf("键中没有节点ID: %w", e)
+	}
+	r := &SignedRecord{}
+	if e := json.Unmarshal(value, r); e != nil {
+		return nil, e
+	}
+	if v.cfg.MaxSize > 0 && len(r.Value) > v.cfg.MaxSize {
+		return nil, fmt.Errorf("记录太大: %d", len(r.Value))
+	}
+	now := time.Now()
+	if !r.Expires.After(now) {
+		return nil, errors.New("记录已过期")
+	}
+	if v.cfg.MaxTTL > 0 && r.Expires.After(now.Add(v.cfg.MaxTTL)) {
+		return nil, fmt.Errorf("记录的有效期超过%s", v.cfg.MaxTTL)
+	}
+	var pub crypto.PubKey
+	if len(r.PublicKey) > 0 {
+		if pub, e = crypto.UnmarshalPublicKey(r.PublicKey); e != nil {
+			return nil, e
+		}
+		if !owner.MatchesPublicKey(pub) {
+			return nil, errors.New("公钥与键中的节点ID不匹配")
+		}
+	} else if pub, e = owner.ExtractPublicKey(); e != nil {
+		return nil, errors.New("记录中没有公钥")
+	}
+	if ok, e := pub.Verify(recordSigningData(key, r), r.Signature); e != nil || !ok {
+		return nil, errors.New("记录的签名无效")
+	}
+	return r, nil
+}
+
+func (v signedRecordValidator) Validate(key string, value []byte) error {
+	_, e := v.parse(key, value)
+	return e
+}
+
+// Select 选择序号最大的记录, 序号相同时选择过期时间最晚的
+func (v signedRecordValidator) Select(key string, values [][]byte) (int, error) {
+	best := -1
+	var bestRecord *SignedRecord
+	for i, value := range values {
+		r, e := v.parse(key, value)
+		if e != nil {
+			continue
+		}
+		if bestRecord == nil || r.Seq > bestRecord.Seq || (r.Seq == bestRecord.Seq && r.Expires.After(bestRecord.Expires)) {
+			best, bestRecord = i, r
+		}
+	}
+	if best < 0 {
+		return 0, errors.New("没有有效的记录")
+	}
+	return best, nil
+}
+
+// recordValidators 返回配置的和WithRecordValidator加入的命名空间的验证器
+func recordValidators(namespaces []recordNamespaceConfig, extra map[string]record.Validator) (map[string]record.Validator, error) {
+	validators := make(map[string]record.Validator, len(namespaces)+len(extra))
+	for _, ns := range namespaces {
+		validators[ns.Name] = signedRecordValidator{cfg: ns}
+	}
+	for name, v := range extra {
+		if _, ok := validators[name]; ok {
+			return nil, fmt.Errorf("记录命名空间%s重复", name)
+		}
+		validators[name] = v
+	}
+	return validators, nil
+}
+
+// recordNamespaceNames 返回按名称排序的命名空间, 用于日志
+func recordNamespaceNames(validators map[string]record.Validator) string {
+	names := make([]string, 0, len(validators))
+	for name := range validators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// WithRecordValidator 在DHT中加入应用自己的记录命名空间, 键为 /<namespace>/..., 由v验证和选择记录.
+// 公共IPFS DHT不允许其他命名空间, 需要设置DHT协议前缀
+func WithRecordValidator(namespace string, v record.Validator) Option {
+	return func(n *Node) error {
+		if namespace == "" || strings.Contains(namespace, "/") || v == nil {
+			return fmt.Errorf("记录命名空间错误: %q", namespace)
+		}
+		if namespace == "pk" || namespace == "ipns" {
+			return fmt.Errorf("记录命名空间%s由DHT使用", namespace)
+		}
+		if n.validators == nil {
+			n.validators = make(map[string]record.Validator)
+		}
+		if _, ok := n.validators[namespace]; ok {
+			return fmt.Errorf("记录命名空间%s重复", namespace)
+		}
+		n.validators[namespace] = v
+		return nil
+	}
+}
@@ -0,0 +1,93 @@
+package bootstrap
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// dhtCheckInterval 是检查路由表大小的间隔
+const dhtCheckInterval = time.Minute
+
+// dhtRefresher 在路由表节点数量低于下限时重新连接引导节点并立即刷新路由表.
+// 定期刷新由DHT按refresh_interval完成.
+type dhtRefresher struct {
+	h     host.Host
+	idht  *dht.IpfsDHT
+	below int
+	dials *dialStats
+
+	mu              sync.Mutex
+	tiers           [][]peer.AddrInfo
+	minPeers        int
+	timeout         time.Duration
+	rebootstraps    uint64
+	lastRebootstrap time.Time
+}
+
+func newDHTRefresher(h host.Host, idht *dht.IpfsDHT, below int, dials *dialStats) *dhtRefresher {
+	return &dhtRefresher{h: h, idht: idht, below: below, dials: dials}
+}
+
+// setTiers 设置重新连接的引导节点, 重新加载配置时更新
+func (r *dhtRefresher) setTiers(tiers [][]peer.AddrInfo, minPeers int, timeout time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tiers = tiers
+	r.minPeers = minPeers
+	r.timeout = timeout
+}
+
+func (r *dhtRefresher) run(ctx context.Context) {
+	if r.below <= 0 {
+		return
+	}
+	ticker := time.NewTicker(dhtCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if size := r.idht.RoutingTable().Size(); size < r.below {
+				logger.Infow("路由表节点数量过少, 重新连接引导节点", "size", size, "below", r.below)
+				r.rebootstrap(ctx)
+			}
+		}
+	}
+}
+
+// rebootstrap 连接引导节点后刷新路由表
+func (r *dhtRefresher) rebootstrap(ctx context.Context) {
+	r.mu.Lock()
+	tiers, minPeers, timeout := r.tiers, r.minPeers, r.timeout
+	r.rebootstraps++
+	r.lastRebootstrap = time.Now()
+	r.mu.Unlock()
+
+	connectTiers(ctx, r.h, tiers, minPeers, timeout, r.dials)
+	select {
+	case <-ctx.Done():
+	case e := <-r.idht.RefreshRoutingTable():
+		if e != nil {
+			logger.Warnw("刷新路由表出错", "error", e)
+			return
+		}
+		logger.Infow("已刷新路由表", "size", r.idht.RoutingTable().Size())
+	}
+}
+
+// status 返回重新连接的次数
+func (r *dhtRefresher) status() interface{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return map[string]interface{}{
+		"below":            r.below,
+		"rebootstraps":     r.rebootstraps,
+		"last_rebootstrap": r.lastRebootstrap,
+	}
+}
@@ -0,0 +1,489 @@
+package bootstrap
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/pnet"
+	autonat "github.com/libp2p/go-libp2p/p2p/host/autonat"
+	nat "github.com/libp2p/go-nat"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// secondarySTUNServer 是判断NAT映射方式的第二个STUN服务器, 需要与defaultSTUNServer的IP不同
+const secondarySTUNServer = "stun.cloudflare.com:3478"
+
+// diagDialBackPeers 是每种传输最多请求回拨的节点数量
+const diagDialBackPeers = 3
+
+// NAT诊断的结论
+const (
+	natPublic     = "public"
+	natFirewalled = "firewalled"
+	natCone       = "cone"
+	natSymmetric  = "symmetric"
+	natUnknown    = "unknown"
+)
+
+// diagCommands 是diag的子命令
+var diagCommands = map[string]func(args []string) int{
+	"nat": runDiagNAT,
+}
+
+// runDiag 执行诊断子命令
+func runDiag(args []string) int {
+	if len(args) == 0 || diagCommands[args[0]] == nil {
+		fmt.Fprintln(os.Stderr, "用法: bootstrap diag [nat] [参数]")
+		return 2
+	}
+	return diagCommands[args[0]](args[1:])
+}
+
+// runDiagNAT 诊断NAT穿透: 路由器端口映射, STUN映射方式, TCP和QUIC的AutoNAT回拨. 公网可达时退出码为0
+func runDiagNAT(args []string) int {
+	fs := flag.NewFlagSet("diag nat", flag.ExitOnError)
+	stun := fs.String("stun", defaultSTUNServer+","+secondarySTUNServer, "STUN服务器, 逗号分隔, 至少两个不同IP的服务器才能判断映射方式")
+	timeout := fs.Duration("timeout", time.Second*15, "每次连接和回拨的超时时间")
+	cfg, e := commandConfig(fs, args)
+	if e != nil {
+		logger.Errorw("读取设置出错", "error", e)
+		return 1
+	}
+	var psk pnet.PSK
+	if cfg.SwarmKey != "" {
+		if psk, e = loadSwarmKey(cfg.SwarmKey); e != nil {
+			logger.Errorw("读取私有网络密钥出错", "error", e)
+			return 1
+		}
+	}
+	d, e := diagnoseNAT(context.Background(), cfg, strings.Split(*stun, ","), *timeout, psk)
+	if e != nil {
+		logger.Errorw("NAT诊断出错", "error", e)
+		return 1
+	}
+	d.print(os.Stdout)
+	if d.verdict == natPublic {
+		return 0
+	}
+	return 1
+}
+
+// diagTransport 是要检查的一种传输, name是addrTransport返回的名称
+type diagTransport struct {
+	name  string
+	label string
+	port  int
+}
+
+// dialBackResult 是一次AutoNAT回拨的结果
+type dialBackResult struct {
+	peer peer.ID
+	// addr 是回拨成功的地址
+	addr ma.Multiaddr
+	err  error
+}
+
+// natDiagnosis 是NAT诊断的结果
+type natDiagnosis struct {
+	// gateways 是找到的路由器, 如 "UPnP (IGDv2-IP1) 外部IP 203.0.113.1"
+	gateways  []string
+	portmap   bool
+	stun      []udpProbeResult
+	localPort int
+	// mapping 是NAT映射方式: none(本机有公网IP), independent, dependent, 为空时不确定
+	mapping    string
+	transports []diagTransport
+	dialBacks  map[string][]dialBackResult
+	verdict    string
+	hints      []string
+}
+
+// diagnoseNAT 依次检查路由器, STUN映射和AutoNAT回拨, 然后得出结论.
+// 使用临时身份在设置的端口上监听, 节点正在运行时端口会被占用
+func diagnoseNAT(ctx context.Context, cfg *Config, servers []string, timeout time.Duration, psk pnet.PSK) (*natDiagnosis, error) {
+	d := &natDiagnosis{portmap: cfg.NATPortMap && (cfg.UPnP || cfg.NATPMP), dialBacks: make(map[string][]dialBackResult)}
+	d.discoverGateways(ctx)
+
+	// 从QUIC端口请求STUN, 可以看出NAT是否保持端口
+	enableQUIC := cfg.Transports.QUIC && len(psk) == 0
+	d.checkMapping(ctx, servers, cfg.quicPort(), timeout)
+
+	if cfg.Transports.TCP {
+		d.transports = append(d.transports, diagTransport{name: "tcp", label: "TCP", port: cfg.tcpPort()})
+	}
+	if enableQUIC {
+		d.transports = append(d.transports, diagTransport{name: "quic-v1", label: "QUIC", port: cfg.quicPort()})
+	}
+	if len(d.transports) == 0 {
+		return nil, errors.New("没有启用TCP或QUIC")
+	}
+	dir, e := cfg.dataDir()
+	if e != nil {
+		return nil, e
+	}
+	tierAddrs, e := cfg.bootstrapTiers(dir)
+	if e != nil {
+		return nil, fmt.Errorf("读取引导节点出错: %w", e)
+	}
+	tiers, e := parseTiers(resolveTiers(ctx, tierAddrs, timeout))
+	if e != nil {
+		return nil, fmt.Errorf("引导节点地址错误: %w", e)
+	}
+	var peers []peer.AddrInfo
+	for _, tier := range tiers {
+		peers = append(peers, tier...)
+	}
+	if len(peers) == 0 {
+		d.hints = append(d.hints, "没有引导节点, 无法请求AutoNAT回拨, 用 -bootstrap 指定公网节点")
+	} else {
+		if e := d.dialBack(ctx, cfg, peers, timeout, psk, enableQUIC); e != nil {
+			return nil, e
+		}
+	}
+	d.conclude()
+	return d, nil
+}
+
+// discoverGateways 查找支持UPnP或NAT-PMP的路由器
+func (d *natDiagnosis) discoverGateways(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, natDiscoveryTimeout)
+	defer cancel()
+	for n := range nat.DiscoverNATs(ctx) {
+		gateway := natType(n)
+		if ip, e := n.GetExternalAddress(); e == nil {
+			gateway += " 外部IP " + ip.String()
+		} else {
+			gateway += " 获取外部IP出错: " + e.Error()
+		}
+		d.gateways = append(d.gateways, gateway)
+	}
+}
+
+// checkMapping 从同一个UDP端口向多个STUN服务器请求映射地址. 映射地址都相同是与目标无关的映射(锥形NAT),
+// 不同则是对称NAT; 映射地址是本机地址说明没有NAT
+func (d *natDiagnosis) checkMapping(ctx context.Context, servers []string, port int, timeout time.Duration) {
+	conn, e := net.ListenPacket("udp4", fmt.Sprint(":", port))
+	if e != nil {
+		// 端口被占用时使用其他端口, 仍然可以判断映射方式
+		if conn, e = net.ListenPacket("udp4", ":0"); e != nil {
+			d.hints = append(d.hints, "无法监听UDP端口: "+e.Error())
+			return
+		}
+	}
+	defer conn.Close()
+	d.localPort = conn.LocalAddr().(*net.UDPAddr).Port
+	var mapped []*net.UDPAddr
+	for _, server := range servers {
+		server = strings.TrimSpace(server)
+		if server == "" {
+			continue
+		}
+		result := udpProbeResult{Server: server}
+		addr, e := stunBindingFrom(ctx, conn, server, timeout)
+		if e != nil {
+			result.Error = e.Error()
+		} else {
+			result.Usable = true
+			result.Mapped = addr.String()
+			mapped = append(mapped, addr)
+		}
+		d.stun = append(d.stun, result)
+	}
+	if len(mapped) == 0 {
+		return
+	}
+	if isLocalIP(mapped[0].IP) {
+		d.mapping = "none"
+		return
+	}
+	if len(mapped) < 2 {
+		return
+	}
+	d.mapping = "independent"
+	for _, addr := range mapped[1:] {
+		if addr.String() != mapped[0].String() {
+			d.mapping = "dependent"
+		}
+	}
+}
+
+// isLocalIP 判断是否是本机网络接口的地址
+func isLocalIP(ip net.IP) bool {
+	addrs, e := net.InterfaceAddrs()
+	if e != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// externalIPs 返回STUN得到的外部IP
+func (d *natDiagnosis) externalIPs() []net.IP {
+	var ips []net.IP
+	for _, result := range d.stun {
+		if !result.Usable {
+			continue
+		}
+		addr, e := net.ResolveUDPAddr("udp", result.Mapped)
+		if e != nil {
+			continue
+		}
+		found := false
+		for _, ip := range ips {
+			found = found || ip.Equal(addr.IP)
+		}
+		if !found {
+			ips = append(ips, addr.IP)
+		}
+	}
+	return ips
+}
+
+// dialBack 在设置的端口上监听, 对每种传输只通过这种传输连接引导节点并请求回拨.
+// 出站连接不复用监听端口, 回拨到其他端口成功只说明NAT放行了已有的映射, 不代表监听端口可达
+func (d *natDiagnosis) dialBack(ctx context.Context, cfg *Config, peers []peer.AddrInfo, timeout time.Duration, psk pnet.PSK, enableQUIC bool) error {
+	set := cfg.transportSet(enableQUIC, psk, nil, nil)
+	set.TCPReusePort, set.QUICReusePort, set.WS = false, false, false
+	var listen []string
+	for _, ip := range cfg.listenIPs(nil) {
+		prefix := "/ip4/" + ip.String()
+		if ip.To4() == nil {
+			prefix = "/ip6/" + ip.String()
+		}
+		for _, t := range d.transports {
+			if t.name == "tcp" {
+				listen = append(listen, fmt.Sprint(prefix, "/tcp/", t.port))
+			} else {
+				listen = append(listen, fmt.Sprint(prefix, "/udp/", t.port, "/quic-v1"))
+			}
+		}
+	}
+	h, e := libp2p.New(append([]libp2p.Option{libp2p.ListenAddrStrings(listen...)}, transportOptions(set)...)...)
+	if e != nil {
+		return fmt.Errorf("监听 %v 出错, 节点正在运行时请先停止或用 -port 指定其他端口: %w", listen, e)
+	}
+	defer h.Close()
+
+	for _, t := range d.transports {
+		t := t
+		for _, p := range peers {
+			if len(d.dialBacks[t.name]) >= diagDialBackPeers {
+				break
+			}
+			info := peer.AddrInfo{ID: p.ID}
+			for _, addr := range p.Addrs {
+				if addrTransport(addr) == t.name {
+					info.Addrs = append(info.Addrs, addr)
+				}
+			}
+			if len(info.Addrs) == 0 {
+				continue
+			}
+			// 清除其他传输的地址, 保证通过这种传输连接
+			_ = h.Network().ClosePeer(p.ID)
+			h.Peerstore().ClearAddrs(p.ID)
+			result, ok := diagDialBack(ctx, h, info, d.candidateAddrs(h, t), timeout)
+			if ok {
+				d.dialBacks[t.name] = append(d.dialBacks[t.name], result)
+			}
+		}
+	}
+	return nil
+}
+
+// diagDialBack 连接节点并逐个请求回拨候选地址, 直到有一个成功. AutoNAT客户端不返回回拨成功的地址,
+// 所以每次只请求一个. 节点无法连接, 不支持AutoNAT或拒绝回拨时ok为false
+func diagDialBack(ctx context.Context, h host.Host, info peer.AddrInfo, candidates []ma.Multiaddr, timeout time.Duration) (result dialBackResult, ok bool) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if len(candidates) == 0 {
+		return result, false
+	}
+	if e := h.Connect(ctx, info); e != nil {
+		return result, false
+	}
+	defer h.Network().ClosePeer(info.ID)
+	var current ma.Multiaddr
+	client := autonat.NewAutoNATClient(h, func() []ma.Multiaddr { return []ma.Multiaddr{current} }, nil)
+	result.peer = info.ID
+	for _, addr := range candidates {
+		current = addr
+		e := client.DialBack(ctx, info.ID)
+		if e == nil {
+			result.addr, result.err = addr, nil
+			return result, true
+		}
+		if !autonat.IsDialError(e) {
+			return result, result.err != nil
+		}
+		result.err = e
+	}
+	return result, true
+}
+
+// candidateAddrs 返回请求回拨的地址: 节点观察到的公网地址, 以及STUN和路由器得到的外部IP加上监听端口
+func (d *natDiagnosis) candidateAddrs(h host.Host, t diagTransport) []ma.Multiaddr {
+	var addrs []ma.Multiaddr
+	for _, addr := range h.Addrs() {
+		if addrTransport(addr) == t.name && manet.IsPublicAddr(addr) {
+			addrs = append(addrs, addr)
+		}
+	}
+	for _, ip := range d.externalIPs() {
+		protocol, rest := "tcp", ma.Multiaddr(nil)
+		if t.name != "tcp" {
+			protocol, rest = "udp", ma.StringCast("/quic-v1")
+		}
+		if addr, e := externalAddr(ip, protocol, t.port, rest); e == nil {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// reachable 返回回拨到监听端口成功的结果数量和回拨到其他端口成功的数量
+func (d *natDiagnosis) reachable(t diagTransport) (listen, other int) {
+	for _, r := range d.dialBacks[t.name] {
+		if r.err != nil || r.addr == nil {
+			continue
+		}
+		code := ma.P_TCP
+		if t.name != "tcp" {
+			code = ma.P_UDP
+		}
+		if port, e := r.addr.ValueForProtocol(code); e == nil && port == strconv.Itoa(t.port) {
+			listen++
+		} else {
+			other++
+		}
+	}
+	return listen, other
+}
+
+// conclude 根据回拨和STUN映射得出结论和建议
+func (d *natDiagnosis) conclude() {
+	var open, closed []diagTransport
+	mappingOnly := false
+	for _, t := range d.transports {
+		listen, other := d.reachable(t)
+		if listen > 0 {
+			open = append(open, t)
+		} else {
+			closed = append(closed, t)
+			mappingOnly = mappingOnly || other > 0
+		}
+	}
+	switch {
+	case len(open) > 0:
+		d.verdict = natPublic
+	case d.mapping == "none":
+		d.verdict = natFirewalled
+	case d.mapping == "independent":
+		d.verdict = natCone
+	case d.mapping == "dependent":
+		d.verdict = natSymmetric
+	default:
+		d.verdict = natUnknown
+	}
+
+	for _, t := range closed {
+		proto := "TCP"
+		if t.name != "tcp" {
+			proto = "UDP"
+		}
+		if len(d.dialBacks[t.name]) == 0 {
+			d.hints = append(d.hints, fmt.Sprintf("没有支持AutoNAT的引导节点通过%s回拨, 无法确定%s是否可达", t.label, t.label))
+			continue
+		}
+		if d.verdict == natPublic || d.verdict == natFirewalled {
+			d.hints = append(d.hints, fmt.Sprintf("%s端口 %d 无法从外部连接, 在防火墙或云主机安全组中放行入站%s %d", t.label, t.port, proto, t.port))
+		} else {
+			d.hints = append(d.hints, fmt.Sprintf("在路由器上把%s端口 %d 转发到本机, 或者使用支持UPnP/NAT-PMP的路由器", proto, t.port))
+		}
+	}
+	if mappingOnly {
+		d.hints = append(d.hints, "回拨到出站连接的端口成功, NAT放行已有的映射, 有利于打洞, 但监听端口仍然不可达")
+	}
+	switch d.verdict {
+	case natPublic:
+		if d.mapping == "independent" || d.mapping == "dependent" {
+			d.hints = append(d.hints, "节点在NAT之后, 通过端口转发或映射可达. 公网IP变化时地址会改变, 固定地址请用 -announce 宣告")
+		}
+	case natCone:
+		if len(d.gateways) > 0 && d.portmap {
+			d.hints = append(d.hints, "找到了支持端口映射的路由器, 节点运行时会自动映射端口, 可以用 -report 确认映射结果")
+		} else if len(d.gateways) > 0 {
+			d.hints = append(d.hints, "找到了支持端口映射的路由器, 但端口映射已关闭, 设置 -nat-portmap -upnp -nat-pmp 启用")
+		}
+		d.hints = append(d.hints, "锥形NAT之后的节点可以与其他节点打洞, 但不适合作为引导节点")
+	case natSymmetric:
+		d.hints = append(d.hints, "对称NAT为每个目标分配不同的端口, 其他节点无法打洞连接, 需要端口转发, 中继或有公网IP的主机")
+	case natUnknown:
+		if len(d.stun) > 0 && len(d.externalIPs()) == 0 {
+			d.hints = append(d.hints, "所有STUN服务器都没有响应, 出站UDP可能被拦截, QUIC无法使用")
+		} else {
+			d.hints = append(d.hints, "至少需要两个不同IP的STUN服务器响应才能判断NAT类型, 用 -stun 指定")
+		}
+	}
+}
+
+// print 输出可读的诊断结果
+func (d *natDiagnosis) print(w io.Writer) {
+	fmt.Fprintln(w, "===== NAT诊断 =====")
+	if len(d.gateways) == 0 {
+		fmt.Fprintln(w, "路由器: 没有找到支持UPnP/NAT-PMP的路由器")
+	}
+	for _, gateway := range d.gateways {
+		fmt.Fprintln(w, "路由器:", gateway)
+	}
+	fmt.Fprintln(w, "STUN (本地UDP端口", d.localPort, "):")
+	for _, r := range d.stun {
+		if r.Usable {
+			fmt.Fprintf(w, "  %s 映射地址 %s\n", r.Server, r.Mapped)
+		} else {
+			fmt.Fprintf(w, "  %s 出错: %s\n", r.Server, r.Error)
+		}
+	}
+	mapping := map[string]string{"none": "没有NAT, 本机有公网IP", "independent": "与目标无关(锥形NAT)", "dependent": "与目标有关(对称NAT)", "": "无法判断"}
+	fmt.Fprintln(w, "映射方式:", mapping[d.mapping])
+	for _, t := range d.transports {
+		fmt.Fprintf(w, "%s 端口 %d 回拨:\n", t.label, t.port)
+		if len(d.dialBacks[t.name]) == 0 {
+			fmt.Fprintln(w, "  没有节点回拨")
+		}
+		for _, r := range d.dialBacks[t.name] {
+			if r.err != nil {
+				fmt.Fprintf(w, "  %s 失败: %s\n", r.peer, r.err)
+			} else {
+				fmt.Fprintf(w, "  %s 成功: %s\n", r.peer, r.addr)
+			}
+		}
+	}
+	verdicts := map[string]string{
+		natPublic:     "公网可达",
+		natFirewalled: "有公网IP, 但入站连接被防火墙拦截",
+		natCone:       "在锥形NAT之后, 无法从外部连接",
+		natSymmetric:  "在对称NAT之后, 无法从外部连接",
+		natUnknown:    "无法判断",
+	}
+	fmt.Fprintf(w, "结论: %s (%s)\n", d.verdict, verdicts[d.verdict])
+	for _, hint := range d.hints {
+		fmt.Fprintln(w, "建议:", hint)
+	}
+}
@@ -0,0 +1,256 @@
+package bootstrap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/net/swarm"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// dialBackoffPending 是拨号后多久没有结果时删除记录, 不计为失败. 拨号的结果由swarm报告, 一般不会出现
+const dialBackoffPending = time.Minute * 5
+
+// dialBackoffInterval 是清理未完成的拨号和过期记录的间隔
+const dialBackoffInterval = time.Minute
+
+// dialBackoffConfig 是拨号退避的设置. DHT查询结果中不可达的地址会被反复拨号,
+// 拨号失败的地址在退避期间不再拨号, 每次失败退避时间加倍
+type dialBackoffConfig struct {
+	// Base 是第一次失败后的退避时间, 0表示不退避
+	Base time.Duration `yaml:"base"`
+	// Max 是退避时间的上限
+	Max time.Duration `yaml:"max"`
+}
+
+// validate 检查拨号退避设置
+func (c dialBackoffConfig) validate() error {
+	if c.Base < 0 {
+		return fmt.Errorf("拨号退避时间不能小于0: %s", c.Base)
+	}
+	if c.Base > 0 && c.Max < c.Base {
+		return fmt.Errorf("拨号退避上限不能小于退避时间: base %s, max %s", c.Base, c.Max)
+	}
+	return nil
+}
+
+// dialAttempt 是一个节点地址的拨号记录
+type dialAttempt struct {
+	// pending 不为零时正在拨号, 是开始的时间
+	pending  time.Time
+	failures int
+	// until 之前不再拨号
+	until time.Time
+}
+
+// dialBackoff 记录每个节点地址的拨号. swarm拨号每个地址前由连接过滤器询问, 拨号失败时由swarm的MetricsTracer报告.
+// swarm对节点的退避在成功连接后就清除, 这里按地址退避, 只在与该地址建立连接后清除
+type dialBackoff struct {
+	swarm.MetricsTracer
+	cfg dialBackoffConfig
+	// exempt 不为nil时跳过返回true的节点
+	exempt func(peer.ID) bool
+
+	mu    sync.Mutex
+	addrs map[peer.ID]map[string]*dialAttempt
+	// dialing 是正在拨号的地址和拨号的节点, swarm报告失败时只有地址
+	dialing map[string]map[peer.ID]struct{}
+
+	skipped uint64
+	failed  uint64
+}
+
+// newDialBackoff 创建拨号退避, 不退避时返回nil. 只使用swarm报告的拨号失败, 其他指标不统计
+func newDialBackoff(cfg dialBackoffConfig) *dialBackoff {
+	if cfg.Base <= 0 {
+		return nil
+	}
+	return &dialBackoff{MetricsTracer: newNoopSwarmTracer(swarm.MetricsTracer.UpdatedBlackHoleSuccessCounter), cfg: cfg, addrs: make(map[peer.ID]map[string]*dialAttempt), dialing: make(map[string]map[peer.ID]struct{})}
+}
+
+// noopSwarmTracer 是不统计任何指标的swarm.MetricsTracer.
+// UpdatedBlackHoleSuccessCounter的参数类型是swarm中未导出的类型, 用类型参数S代替
+type noopSwarmTracer[S any] struct{}
+
+// newNoopSwarmTracer 从swarm.MetricsTracer.UpdatedBlackHoleSuccessCounter的类型推断S
+func newNoopSwarmTracer[S any](func(swarm.MetricsTracer, string, S, int, float64)) *noopSwarmTracer[S] {
+	return &noopSwarmTracer[S]{}
+}
+
+func (*noopSwarmTracer[S]) OpenedConnection(network.Direction, crypto.PubKey, network.ConnectionState, ma.Multiaddr) {
+}
+func (*noopSwarmTracer[S]) ClosedConnection(network.Direction, time.Duration, network.ConnectionState, ma.Multiaddr) {
+}
+func (*noopSwarmTracer[S]) CompletedHandshake(time.Duration, network.ConnectionState, ma.Multiaddr) {}
+func (*noopSwarmTracer[S]) FailedDialing(ma.Multiaddr, error, error)                                {}
+func (*noopSwarmTracer[S]) DialCompleted(bool, int)                                                 {}
+func (*noopSwarmTracer[S]) DialRankingDelay(time.Duration)                                          {}
+func (*noopSwarmTracer[S]) UpdatedBlackHoleSuccessCounter(string, S, int, float64)                  {}
+
+// allow 判断是否可以拨号, 可以时记录拨号
+func (b *dialBackoff) allow(p peer.ID, addr ma.Multiaddr) bool {
+	if b.exempt != nil && b.exempt(p) {
+		return true
+	}
+	key := addr.String()
+	now := time.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	attempts, ok := b.addrs[p]
+	if !ok {
+		attempts = make(map[string]*dialAttempt)
+		b.addrs[p] = attempts
+	}
+	a, ok := attempts[key]
+	if !ok {
+		a = &dialAttempt{}
+		attempts[key] = a
+	}
+	if now.Before(a.until) {
+		atomic.AddUint64(&b.skipped, 1)
+		return false
+	}
+	if a.pending.IsZero() {
+		a.pending = now
+		peers, ok := b.dialing[key]
+		if !ok {
+			peers = make(map[peer.ID]struct{})
+			b.dialing[key] = peers
+		}
+		peers[p] = struct{}{}
+	}
+	return true
+}
+
+// done 结束节点地址的拨号, 调用时持有锁
+func (b *dialBackoff) done(p peer.ID, key string, a *dialAttempt) {
+	a.pending = time.Time{}
+	if peers, ok := b.dialing[key]; ok {
+		delete(peers, p)
+		if len(peers) == 0 {
+			delete(b.dialing, key)
+		}
+	}
+}
+
+// FailedDialing 由swarm在拨号一个地址失败时调用. 节点的其他地址先连接成功或拨号被取消时不计为失败
+func (b *dialBackoff) FailedDialing(addr ma.Multiaddr, dialErr, cause error) {
+	b.MetricsTracer.FailedDialing(addr, dialErr, cause)
+	canceled := errors.Is(cause, context.Canceled) || errors.Is(dialErr, context.Canceled)
+	key := addr.String()
+	now := time.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for p := range b.dialing[key] {
+		a := b.addrs[p][key]
+		if a == nil {
+			continue
+		}
+		b.done(p, key, a)
+		if canceled {
+			continue
+		}
+		a.failures++
+		a.until = now.Add(b.backoff(a.failures))
+		atomic.AddUint64(&b.failed, 1)
+	}
+}
+
+// connected 在与节点建立连接时清除该地址的退避. 节点的其他地址正在拨号时,
+// swarm在一个地址成功后取消其他的, 不计为失败
+func (b *dialBackoff) connected(_ network.Network, c network.Conn) {
+	p := c.RemotePeer()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	attempts, ok := b.addrs[p]
+	if !ok {
+		return
+	}
+	if key := c.RemoteMultiaddr().String(); attempts[key] != nil {
+		b.done(p, key, attempts[key])
+		delete(attempts, key)
+	}
+	for key, a := range attempts {
+		if !a.pending.IsZero() {
+			b.done(p, key, a)
+			if a.failures == 0 {
+				delete(attempts, key)
+			}
+		}
+	}
+	if len(attempts) == 0 {
+		delete(b.addrs, p)
+	}
+}
+
+// backoff 返回第n次失败后的退避时间
+func (b *dialBackoff) backoff(n int) time.Duration {
+	d := b.cfg.Base
+	for i := 1; i < n && d < b.cfg.Max; i++ {
+		d *= 2
+	}
+	if d > b.cfg.Max {
+		d = b.cfg.Max
+	}
+	return d
+}
+
+// run 定期删除没有结果的拨号和退避已结束较久的记录
+func (b *dialBackoff) run(ctx context.Context) {
+	ticker := time.NewTicker(dialBackoffInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		now := time.Now()
+		b.mu.Lock()
+		for p, attempts := range b.addrs {
+			for key, a := range attempts {
+				if !a.pending.IsZero() && now.Sub(a.pending) > dialBackoffPending {
+					b.done(p, key, a)
+				}
+				if a.pending.IsZero() && now.Sub(a.until) > b.cfg.Max {
+					delete(attempts, key)
+				}
+			}
+			if len(attempts) == 0 {
+				delete(b.addrs, p)
+			}
+		}
+		b.mu.Unlock()
+	}
+}
+
+// counts 返回退避中的地址数量以及跳过的拨号和失败的次数
+func (b *dialBackoff) counts() (backingOff int, skipped, failed uint64) {
+	now := time.Now()
+	b.mu.Lock()
+	for _, attempts := range b.addrs {
+		for _, a := range attempts {
+			if now.Before(a.until) {
+				backingOff++
+			}
+		}
+	}
+	b.mu.Unlock()
+	return backingOff, atomic.LoadUint64(&b.skipped), atomic.LoadUint64(&b.failed)
+}
+
+func (b *dialBackoff) status() interface{} {
+	backingOff, skipped, failed := b.counts()
+	return map[string]interface{}{
+		"addrs":   backingOff,
+		"skipped": skipped,
+		"failed":  failed,
+	}
+}
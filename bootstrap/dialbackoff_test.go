@@ -0,0 +1,29 @@
+package bootstrap
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDialBackoffBackoff(t *testing.T) {
+	b := newDialBackoff(dialBackoffConfig{Base: time.Second * 30, Max: time.Minute * 5})
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, time.Second * 30},
+		{2, time.Minute},
+		{3, time.Minute * 2},
+		{4, time.Minute * 4},
+		{5, time.Minute * 5},
+		{100, time.Minute * 5},
+	}
+	for _, tt := range tests {
+		if got := b.backoff(tt.failures); got != tt.want {
+			t.Errorf("第%d次失败后退避 %s, 应为 %s", tt.failures, got, tt.want)
+		}
+	}
+	if newDialBackoff(dialBackoffConfig{}) != nil {
+		t.Error("base为0时不应退避")
+	}
+}
@@ -0,0 +1,68 @@
+package bootstrap
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// dialFilter 拒绝拨号到指定网段. 其他节点宣告的内网, 本机和链路本地地址对公共引导节点没有用,
+// 拨号这些地址还会被云主机商当作内网扫描. 受保护的节点(指定的引导节点和-protect)不受限制
+type dialFilter struct {
+	nets []*net.IPNet
+	// exempt 不为nil时跳过返回true的节点
+	exempt func(peer.ID) bool
+
+	blocked uint64
+}
+
+// newDialFilter 返回拒绝deny中网段的过滤器, blockPrivate为true时同时拒绝内网和不可路由的地址.
+// 没有需要拒绝的网段时返回nil
+func newDialFilter(blockPrivate bool, deny []string) (*dialFilter, error) {
+	f := &dialFilter{}
+	if blockPrivate {
+		for _, list := range [][]*net.IPNet{manet.Private4, manet.Private6, manet.Unroutable4, manet.Unroutable6} {
+			f.nets = append(f.nets, list...)
+		}
+	}
+	for _, v := range deny {
+		_, ipnet, e := net.ParseCIDR(v)
+		if e != nil {
+			return nil, fmt.Errorf("dial-deny网段错误 %s: %w", v, e)
+		}
+		f.nets = append(f.nets, ipnet)
+	}
+	if len(f.nets) == 0 {
+		return nil, nil
+	}
+	return f, nil
+}
+
+// allow 判断是否可以拨号, 只检查地址中的第一个IP, 域名和onion等地址总是允许
+func (f *dialFilter) allow(p peer.ID, addr ma.Multiaddr) bool {
+	ip, e := manet.ToIP(addr)
+	if e != nil {
+		return true
+	}
+	for _, ipnet := range f.nets {
+		if ipnet.Contains(ip) {
+			if f.exempt != nil && f.exempt(p) {
+				return true
+			}
+			atomic.AddUint64(&f.blocked, 1)
+			return false
+		}
+	}
+	return true
+}
+
+func (f *dialFilter) status() interface{} {
+	return map[string]interface{}{
+		"nets":    len(f.nets),
+		"blocked": atomic.LoadUint64(&f.blocked),
+	}
+}
@@ -0,0 +1,158 @@
+package bootstrap
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// protectTagDiversity 是为保持网络多样性而保护的连接的标签
+const protectTagDiversity = "diversity"
+
+// diversityInterval 是检查连接多样性的间隔
+const diversityInterval = time.Minute
+
+// addrPrefix 返回地址所属网段, IPv4取/16, IPv6取/32, 用于近似区分网络
+func addrPrefix(addr ma.Multiaddr) string {
+	ip, e := manet.ToIP(addr)
+	if e != nil {
+		return ""
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return (&net.IPNet{IP: ip4.Mask(net.CIDRMask(16, 32)), Mask: net.CIDRMask(16, 32)}).String()
+	}
+	return (&net.IPNet{IP: ip.Mask(net.CIDRMask(32, 128)), Mask: net.CIDRMask(32, 128)}).String()
+}
+
+// diversityKeeper 使连接分布在尽量多的网段上, 避免单个网络故障导致节点失联.
+// 每个网段保护一个连接不被修剪, 连接数量不足minPeers时优先连接新网段的节点.
+type diversityKeeper struct {
+	h         host.Host
+	protector *peerProtector
+	minPeers  int
+
+	mu           sync.Mutex
+	protected    map[peer.ID]bool
+	distribution map[string]int
+}
+
+func newDiversityKeeper(h host.Host, protector *peerProtector, minPeers int) *diversityKeeper {
+	return &diversityKeeper{
+		h:            h,
+		protector:    protector,
+		minPeers:     minPeers,
+		protected:    make(map[peer.ID]bool),
+		distribution: make(map[string]int),
+	}
+}
+
+func (d *diversityKeeper) run(ctx context.Context) {
+	ticker := time.NewTicker(diversityInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			prefixes := d.rebalance()
+			if d.minPeers > 0 && len(d.h.Network().Peers()) < d.minPeers {
+				d.topUp(ctx, prefixes)
+			}
+		}
+	}
+}
+
+// rebalance 统计连接的网段分布, 每个网段保护最早建立的连接. 返回已有的网段.
+func (d *diversityKeeper) rebalance() map[string]bool {
+	oldest := make(map[string]network.Conn)
+	distribution := make(map[string]int)
+	for _, c := range d.h.Network().Conns() {
+		prefix := addrPrefix(c.RemoteMultiaddr())
+		if prefix == "" {
+			continue
+		}
+		distribution[prefix]++
+		if o, ok := oldest[prefix]; !ok || c.Stat().Opened.Before(o.Stat().Opened) {
+			oldest[prefix] = c
+		}
+	}
+	keep := make(map[peer.ID]bool, len(oldest))
+	for _, c := range oldest {
+		keep[c.RemotePeer()] = true
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for id := range d.protected {
+		if !keep[id] {
+			d.protector.Unprotect(id, protectTagDiversity)
+		}
+	}
+	for id := range keep {
+		if !d.protected[id] {
+			d.protector.Protect(id, protectTagDiversity)
+		}
+	}
+	d.protected = keep
+	d.distribution = distribution
+
+	prefixes := make(map[string]bool, len(distribution))
+	for prefix := range distribution {
+		prefixes[prefix] = true
+	}
+	return prefixes
+}
+
+// topUp 从地址簿中选择未连接的节点补足连接, 优先选择尚未连接过的网段
+func (d *diversityKeeper) topUp(ctx context.Context, prefixes map[string]bool) {
+	need := d.minPeers - len(d.h.Network().Peers())
+	var preferred, others []peer.AddrInfo
+	for _, id := range d.h.Peerstore().PeersWithAddrs() {
+		if id == d.h.ID() || d.h.Network().Connectedness(id) == network.Connected {
+			continue
+		}
+		addrInfo := d.h.Peerstore().PeerInfo(id)
+		newPrefix := false
+		for _, addr := range addrInfo.Addrs {
+			if prefix := addrPrefix(addr); prefix != "" && !prefixes[prefix] {
+				newPrefix = true
+				break
+			}
+		}
+		if newPrefix {
+			preferred = append(preferred, addrInfo)
+		} else {
+			others = append(others, addrInfo)
+		}
+	}
+	candidates := append(preferred, others...)
+	if len(candidates) > need {
+		candidates = candidates[:need]
+	}
+	if len(candidates) == 0 {
+		return
+	}
+	n := connectAll(ctx, d.h, candidates, connectTimeout, nil)
+	logger.Infow("连接数量不足, 补充连接", "connected", n, "candidates", len(candidates))
+}
+
+// status 返回连接的网段分布
+func (d *diversityKeeper) status() interface{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	distribution := make(map[string]int, len(d.distribution))
+	for prefix, n := range d.distribution {
+		distribution[prefix] = n
+	}
+	return map[string]interface{}{
+		"prefixes":     len(distribution),
+		"distribution": distribution,
+	}
+}
@@ -0,0 +1,99 @@
+package bootstrap
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// runDNSAddr 输出本节点公网地址的dnsaddr TXT记录值, 发布到_dnsaddr.<域名>后客户端可以使用/dnsaddr/<域名>.
+// 设置了http-addr时使用运行中节点的地址(包括端口映射和观察到的地址), 否则使用监听地址和网络接口地址.
+func runDNSAddr(args []string) int {
+	fs := flag.NewFlagSet("dnsaddr", flag.ExitOnError)
+	domain := fs.String("domain", "", "输出区域文件格式的记录: _dnsaddr.<域名>. IN TXT \"dnsaddr=...\"")
+	all := fs.Bool("all", false, "包括内网和本机地址")
+	cfg, e := commandConfig(fs, args)
+	if e != nil {
+		logger.Errorw("读取设置出错", "error", e)
+		return 1
+	}
+	var id peer.ID
+	var addrs []ma.Multiaddr
+	if cfg.HTTPAddr != "" {
+		id, addrs, e = runningNodeAddrs(cfg.HTTPAddr)
+	} else {
+		logger.Warnw("没有设置-http-addr, 使用监听地址, 不包括端口映射后的地址")
+		id, addrs, e = localAddrs(cfg)
+	}
+	if e != nil {
+		logger.Errorw("读取地址出错", "error", e)
+		return 1
+	}
+	p2pAddr, e := ma.NewMultiaddr("/p2p/" + id.String())
+	if e != nil {
+		logger.Errorw("节点ID错误", "error", e)
+		return 1
+	}
+	seen := make(map[string]bool)
+	for _, addr := range addrs {
+		if !*all && !manet.IsPublicAddr(addr) {
+			continue
+		}
+		value := "dnsaddr=" + addr.Encapsulate(p2pAddr).String()
+		if seen[value] {
+			continue
+		}
+		seen[value] = true
+		if *domain != "" {
+			fmt.Printf("_dnsaddr.%s. IN TXT \"%s\"\n", strings.TrimSuffix(*domain, "."), value)
+		} else {
+			fmt.Println(value)
+		}
+	}
+	if len(seen) == 0 {
+		logger.Warnw("没有公网地址, 使用-all输出所有地址")
+		return 1
+	}
+	return 0
+}
+
+// runningNodeAddrs 从运行中节点的 /status 读取节点ID和地址
+func runningNodeAddrs(httpAddr string) (peer.ID, []ma.Multiaddr, error) {
+	client := http.Client{Timeout: time.Second * 10}
+	resp, e := client.Get("http://" + httpAddr + "/status")
+	if e != nil {
+		return "", nil, e
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", nil, errors.New(resp.Status)
+	}
+	var status struct {
+		ID    string   `json:"id"`
+		Addrs []string `json:"addrs"`
+	}
+	if e := json.NewDecoder(resp.Body).Decode(&status); e != nil {
+		return "", nil, e
+	}
+	id, e := peer.Decode(status.ID)
+	if e != nil {
+		return "", nil, e
+	}
+	addrs := make([]ma.Multiaddr, 0, len(status.Addrs))
+	for _, v := range status.Addrs {
+		addr, e := ma.NewMultiaddr(v)
+		if e != nil {
+			return "", nil, e
+		}
+		addrs = append(addrs, addr)
+	}
+	return id, addrs, nil
+}
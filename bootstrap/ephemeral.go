@@ -0,0 +1,44 @@
+package bootstrap
+
+import (
+	"errors"
+)
+
+// checkEphemeral 检查临时模式的设置, 后台运行需要日志文件, 临时模式不写日志文件
+func checkEphemeral(cfg *Config) error {
+	if cfg.Ephemeral && cfg.Daemon {
+		return errors.New("ephemeral不能和daemon同时使用")
+	}
+	return nil
+}
+
+// applyEphemeral 在临时模式下修改会写入磁盘的设置: 地址簿和DHT记录保存在内存中,
+// 不写PID文件和日志文件, 不遍历DHT, 不保存连接快照, 不启动需要token文件或unix socket的管理接口.
+// 私钥在启动时生成, 只保存在内存中. 可以重复调用
+func (c *Config) applyEphemeral() {
+	if !c.Ephemeral {
+		return
+	}
+	c.Peerstore = "memory"
+	c.DHT.Datastore = "memory"
+	if c.PIDFile != "" {
+		logger.Infow("临时模式不写PID文件", "pid_file", c.PIDFile)
+		c.PIDFile = ""
+	}
+	if c.Log.File != "" {
+		logger.Infow("临时模式不写日志文件", "file", c.Log.File)
+		c.Log.File = ""
+	}
+	if c.Crawl.Interval > 0 {
+		logger.Infow("临时模式不遍历DHT")
+		c.Crawl.Interval = 0
+	}
+	if c.Snapshot.Interval > 0 {
+		logger.Infow("临时模式不保存连接快照")
+		c.Snapshot.Interval = 0
+	}
+	if c.AdminAddr != "" || c.GRPCAddr != "" {
+		logger.Infow("临时模式不启动管理接口和gRPC控制接口")
+		c.AdminAddr, c.GRPCAddr = "", ""
+	}
+}
@@ -0,0 +1,74 @@
+package bootstrap
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/event"
+)
+
+// errSubscriptionsClosed 表示订阅已关闭, 通常是正在关闭程序
+var errSubscriptionsClosed = errors.New("事件订阅已关闭")
+
+// subscriptions 统一管理事件总线订阅, 关闭时取消所有订阅并等待处理结束
+type subscriptions struct {
+	mu     sync.Mutex
+	subs   []event.Subscription
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// Subscribe 订阅事件总线上的evtType事件, 并在单独的协程中逐个交给handler处理.
+// handler出现panic时记录日志并继续处理后续事件.
+func (s *subscriptions) Subscribe(bus event.Bus, evtType interface{}, handler func(evt interface{})) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errSubscriptionsClosed
+	}
+	sub, e := bus.Subscribe(evtType)
+	if e != nil {
+		return e
+	}
+	s.subs = append(s.subs, sub)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		// 总线关闭或取消订阅时Out()会被关闭
+		for evt := range sub.Out() {
+			handle(handler, evt)
+		}
+	}()
+	return nil
+}
+
+func handle(handler func(evt interface{}), evt interface{}) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Errorw("处理事件出错", "event", fmt.Sprintf("%T", evt), "panic", r, "stack", string(debug.Stack()))
+		}
+	}()
+	handler(evt)
+}
+
+// Close 取消所有订阅并等待处理协程退出, 可以多次调用
+func (s *subscriptions) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	subs := s.subs
+	s.subs = nil
+	s.mu.Unlock()
+
+	for _, sub := range subs {
+		if e := sub.Close(); e != nil {
+			logger.Warnw("取消事件订阅出错", "error", e)
+		}
+	}
+	s.wg.Wait()
+}
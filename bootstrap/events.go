@@ -0,0 +1,178 @@
+package bootstrap
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// 事件类型
+const (
+	eventPeerConnected    = "peer_connected"
+	eventPeerDisconnected = "peer_disconnected"
+	eventDropped          = "dropped"
+	eventReachability     = "reachability"
+	eventZeroPeers        = "zero_peers"
+	eventIsolated         = "isolated"
+	eventIdentified       = "identified"
+	eventIdentifyFailed   = "identify_failed"
+)
+
+// nodeEvent 是节点运行中发生的事件
+type nodeEvent struct {
+	Time      time.Time `json:"time"`
+	Type      string    `json:"type"`
+	Peer      string    `json:"peer,omitempty"`
+	Addr      string    `json:"addr,omitempty"`
+	Direction string    `json:"direction,omitempty"`
+	// Reachability 是NAT可达性: Public, Private, Unknown
+	Reachability string `json:"reachability,omitempty"`
+	// Dropped 是因订阅者处理不及时丢弃的事件数量
+	Dropped uint64 `json:"dropped,omitempty"`
+	// AgentVersion 是identify得到的对方客户端版本
+	AgentVersion string `json:"agent_version,omitempty"`
+	// Error 是identify失败的原因
+	Error string `json:"error,omitempty"`
+}
+
+// eventHub 将事件分发给所有订阅者.
+// 发布不会阻塞, 订阅者缓冲区满时丢弃事件并计数.
+type eventHub struct {
+	mu   sync.RWMutex
+	subs map[*eventSubscription]struct{}
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{subs: make(map[*eventSubscription]struct{})}
+}
+
+// eventSubscription 是一个事件订阅
+type eventSubscription struct {
+	hub     *eventHub
+	C       chan nodeEvent
+	dropped uint64
+	once    sync.Once
+}
+
+// Subscribe 订阅事件, buffer为缓冲区大小
+func (hub *eventHub) Subscribe(buffer int) *eventSubscription {
+	sub := &eventSubscription{hub: hub, C: make(chan nodeEvent, buffer)}
+	hub.mu.Lock()
+	hub.subs[sub] = struct{}{}
+	hub.mu.Unlock()
+	return sub
+}
+
+// Publish 发布事件
+func (hub *eventHub) Publish(evt nodeEvent) {
+	if evt.Time.IsZero() {
+		evt.Time = time.Now()
+	}
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+	for sub := range hub.subs {
+		select {
+		case sub.C <- evt:
+		default:
+			atomic.AddUint64(&sub.dropped, 1)
+		}
+	}
+}
+
+// TakeDropped 返回上次调用以来丢弃的事件数量
+func (sub *eventSubscription) TakeDropped() uint64 {
+	return atomic.SwapUint64(&sub.dropped, 0)
+}
+
+// Close 取消订阅
+func (sub *eventSubscription) Close() {
+	sub.once.Do(func() {
+		sub.hub.mu.Lock()
+		delete(sub.hub.subs, sub)
+		sub.hub.mu.Unlock()
+	})
+}
+
+// eventNotifee 将网络连接变化发布为事件
+type eventNotifee struct {
+	hub *eventHub
+}
+
+func (n *eventNotifee) Connected(_ network.Network, c network.Conn) {
+	n.hub.Publish(connEvent(eventPeerConnected, c))
+}
+
+func (n *eventNotifee) Disconnected(net network.Network, c network.Conn) {
+	n.hub.Publish(connEvent(eventPeerDisconnected, c))
+	if len(net.Peers()) == 0 {
+		n.hub.Publish(nodeEvent{Type: eventZeroPeers})
+	}
+}
+
+func (n *eventNotifee) Listen(network.Network, ma.Multiaddr)         {}
+func (n *eventNotifee) ListenClose(network.Network, ma.Multiaddr)    {}
+func (n *eventNotifee) OpenedStream(network.Network, network.Stream) {}
+func (n *eventNotifee) ClosedStream(network.Network, network.Stream) {}
+
+func connEvent(typ string, c network.Conn) nodeEvent {
+	return nodeEvent{
+		Type:      typ,
+		Peer:      c.RemotePeer().String(),
+		Addr:      c.RemoteMultiaddr().String(),
+		Direction: c.Stat().Direction.String(),
+	}
+}
+
+// publishIdentify 将identify结果发布为事件
+func publishIdentify(h host.Host, hub *eventHub, subs *subscriptions) error {
+	e := subs.Subscribe(h.EventBus(), new(event.EvtPeerIdentificationCompleted), func(evt interface{}) {
+		p := evt.(event.EvtPeerIdentificationCompleted).Peer
+		agent, _ := h.Peerstore().Get(p, "AgentVersion")
+		agentVersion, _ := agent.(string)
+		hub.Publish(nodeEvent{Type: eventIdentified, Peer: p.String(), AgentVersion: agentVersion})
+	})
+	if e != nil {
+		return e
+	}
+	return subs.Subscribe(h.EventBus(), new(event.EvtPeerIdentificationFailed), func(evt interface{}) {
+		failed := evt.(event.EvtPeerIdentificationFailed)
+		published := nodeEvent{Type: eventIdentifyFailed, Peer: failed.Peer.String()}
+		if failed.Reason != nil {
+			published.Error = failed.Reason.Error()
+		}
+		hub.Publish(published)
+	})
+}
+
+// watchIsolation 在没有任何连接持续超过after时发布孤立事件, 每次孤立只发布一次
+func watchIsolation(ctx context.Context, net network.Network, hub *eventHub, after time.Duration) {
+	ticker := time.NewTicker(after / 4)
+	defer ticker.Stop()
+	var since time.Time
+	published := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if len(net.Peers()) > 0 {
+				since = time.Time{}
+				published = false
+				continue
+			}
+			if since.IsZero() {
+				since = time.Now()
+			}
+			if !published && time.Since(since) >= after {
+				hub.Publish(nodeEvent{Type: eventIsolated})
+				published = true
+			}
+		}
+	}
+}
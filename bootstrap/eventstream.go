@@ -0,0 +1,73 @@
+package bootstrap
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// eventStreamProtocol 是向授权节点推送事件的协议, 每行一个JSON事件
+const eventStreamProtocol protocol.ID = "/bootstrap/events/1.0.0"
+
+// eventStreamBuffer 是每个订阅者的事件缓冲数量
+const eventStreamBuffer = 256
+
+// newEventStreamHandler 返回事件流协议处理器, 只有allow中的节点可以订阅.
+// 订阅者不需要发送数据, 发送的数据超过上限时重置流.
+func newEventStreamHandler(ctx context.Context, hub *eventHub, allow map[peer.ID]bool, limiter *messageLimiter) network.StreamHandler {
+	return func(s network.Stream) {
+		remote := s.Conn().RemotePeer()
+		if !allow[remote] {
+			logger.Warnw("拒绝未授权节点订阅事件", "peer", remote)
+			_ = s.Reset()
+			return
+		}
+		logger.Infow("节点订阅事件", "peer", remote)
+		defer logger.Infow("节点取消订阅事件", "peer", remote)
+
+		sub := hub.Subscribe(eventStreamBuffer)
+		defer sub.Close()
+
+		// 对方关闭流时结束
+		closed := make(chan struct{})
+		go func() {
+			_, _ = io.Copy(ioutil.Discard, limiter.Reader(s))
+			close(closed)
+		}()
+
+		w := bufio.NewWriter(s)
+		encoder := json.NewEncoder(w)
+		for {
+			select {
+			case <-ctx.Done():
+				_ = s.Close()
+				return
+			case <-closed:
+				_ = s.Close()
+				return
+			case evt := <-sub.C:
+				if dropped := sub.TakeDropped(); dropped > 0 {
+					if e := encoder.Encode(nodeEvent{Time: time.Now(), Type: eventDropped, Dropped: dropped}); e != nil {
+						_ = s.Reset()
+						return
+					}
+				}
+				if e := encoder.Encode(evt); e != nil {
+					_ = s.Reset()
+					return
+				}
+				if e := w.Flush(); e != nil {
+					_ = s.Reset()
+					return
+				}
+			}
+		}
+	}
+}
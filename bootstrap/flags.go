@@ -0,0 +1,105 @@
+package bootstrap
+
+import (
+	"strconv"
+	"strings"
+)
+
+// listValue 是可重复的参数, 每次也可以用逗号分隔多个值.
+// 第一次设置时替换默认值(如配置文件中的值), 之后追加.
+type listValue struct {
+	target *[]string
+	set    bool
+}
+
+func newListValue(target *[]string) *listValue {
+	return &listValue{target: target}
+}
+
+func (v *listValue) String() string {
+	if v.target == nil {
+		return ""
+	}
+	return strings.Join(*v.target, ",")
+}
+
+func (v *listValue) Set(value string) error {
+	if !v.set {
+		*v.target = nil
+		v.set = true
+	}
+	*v.target = append(*v.target, splitList(value)...)
+	return nil
+}
+
+// tiersValue 是可重复的参数, 每次指定一组用逗号分隔的值.
+// 第一次设置时替换默认值, 之后追加.
+type tiersValue struct {
+	target *[][]string
+	set    bool
+}
+
+func newTiersValue(target *[][]string) *tiersValue {
+	return &tiersValue{target: target}
+}
+
+func (v *tiersValue) String() string {
+	if v.target == nil {
+		return ""
+	}
+	tiers := make([]string, 0, len(*v.target))
+	for _, tier := range *v.target {
+		tiers = append(tiers, strings.Join(tier, ","))
+	}
+	return strings.Join(tiers, " ")
+}
+
+func (v *tiersValue) Set(value string) error {
+	if !v.set {
+		*v.target = nil
+		v.set = true
+	}
+	if tier := splitList(value); len(tier) > 0 {
+		*v.target = append(*v.target, tier)
+	}
+	return nil
+}
+
+// disableValue 是关闭某项设置的布尔参数, 如-disable-tcp为true时设置为false
+type disableValue struct {
+	target *bool
+}
+
+func newDisableValue(target *bool) *disableValue {
+	return &disableValue{target: target}
+}
+
+func (v *disableValue) String() string {
+	if v.target == nil {
+		return "false"
+	}
+	return strconv.FormatBool(!*v.target)
+}
+
+func (v *disableValue) Set(value string) error {
+	b, e := strconv.ParseBool(value)
+	if e != nil {
+		return e
+	}
+	*v.target = !b
+	return nil
+}
+
+func (v *disableValue) IsBoolFlag() bool { return true }
+
+// splitList 按逗号拆分并去掉空白和空值
+func splitList(value string) []string {
+	var list []string
+	for _, v := range strings.Split(value, ",") {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
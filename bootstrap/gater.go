@@ -0,0 +1,192 @@
+package bootstrap
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/libp2p/go-libp2p/core/control"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// connHook 在建立连接的各个阶段被调用, 返回false时拒绝连接. 用于网络模拟.
+type connHook interface {
+	allowDial(ma.Multiaddr) bool
+	allowAccept(network.ConnMultiaddrs) bool
+	secured(network.Direction, peer.ID) bool
+}
+
+// connectionGater 决定是否允许建立连接
+type connectionGater struct {
+	// maxGoroutines 是协程数量上限, 超过时拒绝新的入站连接, 0表示不限制
+	maxGoroutines int
+	// hook 不为nil时参与判断
+	hook connHook
+	// bans 不为nil时拒绝被封禁的节点和地址
+	bans *banList
+	// reputation 不为nil时拒绝信誉列表中的地址
+	reputation *reputationList
+	// acl 不为nil时按访问控制列表判断
+	acl *accessList
+	// throttle 不为nil时限制每个IP的入站连接
+	throttle *inboundThrottle
+	// dialDeny 不为nil时拒绝拨号到内网等网段
+	dialDeny *dialFilter
+	// backoff 不为nil时跳过拨号失败后退避中的地址
+	backoff *dialBackoff
+	// budget 不为nil时记录开始建立连接的时间
+	budget *setupBudget
+
+	mu       sync.Mutex
+	shedding bool
+	shed     uint64
+	// closing 不为0时正在关闭, 拒绝所有新连接
+	closing int32
+}
+
+func newConnectionGater(maxGoroutines int) *connectionGater {
+	return &connectionGater{maxGoroutines: maxGoroutines}
+}
+
+// close 在关闭节点时调用, 之后不再接受和拨出新连接
+func (g *connectionGater) close() {
+	atomic.StoreInt32(&g.closing, 1)
+}
+
+func (g *connectionGater) closed() bool {
+	return atomic.LoadInt32(&g.closing) != 0
+}
+
+func (g *connectionGater) InterceptPeerDial(p peer.ID) bool {
+	if g.closed() {
+		return false
+	}
+	if g.bans != nil && g.bans.banned(p, nil) {
+		return false
+	}
+	if g.acl != nil && !g.acl.allow(p, nil) {
+		return false
+	}
+	return true
+}
+
+func (g *connectionGater) InterceptAddrDial(p peer.ID, addr ma.Multiaddr) bool {
+	if g.bans != nil && g.bans.banned(p, addr) {
+		return false
+	}
+	if g.reputation != nil && g.reputation.blocked(addr) {
+		return false
+	}
+	if g.acl != nil && !g.acl.allow(p, addr) {
+		return false
+	}
+	if g.dialDeny != nil && !g.dialDeny.allow(p, addr) {
+		return false
+	}
+	if g.hook != nil && !g.hook.allowDial(addr) {
+		return false
+	}
+	if g.backoff != nil && !g.backoff.allow(p, addr) {
+		return false
+	}
+	if g.budget != nil {
+		g.budget.start(addr)
+	}
+	return true
+}
+
+func (g *connectionGater) InterceptAccept(addrs network.ConnMultiaddrs) bool {
+	if g.closed() {
+		return false
+	}
+	if g.shouldShed() {
+		atomic.AddUint64(&g.shed, 1)
+		return false
+	}
+	if g.bans != nil && g.bans.banned("", addrs.RemoteMultiaddr()) {
+		return false
+	}
+	if g.reputation != nil && g.reputation.blocked(addrs.RemoteMultiaddr()) {
+		return false
+	}
+	if g.acl != nil && !g.acl.allow("", addrs.RemoteMultiaddr()) {
+		return false
+	}
+	if g.throttle != nil && !g.throttle.allow(addrs.RemoteMultiaddr()) {
+		return false
+	}
+	if g.hook != nil && !g.hook.allowAccept(addrs) {
+		return false
+	}
+	if g.budget != nil {
+		g.budget.start(addrs.RemoteMultiaddr())
+	}
+	return true
+}
+
+func (g *connectionGater) InterceptSecured(dir network.Direction, p peer.ID, addrs network.ConnMultiaddrs) bool {
+	if g.bans != nil && g.bans.banned(p, addrs.RemoteMultiaddr()) {
+		return false
+	}
+	if g.acl != nil && !g.acl.allow(p, addrs.RemoteMultiaddr()) {
+		return false
+	}
+	if g.hook != nil && !g.hook.secured(dir, p) {
+		return false
+	}
+	return true
+}
+
+func (g *connectionGater) InterceptUpgraded(network.Conn) (bool, control.DisconnectReason) {
+	return true, 0
+}
+
+// shouldShed 判断协程数量是否超过上限.
+// 超过上限后开始拒绝, 降到上限的90%以下才恢复, 避免反复切换.
+func (g *connectionGater) shouldShed() bool {
+	if g.maxGoroutines <= 0 {
+		return false
+	}
+	n := runtime.NumGoroutine()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.shedding && n > g.maxGoroutines {
+		g.shedding = true
+		logger.Warnw("协程数量超过上限, 开始拒绝入站连接", "goroutines", n)
+	} else if g.shedding && n < g.maxGoroutines*9/10 {
+		g.shedding = false
+		logger.Infow("协程数量恢复, 停止拒绝入站连接", "goroutines", n)
+	}
+	return g.shedding
+}
+
+// shedCounts 返回是否正在拒绝入站连接和因协程数量拒绝的连接数量
+func (g *connectionGater) shedCounts() (bool, uint64) {
+	g.mu.Lock()
+	shedding := g.shedding
+	g.mu.Unlock()
+	return shedding, atomic.LoadUint64(&g.shed)
+}
+
+// status 返回连接拒绝情况
+func (g *connectionGater) status() interface{} {
+	g.mu.Lock()
+	shedding := g.shedding
+	g.mu.Unlock()
+	status := map[string]interface{}{
+		"goroutines":     runtime.NumGoroutine(),
+		"max_goroutines": g.maxGoroutines,
+		"shedding":       shedding,
+		"shed":           atomic.LoadUint64(&g.shed),
+		"closing":        g.closed(),
+	}
+	if g.dialDeny != nil {
+		status["dial_filter"] = g.dialDeny.status()
+	}
+	if g.backoff != nil {
+		status["dial_backoff"] = g.backoff.status()
+	}
+	return status
+}
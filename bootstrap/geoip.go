@@ -0,0 +1,147 @@
+package bootstrap
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// geoipTop 是分布中按节点数量保留的国家或ASN数量, 其余合并为other
+const geoipTop = 50
+
+// geoipConfig 是GeoIP数据库的设置, 使用MaxMind格式(mmdb)
+type geoipConfig struct {
+	// CountryDB 是国家或城市数据库, 如GeoLite2-Country.mmdb
+	CountryDB string `yaml:"country_db"`
+	// ASNDB 是ASN数据库, 如GeoLite2-ASN.mmdb
+	ASNDB string `yaml:"asn_db"`
+}
+
+// geoIP 查询IP地址的国家和ASN
+type geoIP struct {
+	country *maxminddb.Reader
+	asn     *maxminddb.Reader
+}
+
+// openGeoIP 打开数据库, 都没有设置时返回nil
+func openGeoIP(c geoipConfig) (*geoIP, error) {
+	if c.CountryDB == "" && c.ASNDB == "" {
+		return nil, nil
+	}
+	g := &geoIP{}
+	var e error
+	if c.CountryDB != "" {
+		if g.country, e = maxminddb.Open(c.CountryDB); e != nil {
+			return nil, fmt.Errorf("打开国家数据库出错: %w", e)
+		}
+	}
+	if c.ASNDB != "" {
+		if g.asn, e = maxminddb.Open(c.ASNDB); e != nil {
+			g.Close()
+			return nil, fmt.Errorf("打开ASN数据库出错: %w", e)
+		}
+	}
+	return g, nil
+}
+
+func (g *geoIP) Close() {
+	if g.country != nil {
+		_ = g.country.Close()
+	}
+	if g.asn != nil {
+		_ = g.asn.Close()
+	}
+}
+
+// geoLocation 是一个地址的查询结果
+type geoLocation struct {
+	Country string `json:"country,omitempty"`
+	ASN     uint   `json:"asn,omitempty"`
+	ASOrg   string `json:"as_org,omitempty"`
+}
+
+// lookup 查询地址, 不是IP地址或没有记录时返回空结果
+func (g *geoIP) lookup(addr ma.Multiaddr) geoLocation {
+	var loc geoLocation
+	ip, e := manet.ToIP(addr)
+	if e != nil {
+		return loc
+	}
+	if g.country != nil {
+		var record struct {
+			Country struct {
+				ISOCode string `maxminddb:"iso_code"`
+			} `maxminddb:"country"`
+		}
+		if g.country.Lookup(ip, &record) == nil {
+			loc.Country = record.Country.ISOCode
+		}
+	}
+	if g.asn != nil {
+		var record struct {
+			Number uint   `maxminddb:"autonomous_system_number"`
+			Org    string `maxminddb:"autonomous_system_organization"`
+		}
+		if g.asn.Lookup(ip, &record) == nil {
+			loc.ASN, loc.ASOrg = record.Number, record.Org
+		}
+	}
+	return loc
+}
+
+// distribution 按国家和ASN统计已连接的节点, 每个节点只计一次.
+// 没有记录的国家和ASN计为unknown, 超过geoipTop的计为other.
+func (g *geoIP) distribution(n network.Network) (countries, asns map[string]uint64) {
+	countries = make(map[string]uint64)
+	asns = make(map[string]uint64)
+	for _, p := range n.Peers() {
+		conns := n.ConnsToPeer(p)
+		if len(conns) == 0 {
+			continue
+		}
+		loc := g.lookup(conns[0].RemoteMultiaddr())
+		country, asn := loc.Country, "unknown"
+		if country == "" {
+			country = "unknown"
+		}
+		if loc.ASN != 0 {
+			asn = fmt.Sprintf("AS%d", loc.ASN)
+		}
+		countries[country]++
+		asns[asn]++
+	}
+	return topCounts(countries, geoipTop), topCounts(asns, geoipTop)
+}
+
+// topCounts 只保留数量最多的n项, 其余合并为other
+func topCounts(counts map[string]uint64, n int) map[string]uint64 {
+	if len(counts) <= n {
+		return counts
+	}
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return counts[keys[i]] > counts[keys[j]] })
+	top := make(map[string]uint64, n+1)
+	for i, k := range keys {
+		if i < n {
+			top[k] = counts[k]
+		} else {
+			top["other"] += counts[k]
+		}
+	}
+	return top
+}
+
+// status 返回已连接节点的国家和ASN分布
+func (g *geoIP) status(n network.Network) func() interface{} {
+	return func() interface{} {
+		countries, asns := g.distribution(n)
+		return map[string]interface{}{"countries": countries, "asns": asns}
+	}
+}
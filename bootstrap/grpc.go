@@ -0,0 +1,179 @@
+package bootstrap
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/alx696/go-libp2p-bootstrap/controlpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+// controlServer 是gRPC控制接口, 功能与管理接口相同, 另外可以持续接收事件
+type controlServer struct {
+	controlpb.UnimplementedControlServer
+	admin        *adminAPI
+	events       *eventHub
+	reachability *reachabilityTracker
+
+	// networks 是多网络模式下按名称选择的各个网络的控制接口
+	mu       sync.RWMutex
+	networks map[string]*controlServer
+}
+
+// controlNetworkKey 是选择网络的请求元数据, 为空时访问提供控制接口的网络
+const controlNetworkKey = "network"
+
+// setNetworks 设置多网络模式下可以通过请求元数据选择的网络
+func (c *controlServer) setNetworks(networks map[string]*controlServer) {
+	c.mu.Lock()
+	c.networks = networks
+	c.mu.Unlock()
+}
+
+// route 返回请求元数据选择的网络的控制接口
+func (c *controlServer) route(ctx context.Context) (*controlServer, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	names := md.Get(controlNetworkKey)
+	if len(names) == 0 || names[0] == "" {
+		return c, nil
+	}
+	c.mu.RLock()
+	target, ok := c.networks[names[0]]
+	c.mu.RUnlock()
+	if !ok {
+		return nil, grpcstatus.Errorf(codes.NotFound, "没有网络 %s", names[0])
+	}
+	return target, nil
+}
+
+// serveControl 在监听地址上提供gRPC控制接口, 返回的gRPC服务需要在退出时停止
+func serveControl(l net.Listener, addr string, c *controlServer, opts ...grpc.ServerOption) *grpc.Server {
+	server := grpc.NewServer(opts...)
+	controlpb.RegisterControlServer(server, c)
+	go func() {
+		logger.Infow("gRPC控制接口地址", "addr", addr)
+		if e := server.Serve(l); e != nil {
+			logger.Errorw("gRPC控制接口出错", "error", e)
+		}
+	}()
+	return server
+}
+
+func (c *controlServer) NodeInfo(ctx context.Context, _ *controlpb.NodeInfoRequest) (*controlpb.NodeInfoResponse, error) {
+	c, e := c.route(ctx)
+	if e != nil {
+		return nil, e
+	}
+	addrs, e := c.admin.ownAddrs()
+	if e != nil {
+		return nil, grpcstatus.Error(codes.Internal, e.Error())
+	}
+	return &controlpb.NodeInfoResponse{
+		Id:           c.admin.h.ID().String(),
+		Addrs:        addrs,
+		Reachability: c.reachability.Get().String(),
+	}, nil
+}
+
+func (c *controlServer) ListPeers(ctx context.Context, _ *controlpb.ListPeersRequest) (*controlpb.ListPeersResponse, error) {
+	c, e := c.route(ctx)
+	if e != nil {
+		return nil, e
+	}
+	list := c.admin.listPeers()
+	resp := &controlpb.ListPeersResponse{Peers: make([]*controlpb.Peer, 0, len(list))}
+	for _, p := range list {
+		resp.Peers = append(resp.Peers, &controlpb.Peer{
+			Id:           p.ID,
+			Addrs:        p.Addrs,
+			Directions:   p.Directions,
+			Protocols:    p.Protocols,
+			AgentVersion: p.AgentVersion,
+		})
+	}
+	return resp, nil
+}
+
+func (c *controlServer) Connect(ctx context.Context, req *controlpb.ConnectRequest) (*controlpb.ConnectResponse, error) {
+	c, e := c.route(ctx)
+	if e != nil {
+		return nil, e
+	}
+	connected, e := c.admin.connectAddr(ctx, req.Addr)
+	if e != nil {
+		return nil, controlError(e, codes.Unavailable)
+	}
+	return &controlpb.ConnectResponse{Peers: connected}, nil
+}
+
+func (c *controlServer) Disconnect(ctx context.Context, req *controlpb.DisconnectRequest) (*controlpb.DisconnectResponse, error) {
+	c, e := c.route(ctx)
+	if e != nil {
+		return nil, e
+	}
+	closed, e := c.admin.disconnectPeer(req.Peer)
+	if e != nil {
+		return nil, controlError(e, codes.Internal)
+	}
+	return &controlpb.DisconnectResponse{Closed: int32(closed)}, nil
+}
+
+// WatchEvents 持续发送事件直到客户端取消, 处理不及时时先发送dropped事件
+func (c *controlServer) WatchEvents(req *controlpb.WatchEventsRequest, stream controlpb.Control_WatchEventsServer) error {
+	c, e := c.route(stream.Context())
+	if e != nil {
+		return e
+	}
+	types := make(map[string]bool)
+	for _, t := range req.Types {
+		types[t] = true
+	}
+	sub := c.events.Subscribe(eventStreamBuffer)
+	defer sub.Close()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case evt := <-sub.C:
+			if dropped := sub.TakeDropped(); dropped > 0 {
+				if e := stream.Send(eventMessage(nodeEvent{Time: time.Now(), Type: eventDropped, Dropped: dropped})); e != nil {
+					return e
+				}
+			}
+			if len(types) > 0 && !types[evt.Type] {
+				continue
+			}
+			if e := stream.Send(eventMessage(evt)); e != nil {
+				return e
+			}
+		}
+	}
+}
+
+// eventMessage 把事件转换为gRPC消息
+func eventMessage(evt nodeEvent) *controlpb.Event {
+	return &controlpb.Event{
+		TimeUnixNano: evt.Time.UnixNano(),
+		Type:         evt.Type,
+		Peer:         evt.Peer,
+		Addr:         evt.Addr,
+		Direction:    evt.Direction,
+		Reachability: evt.Reachability,
+		Dropped:      evt.Dropped,
+		AgentVersion: evt.AgentVersion,
+		Error:        evt.Error,
+	}
+}
+
+// controlError 把管理接口的错误转换为gRPC状态, 请求内容错误是InvalidArgument, 其他是code
+func controlError(e error, code codes.Code) error {
+	if isAdminRequestError(e) {
+		code = codes.InvalidArgument
+	}
+	return grpcstatus.Error(code, e.Error())
+}
@@ -0,0 +1,79 @@
+package bootstrap
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/libp2p/go-libp2p/core/host"
+)
+
+// readiness 判断节点是否可以接收流量: 正在监听, DHT已启动, 连接数量不少于下限
+type readiness struct {
+	h        host.Host
+	minPeers int
+
+	bootstrapped int32
+	draining     int32
+}
+
+func newReadiness(h host.Host, minPeers int) *readiness {
+	return &readiness{h: h, minPeers: minPeers}
+}
+
+// setBootstrapped 在连接引导节点并启动DHT后调用.
+// 第一个节点(没有引导节点)不要求连接数量.
+func (r *readiness) setBootstrapped(genesis bool) {
+	if genesis {
+		r.minPeers = 0
+	}
+	atomic.StoreInt32(&r.bootstrapped, 1)
+}
+
+// setDraining 在计划关闭前标记为未就绪, 负载均衡不再转发新的请求
+func (r *readiness) setDraining() {
+	atomic.StoreInt32(&r.draining, 1)
+}
+
+// readinessStatus 是 /readyz 的内容
+type readinessStatus struct {
+	Ready           bool `json:"ready"`
+	Listening       bool `json:"listening"`
+	DHTBootstrapped bool `json:"dht_bootstrapped"`
+	Peers           int  `json:"peers"`
+	MinPeers        int  `json:"min_peers"`
+	Draining        bool `json:"draining,omitempty"`
+}
+
+func (r *readiness) check() readinessStatus {
+	bootstrapped := atomic.LoadInt32(&r.bootstrapped) == 1
+	s := readinessStatus{
+		Listening:       len(r.h.Network().ListenAddresses()) > 0,
+		DHTBootstrapped: bootstrapped,
+		Peers:           len(r.h.Network().Peers()),
+		Draining:        atomic.LoadInt32(&r.draining) == 1,
+	}
+	if bootstrapped {
+		s.MinPeers = r.minPeers
+	}
+	s.Ready = s.Listening && s.DHTBootstrapped && s.Peers >= s.MinPeers && !s.Draining
+	return s
+}
+
+// ServeHTTP 提供 /readyz, 未就绪时返回503
+func (r *readiness) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	s := r.check()
+	w.Header().Set("Content-Type", "application/json")
+	if !s.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if e := json.NewEncoder(w).Encode(s); e != nil {
+		logger.Warnw("输出就绪状态出错", "error", e)
+	}
+}
+
+// healthz 提供 /healthz, 进程在运行即返回200
+func healthz(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write([]byte("ok\n"))
+}
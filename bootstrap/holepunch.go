@@ -0,0 +1,56 @@
+package bootstrap
+
+import (
+	"sync/atomic"
+
+	"github.com/libp2p/go-libp2p/p2p/protocol/holepunch"
+)
+
+// holePunchStats 实现holepunch.EventTracer, 统计本节点参与的打洞(DCUtR)结果.
+// 通过中继连接后先尝试直接拨号, 失败时打洞, 都成功时改用直接连接
+type holePunchStats struct {
+	directSucceeded uint64
+	directFailed    uint64
+	succeeded       uint64
+	failed          uint64
+	protocolErrors  uint64
+}
+
+func (s *holePunchStats) Trace(evt *holepunch.Event) {
+	switch e := evt.Evt.(type) {
+	case *holepunch.DirectDialEvt:
+		if e.Success {
+			atomic.AddUint64(&s.directSucceeded, 1)
+		} else {
+			atomic.AddUint64(&s.directFailed, 1)
+		}
+	case *holepunch.EndHolePunchEvt:
+		if e.Success {
+			atomic.AddUint64(&s.succeeded, 1)
+		} else {
+			atomic.AddUint64(&s.failed, 1)
+			logger.Debugw("打洞失败", "peer", evt.Remote, "error", e.Error)
+		}
+	case *holepunch.ProtocolErrorEvt:
+		atomic.AddUint64(&s.protocolErrors, 1)
+	}
+}
+
+// results 返回按结果统计的打洞次数
+func (s *holePunchStats) results() map[string]uint64 {
+	return map[string]uint64{"success": atomic.LoadUint64(&s.succeeded), "failure": atomic.LoadUint64(&s.failed)}
+}
+
+// directDials 返回打洞前直接拨号的结果
+func (s *holePunchStats) directDials() map[string]uint64 {
+	return map[string]uint64{"success": atomic.LoadUint64(&s.directSucceeded), "failure": atomic.LoadUint64(&s.directFailed)}
+}
+
+// status 返回打洞和直接拨号的次数
+func (s *holePunchStats) status() interface{} {
+	return map[string]interface{}{
+		"hole_punches":    s.results(),
+		"direct_dials":    s.directDials(),
+		"protocol_errors": atomic.LoadUint64(&s.protocolErrors),
+	}
+}
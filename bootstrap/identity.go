@@ -0,0 +1,172 @@
+package bootstrap
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+)
+
+// keyTypes 是支持的私钥类型
+var keyTypes = map[string]int{
+	"ed25519":   crypto.Ed25519,
+	"rsa":       crypto.RSA,
+	"secp256k1": crypto.Secp256k1,
+	"ecdsa":     crypto.ECDSA,
+}
+
+// loadOrCreatePrivateKey 读取私钥文件, 文件不存在时按keyType生成并保存, encrypt时用密码加密. 读取时支持所有类型
+func loadOrCreatePrivateKey(privateKeyPath, keyType string, bits int, encrypt bool) (crypto.PrivKey, error) {
+	_, e := os.Stat(privateKeyPath)
+	if !os.IsNotExist(e) {
+		privateKey, e := loadPrivateKey(privateKeyPath)
+		if e != nil {
+			return nil, e
+		}
+		if t, ok := keyTypes[keyType]; ok && int(privateKey.Type()) != t {
+			logger.Warnw("已有私钥的类型与key-type不同, 使用已有私钥", "type", privateKey.Type())
+		}
+		return privateKey, nil
+	}
+
+	privateKey, e := generatePrivateKey(keyType, bits)
+	if e != nil {
+		return nil, e
+	}
+	e = savePrivateKey(privateKeyPath, privateKey, encrypt)
+	if e != nil {
+		return nil, e
+	}
+	return privateKey, nil
+}
+
+// loadPrivateKey 读取私钥文件, 加密的私钥需要密码. 未加密的私钥文件其他用户可读时改为0600
+func loadPrivateKey(privateKeyPath string) (crypto.PrivKey, error) {
+	privateKeyBytes, e := ioutil.ReadFile(privateKeyPath)
+	if e != nil {
+		return nil, e
+	}
+	if isEncryptedKey(privateKeyBytes) {
+		password, e := readPassword(false)
+		if e != nil {
+			return nil, e
+		}
+		privateKeyBytes, e = decryptKey(privateKeyBytes, password)
+		if e != nil {
+			return nil, e
+		}
+	} else if info, e := os.Stat(privateKeyPath); e == nil && info.Mode().Perm()&0077 != 0 {
+		logger.Warnw("私钥文件权限过宽, 改为0600", "path", privateKeyPath)
+		if e := os.Chmod(privateKeyPath, 0600); e != nil {
+			logger.Warnw("修改私钥文件权限出错", "error", e)
+		}
+	}
+	return crypto.UnmarshalPrivateKey(privateKeyBytes)
+}
+
+// isEncryptedKeyFile 判断私钥文件是否加密
+func isEncryptedKeyFile(privateKeyPath string) bool {
+	b, e := ioutil.ReadFile(privateKeyPath)
+	return e == nil && isEncryptedKey(b)
+}
+
+// generatePrivateKey 生成新的私钥, bits只用于RSA
+func generatePrivateKey(keyType string, bits int) (crypto.PrivKey, error) {
+	t, ok := keyTypes[keyType]
+	if !ok {
+		return nil, fmt.Errorf("不支持的私钥类型 %s", keyType)
+	}
+	privateKey, _, e := crypto.GenerateKeyPair(
+		t,    // Select your key type. Ed25519 are nice short
+		bits, // Select key length when possible (i.e. RSA).
+	)
+	return privateKey, e
+}
+
+// savePrivateKey 保存私钥文件, 只有当前用户可读. encrypt时用密码加密
+func savePrivateKey(privateKeyPath string, privateKey crypto.PrivKey, encrypt bool) error {
+	privateKeyBytes, e := crypto.MarshalPrivateKey(privateKey)
+	if e != nil {
+		return e
+	}
+	if encrypt {
+		password, e := readPassword(true)
+		if e != nil {
+			return e
+		}
+		privateKeyBytes, e = encryptKey(privateKeyBytes, password)
+		if e != nil {
+			return e
+		}
+	}
+	return ioutil.WriteFile(privateKeyPath, privateKeyBytes, 0600)
+}
+
+// keyPath 返回私钥文件路径, 未设置时使用数据目录下的private.key
+func keyPath(cfg *Config, dir string) string {
+	if cfg.KeyPath != "" {
+		return cfg.KeyPath
+	}
+	return filepath.Join(dir, "private.key")
+}
+
+// nodePrivateKey 按设置派生身份或从keystore读取私钥
+func nodePrivateKey(cfg *Config, dir string) (crypto.PrivKey, error) {
+	if cfg.IdentityFromHostname {
+		name := cfg.NodeName
+		if name == "" {
+			var e error
+			name, e = os.Hostname()
+			if e != nil {
+				return nil, e
+			}
+		}
+		// 其他类型的生成过程不保证由种子唯一确定
+		if cfg.KeyType != "ed25519" {
+			return nil, errors.New("派生身份只支持ed25519私钥")
+		}
+		logger.Infow("根据节点名称派生身份", "name", name)
+		return derivePrivateKey(name, cfg.IdentitySalt)
+	}
+	ks, e := newKeystore(cfg, dir)
+	if e != nil {
+		return nil, e
+	}
+	return ks.Load()
+}
+
+// migrateLegacyKey 把旧版本保存在程序所在目录的私钥复制到新位置, 保持节点ID不变
+func migrateLegacyKey(legacyPath, privateKeyPath string) (bool, error) {
+	if _, e := os.Stat(privateKeyPath); !os.IsNotExist(e) {
+		return false, nil
+	}
+	privateKeyBytes, e := ioutil.ReadFile(legacyPath)
+	if os.IsNotExist(e) {
+		return false, nil
+	}
+	if e != nil {
+		return false, e
+	}
+	return true, ioutil.WriteFile(privateKeyPath, privateKeyBytes, 0600)
+}
+
+// derivePrivateKey 使用HMAC(salt, name)作为种子确定性地生成私钥, 同一名称总是得到同一节点ID
+func derivePrivateKey(name, salt string) (crypto.PrivKey, error) {
+	if name == "" {
+		return nil, errors.New("节点名称为空")
+	}
+	if salt == "" {
+		return nil, errors.New("派生身份必须设置盐")
+	}
+	mac := hmac.New(sha256.New, []byte(salt))
+	mac.Write([]byte(name))
+	// Ed25519只从种子读取32字节
+	privateKey, _, e := crypto.GenerateKeyPairWithReader(crypto.Ed25519, -1, bytes.NewReader(mac.Sum(nil)))
+	return privateKey, e
+}
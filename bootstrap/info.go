@@ -0,0 +1,307 @@
+package bootstrap
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// infoProtocol 是客户端获取引导节点网络信息的协议.
+// 客户端打开流后不发送内容, 收到一个签名的JSON后流关闭.
+// 引导节点计划关闭时也在这个协议上向支持它的已连接节点推送带going_away的网络信息.
+const infoProtocol protocol.ID = "/bootstrap/info/1.0.0"
+
+// infoTimeout 是获取网络信息的超时时间
+const infoTimeout = time.Second * 10
+
+// infoMaxSize 是网络信息的最大字节数
+const infoMaxSize = 64 << 10
+
+// infoPushWorkers 是同时推送关闭通知的节点数量
+const infoPushWorkers = 16
+
+// infoConfig 是网络信息的设置. 客户端在加入网络之前获取网络信息, 确认连接到了正确的网络
+type infoConfig struct {
+	// Enabled 为true时提供网络信息
+	Enabled bool `yaml:"enabled"`
+	// Network 是网络名称, 为空时使用DHT协议前缀
+	Network string `yaml:"network"`
+	// MinClientVersion 是建议的最低客户端版本, 由客户端自己比较
+	MinClientVersion string `yaml:"min_client_version"`
+	// Alternatives 是其他引导节点的地址(带/p2p), 集群模式下还包括有公网地址的成员
+	Alternatives []string `yaml:"alternatives"`
+}
+
+// validate 检查网络信息设置
+func (c infoConfig) validate() error {
+	if _, e := parseAddrInfos(c.Alternatives); e != nil {
+		return fmt.Errorf("网络信息中的引导节点地址错误: %w", e)
+	}
+	return nil
+}
+
+// NodeInfo 是引导节点在 /bootstrap/info/1.0.0 上提供的网络信息
+type NodeInfo struct {
+	// ID 是引导节点的节点ID, 签名由这个节点的私钥生成
+	ID string `json:"id"`
+	// Network 是网络名称
+	Network string `json:"network"`
+	// Protocols 是引导节点支持的协议
+	Protocols []string `json:"protocols"`
+	// MinClientVersion 是建议的最低客户端版本, 为空时没有要求
+	MinClientVersion string `json:"min_client_version,omitempty"`
+	// Peers 是引导节点当前连接的节点数量
+	Peers int `json:"peers"`
+	// Alternatives 是其他引导节点的地址
+	Alternatives []string `json:"alternatives"`
+	// Time 是生成信息的时间, 客户端可以拒绝过期的信息
+	Time time.Time `json:"time"`
+	// GoingAway 不为空时引导节点计划在这个时间关闭, 客户端应换用Alternatives中的节点
+	GoingAway *time.Time `json:"going_away,omitempty"`
+}
+
+// infoMessage 是发送的内容, Signature 是对 infoSigningData(Info) 的签名
+type infoMessage struct {
+	Info      json.RawMessage `json:"info"`
+	Signature []byte          `json:"signature"`
+}
+
+// infoSigningData 返回网络信息签名的内容, 包括协议, 避免与其他协议的签名混用
+func infoSigningData(info []byte) []byte {
+	return append([]byte(infoProtocol+"\n"), info...)
+}
+
+// infoService 提供网络信息
+type infoService struct {
+	h       host.Host
+	cfg     infoConfig
+	network string
+	cluster *cluster
+
+	mu        sync.Mutex
+	goingAway time.Time
+
+	served uint64
+	pushed uint64
+}
+
+func newInfoService(h host.Host, cfg infoConfig, network string, cluster *cluster) *infoService {
+	if cfg.Network != "" {
+		network = cfg.Network
+	}
+	return &infoService{h: h, cfg: cfg, network: network, cluster: cluster}
+}
+
+// info 返回当前的网络信息
+func (s *infoService) info() NodeInfo {
+	protocols := protocol.ConvertToStrings(s.h.Mux().Protocols())
+	sort.Strings(protocols)
+	alternatives := append([]string{}, s.cfg.Alternatives...)
+	if s.cluster != nil {
+		members, _ := s.cluster.candidates(s.h.ID())
+		for _, m := range members {
+			addrs, e := peer.AddrInfoToP2pAddrs(&m)
+			if e != nil {
+				continue
+			}
+			for _, addr := range addrs {
+				alternatives = append(alternatives, addr.String())
+			}
+		}
+	}
+	info := NodeInfo{
+		ID:               s.h.ID().String(),
+		Network:          s.network,
+		Protocols:        protocols,
+		MinClientVersion: s.cfg.MinClientVersion,
+		Peers:            len(s.h.Network().Peers()),
+		Alternatives:     alternatives,
+		Time:             time.Now().UTC(),
+	}
+	s.mu.Lock()
+	if !s.goingAway.IsZero() {
+		at := s.goingAway
+		info.GoingAway = &at
+	}
+	s.mu.Unlock()
+	return info
+}
+
+// signed 返回签名的网络信息
+func (s *infoService) signed() (infoMessage, error) {
+	key := s.h.Peerstore().PrivKey(s.h.ID())
+	if key == nil {
+		return infoMessage{}, errors.New("没有本节点的私钥")
+	}
+	b, e := json.Marshal(s.info())
+	if e != nil {
+		return infoMessage{}, e
+	}
+	signature, e := key.Sign(infoSigningData(b))
+	if e != nil {
+		return infoMessage{}, e
+	}
+	return infoMessage{Info: b, Signature: signature}, nil
+}
+
+func (s *infoService) handle(st network.Stream) {
+	msg, e := s.signed()
+	if e != nil {
+		logger.Warnw("签名网络信息出错", "error", e)
+		_ = st.Reset()
+		return
+	}
+	_ = st.SetWriteDeadline(time.Now().Add(infoTimeout))
+	if e := json.NewEncoder(st).Encode(msg); e != nil {
+		_ = st.Reset()
+		return
+	}
+	atomic.AddUint64(&s.served, 1)
+	_ = st.Close()
+}
+
+// announceShutdown 记录计划关闭的时间, 之后的网络信息都带going_away,
+// 并向已连接且支持网络信息协议的节点推送, 返回推送成功的节点数量
+func (s *infoService) announceShutdown(ctx context.Context, at time.Time) int {
+	s.mu.Lock()
+	s.goingAway = at.UTC()
+	s.mu.Unlock()
+	msg, e := s.signed()
+	if e != nil {
+		logger.Warnw("签名关闭通知出错", "error", e)
+		return 0
+	}
+	queue := make(chan peer.ID)
+	var wg sync.WaitGroup
+	for i := 0; i < infoPushWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range queue {
+				if e := s.push(ctx, p, msg); e != nil {
+					logger.Debugw("推送关闭通知出错", "peer", p, "error", e)
+					continue
+				}
+				atomic.AddUint64(&s.pushed, 1)
+			}
+		}()
+	}
+	before := atomic.LoadUint64(&s.pushed)
+	for _, p := range s.h.Network().Peers() {
+		if protocols, e := s.h.Peerstore().SupportsProtocols(p, infoProtocol); e != nil || len(protocols) == 0 {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+		case queue <- p:
+		}
+	}
+	close(queue)
+	wg.Wait()
+	return int(atomic.LoadUint64(&s.pushed) - before)
+}
+
+// push 在已有连接上向节点发送网络信息
+func (s *infoService) push(ctx context.Context, p peer.ID, msg infoMessage) error {
+	ctx, cancel := context.WithTimeout(network.WithNoDial(ctx, "关闭通知"), infoTimeout)
+	defer cancel()
+	st, e := s.h.NewStream(ctx, p, infoProtocol)
+	if e != nil {
+		return e
+	}
+	_ = st.SetWriteDeadline(time.Now().Add(infoTimeout))
+	if e := json.NewEncoder(st).Encode(msg); e != nil {
+		_ = st.Reset()
+		return e
+	}
+	return st.Close()
+}
+
+// status 返回网络名称, 提供和推送的次数
+func (s *infoService) status() interface{} {
+	s.mu.Lock()
+	goingAway := s.goingAway
+	s.mu.Unlock()
+	result := map[string]interface{}{"network": s.network, "served": atomic.LoadUint64(&s.served), "pushed": atomic.LoadUint64(&s.pushed)}
+	if !goingAway.IsZero() {
+		result["going_away"] = goingAway
+	}
+	return result
+}
+
+// FetchInfo 从引导节点获取网络信息, 并用节点ID中的公钥验证签名. 主机需要已经知道引导节点的地址
+func FetchInfo(ctx context.Context, h host.Host, id peer.ID) (*NodeInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, infoTimeout)
+	defer cancel()
+	s, e := h.NewStream(ctx, id, infoProtocol)
+	if e != nil {
+		return nil, e
+	}
+	defer s.Close()
+	info, e := readInfo(h, id, s)
+	if e != nil {
+		_ = s.Reset()
+	}
+	return info, e
+}
+
+// HandleInfo 在客户端上接收引导节点推送的网络信息, 验证签名后调用fn.
+// 引导节点计划关闭时推送GoingAway不为空的信息, 客户端可以在关闭前连接Alternatives中的节点.
+// 设置后客户端的identify宣告支持这个协议, 引导节点只向这些节点推送
+func HandleInfo(h host.Host, fn func(NodeInfo)) {
+	h.SetStreamHandler(infoProtocol, func(s network.Stream) {
+		defer s.Close()
+		info, e := readInfo(h, s.Conn().RemotePeer(), s)
+		if e != nil {
+			_ = s.Reset()
+			return
+		}
+		fn(*info)
+	})
+}
+
+// readInfo 读取并验证节点id签名的网络信息
+func readInfo(h host.Host, id peer.ID, s network.Stream) (*NodeInfo, error) {
+	_ = s.SetReadDeadline(time.Now().Add(infoTimeout))
+	b, e := ioutil.ReadAll(io.LimitReader(s, infoMaxSize+1))
+	if e != nil {
+		return nil, e
+	}
+	if len(b) > infoMaxSize {
+		return nil, errors.New("网络信息太大")
+	}
+	var msg infoMessage
+	if e := json.Unmarshal(b, &msg); e != nil {
+		return nil, e
+	}
+	pub, e := id.ExtractPublicKey()
+	if e != nil {
+		pub = h.Peerstore().PubKey(id)
+	}
+	if pub == nil {
+		return nil, errors.New("没有引导节点的公钥")
+	}
+	if ok, e := pub.Verify(infoSigningData(msg.Info), msg.Signature); e != nil || !ok {
+		return nil, errors.New("网络信息的签名无效")
+	}
+	info := &NodeInfo{}
+	if e := json.Unmarshal(msg.Info, info); e != nil {
+		return nil, e
+	}
+	if info.ID != id.String() {
+		return nil, fmt.Errorf("网络信息的节点ID不匹配: %s", info.ID)
+	}
+	return info, nil
+}
@@ -0,0 +1,205 @@
+package bootstrap
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// keyCommands 是key的子命令
+var keyCommands = map[string]func(args []string) int{
+	"rotate": runKeyRotate,
+	"export": runKeyExport,
+	"import": runKeyImport,
+}
+
+// runKey 执行私钥管理子命令
+func runKey(args []string) int {
+	if len(args) == 0 || keyCommands[args[0]] == nil {
+		fmt.Fprintln(os.Stderr, "用法: bootstrap key [rotate|export|import] [参数]")
+		return 2
+	}
+	return keyCommands[args[0]](args[1:])
+}
+
+// runKeyRotate 生成新身份并归档旧私钥. 设置宽限期时, 节点在宽限期内继续使用旧身份并通过轮换协议告知新身份
+func runKeyRotate(args []string) int {
+	fs := flag.NewFlagSet("key rotate", flag.ExitOnError)
+	grace := fs.Duration("grace", 0, "宽限期, 期间节点继续使用旧身份并告知其他节点新身份, 0表示重启后立即使用新身份")
+	cfg, e := commandConfig(fs, args)
+	if e != nil {
+		logger.Errorw("读取设置出错", "error", e)
+		return 1
+	}
+	if e := rotateKey(cfg, *grace); e != nil {
+		logger.Errorw("轮换身份出错", "error", e)
+		return 1
+	}
+	return 0
+}
+
+// rotateKey 把旧私钥改名为<私钥文件>.<旧节点ID>, 在原路径生成新私钥
+func rotateKey(cfg *Config, grace time.Duration) error {
+	if cfg.IdentityFromHostname {
+		return errors.New("派生身份不能轮换, 请修改node-name或identity-salt")
+	}
+	if e := requireFileKeystore(cfg); e != nil {
+		return e
+	}
+	dir, e := cfg.dataDir()
+	if e != nil {
+		return e
+	}
+	if r, e := readRotation(dir); e != nil {
+		return e
+	} else if r != nil && time.Now().Before(r.Until) {
+		return fmt.Errorf("上一次轮换的宽限期还没有结束: %s", r.Until.Format(time.RFC3339))
+	}
+	path := keyPath(cfg, dir)
+	// 旧私钥加密时新私钥也加密
+	encrypt := cfg.KeyEncrypt || isEncryptedKeyFile(path)
+	oldKey, e := loadPrivateKey(path)
+	if e != nil {
+		return e
+	}
+	oldID, e := peer.IDFromPrivateKey(oldKey)
+	if e != nil {
+		return e
+	}
+	newKey, e := generatePrivateKey(cfg.KeyType, cfg.KeyBits)
+	if e != nil {
+		return e
+	}
+	newID, e := peer.IDFromPrivateKey(newKey)
+	if e != nil {
+		return e
+	}
+
+	archivePath := path + "." + oldID.String()
+	if e := os.Rename(path, archivePath); e != nil {
+		return e
+	}
+	if e := savePrivateKey(path, newKey, encrypt); e != nil {
+		_ = os.Rename(archivePath, path)
+		return e
+	}
+	logger.Infow("旧私钥已归档", "path", archivePath)
+
+	if grace > 0 {
+		r := &keyRotation{OldKeyPath: archivePath, OldID: oldID.String(), NewID: newID.String(), Until: time.Now().Add(grace)}
+		if e := r.save(dir); e != nil {
+			return e
+		}
+		logger.Infow("重启节点后在宽限期内继续使用旧身份", "until", r.Until.Format(time.RFC3339))
+	} else if e := removeRotation(dir); e != nil {
+		return e
+	}
+	logger.Infow("旧节点ID", "peer", oldID)
+	fmt.Println(newID.String())
+	return nil
+}
+
+// runKeyExport 把私钥导出为其他格式
+func runKeyExport(args []string) int {
+	fs := flag.NewFlagSet("key export", flag.ExitOnError)
+	format := fs.String("format", "pem", "导出格式: "+strings.Join(keyFormats, ", "))
+	out := fs.String("out", "", "输出文件, 为空时输出到标准输出")
+	cfg, e := commandConfig(fs, args)
+	if e != nil {
+		logger.Errorw("读取设置出错", "error", e)
+		return 1
+	}
+	dir, e := cfg.dataDir()
+	if e != nil {
+		logger.Errorw("读取数据目录出错", "error", e)
+		return 1
+	}
+	var privateKey crypto.PrivKey
+	if requireFileKeystore(cfg) == nil && !cfg.IdentityFromHostname {
+		privateKey, e = loadPrivateKey(keyPath(cfg, dir))
+	} else {
+		privateKey, e = nodePrivateKey(cfg, dir)
+	}
+	if e != nil {
+		logger.Errorw("读取私钥出错", "error", e)
+		return 1
+	}
+	b, e := encodeKey(privateKey, *format)
+	if e != nil {
+		logger.Errorw("导出私钥出错", "error", e)
+		return 1
+	}
+	if *out == "" {
+		_, _ = os.Stdout.Write(b)
+		return 0
+	}
+	if e := ioutil.WriteFile(*out, b, 0600); e != nil {
+		logger.Errorw("保存文件出错", "error", e)
+		return 1
+	}
+	return 0
+}
+
+// runKeyImport 从其他格式导入私钥, 保存到私钥文件路径
+func runKeyImport(args []string) int {
+	fs := flag.NewFlagSet("key import", flag.ExitOnError)
+	format := fs.String("format", "", "导入格式: "+strings.Join(keyFormats, ", ")+", 为空时自动识别")
+	in := fs.String("in", "", "输入文件, 如go-ipfs的~/.ipfs/config, 为空时从标准输入读取")
+	force := fs.Bool("force", false, "覆盖已有的私钥文件, 节点ID会改变")
+	cfg, e := commandConfig(fs, args)
+	if e == nil {
+		e = requireFileKeystore(cfg)
+	}
+	if e != nil {
+		logger.Errorw("读取设置出错", "error", e)
+		return 1
+	}
+	var b []byte
+	if *in == "" {
+		b, e = ioutil.ReadAll(os.Stdin)
+	} else {
+		b, e = ioutil.ReadFile(*in)
+	}
+	if e != nil {
+		logger.Errorw("读取输入出错", "error", e)
+		return 1
+	}
+	privateKey, e := decodeKey(b, *format)
+	if e != nil {
+		logger.Errorw("解析私钥出错", "error", e)
+		return 1
+	}
+	dir, e := cfg.dataDir()
+	if e != nil {
+		logger.Errorw("读取数据目录出错", "error", e)
+		return 1
+	}
+	path := keyPath(cfg, dir)
+	if _, e := os.Stat(path); !os.IsNotExist(e) && !*force {
+		logger.Errorw("私钥文件已存在, 使用-force覆盖", "path", path)
+		return 1
+	}
+	if e := os.MkdirAll(dir, 0700); e != nil {
+		logger.Errorw("创建数据目录出错", "error", e)
+		return 1
+	}
+	if e := savePrivateKey(path, privateKey, cfg.KeyEncrypt); e != nil {
+		logger.Errorw("保存私钥出错", "error", e)
+		return 1
+	}
+	id, e := peer.IDFromPrivateKey(privateKey)
+	if e != nil {
+		logger.Errorw("读取节点ID出错", "error", e)
+		return 1
+	}
+	logger.Infow("私钥已保存", "path", path)
+	fmt.Println(id.String())
+	return 0
+}
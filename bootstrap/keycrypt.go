@@ -0,0 +1,145 @@
+package bootstrap
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/term"
+)
+
+// keyPasswordEnv 是私钥密码的环境变量, 没有设置时在终端中输入
+const keyPasswordEnv = envPrefix + "KEY_PASSWORD"
+
+// scrypt参数, 在普通服务器上解密约需要0.1秒
+const (
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32
+)
+
+// 解密时接受的scrypt参数上限, 防止修改过的私钥文件让scrypt占用过多内存和时间
+const (
+	scryptMaxN      = 1 << 20
+	scryptMaxR      = 16
+	scryptMaxP      = 16
+	scryptMaxMemory = 1 << 30
+)
+
+// encryptedKey 是加密私钥文件的内容, 私钥用scrypt派生的密钥以AES-GCM加密
+type encryptedKey struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	N          int    `json:"n"`
+	R          int    `json:"r"`
+	P          int    `json:"p"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// isEncryptedKey 判断私钥文件是否加密. 未加密的私钥是protobuf, 不会以{开头
+func isEncryptedKey(b []byte) bool {
+	b = bytes.TrimSpace(b)
+	return len(b) > 0 && b[0] == '{'
+}
+
+// encryptKey 用密码加密私钥
+func encryptKey(raw, password []byte) ([]byte, error) {
+	k := encryptedKey{Version: 1, KDF: "scrypt", N: scryptN, R: scryptR, P: scryptP, Salt: make([]byte, 16)}
+	if _, e := io.ReadFull(rand.Reader, k.Salt); e != nil {
+		return nil, e
+	}
+	aead, e := keyAEAD(&k, password)
+	if e != nil {
+		return nil, e
+	}
+	k.Nonce = make([]byte, aead.NonceSize())
+	if _, e := io.ReadFull(rand.Reader, k.Nonce); e != nil {
+		return nil, e
+	}
+	k.Ciphertext = aead.Seal(nil, k.Nonce, raw, nil)
+	return json.MarshalIndent(&k, "", "  ")
+}
+
+// decryptKey 用密码解密私钥
+func decryptKey(b, password []byte) ([]byte, error) {
+	var k encryptedKey
+	if e := json.Unmarshal(b, &k); e != nil {
+		return nil, fmt.Errorf("加密私钥文件错误: %w", e)
+	}
+	if k.Version != 1 || k.KDF != "scrypt" {
+		return nil, fmt.Errorf("不支持的加密私钥版本 %d %s", k.Version, k.KDF)
+	}
+	// scrypt使用128*N*R字节内存
+	if k.N <= 1 || k.N > scryptMaxN || k.R <= 0 || k.R > scryptMaxR || k.P <= 0 || k.P > scryptMaxP || 128*k.N*k.R > scryptMaxMemory {
+		return nil, fmt.Errorf("加密私钥的scrypt参数超出范围 n=%d r=%d p=%d", k.N, k.R, k.P)
+	}
+	aead, e := keyAEAD(&k, password)
+	if e != nil {
+		return nil, e
+	}
+	if len(k.Nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("加密私钥的nonce长度错误 %d", len(k.Nonce))
+	}
+	raw, e := aead.Open(nil, k.Nonce, k.Ciphertext, nil)
+	if e != nil {
+		return nil, errors.New("私钥密码错误")
+	}
+	return raw, nil
+}
+
+func keyAEAD(k *encryptedKey, password []byte) (cipher.AEAD, error) {
+	key, e := scrypt.Key(password, k.Salt, k.N, k.R, k.P, scryptKeyLen)
+	if e != nil {
+		return nil, e
+	}
+	block, e := aes.NewCipher(key)
+	if e != nil {
+		return nil, e
+	}
+	return cipher.NewGCM(block)
+}
+
+// readPassword 从环境变量或终端读取私钥密码, confirm时要求输入两次
+func readPassword(confirm bool) ([]byte, error) {
+	if v, ok := os.LookupEnv(keyPasswordEnv); ok {
+		if v == "" {
+			return nil, errors.New(keyPasswordEnv + "为空")
+		}
+		return []byte(v), nil
+	}
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, errors.New("私钥已加密, 需要在终端中输入密码或设置" + keyPasswordEnv)
+	}
+	fmt.Fprint(os.Stderr, "私钥密码: ")
+	password, e := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if e != nil {
+		return nil, e
+	}
+	if len(password) == 0 {
+		return nil, errors.New("私钥密码为空")
+	}
+	if confirm {
+		fmt.Fprint(os.Stderr, "再次输入私钥密码: ")
+		again, e := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if e != nil {
+			return nil, e
+		}
+		if !bytes.Equal(password, again) {
+			return nil, errors.New("两次输入的密码不同")
+		}
+	}
+	return password, nil
+}
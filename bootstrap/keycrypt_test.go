@@ -0,0 +1,77 @@
+package bootstrap
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestEncryptKeyRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      []byte
+		password string
+	}{
+		{"普通", []byte("private key bytes"), "password"},
+		{"空私钥", []byte{}, "password"},
+		{"中文密码", bytes.Repeat([]byte{0xaa}, 68), "私钥密码"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, e := encryptKey(tt.raw, []byte(tt.password))
+			if e != nil {
+				t.Fatal(e)
+			}
+			if !isEncryptedKey(b) {
+				t.Fatal("加密后的私钥应被识别为加密私钥")
+			}
+			raw, e := decryptKey(b, []byte(tt.password))
+			if e != nil {
+				t.Fatal(e)
+			}
+			if !bytes.Equal(raw, tt.raw) {
+				t.Fatalf("解密得到 %x, 应为 %x", raw, tt.raw)
+			}
+			if _, e := decryptKey(b, []byte(tt.password+"x")); e == nil {
+				t.Fatal("密码错误时应出错")
+			}
+		})
+	}
+}
+
+func TestDecryptKeyInvalid(t *testing.T) {
+	b, e := encryptKey([]byte("private key bytes"), []byte("password"))
+	if e != nil {
+		t.Fatal(e)
+	}
+	tests := []struct {
+		name   string
+		modify func(k *encryptedKey)
+	}{
+		{"版本", func(k *encryptedKey) { k.Version = 2 }},
+		{"kdf", func(k *encryptedKey) { k.KDF = "pbkdf2" }},
+		{"n过大", func(k *encryptedKey) { k.N = 1 << 30 }},
+		{"n为0", func(k *encryptedKey) { k.N = 0 }},
+		{"r过大", func(k *encryptedKey) { k.R = 1 << 20 }},
+		{"p过大", func(k *encryptedKey) { k.P = 1 << 20 }},
+		{"内存过大", func(k *encryptedKey) { k.N, k.R = 1<<20, 16 }},
+		{"nonce过短", func(k *encryptedKey) { k.Nonce = k.Nonce[:4] }},
+		{"nonce为空", func(k *encryptedKey) { k.Nonce = nil }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var k encryptedKey
+			if e := json.Unmarshal(b, &k); e != nil {
+				t.Fatal(e)
+			}
+			tt.modify(&k)
+			modified, e := json.Marshal(&k)
+			if e != nil {
+				t.Fatal(e)
+			}
+			if _, e := decryptKey(modified, []byte("password")); e == nil {
+				t.Fatal("应该出错")
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package bootstrap
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// keyFormats 是导入导出私钥支持的格式
+var keyFormats = []string{"libp2p", "base64", "pem", "ipfs"}
+
+// ipfsIdentity 是go-ipfs配置文件中的Identity
+type ipfsIdentity struct {
+	PeerID  string
+	PrivKey string
+}
+
+// encodeKey 把私钥编码为指定格式:
+// libp2p 是私钥文件使用的protobuf, base64 是protobuf的base64,
+// pem 是PKCS#8 PEM(不支持secp256k1), ipfs 是go-ipfs配置文件中的Identity.
+func encodeKey(privateKey crypto.PrivKey, format string) ([]byte, error) {
+	raw, e := crypto.MarshalPrivateKey(privateKey)
+	if e != nil {
+		return nil, e
+	}
+	switch format {
+	case "libp2p":
+		return raw, nil
+	case "base64":
+		return []byte(crypto.ConfigEncodeKey(raw) + "\n"), nil
+	case "pem":
+		stdKey, e := crypto.PrivKeyToStdKey(privateKey)
+		if e != nil {
+			return nil, e
+		}
+		if k, ok := stdKey.(*ed25519.PrivateKey); ok {
+			stdKey = *k
+		}
+		der, e := x509.MarshalPKCS8PrivateKey(stdKey)
+		if e != nil {
+			return nil, fmt.Errorf("%s私钥不能导出为PEM: %w", privateKey.Type(), e)
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+	case "ipfs":
+		id, e := peer.IDFromPrivateKey(privateKey)
+		if e != nil {
+			return nil, e
+		}
+		b, e := json.MarshalIndent(struct{ Identity ipfsIdentity }{ipfsIdentity{id.String(), crypto.ConfigEncodeKey(raw)}}, "", "  ")
+		return append(b, '\n'), e
+	}
+	return nil, fmt.Errorf("不支持的格式 %s", format)
+}
+
+// decodeKey 按指定格式解析私钥, format为空时自动识别. ipfs格式可以直接使用go-ipfs的配置文件
+func decodeKey(b []byte, format string) (crypto.PrivKey, error) {
+	if format == "" {
+		format = detectKeyFormat(b)
+	}
+	switch format {
+	case "libp2p":
+		return crypto.UnmarshalPrivateKey(b)
+	case "base64":
+		raw, e := crypto.ConfigDecodeKey(string(bytes.TrimSpace(b)))
+		if e != nil {
+			return nil, e
+		}
+		return crypto.UnmarshalPrivateKey(raw)
+	case "pem":
+		block, _ := pem.Decode(b)
+		if block == nil {
+			return nil, errors.New("没有找到PEM数据")
+		}
+		stdKey, e := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if e != nil {
+			return nil, e
+		}
+		if k, ok := stdKey.(ed25519.PrivateKey); ok {
+			stdKey = &k
+		}
+		privateKey, _, e := crypto.KeyPairFromStdKey(stdKey)
+		return privateKey, e
+	case "ipfs":
+		var cfg struct{ Identity ipfsIdentity }
+		if e := json.Unmarshal(b, &cfg); e != nil {
+			return nil, e
+		}
+		if cfg.Identity.PrivKey == "" {
+			return nil, errors.New("配置文件中没有Identity.PrivKey")
+		}
+		return decodeKey([]byte(cfg.Identity.PrivKey), "base64")
+	}
+	return nil, fmt.Errorf("不支持的格式 %s", format)
+}
+
+// detectKeyFormat 根据内容判断私钥格式
+func detectKeyFormat(b []byte) string {
+	t := bytes.TrimSpace(b)
+	switch {
+	case bytes.HasPrefix(t, []byte("-----BEGIN")):
+		return "pem"
+	case bytes.HasPrefix(t, []byte("{")):
+		return "ipfs"
+	}
+	if _, e := crypto.ConfigDecodeKey(string(t)); e == nil {
+		return "base64"
+	}
+	return "libp2p"
+}
@@ -0,0 +1,103 @@
+package bootstrap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+)
+
+// keystore 提供节点私钥, 由keystore设置选择.
+// 不支持PKCS#11/HSM: QUIC传输需要私钥原文派生stateless reset密钥, 私钥不能只留在HSM中.
+// 需要从KMS或Vault获取私钥时使用command.
+type keystore interface {
+	Load() (crypto.PrivKey, error)
+}
+
+// keystoreCommandTimeout 是command执行的最长时间
+const keystoreCommandTimeout = time.Second * 30
+
+// newKeystore 按设置创建keystore
+func newKeystore(cfg *Config, dir string) (keystore, error) {
+	switch cfg.Keystore {
+	case "", "file":
+		return &fileKeystore{cfg: cfg, dir: dir}, nil
+	case "env":
+		return envKeystore(cfg.KeyEnv), nil
+	case "command":
+		if cfg.KeyCommand == "" {
+			return nil, errors.New("keystore为command时必须设置key_command")
+		}
+		return commandKeystore(cfg.KeyCommand), nil
+	}
+	return nil, fmt.Errorf("不支持的keystore %s, 可用: file, env, command", cfg.Keystore)
+}
+
+// requireFileKeystore 检查是否使用私钥文件, 生成和轮换私钥的子命令只支持私钥文件
+func requireFileKeystore(cfg *Config) error {
+	if cfg.Keystore != "" && cfg.Keystore != "file" {
+		return fmt.Errorf("keystore为%s, 只能修改私钥文件", cfg.Keystore)
+	}
+	return nil
+}
+
+// fileKeystore 从私钥文件读取, 文件不存在时生成
+type fileKeystore struct {
+	cfg *Config
+	dir string
+}
+
+func (k *fileKeystore) Load() (crypto.PrivKey, error) {
+	path := keyPath(k.cfg, k.dir)
+	if k.cfg.KeyPath == "" {
+		if e := os.MkdirAll(k.dir, 0700); e != nil {
+			return nil, fmt.Errorf("创建数据目录出错: %w", e)
+		}
+		exeDir, e := filepath.Abs(filepath.Dir(os.Args[0]))
+		if e != nil {
+			return nil, e
+		}
+		legacyPath := filepath.Join(exeDir, "private.key")
+		migrated, e := migrateLegacyKey(legacyPath, path)
+		if e != nil {
+			return nil, fmt.Errorf("复制旧私钥出错: %w", e)
+		}
+		if migrated {
+			logger.Warnw("已将私钥从旧位置复制到新位置", "from", legacyPath, "to", path)
+		}
+	}
+	return loadOrCreatePrivateKey(path, k.cfg.KeyType, k.cfg.KeyBits, k.cfg.KeyEncrypt)
+}
+
+// envKeystore 从环境变量读取私钥, 格式自动识别(base64, pem, ipfs)
+type envKeystore string
+
+func (k envKeystore) Load() (crypto.PrivKey, error) {
+	v := os.Getenv(string(k))
+	if v == "" {
+		return nil, fmt.Errorf("环境变量%s为空", string(k))
+	}
+	logger.Infow("从环境变量读取私钥", "env", string(k))
+	return decodeKey([]byte(v), "")
+}
+
+// commandKeystore 执行命令, 从标准输出读取私钥, 格式自动识别(base64, pem, ipfs)
+type commandKeystore string
+
+func (k commandKeystore) Load() (crypto.PrivKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), keystoreCommandTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sh", "-c", string(k))
+	cmd.Stderr = os.Stderr
+	out, e := cmd.Output()
+	if e != nil {
+		return nil, fmt.Errorf("执行key_command出错: %w", e)
+	}
+	logger.Infow("从key_command读取私钥")
+	return decodeKey(out, "")
+}
@@ -0,0 +1,145 @@
+package bootstrap
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+// rotatingFile 是按大小和时间轮转的日志文件.
+// 轮转时当前文件改名为<文件名>.<时间>, 只保留最近maxBackups个旧文件.
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	interval   time.Duration
+	maxBackups int
+
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	opened time.Time
+}
+
+func openRotatingFile(path string, maxSize int64, interval time.Duration, maxBackups int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize, interval: interval, maxBackups: maxBackups}
+	if e := os.MkdirAll(filepath.Dir(path), 0700); e != nil {
+		return nil, e
+	}
+	if e := r.open(); e != nil {
+		return nil, e
+	}
+	return r, nil
+}
+
+// open 打开日志文件, 已有的文件继续追加
+func (r *rotatingFile) open() error {
+	f, e := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if e != nil {
+		return e
+	}
+	info, e := f.Stat()
+	if e != nil {
+		_ = f.Close()
+		return e
+	}
+	r.f = f
+	r.size = info.Size()
+	r.opened = time.Now()
+	return nil
+}
+
+func (r *rotatingFile) Write(b []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return 0, os.ErrClosed
+	}
+	if (r.maxSize > 0 && r.size > 0 && r.size+int64(len(b)) > r.maxSize) || (r.interval > 0 && time.Since(r.opened) >= r.interval) {
+		if e := r.rotate(); e != nil {
+			fmt.Fprintln(os.Stderr, "轮转日志文件出错", e)
+		}
+	}
+	n, e := r.f.Write(b)
+	r.size += int64(n)
+	return n, e
+}
+
+// rotate 把当前文件改名后打开新文件, 删除多余的旧文件
+func (r *rotatingFile) rotate() error {
+	_ = r.f.Close()
+	r.f = nil
+	backup := r.path + "." + time.Now().UTC().Format("20060102T150405Z")
+	if e := os.Rename(r.path, backup); e != nil && !os.IsNotExist(e) {
+		return e
+	}
+	if e := r.open(); e != nil {
+		return e
+	}
+	if r.maxBackups <= 0 {
+		return nil
+	}
+	backups, e := filepath.Glob(r.path + ".*")
+	if e != nil {
+		return e
+	}
+	sort.Strings(backups)
+	for len(backups) > r.maxBackups {
+		_ = os.Remove(backups[0])
+		backups = backups[1:]
+	}
+	return nil
+}
+
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return nil
+	}
+	e := r.f.Close()
+	r.f = nil
+	return e
+}
+
+// logFileOutput 把go-log的输出写入轮转的日志文件
+type logFileOutput struct {
+	pipe *logging.PipeReader
+	file *rotatingFile
+}
+
+// currentLogFile 是正在使用的日志文件, 重新加载日志设置时关闭
+var currentLogFile *logFileOutput
+
+// startLogFile 开始把日志写入文件, 替换之前的日志文件
+func startLogFile(c logConfig, format logging.LogFormat) error {
+	stopLogFile()
+	if c.File == "" {
+		return nil
+	}
+	file, e := openRotatingFile(c.File, int64(c.MaxSize)*1024*1024, c.RotateInterval, c.MaxBackups)
+	if e != nil {
+		return fmt.Errorf("打开日志文件出错: %w", e)
+	}
+	out := &logFileOutput{pipe: logging.NewPipeReader(logging.PipeFormat(format)), file: file}
+	go func() {
+		_, _ = io.Copy(file, out.pipe)
+	}()
+	currentLogFile = out
+	return nil
+}
+
+// stopLogFile 停止写入当前的日志文件
+func stopLogFile() {
+	if currentLogFile == nil {
+		return
+	}
+	_ = currentLogFile.pipe.Close()
+	_ = currentLogFile.file.Close()
+	currentLogFile = nil
+}
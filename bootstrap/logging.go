@@ -0,0 +1,104 @@
+package bootstrap
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+// logger 是本程序的日志子系统 bootstrap, 与libp2p的子系统(如dht, swarm2)使用同一个go-log后端
+var logger = logging.Logger("bootstrap")
+
+// defaultLogLevel 在没有设置log_level和GOLOG_LOG_LEVEL时使用: libp2p只输出错误, 本程序和连接日志输出info
+const defaultLogLevel = "error,bootstrap=info,bootstrap/conn=info"
+
+func init() {
+	// 子命令也会用到logger, 没有GOLOG_LOG_LEVEL时不隐藏本程序的info和warn
+	if os.Getenv("GOLOG_LOG_LEVEL") == "" {
+		_ = logging.SetLogLevel("bootstrap", "info")
+	}
+}
+
+// logConfig 是日志设置
+type logConfig struct {
+	// Level 是日志级别, 格式同GOLOG_LOG_LEVEL: <默认级别>,<子系统>=<级别>,...
+	// 为空时使用GOLOG_LOG_LEVEL, 也没有时使用defaultLogLevel.
+	Level string `yaml:"level"`
+	// Format 是日志格式: text或json
+	Format string `yaml:"format"`
+	// File 是日志文件路径, 为空时只输出到stderr. 设置时Stderr为true才同时输出到stderr
+	File   string `yaml:"file"`
+	Stderr bool   `yaml:"stderr"`
+	// MaxSize 是日志文件轮转的大小, 单位MB, 0表示不按大小轮转
+	MaxSize int `yaml:"max_size"`
+	// RotateInterval 是日志文件轮转的间隔, 0表示不按时间轮转
+	RotateInterval time.Duration `yaml:"rotate_interval"`
+	// MaxBackups 是保留的旧日志文件数量, 0表示全部保留
+	MaxBackups int `yaml:"max_backups"`
+}
+
+// logLevels 解析日志级别, 返回默认级别和各子系统的级别
+func (c logConfig) logLevels() (logging.LogLevel, map[string]string, error) {
+	spec := c.Level
+	if spec == "" {
+		spec = os.Getenv("GOLOG_LOG_LEVEL")
+	}
+	if spec == "" {
+		spec = defaultLogLevel
+	}
+	level := logging.LevelError
+	subsystems := make(map[string]string)
+	for _, part := range splitList(spec) {
+		name, value := "", part
+		if i := strings.Index(part, "="); i >= 0 {
+			name, value = part[:i], part[i+1:]
+		}
+		l, e := logging.LevelFromString(value)
+		if e != nil {
+			return 0, nil, fmt.Errorf("日志级别错误 %s: %w", part, e)
+		}
+		if name == "" {
+			level = l
+		} else {
+			subsystems[name] = value
+		}
+	}
+	return level, subsystems, nil
+}
+
+// validate 检查日志设置
+func (c logConfig) validate() error {
+	if c.Format != "text" && c.Format != "json" {
+		return fmt.Errorf("不支持的日志格式 %s, 可用的有: text, json", c.Format)
+	}
+	if c.MaxSize < 0 || c.RotateInterval < 0 || c.MaxBackups < 0 {
+		return fmt.Errorf("日志文件轮转设置错误: max_size %d, rotate_interval %s, max_backups %d", c.MaxSize, c.RotateInterval, c.MaxBackups)
+	}
+	_, _, e := c.logLevels()
+	return e
+}
+
+// apply 设置go-log的格式, 级别和输出
+func (c logConfig) apply() error {
+	level, subsystems, e := c.logLevels()
+	if e != nil {
+		return e
+	}
+	format := logging.PlaintextOutput
+	if c.Format == "json" {
+		format = logging.JSONOutput
+	}
+	logging.SetupLogging(logging.Config{Format: format, Level: level, Stderr: c.File == "" || c.Stderr})
+	if e := startLogFile(c, format); e != nil {
+		return e
+	}
+	for name, value := range subsystems {
+		if e := logging.SetLogLevel(name, value); e != nil {
+			return fmt.Errorf("日志子系统 %s: %w", name, e)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package bootstrap
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/discovery/mdns"
+)
+
+// mdnsNotifee 把局域网中通过mDNS发现的节点加入地址簿并连接
+type mdnsNotifee struct {
+	ctx context.Context
+	h   host.Host
+	ttl time.Duration
+
+	mu    sync.Mutex
+	found map[peer.ID]bool
+}
+
+// startMDNS 在局域网中宣告本节点并发现其他节点, 需要监听TCP
+func startMDNS(ctx context.Context, h host.Host, serviceTag string, ttl time.Duration) (*mdnsNotifee, error) {
+	n := &mdnsNotifee{ctx: ctx, h: h, ttl: ttl, found: make(map[peer.ID]bool)}
+	service := mdns.NewMdnsService(h, serviceTag, n)
+	if e := service.Start(); e != nil {
+		return nil, e
+	}
+	go func() {
+		<-ctx.Done()
+		_ = service.Close()
+	}()
+	return n, nil
+}
+
+// HandlePeerFound 每次轮询都会收到已发现的节点, 只在第一次发现时输出日志
+func (n *mdnsNotifee) HandlePeerFound(addrInfo peer.AddrInfo) {
+	if addrInfo.ID == n.h.ID() {
+		return
+	}
+	n.h.Peerstore().AddAddrs(addrInfo.ID, addrInfo.Addrs, n.ttl)
+	n.mu.Lock()
+	first := !n.found[addrInfo.ID]
+	n.found[addrInfo.ID] = true
+	n.mu.Unlock()
+	if first {
+		logger.Debugw("局域网中发现节点", "peer", addrInfo.ID, "addrs", addrInfo.Addrs)
+	}
+	if len(n.h.Network().ConnsToPeer(addrInfo.ID)) > 0 {
+		return
+	}
+	go func() {
+		if e := connectPeer(n.ctx, n.h, addrInfo, connectTimeout); e != nil && first {
+			logger.Debugw("连接局域网节点出错", "peer", addrInfo.ID, "error", e)
+		}
+	}()
+}
+
+// status 返回发现的节点数量
+func (n *mdnsNotifee) status() interface{} {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return map[string]int{"found": len(n.found)}
+}
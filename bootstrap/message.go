@@ -0,0 +1,95 @@
+package bootstrap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// errMessageTooLarge 表示对方发送的数据超过上限
+var errMessageTooLarge = errors.New("消息超过大小上限")
+
+// messageLimiter 限制自定义协议从对方读取的数据大小, 超过时重置流并计数
+type messageLimiter struct {
+	max int64
+
+	mu        sync.Mutex
+	oversized map[protocol.ID]uint64
+}
+
+func newMessageLimiter(max int64) *messageLimiter {
+	return &messageLimiter{max: max, oversized: make(map[protocol.ID]uint64)}
+}
+
+// Reader 返回限制大小的读取器, 从流中读取的数据总量超过上限时返回errMessageTooLarge
+func (l *messageLimiter) Reader(s network.Stream) io.Reader {
+	return &limitedStreamReader{s: s, limiter: l, remaining: l.max}
+}
+
+// reject 重置超过上限的流
+func (l *messageLimiter) reject(s network.Stream) {
+	l.mu.Lock()
+	l.oversized[s.Protocol()]++
+	l.mu.Unlock()
+	logger.Warnw("消息超过大小上限, 重置流", "protocol", s.Protocol(), "peer", s.Conn().RemotePeer())
+	_ = s.Reset()
+}
+
+// status 返回各协议超过上限的次数
+func (l *messageLimiter) status() interface{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	oversized := make(map[protocol.ID]uint64, len(l.oversized))
+	for p, n := range l.oversized {
+		oversized[p] = n
+	}
+	return map[string]interface{}{
+		"max":       l.max,
+		"oversized": oversized,
+	}
+}
+
+type limitedStreamReader struct {
+	s         network.Stream
+	limiter   *messageLimiter
+	remaining int64
+}
+
+func (r *limitedStreamReader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		r.limiter.reject(r.s)
+		return 0, errMessageTooLarge
+	}
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n, e := r.s.Read(p)
+	r.remaining -= int64(n)
+	return n, e
+}
+
+// readDelimited 读取一个以变长整数长度开头的消息, 长度超过max时返回errMessageTooLarge
+func readDelimited(r *bufio.Reader, max int64) ([]byte, error) {
+	size, e := binary.ReadUvarint(r)
+	if e != nil {
+		return nil, e
+	}
+	if size > uint64(max) {
+		return nil, errMessageTooLarge
+	}
+	b := make([]byte, size)
+	_, e = io.ReadFull(r, b)
+	return b, e
+}
+
+// writeDelimited 写入以变长整数长度开头的消息
+func writeDelimited(w io.Writer, b []byte) error {
+	_, e := w.Write(append(protowire.AppendVarint(nil, uint64(len(b))), b...))
+	return e
+}
@@ -0,0 +1,193 @@
+package bootstrap
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync/atomic"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/metrics"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// metricsHandler 以Prometheus文本格式提供 /metrics, 不依赖Prometheus客户端库.
+// 连接的建立和关闭是计数器, 速率由Prometheus计算(rate).
+type metricsHandler struct {
+	h        host.Host
+	idht     *dht.IpfsDHT
+	bwc      *bandwidthCounter
+	relay    *relayMetrics
+	quota    *relayQuota
+	traffic  *relayTraffic
+	geo      *geoIP
+	throttle *inboundThrottle
+	dials    *dialStats
+	backoff  *dialBackoff
+	scorer   *peerScorer
+	psgc     *peerstoreGC
+	dhtm     *dhtMetrics
+	punches  *holePunchStats
+	rcmgr    *resourceStats
+	gater    *connectionGater
+
+	openedInbound, openedOutbound uint64
+	closedInbound, closedOutbound uint64
+}
+
+func newMetricsHandler(h host.Host, idht *dht.IpfsDHT, bwc *bandwidthCounter, relay *relayMetrics, quota *relayQuota, traffic *relayTraffic, geo *geoIP, throttle *inboundThrottle, dials *dialStats, backoff *dialBackoff, scorer *peerScorer, psgc *peerstoreGC, dhtm *dhtMetrics, punches *holePunchStats, rcmgr *resourceStats, gater *connectionGater) *metricsHandler {
+	m := &metricsHandler{h: h, idht: idht, bwc: bwc, relay: relay, quota: quota, traffic: traffic, geo: geo, throttle: throttle, dials: dials, backoff: backoff, scorer: scorer, psgc: psgc, dhtm: dhtm, punches: punches, rcmgr: rcmgr, gater: gater}
+	h.Network().Notify(m)
+	return m
+}
+
+func (m *metricsHandler) Connected(_ network.Network, c network.Conn) {
+	if c.Stat().Direction == network.DirInbound {
+		atomic.AddUint64(&m.openedInbound, 1)
+	} else {
+		atomic.AddUint64(&m.openedOutbound, 1)
+	}
+}
+
+func (m *metricsHandler) Disconnected(_ network.Network, c network.Conn) {
+	if c.Stat().Direction == network.DirInbound {
+		atomic.AddUint64(&m.closedInbound, 1)
+	} else {
+		atomic.AddUint64(&m.closedOutbound, 1)
+	}
+}
+
+func (m *metricsHandler) Listen(network.Network, ma.Multiaddr)         {}
+func (m *metricsHandler) ListenClose(network.Network, ma.Multiaddr)    {}
+func (m *metricsHandler) OpenedStream(network.Network, network.Stream) {}
+func (m *metricsHandler) ClosedStream(network.Network, network.Stream) {}
+
+func (m *metricsHandler) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	n := m.h.Network()
+
+	writeMetric(w, "bootstrap_connected_peers", "gauge", "当前连接的节点数量", uint64(len(n.Peers())))
+	writeMetric(w, "bootstrap_peerstore_peers", "gauge", "地址簿中的节点数量", uint64(len(m.h.Peerstore().Peers())))
+	writeMetric(w, "bootstrap_routing_table_peers", "gauge", "DHT路由表中的节点数量", uint64(m.idht.RoutingTable().Size()))
+	m.psgc.writeMetrics(w)
+	m.dhtm.writeMetrics(w)
+
+	writeLabeledMetric(w, "bootstrap_connections_opened_total", "counter", "建立的连接数量", "direction", map[string]uint64{
+		"inbound":  atomic.LoadUint64(&m.openedInbound),
+		"outbound": atomic.LoadUint64(&m.openedOutbound),
+	})
+	writeLabeledMetric(w, "bootstrap_connections_closed_total", "counter", "关闭的连接数量", "direction", map[string]uint64{
+		"inbound":  atomic.LoadUint64(&m.closedInbound),
+		"outbound": atomic.LoadUint64(&m.closedOutbound),
+	})
+
+	transports := make(map[string]uint64)
+	for _, c := range n.Conns() {
+		transports[addrTransport(c.RemoteMultiaddr())]++
+	}
+	writeLabeledMetric(w, "bootstrap_connections", "gauge", "按传输统计的当前连接数量", "transport", transports)
+	writeLabeledMetric(w, "bootstrap_inbound_rejected_total", "counter", "按原因统计的被入站连接限制拒绝的连接数量", "reason", m.throttle.rejected())
+	shedding, shed := m.gater.shedCounts()
+	var sheddingValue uint64
+	if shedding {
+		sheddingValue = 1
+	}
+	writeMetric(w, "bootstrap_goroutine_shed_total", "counter", "协程数量超过上限时拒绝的入站连接数量", shed)
+	writeMetric(w, "bootstrap_goroutine_shedding", "gauge", "是否因协程数量超过上限拒绝入站连接, 1表示正在拒绝", sheddingValue)
+	successes, failures := m.dials.counts()
+	writeLabeledMetric(w, "bootstrap_seed_dial_successes_total", "counter", "按节点统计的连接引导节点成功次数", "peer", successes)
+	writeLabeledMetric(w, "bootstrap_seed_dial_failures_total", "counter", "按节点统计的连接引导节点失败次数", "peer", failures)
+	if m.backoff != nil {
+		backingOff, skipped, failed := m.backoff.counts()
+		writeMetric(w, "bootstrap_dial_backoff_addrs", "gauge", "拨号失败后退避中的地址数量", uint64(backingOff))
+		writeMetric(w, "bootstrap_dial_backoff_skipped_total", "counter", "因退避跳过的地址拨号次数", skipped)
+		writeMetric(w, "bootstrap_dial_backoff_failures_total", "counter", "计入退避的地址拨号失败次数", failed)
+	}
+	if m.scorer != nil {
+		m.scorer.writeMetrics(w)
+	}
+	if m.rcmgr != nil {
+		m.rcmgr.writeMetrics(w, m.h)
+	}
+	if m.punches != nil {
+		writeLabeledMetric(w, "bootstrap_hole_punches_total", "counter", "按结果统计的打洞(DCUtR)次数", "result", m.punches.results())
+		writeLabeledMetric(w, "bootstrap_hole_punch_direct_dials_total", "counter", "按结果统计的打洞前直接拨号次数", "result", m.punches.directDials())
+	}
+	if m.relay != nil {
+		writeMetric(w, "bootstrap_relay_streams", "gauge", "中继协议的流数量, 每个电路两个", uint64(relayStreams(n)))
+		reservations, circuits := m.relay.active()
+		writeMetric(w, "bootstrap_relay_reservations", "gauge", "当前的中继预约数量", uint64(reservations))
+		writeMetric(w, "bootstrap_relay_circuits", "gauge", "当前中继的电路数量", uint64(circuits))
+		reserves, connects := m.relay.requests()
+		writeLabeledMetric(w, "bootstrap_relay_reservation_requests_total", "counter", "按结果统计的中继预约请求", "status", reserves)
+		writeLabeledMetric(w, "bootstrap_relay_connect_requests_total", "counter", "按结果统计的中继连接请求", "status", connects)
+		if m.traffic != nil {
+			totals := m.traffic.totals()
+			writeMetric(w, "bootstrap_relay_circuits_total", "counter", "开始中继的电路数量", totals["circuits"])
+			writeLabeledMetric(w, "bootstrap_relay_bytes_total", "counter", "中继的流量, in是来源发出的, out是发给来源的", "direction", map[string]uint64{"in": totals["bytes_in"], "out": totals["bytes_out"]})
+			writeLabeledMetric(w, "bootstrap_relay_capped_total", "counter", "超过每日上限被拒绝(rejected)或关闭(reset)的中继电路数量", "action", map[string]uint64{"rejected": totals["rejected"], "reset": totals["reset"]})
+		}
+		if m.quota != nil {
+			writeLabeledMetric(w, "bootstrap_relay_rejected_total", "counter", "按原因统计的超过来源IP或ASN限制被拒绝的中继预约数量", "reason", m.quota.rejected())
+		}
+	}
+
+	if m.geo != nil {
+		countries, asns := m.geo.distribution(n)
+		writeLabeledMetric(w, "bootstrap_peers_by_country", "gauge", "按国家统计的已连接节点数量", "country", countries)
+		writeLabeledMetric(w, "bootstrap_peers_by_asn", "gauge", "按ASN统计的已连接节点数量", "asn", asns)
+	}
+
+	totals := m.bwc.GetBandwidthTotals()
+	writeLabeledMetric(w, "bootstrap_bandwidth_bytes_total", "counter", "总流量", "direction", map[string]uint64{
+		"in":  uint64(totals.TotalIn),
+		"out": uint64(totals.TotalOut),
+	})
+
+	// 按连接方向的流量, 与 /status 的 bandwidth 相同
+	inbound, outbound := m.bwc.GetBandwidthByDirection()
+	fmt.Fprintf(w, "# HELP bootstrap_conn_bandwidth_bytes_total 按连接方向统计的流量\n# TYPE bootstrap_conn_bandwidth_bytes_total counter\n")
+	for _, c := range []struct {
+		dir   string
+		stats metrics.Stats
+	}{{"inbound", inbound}, {"outbound", outbound}} {
+		fmt.Fprintf(w, "bootstrap_conn_bandwidth_bytes_total{conn_direction=%q,direction=\"in\"} %d\n", c.dir, c.stats.TotalIn)
+		fmt.Fprintf(w, "bootstrap_conn_bandwidth_bytes_total{conn_direction=%q,direction=\"out\"} %d\n", c.dir, c.stats.TotalOut)
+	}
+
+	// 按协议的流量, 节点数量太多不按节点输出, 见 /bandwidth
+	fmt.Fprintf(w, "# HELP bootstrap_protocol_bandwidth_bytes_total 按协议统计的流量\n# TYPE bootstrap_protocol_bandwidth_bytes_total counter\n")
+	byProtocol := m.bwc.GetBandwidthByProtocol()
+	protocols := make([]string, 0, len(byProtocol))
+	for proto := range byProtocol {
+		protocols = append(protocols, string(proto))
+	}
+	sort.Strings(protocols)
+	for _, proto := range protocols {
+		stats := byProtocol[protocol.ID(proto)]
+		fmt.Fprintf(w, "bootstrap_protocol_bandwidth_bytes_total{protocol=%q,direction=\"in\"} %d\n", proto, stats.TotalIn)
+		fmt.Fprintf(w, "bootstrap_protocol_bandwidth_bytes_total{protocol=%q,direction=\"out\"} %d\n", proto, stats.TotalOut)
+	}
+}
+
+// writeMetric 输出一个没有标签的指标
+func writeMetric(w io.Writer, name, kind, help string, value uint64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", name, help, name, kind, name, value)
+}
+
+// writeLabeledMetric 输出带一个标签的指标, 按标签值排序
+func writeLabeledMetric(w io.Writer, name, kind, help, label string, values map[string]uint64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s{%s=%q} %d\n", name, label, k, values[k])
+	}
+}
@@ -0,0 +1,81 @@
+package bootstrap
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+
+	"github.com/libp2p/go-libp2p"
+	mplex "github.com/libp2p/go-libp2p-mplex"
+	yamux "github.com/libp2p/go-libp2p/p2p/muxer/yamux"
+	yamuxcfg "github.com/libp2p/go-yamux/v4"
+)
+
+// 流多路复用器的协议ID
+const (
+	yamuxID = "/yamux/1.0.0"
+	mplexID = "/mplex/6.7.0"
+)
+
+// yamuxMinWindow 是yamux流的初始窗口, 最大窗口不能小于它
+const yamuxMinWindow = 256 * 1024
+
+// muxerConfig 设置提供的流多路复用器. QUIC自带多路复用, 不受影响.
+type muxerConfig struct {
+	// Offer 是提供的多路复用器, 按顺序协商
+	Offer []string `yaml:"offer"`
+	// YamuxWindow 是yamux每个流的最大接收窗口(字节), 决定单个流最多缓冲多少数据
+	YamuxWindow int `yaml:"yamux_window"`
+	// YamuxAcceptBacklog 是每个连接上等待处理的入站流数量上限.
+	// 当前版本的yamux没有限制流总数的设置, 超过上限的入站流会被拒绝.
+	YamuxAcceptBacklog int `yaml:"yamux_accept_backlog"`
+}
+
+// validate 检查多路复用器设置
+func (c muxerConfig) validate() error {
+	if len(c.Offer) == 0 {
+		return fmt.Errorf("没有启用任何流多路复用器")
+	}
+	seen := make(map[string]bool)
+	for _, name := range c.Offer {
+		if name != "yamux" && name != "mplex" {
+			return fmt.Errorf("不支持的流多路复用器 %s, 可用的有: yamux, mplex", name)
+		}
+		if seen[name] {
+			return fmt.Errorf("流多路复用器重复 %s", name)
+		}
+		seen[name] = true
+	}
+	if c.YamuxWindow < yamuxMinWindow || c.YamuxWindow > math.MaxUint32 {
+		return fmt.Errorf("yamux窗口必须在%d到%d之间: %d", yamuxMinWindow, uint32(math.MaxUint32), c.YamuxWindow)
+	}
+	if c.YamuxAcceptBacklog <= 0 {
+		return fmt.Errorf("yamux入站流上限必须大于0: %d", c.YamuxAcceptBacklog)
+	}
+	return nil
+}
+
+// options 返回多路复用器选项, 没有设置时使用libp2p的默认值
+func (c muxerConfig) options() []libp2p.Option {
+	var options []libp2p.Option
+	for _, name := range c.Offer {
+		switch name {
+		case "yamux":
+			options = append(options, libp2p.Muxer(yamuxID, c.yamuxTransport()))
+		case "mplex":
+			options = append(options, libp2p.Muxer(mplexID, mplex.DefaultTransport))
+		}
+	}
+	return options
+}
+
+// yamuxTransport 在libp2p默认设置的基础上修改窗口和入站流上限
+func (c muxerConfig) yamuxTransport() *yamux.Transport {
+	config := yamuxcfg.DefaultConfig()
+	config.MaxStreamWindowSize = uint32(c.YamuxWindow)
+	config.AcceptBacklog = c.YamuxAcceptBacklog
+	config.LogOutput = ioutil.Discard
+	// 总是运行在有缓冲的安全协议之上
+	config.ReadBufSize = 0
+	return (*yamux.Transport)(config)
+}
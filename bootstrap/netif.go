@@ -0,0 +1,72 @@
+package bootstrap
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+// waitInterfaceAddr 等待网络接口可用并返回其IPv4地址.
+// name可以是接口名称(如wg0), 也可以是某个接口上的IP地址.
+// Wireguard等覆盖网络的接口可能在本程序启动后才出现.
+func waitInterfaceAddr(ctx context.Context, name string, timeout time.Duration) (net.IP, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	logged := false
+	for {
+		ip, e := interfaceAddr(name)
+		if e == nil {
+			if logged {
+				logger.Infow("网络接口已可用", "interface", name, "ip", ip)
+			}
+			return ip, nil
+		}
+		if !logged {
+			logger.Infow("等待网络接口", "interface", name, "error", e)
+			logged = true
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("等待网络接口%s超时: %w", name, e)
+		case <-ticker.C:
+		}
+	}
+}
+
+// interfaceAddr 返回接口当前的IPv4地址
+func interfaceAddr(name string) (net.IP, error) {
+	if ip := net.ParseIP(name); ip != nil {
+		addrs, e := net.InterfaceAddrs()
+		if e != nil {
+			return nil, e
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+				return ip, nil
+			}
+		}
+		return nil, fmt.Errorf("没有接口使用地址%s", ip)
+	}
+
+	iface, e := net.InterfaceByName(name)
+	if e != nil {
+		return nil, e
+	}
+	if iface.Flags&net.FlagUp == 0 {
+		return nil, fmt.Errorf("接口%s未启用", name)
+	}
+	addrs, e := iface.Addrs()
+	if e != nil {
+		return nil, e
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+			return ipNet.IP.To4(), nil
+		}
+	}
+	return nil, fmt.Errorf("接口%s没有IPv4地址", name)
+}
@@ -0,0 +1,108 @@
+package bootstrap
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// NetSimConfig 是网络模拟的设置, 用于在测试中验证节点在恶劣网络下的重连和重新引导.
+// 模拟通过连接过滤器实现, 只影响连接的建立和断开, 不模拟丢包和每个包的延迟. 为0的项不启用
+type NetSimConfig struct {
+	// RejectSetup 是拒绝建立连接(拨号和入站)的概率, 0到1
+	RejectSetup float64
+	// HandshakeDelay 是每个连接在加密握手完成后等待的时间, 拖慢连接的建立
+	HandshakeDelay time.Duration
+	// Churn 是断开连接的间隔, 每次断开一个随机的连接
+	Churn time.Duration
+	// Seed 是随机数种子, 相同种子得到相同的模拟序列
+	Seed int64
+}
+
+// validate 检查网络模拟设置
+func (c NetSimConfig) validate() error {
+	if c.RejectSetup < 0 || c.RejectSetup > 1 {
+		return fmt.Errorf("网络模拟拒绝连接的概率应在0到1之间: %v", c.RejectSetup)
+	}
+	if c.HandshakeDelay < 0 || c.Churn < 0 {
+		return fmt.Errorf("网络模拟的时间不能小于0: handshake_delay %s, churn %s", c.HandshakeDelay, c.Churn)
+	}
+	return nil
+}
+
+// netSimulator 通过连接过滤器拒绝建立连接, 拖慢握手和断开连接
+type netSimulator struct {
+	cfg NetSimConfig
+
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+func (s *netSimulator) reject() bool {
+	if s.cfg.RejectSetup <= 0 {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rnd.Float64() < s.cfg.RejectSetup
+}
+
+func (s *netSimulator) allowDial(ma.Multiaddr) bool {
+	return !s.reject()
+}
+
+func (s *netSimulator) allowAccept(network.ConnMultiaddrs) bool {
+	return !s.reject()
+}
+
+func (s *netSimulator) secured(network.Direction, peer.ID) bool {
+	if s.cfg.HandshakeDelay > 0 {
+		time.Sleep(s.cfg.HandshakeDelay)
+	}
+	return true
+}
+
+// run 定期断开随机连接
+func (s *netSimulator) run(ctx context.Context, h host.Host) {
+	if s.cfg.Churn <= 0 {
+		return
+	}
+	ticker := time.NewTicker(s.cfg.Churn)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			conns := h.Network().Conns()
+			if len(conns) == 0 {
+				continue
+			}
+			s.mu.Lock()
+			c := conns[s.rnd.Intn(len(conns))]
+			s.mu.Unlock()
+			logger.Infow("网络模拟: 断开连接", "peer", c.RemotePeer())
+			_ = c.Close()
+		}
+	}
+}
+
+// setupNetSim 在cfg不为nil时启用网络模拟, 返回在主机创建后调用的启动函数
+func setupNetSim(g *connectionGater, cfg *NetSimConfig) func(context.Context, host.Host) {
+	if cfg == nil || (cfg.RejectSetup <= 0 && cfg.HandshakeDelay <= 0 && cfg.Churn <= 0) {
+		return func(context.Context, host.Host) {}
+	}
+	logger.Warnw("启用网络模拟", "reject_setup", cfg.RejectSetup, "handshake_delay", cfg.HandshakeDelay, "churn", cfg.Churn, "seed", cfg.Seed)
+	s := &netSimulator{cfg: *cfg, rnd: rand.New(rand.NewSource(cfg.Seed))}
+	g.hook = s
+	return func(ctx context.Context, h host.Host) {
+		go s.run(ctx, h)
+	}
+}
@@ -0,0 +1,156 @@
+package bootstrap
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// networkDirName 是数据目录中保存各个网络数据的目录
+const networkDirName = "networks"
+
+// networkConfigs 返回多网络模式下每个网络的名称和设置. 每个网络的设置是在基础设置(配置文件,
+// 环境变量和命令行参数)上应用networks中的一项, name是网络名称, 其他项与配置文件相同.
+// 数据目录默认为<数据目录>/networks/<名称>, 私钥等状态互相独立.
+// 第一个网络使用基础设置的HTTP服务和管理接口, 其他网络默认不启用, 在第一个网络的接口中按名称访问
+func (c *Config) networkConfigs() ([]string, []*Config, error) {
+	dir, e := c.dataDir()
+	if e != nil {
+		return nil, nil, e
+	}
+	template := *c
+	template.Networks = nil
+	base, e := yaml.Marshal(&template)
+	if e != nil {
+		return nil, nil, e
+	}
+	names := make([]string, 0, len(c.Networks))
+	cfgs := make([]*Config, 0, len(c.Networks))
+	seen := make(map[string]bool)
+	ports := make(map[string]string)
+	for i, network := range c.Networks {
+		name, _ := network["name"].(string)
+		if name == "" || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+			return nil, nil, fmt.Errorf("第%d个网络的名称错误: %q", i+1, name)
+		}
+		if seen[name] {
+			return nil, nil, fmt.Errorf("网络名称重复: %s", name)
+		}
+		seen[name] = true
+		override := make(map[string]interface{}, len(network))
+		for k, v := range network {
+			switch k {
+			case "name":
+			case "daemon", "pid_file", "log", "networks":
+				return nil, nil, fmt.Errorf("网络 %s 不能设置%s, 这是整个进程的设置", name, k)
+			default:
+				override[k] = v
+			}
+		}
+		b, e := yaml.Marshal(override)
+		if e != nil {
+			return nil, nil, e
+		}
+		cfg := &Config{}
+		if e := yaml.UnmarshalStrict(base, cfg); e != nil {
+			return nil, nil, e
+		}
+		cfg.DataDir = filepath.Join(dir, networkDirName, name)
+		if i > 0 {
+			cfg.HTTPAddr, cfg.AdminAddr, cfg.GRPCAddr, cfg.PprofAddr = "", "", "", ""
+		}
+		if e := yaml.UnmarshalStrict(b, cfg); e != nil {
+			return nil, nil, fmt.Errorf("网络 %s 的设置错误: %w", name, e)
+		}
+		// 没有指定监听地址时按端口检查冲突, 端口为0时由系统分配
+		if len(cfg.Listen) == 0 {
+			var used []string
+			if cfg.Transports.TCP && cfg.tcpPort() > 0 {
+				used = append(used, fmt.Sprint("tcp/", cfg.tcpPort()))
+			}
+			if cfg.Transports.QUIC && cfg.quicPort() > 0 {
+				used = append(used, fmt.Sprint("udp/", cfg.quicPort()))
+			}
+			for _, port := range used {
+				if other, ok := ports[port]; ok {
+					return nil, nil, fmt.Errorf("网络 %s 和 %s 使用同一个端口 %s", name, other, port)
+				}
+				ports[port] = name
+			}
+		}
+		names = append(names, name)
+		cfgs = append(cfgs, cfg)
+	}
+	return names, cfgs, nil
+}
+
+// networkConfig 返回名称为name的网络的设置
+func (c *Config) networkConfig(name string) (*Config, error) {
+	names, cfgs, e := c.networkConfigs()
+	if e != nil {
+		return nil, e
+	}
+	for i, n := range names {
+		if n == name {
+			return cfgs[i], nil
+		}
+	}
+	return nil, fmt.Errorf("配置中没有网络 %s", name)
+}
+
+// newNodes 创建节点: 设置了networks时每个网络一个节点, 否则只有一个节点, 名称为空
+func newNodes(cfg *Config) ([]string, []*Node, error) {
+	if len(cfg.Networks) == 0 {
+		n, e := New(cfg)
+		if e != nil {
+			return nil, nil, e
+		}
+		return []string{""}, []*Node{n}, nil
+	}
+	names, cfgs, e := cfg.networkConfigs()
+	if e != nil {
+		return nil, nil, errConfig(e)
+	}
+	nodes := make([]*Node, 0, len(cfgs))
+	for i, c := range cfgs {
+		n, e := New(c)
+		if e != nil {
+			return nil, nil, fmt.Errorf("网络 %s: %w", names[i], e)
+		}
+		nodes = append(nodes, n)
+	}
+	logger.Infow("多网络模式", "networks", names)
+	return names, nodes, nil
+}
+
+// mountNetworks 在第一个网络的接口中按名称访问每个网络: HTTP服务和管理接口在 /networks/<名称>/ 下,
+// gRPC控制接口按请求元数据中的network选择网络. /networks 返回网络列表
+func mountNetworks(names []string, nodes []*Node) {
+	primary := nodes[0]
+	list := make([]map[string]string, 0, len(nodes))
+	controls := make(map[string]*controlServer, len(nodes))
+	for i, n := range nodes {
+		prefix := "/" + networkDirName + "/" + names[i]
+		primary.mux.Handle(prefix+"/", http.StripPrefix(prefix, n.mux))
+		if admin := primary.control.admin.routes; admin != nil {
+			admin.Handle(prefix+"/", http.StripPrefix(prefix, n.control.admin.handler()))
+		}
+		controls[names[i]] = n.control
+		list = append(list, map[string]string{"name": names[i], "id": n.Host().ID().String()})
+	}
+	listNetworks := func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if e := json.NewEncoder(w).Encode(list); e != nil {
+			logger.Warnw("输出网络列表出错", "error", e)
+		}
+	}
+	primary.mux.HandleFunc("/"+networkDirName, listNetworks)
+	if admin := primary.control.admin.routes; admin != nil {
+		admin.HandleFunc("/"+networkDirName, listNetworks)
+	}
+	primary.control.setNetworks(controls)
+}
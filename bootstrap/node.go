@@ -0,0 +1,1274 @@
+package bootstrap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	"github.com/libp2p/go-libp2p"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	record "github.com/libp2p/go-libp2p-record"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/pnet"
+	routing "github.com/libp2p/go-libp2p/core/routing"
+	"github.com/libp2p/go-libp2p/p2p/net/swarm"
+	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
+	"github.com/libp2p/go-libp2p/p2p/protocol/holepunch"
+	"golang.org/x/net/proxy"
+)
+
+// isolatedAfter 是没有任何连接多久后视为孤立
+const isolatedAfter = time.Minute
+
+// Node 是引导节点. New创建节点, Start启动, Stop按顺序关闭.
+// 可以嵌入其他程序, 命令行的run子命令也使用它.
+type Node struct {
+	cfg *Config
+	dir string
+	// identity 是WithIdentity指定的私钥, 为nil时读取或生成私钥文件
+	identity crypto.PrivKey
+	// configPath和args是重新加载配置时读取的配置文件和命令行参数, 为空时不能重新加载
+	configPath string
+	args       []string
+	// network 是多网络模式下的网络名称, 重新加载时从配置文件的networks中读取这个网络的设置
+	network string
+	// plugins 是WithStreamHandler和WithService加入的协议处理器和服务
+	plugins []plugin
+	// validators 是WithRecordValidator加入的DHT记录命名空间
+	validators map[string]record.Validator
+	// rnd 是节点自己的随机数, 用于错开启动, 不修改全局的随机数
+	rnd *rand.Rand
+	// netSim 是WithNetSim设置的网络模拟, 为nil时不模拟
+	netSim *NetSimConfig
+
+	mu      sync.Mutex
+	started bool
+	stopped bool
+
+	h        host.Host
+	idht     *dht.IpfsDHT
+	ready    *readiness
+	reloader *reloader
+	report   *reachabilityReport
+	mux      *http.ServeMux
+	control  *controlServer
+	closing  shutdownSequence
+	// drain 不为nil时在关闭前通知已连接的节点并继续服务一段时间
+	drain func(force <-chan os.Signal)
+}
+
+// New 创建节点, 不启动. cfg为nil时使用DefaultConfig, opts在cfg之后应用并会修改cfg. 启动后不要再修改cfg
+func New(cfg *Config, opts ...Option) (*Node, error) {
+	if cfg == nil {
+		cfg = DefaultConfig()
+	}
+	n := &Node{cfg: cfg, rnd: rand.New(rand.NewSource(time.Now().UnixNano()))}
+	for _, opt := range opts {
+		if e := opt(n); e != nil {
+			return nil, errConfig(e)
+		}
+	}
+	if e := checkEphemeral(cfg); e != nil {
+		return nil, errConfig(e)
+	}
+	cfg.applyEphemeral()
+	dir, e := cfg.dataDir()
+	if e != nil {
+		return nil, errConfig(e)
+	}
+	n.dir = dir
+	return n, nil
+}
+
+// Host 返回libp2p节点, Start之前为nil
+func (n *Node) Host() host.Host {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.h
+}
+
+// DHT 返回DHT, Start之前为nil
+func (n *Node) DHT() *dht.IpfsDHT {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.idht
+}
+
+// AddrInfo 返回节点ID和当前的监听地址, 使用随机端口(如/tcp/0)时是实际的端口. Start之前为空
+func (n *Node) AddrInfo() peer.AddrInfo {
+	h := n.Host()
+	if h == nil {
+		return peer.AddrInfo{}
+	}
+	return peer.AddrInfo{ID: h.ID(), Addrs: h.Addrs()}
+}
+
+// Start 启动节点并连接引导节点, 连接不到引导节点时在后台重试. 设置了StartDelay时先等待再启动.
+// ctx取消时后台任务和libp2p节点停止, 仍需调用Stop释放资源. 启动失败时已打开的资源被释放.
+func (n *Node) Start(ctx context.Context) (e error) {
+	n.mu.Lock()
+	if n.started {
+		n.mu.Unlock()
+		return errors.New("节点已启动")
+	}
+	n.started = true
+	n.mu.Unlock()
+	cfg, dir := n.cfg, n.dir
+
+	// 启动失败时按相反的顺序释放已打开的资源
+	var cleanup []func()
+	defer func() {
+		if e == nil {
+			return
+		}
+		for i := len(cleanup) - 1; i >= 0; i-- {
+			cleanup[i]()
+		}
+	}()
+
+	if cfg.StartupRetry < 0 {
+		return errConfig("startup-retry不能小于0", cfg.StartupRetry)
+	}
+
+	// 私有网络
+	var psk pnet.PSK
+	if cfg.SwarmKey != "" {
+		if psk, e = loadSwarmKey(cfg.SwarmKey); e != nil {
+			return errConfig(fmt.Errorf("读取私有网络密钥出错: %w", e))
+		}
+	}
+
+	// 错开启动, 在监听和连接引导节点之前等待
+	delay := cfg.StartDelay
+	if cfg.StartDelayRandom && delay > 0 {
+		delay = time.Duration(n.rnd.Int63n(int64(delay)))
+	}
+	if delay > 0 {
+		logger.Infow("等待后启动", "delay", delay)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+
+	logger.Infow("启动引导节点", "tcp", cfg.tcpPort(), "quic", cfg.quicPort())
+	if cfg.Profile != "" {
+		logger.Infow("使用预设", "profile", cfg.Profile)
+	}
+	logger.Infow("数据目录", "dir", dir)
+
+	// 上下文控制libp2p节点的生命周期, 取消它可以停止节点.
+	ctx, ctxCancel := context.WithCancel(ctx)
+	cleanup = append(cleanup, ctxCancel)
+
+	// 生成或读取私密, 指定了身份时直接使用, 临时模式只在内存中生成
+	privateKey := n.identity
+	var rotation *keyRotation
+	if privateKey == nil && cfg.Ephemeral && !cfg.IdentityFromHostname {
+		if privateKey, e = generatePrivateKey(cfg.KeyType, cfg.KeyBits); e != nil {
+			return errConfig(e)
+		}
+		logger.Infow("临时模式, 使用内存中生成的私钥")
+	} else if privateKey == nil {
+		e = retryStartup("private_key", cfg.StartupRetry, func() (e error) {
+			privateKey, e = nodePrivateKey(cfg, dir)
+			return e
+		})
+		if e != nil {
+			return e
+		}
+		// 身份轮换的宽限期内继续使用旧身份
+		if rotation, e = readRotation(dir); e != nil {
+			return fmt.Errorf("读取身份轮换记录出错: %w", e)
+		}
+	}
+	if rotation != nil && time.Now().Before(rotation.Until) {
+		logger.Infow("身份轮换宽限期内, 继续使用旧身份", "until", rotation.Until.Format(time.RFC3339), "new_id", rotation.NewID)
+		privateKey, e = loadPrivateKey(rotation.OldKeyPath)
+		if e != nil {
+			return fmt.Errorf("读取旧私钥出错: %w", e)
+		}
+	} else if rotation != nil {
+		logger.Infow("身份轮换宽限期已结束, 使用新身份")
+		if e := removeRotation(dir); e != nil {
+			logger.Warnw("删除身份轮换记录出错", "error", e)
+		}
+		rotation = nil
+	}
+
+	// 地址簿有效期
+	if e := cfg.PeerstoreGC.validate(); e != nil {
+		return errConfig(e)
+	}
+
+	// 监听地址
+	var interfaceIP net.IP
+	if cfg.Interface != "" {
+		interfaceIP, e = waitInterfaceAddr(ctx, cfg.Interface, cfg.InterfaceTimeout)
+		if e != nil {
+			return e
+		}
+	}
+
+	// 探测出站UDP
+	enableQUIC := cfg.Transports.QUIC
+	if len(psk) > 0 && enableQUIC {
+		logger.Warnw("私有网络不支持QUIC, 禁用QUIC")
+		enableQUIC = false
+	}
+	var udpResult *udpProbeResult
+	if cfg.UDPProbe != "" {
+		result := probeUDP(ctx, cfg.UDPProbe, time.Second*5)
+		udpResult = &result
+		if result.Usable {
+			logger.Infow("出站UDP可用", "mapped", result.Mapped)
+		} else {
+			logger.Warnw("出站UDP不可用, QUIC连接可能无法建立", "error", result.Error)
+			if cfg.UDPProbeDisableQUIC && enableQUIC {
+				logger.Warnw("禁用QUIC")
+				enableQUIC = false
+			}
+		}
+	}
+	if cfg.Transports.WebTransport && !enableQUIC {
+		logger.Warnw("WebTransport需要QUIC, 不启用WebTransport")
+	}
+	enableWebRTC := cfg.enableWebRTC(psk)
+	if len(psk) > 0 && cfg.Transports.WebRTC {
+		logger.Warnw("私有网络不支持WebRTC, 禁用WebRTC")
+	}
+	if e := cfg.validateWebRTC(enableQUIC); e != nil {
+		return errConfig(e)
+	}
+	if !cfg.Transports.TCP && !enableQUIC && cfg.WSPort == 0 {
+		return errConfig("没有启用任何传输")
+	}
+	if !cfg.Security.TLS && !cfg.Security.Noise {
+		return errConfig("没有启用任何安全协议")
+	}
+	if e := cfg.Muxers.validate(); e != nil {
+		return errConfig(e)
+	}
+	if e := cfg.DHT.validate(); e != nil {
+		return errConfig(e)
+	}
+	if e := cfg.Rendezvous.validate(); e != nil {
+		return errConfig(e)
+	}
+	if e := cfg.Crawl.validate(); e != nil {
+		return errConfig(e)
+	}
+	if e := cfg.Snapshot.validate(); e != nil {
+		return errConfig(e)
+	}
+	if e := cfg.Datastore.validate(cfg.DHT.Datastore, cfg.Peerstore); e != nil {
+		return errConfig(e)
+	}
+	logger.Infow("DHT模式", "mode", cfg.DHT.Mode, "k", cfg.DHT.BucketSize, "alpha", cfg.DHT.Concurrency, "beta", cfg.DHT.Resiliency, "refresh_interval", cfg.DHT.RefreshInterval)
+	if cfg.DHT.ProtocolPrefix != "" {
+		logger.Infow("DHT协议前缀", "prefix", cfg.DHT.ProtocolPrefix)
+	}
+	if len(n.validators) > 0 && cfg.DHT.ProtocolPrefix == "" {
+		return errConfig("公共IPFS DHT不能加入记录命名空间, 需要设置DHT协议前缀")
+	}
+	validators, e := recordValidators(cfg.DHT.Namespaces, n.validators)
+	if e != nil {
+		return errConfig(e)
+	}
+	if e := cfg.SelfRecord.validate(); e != nil {
+		return errConfig(e)
+	}
+	putPeerRecord := peerRecordValidators(cfg, validators)
+	if len(validators) > 0 {
+		logger.Infow("DHT记录命名空间", "namespaces", recordNamespaceNames(validators))
+	}
+	var dhtStore ds.Batching
+	e = retryStartup("datastore", cfg.StartupRetry, func() (e error) {
+		dhtStore, e = cfg.DHT.openDatastore(dir, cfg.Datastore)
+		return e
+	})
+	if e != nil {
+		return fmt.Errorf("打开DHT存储出错: %w", e)
+	}
+	if cfg.DHT.Datastore != "memory" {
+		logger.Infow("DHT存储", "kind", cfg.DHT.Datastore, "path", datastorePath(dir, dhtDatastoreDir, cfg.DHT.Datastore))
+	}
+	cleanup = append(cleanup, func() { _ = dhtStore.Close() })
+	if e := checkPeerstore(cfg.Peerstore); e != nil {
+		return errConfig(e)
+	}
+	var ps peerstore.Peerstore
+	var closePeerstore func()
+	e = retryStartup("peerstore", cfg.StartupRetry, func() (e error) {
+		ps, closePeerstore, e = openPeerstore(ctx, cfg.Peerstore, dir, cfg.PeerstoreGC.Interval, cfg.Datastore)
+		return e
+	})
+	if e != nil {
+		return fmt.Errorf("打开地址簿出错: %w", e)
+	}
+	if cfg.Peerstore != "memory" {
+		logger.Infow("地址簿", "kind", cfg.Peerstore, "path", datastorePath(dir, peerstoreDir, cfg.Peerstore), "peers", len(ps.PeersWithAddrs()))
+	}
+	cleanup = append(cleanup, closePeerstore)
+	ps = newTTLPeerstore(ps, cfg.PeerstoreGC, cfg.DiscoveredAddrTTL)
+	listenAddrs, e := cfg.listenAddrs(cfg.listenIPs(interfaceIP), enableQUIC, enableWebRTC)
+	if e != nil {
+		return errConfig(e)
+	}
+
+	gater := newConnectionGater(cfg.MaxGoroutines)
+	acl, e := loadAccessList(aclPath(cfg, dir))
+	if e != nil {
+		return errConfig(fmt.Errorf("读取访问控制列表出错: %w", e))
+	}
+	gater.acl = acl
+	bans, e := loadBanList(dir)
+	if e != nil {
+		return fmt.Errorf("读取封禁列表出错: %w", e)
+	}
+	gater.bans = bans
+	if e := cfg.Reputation.validate(); e != nil {
+		return errConfig(e)
+	}
+	var reputation *reputationList
+	if len(cfg.Reputation.Feeds) > 0 {
+		reputation = newReputationList(cfg.Reputation, acl)
+		gater.reputation = reputation
+	}
+	if e := cfg.Inbound.validate(); e != nil {
+		return errConfig(e)
+	}
+	throttle := newInboundThrottle(cfg.Inbound, acl.allowedIP)
+	gater.throttle = throttle
+	dialDeny, e := newDialFilter(cfg.blockPrivateDials(), cfg.DialDeny)
+	if e != nil {
+		return errConfig(e)
+	}
+	gater.dialDeny = dialDeny
+	// 拨号失败的地址在退避期间不再拨号
+	if e := cfg.DialBackoff.validate(); e != nil {
+		return errConfig(e)
+	}
+	backoff := newDialBackoff(cfg.DialBackoff)
+	gater.backoff = backoff
+	if cfg.blockPrivateDials() {
+		logger.Infow("不拨号内网和不可路由的地址")
+		if cfg.MDNS {
+			logger.Warn("mDNS发现的局域网节点需要设置-dial-private")
+		}
+	}
+	// 连接建立时间限制, 从接受或拨号时开始计时
+	var budget *setupBudget
+	if cfg.SetupBudget > 0 {
+		budget = newSetupBudget(cfg.SetupBudget)
+		gater.budget = budget
+	}
+	startNetSim := setupNetSim(gater, n.netSim)
+	bwc := newBandwidthCounter()
+	// 连接管理器可以在重新加载配置时修改水位线
+	if e := cfg.ConnMgr.validate(); e != nil {
+		return errConfig(e)
+	}
+	logger.Infow("连接管理器", "low_water", cfg.ConnMgr.LowWater, "high_water", cfg.ConnMgr.HighWater, "grace_period", cfg.ConnMgr.GracePeriod)
+	cm, e := newReloadableConnMgr(
+		cfg.ConnMgr.LowWater,    // Lowwater
+		cfg.ConnMgr.HighWater,   // HighWater,
+		cfg.ConnMgr.GracePeriod, // GracePeriod
+	)
+	if e != nil {
+		return errConfig(e)
+	}
+	// 启动时即保护重要节点, 不必等到重新发现. 受保护的节点不受拨号过滤限制
+	protector := newPeerProtector(cm)
+	if dialDeny != nil {
+		dialDeny.exempt = protector.isProtected
+	}
+	if backoff != nil {
+		backoff.exempt = protector.isProtected
+	}
+	// 资源管理器限制连接, 流和内存, 不启用时不限制
+	var resources network.ResourceManager = &network.NullResourceManager{}
+	var resourceUsage *resourceStats
+	if cfg.Resources.Enabled {
+		if e := cfg.Resources.validate(); e != nil {
+			return errConfig(e)
+		}
+		if resources, resourceUsage, e = newResourceManager(cfg.Resources, cfg.ConnMgr.HighWater); e != nil {
+			return e
+		}
+		cleanup = append(cleanup, func() { _ = resources.Close() })
+		limits := resourceUsage.limits.System
+		logger.Infow("资源管理器", "conns", limits.Conns, "conns_inbound", limits.ConnsInbound, "streams", limits.Streams, "memory", limits.Memory)
+	}
+	var idht *dht.IpfsDHT
+	options := []libp2p.Option{
+		// Use the keypair we generated
+		libp2p.Identity(privateKey),
+		// Multiple listen addresses
+		libp2p.ListenAddrStrings(listenAddrs...),
+		// Let's prevent our peer from having too many
+		// connections by attaching a connection manager.
+		libp2p.ConnectionManager(cm),
+		// Let this host use the DHT to find other hosts
+		libp2p.Routing(func(h host.Host) (routing.PeerRouting, error) {
+			var e error
+			idht, e = dht.New(ctx, h, cfg.DHT.options(dhtStore, validators)...)
+			return idht, e
+		}),
+		// 统计流量
+		libp2p.BandwidthReporter(bwc),
+		// 过滤连接
+		libp2p.ConnectionGater(gater),
+		// 响应其他节点的ping, 节点评分也用ping测量延迟
+		libp2p.Ping(true),
+		// 按资源限制拒绝新的连接和流
+		libp2p.ResourceManager(resources),
+		// 指标由 /metrics 提供, 不注册libp2p自带的prometheus指标
+		libp2p.DisableMetrics(),
+	}
+	// identify中的客户端版本, 便于爬虫和其他运营者区分引导节点
+	if e := checkAgentVersion(cfg.AgentVersion); e != nil {
+		return errConfig(e)
+	}
+	if cfg.AgentVersion != "" {
+		options = append(options, libp2p.UserAgent(cfg.AgentVersion))
+	}
+	// 拨号失败的地址计入退避
+	if backoff != nil {
+		options = append(options, libp2p.SwarmOpts(swarm.WithMetricsTracer(backoff)))
+	}
+	options = append(options, libp2p.Peerstore(ps))
+	// Let this host use relays and advertise itself on relays if
+	// it finds it is behind NAT. AutoRelay使用指定的中继节点, 没有指定时在DHT路由表中寻找
+	candidates := &relayCandidates{}
+	if len(cfg.Relay.Static) > 0 {
+		relays, e := cfg.Relay.staticRelays()
+		if e != nil {
+			return errConfig(e)
+		}
+		options = append(options, libp2p.EnableAutoRelayWithStaticRelays(relays))
+		logger.Infow("AutoRelay使用指定的中继节点", "relays", len(relays))
+	} else {
+		options = append(options, libp2p.EnableAutoRelayWithPeerSource(candidates.source))
+	}
+	// 通过中继连接后尝试打洞改用直接连接
+	var punches *holePunchStats
+	if cfg.HolePunching {
+		punches = &holePunchStats{}
+		options = append(options, libp2p.EnableHolePunching(holepunch.WithTracer(punches)))
+	}
+	// 为其他节点提供中继
+	if cfg.RelayService {
+		if e := cfg.Relay.validate(); e != nil {
+			return errConfig(e)
+		}
+	}
+	// 为其他节点提供AutoNAT回拨
+	if e := cfg.AutoNAT.validate(); e != nil {
+		return errConfig(e)
+	}
+	options = append(options, cfg.AutoNAT.options()...)
+	var announce addrsFactories
+	// 按节点确认的数量过滤观察地址, 在其他功能加入地址之前
+	if e := cfg.ObservedAddrs.validate(); e != nil {
+		return errConfig(e)
+	}
+	var observed *observedAddrs
+	if cfg.ObservedAddrs.Enabled {
+		observed = newObservedAddrs(cfg.ObservedAddrs)
+		announce = append(announce, observed.AddrsFactory)
+	}
+	// Attempt to open ports using uPNP or NAT-PMP for NATed hosts.
+	portmap := newPortMapper(cfg.NATPortMap && cfg.UPnP, cfg.NATPortMap && cfg.NATPMP)
+	if portmap.enableUPnP || portmap.enableNATPMP {
+		options = append(options, libp2p.NATManager(portmap.manager))
+		announce = append(announce, portmap.AddrsFactory)
+	} else {
+		logger.Infow("不映射端口")
+	}
+	// 安全WebSocket, 在ws前终止TLS
+	var wss *wssProxy
+	if cfg.WSS.Domain != "" {
+		if cfg.WSPort == 0 {
+			return errConfig("wss-domain需要同时设置ws-port")
+		}
+		target := net.JoinHostPort("127.0.0.1", strconv.Itoa(cfg.WSPort))
+		if interfaceIP != nil {
+			target = net.JoinHostPort(interfaceIP.String(), strconv.Itoa(cfg.WSPort))
+		}
+		// 临时模式不缓存证书
+		certDir := dir
+		if cfg.Ephemeral {
+			certDir = ""
+		}
+		wss, e = newWSSProxy(cfg.WSS, certDir, target)
+		if e != nil {
+			return errConfig(fmt.Errorf("wss设置错误: %w", e))
+		}
+		if e := retryStartup("wss", cfg.StartupRetry, func() error { return wss.start(ctx) }); e != nil {
+			return fmt.Errorf("wss监听出错: %w", e)
+		}
+		announce = append(announce, wss.AddrsFactory)
+	}
+	// 指定宣告的地址, 在其他功能处理之后
+	announceFilter, e := newAnnounceFilter(cfg.Announce, cfg.NoAnnounce, cfg.AnnouncePrivate)
+	if e != nil {
+		return errConfig(e)
+	}
+	if announceFilter != nil {
+		announce = append(announce, announceFilter)
+	}
+	if cfg.Interface != "" && !cfg.AnnouncePrivate && len(cfg.Announce) == 0 {
+		logger.Warn("不宣告内网地址, 在覆盖网络中使用时需要设置-announce-private")
+	}
+	// Tor onion服务, onion地址总是宣告
+	if e := checkOnion(cfg); e != nil {
+		return errConfig(e)
+	}
+	var onion *onionService
+	if cfg.Onion.Control != "" {
+		target := net.JoinHostPort("127.0.0.1", strconv.Itoa(cfg.tcpPort()))
+		if interfaceIP != nil {
+			target = net.JoinHostPort(interfaceIP.String(), strconv.Itoa(cfg.tcpPort()))
+		}
+		// 临时模式不保存onion私钥, 每次启动地址不同
+		keyPath := onionKeyPath(dir)
+		if cfg.Ephemeral {
+			keyPath = ""
+		}
+		onion = newOnionService(cfg.Onion, keyPath, target)
+		if e := retryStartup("onion", cfg.StartupRetry, func() error { return onion.start(ctx) }); e != nil {
+			return fmt.Errorf("onion服务出错: %w", e)
+		}
+		announce = append(announce, onion.AddrsFactory)
+	}
+	var onionDialer proxy.ContextDialer
+	if cfg.Onion.SOCKS != "" {
+		if onionDialer, e = newOnionDialer(cfg.Onion.SOCKS); e != nil {
+			return errConfig(e)
+		}
+		logger.Infow("通过Tor连接onion地址", "socks", cfg.Onion.SOCKS)
+	}
+	if len(announce) > 0 {
+		options = append(options, libp2p.AddrsFactory(announce.apply))
+	}
+	// TCP出站连接通过代理
+	var dialer proxy.ContextDialer
+	if cfg.Proxy != "" {
+		if dialer, e = newProxyDialer(cfg.Proxy); e != nil {
+			return errConfig(e)
+		}
+		logger.Infow("TCP出站连接使用代理", "proxy", redactProxy(cfg.Proxy))
+		if enableQUIC || cfg.WSPort != 0 {
+			logger.Warn("QUIC和WebSocket的出站连接不经过代理")
+		}
+	}
+	options = append(options, transportOptions(cfg.transportSet(enableQUIC, psk, dialer, onionDialer))...)
+	// 端口被占用等暂时性错误时重试
+	var h host.Host
+	e = retryStartup("listen", cfg.StartupRetry, func() (e error) {
+		h, e = libp2p.New(options...)
+		return e
+	})
+	if e != nil {
+		return fmt.Errorf("启动节点出错: %w", e)
+	}
+	cleanup = append(cleanup, func() {
+		_ = idht.Close()
+		_ = h.Close()
+	})
+	candidates.set(h, idht)
+	bwc.setNetwork(h.Network())
+	go bwc.run(ctx)
+	if backoff != nil {
+		h.Network().Notify(&network.NotifyBundle{ConnectedF: backoff.connected})
+		go backoff.run(ctx)
+	}
+	startNetSim(ctx, h)
+	if enableWebRTC {
+		certs := &webrtcCerts{h: h, lifetime: cfg.Transports.WebRTCCertLifetime}
+		go certs.run(ctx)
+	}
+	myAddrs, e := peer.AddrInfoToP2pAddrs(&peer.AddrInfo{ID: h.ID(), Addrs: h.Addrs()})
+	if e != nil {
+		return e
+	}
+	logger.Infow("我的地址", "addrs", myAddrs)
+
+	trusted, e := decodePeerIDs(cfg.Protect)
+	if e != nil {
+		return errConfig(fmt.Errorf("受保护节点ID错误: %w", e))
+	}
+	for _, id := range trusted {
+		protector.Protect(id, protectTagTrusted)
+	}
+
+	// 连接多样性
+	diversity := newDiversityKeeper(h, protector, cfg.MinPeers)
+	go diversity.run(ctx)
+
+	// 事件总线订阅, 退出时统一取消
+	var subs subscriptions
+	cleanup = append(cleanup, subs.Close)
+
+	// OpenTelemetry追踪
+	var stopTracing func()
+	if cfg.Tracing.Endpoint != "" {
+		if e := cfg.Tracing.validate(); e != nil {
+			return errConfig(e)
+		}
+		tracer, e := newTracer(ctx, cfg.Tracing, h)
+		if e != nil {
+			return fmt.Errorf("启动追踪出错: %w", e)
+		}
+		stopTracing = tracer.shutdown
+		cleanup = append(cleanup, stopTracing)
+		if e := subs.Subscribe(h.EventBus(), new(event.EvtPeerIdentificationCompleted), tracer.identified(h)); e != nil {
+			return e
+		}
+		logger.Infow("发送追踪数据", "endpoint", cfg.Tracing.Endpoint, "sample_ratio", cfg.Tracing.SampleRatio)
+	}
+
+	// 自定义协议的消息大小限制
+	limiter := newMessageLimiter(cfg.MaxMessageSize)
+
+	// 事件
+	events := newEventHub()
+	h.Network().Notify(&eventNotifee{hub: events})
+	go watchIsolation(ctx, h.Network(), events, isolatedAfter)
+	if e := (&connLogger{h: h}).subscribe(&subs); e != nil {
+		return e
+	}
+	if e := publishIdentify(h, events, &subs); e != nil {
+		return e
+	}
+	var reachability reachabilityTracker
+	e = subs.Subscribe(h.EventBus(), new(event.EvtLocalReachabilityChanged), func(evt interface{}) {
+		r := evt.(event.EvtLocalReachabilityChanged).Reachability
+		if previous := reachability.Set(r); previous != r {
+			logger.Infow("可达性变化", "from", previous, "to", r)
+		}
+		events.Publish(nodeEvent{Type: eventReachability, Reachability: r.String()})
+	})
+	if e != nil {
+		return e
+	}
+	if cfg.WebhookURL != "" {
+		go newWebhook(cfg.WebhookURL, cfg.WebhookEvents).run(ctx, events)
+	}
+	if len(cfg.EventsAllow) > 0 {
+		allow := make(map[peer.ID]bool)
+		for _, v := range cfg.EventsAllow {
+			id, e := peer.Decode(v)
+			if e != nil {
+				return errConfig("事件流授权节点ID错误", v, e)
+			}
+			allow[id] = true
+		}
+		h.SetStreamHandler(eventStreamProtocol, newEventStreamHandler(ctx, events, allow, limiter))
+	}
+
+	// rendezvous服务
+	var rendezvous *rendezvousServer
+	if cfg.Rendezvous.Enabled {
+		rendezvous = newRendezvousServer(cfg.Rendezvous, limiter)
+		go rendezvous.run(ctx)
+		h.SetStreamHandler(rendezvousProtocol, rendezvous.handle)
+		logger.Infow("提供rendezvous服务", "protocol", rendezvousProtocol)
+	}
+
+	// 遍历DHT
+	var fullrt *fullRoutingTable
+	if cfg.DHT.Accelerated.Enabled {
+		fullrt = newFullRoutingTable(h, idht, cfg.DHT.protocolID(), cfg.DHT.Accelerated)
+		go fullrt.run(ctx)
+		logger.Infow("加速DHT已启用", "interval", cfg.DHT.Accelerated.Interval)
+	}
+	var crawl *crawler
+	if cfg.Crawl.Interval > 0 {
+		crawl = newCrawler(h, idht, cfg.DHT.protocolID(), cfg.Crawl, dir)
+		go crawl.run(ctx)
+		logger.Infow("遍历DHT", "interval", cfg.Crawl.Interval)
+	}
+
+	// 定期保存连接快照
+	var snapshots *peerSnapshotter
+	if cfg.Snapshot.Interval > 0 {
+		snapshots = newPeerSnapshotter(h, cfg.Snapshot, dir)
+		go snapshots.run(ctx)
+		logger.Infow("保存连接快照", "interval", cfg.Snapshot.Interval, "dir", snapshots.dir)
+	}
+
+	// 告知其他节点新身份, 宽限期结束后提示重启
+	var rotationTimer *time.Timer
+	if rotation != nil {
+		h.SetStreamHandler(rotationProtocol, newRotationHandler(h, rotation))
+		rotationTimer = time.AfterFunc(time.Until(rotation.Until), func() {
+			logger.Warnw("身份轮换宽限期已结束, 重启节点以使用新身份", "new_id", rotation.NewID)
+		})
+		cleanup = append(cleanup, func() { rotationTimer.Stop() })
+	}
+
+	// 统计每个IP的入站连接
+	h.Network().Notify(throttle)
+	go throttle.run(ctx)
+	go bans.run(ctx)
+	if reputation != nil {
+		go reputation.run(ctx, h.Network())
+		logger.Infow("使用IP信誉列表", "feeds", len(cfg.Reputation.Feeds), "interval", cfg.Reputation.Interval)
+	}
+	// 与可信节点同步封禁
+	if e := cfg.BanSync.validate(); e != nil {
+		return errConfig(e)
+	}
+	var banSync *banSyncer
+	if len(cfg.BanSync.Peers) > 0 {
+		trusted, _ := cfg.BanSync.trusted()
+		banSync = newBanSyncer(h, bans, cfg.BanSync, trusted, limiter)
+		banSync.protect(protector)
+		h.SetStreamHandler(banSyncProtocol, banSync.handle)
+		go banSync.run(ctx)
+		logger.Infow("与可信节点同步封禁", "peers", len(trusted), "interval", cfg.BanSync.Interval)
+	}
+
+	// 连接建立时间限制
+	if budget != nil {
+		h.Network().Notify(budget)
+		e = subs.Subscribe(h.EventBus(), new(event.EvtPeerIdentificationCompleted), func(evt interface{}) {
+			budget.Identified(evt.(event.EvtPeerIdentificationCompleted).Peer)
+		})
+		if e != nil {
+			return e
+		}
+	}
+
+	// 局域网发现
+	var mdns *mdnsNotifee
+	if cfg.MDNS {
+		mdns, e = startMDNS(ctx, h, cfg.MDNSServiceTag, peerstore.RecentlyConnectedAddrTTL)
+		if e != nil {
+			return fmt.Errorf("启动mDNS出错: %w", e)
+		}
+		logger.Infow("mDNS服务名", "service_tag", cfg.MDNSServiceTag)
+	}
+
+	// 路由表过小时重新连接引导节点
+	dials := newDialStats()
+	refresher := newDHTRefresher(h, idht, cfg.DHT.RebootstrapBelow, dials)
+	// 中继客户端和路由表中的节点晚被修剪
+	tagger := newPeerTagger(h, idht)
+	tagger.setWeights(cfg.ConnMgr.RelayWeight, cfg.ConnMgr.DHTWeight, cfg.ConnMgr.UsefulWeight)
+	if e := tagger.registerDecaying(cfg.ConnMgr.UsefulHalfLife); e != nil {
+		logger.Warnw("注册衰减标签出错, 只使用固定标签", "error", e)
+	}
+	go tagger.run(ctx)
+	// 按延迟和失败次数给节点评分, 断开没有响应的节点
+	if e := cfg.Scoring.validate(); e != nil {
+		return errConfig(e)
+	}
+	var scorer *peerScorer
+	if cfg.Scoring.Interval > 0 {
+		scorer = newPeerScorer(h, cfg.Scoring)
+		if e := scorer.subscribe(&subs); e != nil {
+			return e
+		}
+		go scorer.run(ctx)
+	}
+	// 长时间空闲的连接ping失败时关闭
+	if e := cfg.Watchdog.validate(); e != nil {
+		return errConfig(e)
+	}
+	var dog *watchdog
+	if cfg.Watchdog.Idle > 0 {
+		dog = newWatchdog(h, cfg.Watchdog)
+		go dog.run(ctx)
+	}
+	if observed != nil {
+		observed.setHost(h)
+		go observed.run(ctx)
+	}
+	// 定期发布本节点的地址
+	var self *selfRecord
+	if cfg.SelfRecord.Enabled {
+		self = newSelfRecord(h, idht, cfg.SelfRecord, putPeerRecord)
+		if e := self.subscribe(&subs); e != nil {
+			return e
+		}
+		go self.run(ctx)
+	}
+	// 集群成员互相交换健康的节点, 中继负载和可达性
+	if e := cfg.Cluster.validate(); e != nil {
+		return errConfig(e)
+	}
+	var clust *cluster
+	if len(cfg.Cluster.Peers) > 0 {
+		members, _ := cfg.Cluster.members()
+		clust = newCluster(h, cfg.Cluster, members, scorer, &reachability, limiter)
+		clust.protect(protector)
+		h.SetStreamHandler(clusterProtocol, clust.handle)
+		go clust.run(ctx)
+		logger.Infow("集群模式", "members", len(members), "interval", cfg.Cluster.Interval)
+	}
+	// 向客户端提供签名的网络信息
+	var info *infoService
+	if cfg.Info.Enabled {
+		if e := cfg.Info.validate(); e != nil {
+			return errConfig(e)
+		}
+		network := cfg.DHT.ProtocolPrefix
+		if network == "" {
+			network = "/ipfs"
+		}
+		info = newInfoService(h, cfg.Info, network, clust)
+		h.SetStreamHandler(infoProtocol, info.handle)
+	}
+	// 向客户端提供已知节点
+	var exchange *peerExchange
+	if cfg.PeerExchange {
+		exchange = newPeerExchange(h, cfg.PeerExchangeMax, limiter, clust)
+		h.SetStreamHandler(peerExchangeProtocol, exchange.handle)
+	}
+	// 清理地址簿中没有连接且地址已过期的节点
+	psgc := newPeerstoreGC(h, cfg.PeerstoreGC.Interval)
+	if cfg.PeerstoreGC.Interval > 0 {
+		go psgc.run(ctx)
+	}
+	// 在DHT上宣告名称空间
+	if e := checkAdvertise(cfg.Advertise, cfg.AdvertiseFind); e != nil {
+		return errConfig(e)
+	}
+	var adv *advertiser
+	if len(cfg.Advertise) > 0 {
+		adv = newAdvertiser(idht, cfg.Advertise)
+		go adv.run(ctx)
+		logger.Infow("在DHT上宣告名称空间", "namespaces", cfg.Advertise)
+	}
+	// GossipSub路由
+	if e := cfg.PubSub.validate(); e != nil {
+		return errConfig(e)
+	}
+	var gossip *gossipRouter
+	if cfg.PubSub.Enabled {
+		if gossip, e = newGossipRouter(ctx, h, cfg.PubSub); e != nil {
+			return e
+		}
+		logger.Infow("GossipSub已启用", "topics", cfg.PubSub.Topics, "px", cfg.PubSub.PeerExchange, "scoring", cfg.PubSub.Scoring)
+	}
+
+	// 状态
+	status := newStatusRegistry()
+	status.Set("id", func() interface{} { return h.ID().String() })
+	status.Set("addrs", func() interface{} { return h.Addrs() })
+	status.Set("peers", func() interface{} { return len(h.Network().Peers()) })
+	status.Set("peerstore", func() interface{} { return len(h.Peerstore().Peers()) })
+	status.Set("peerstore_gc", psgc.status)
+	status.Set("routing_table", func() interface{} { return idht.RoutingTable().Size() })
+	dhtm := newDHTMetrics(idht, dhtStore)
+	status.Set("dht", dhtm.status)
+	status.Set("dht_refresh", refresher.status)
+	status.Set("bootstrap_dials", dials.status)
+	status.Set("conn_tags", tagger.status)
+	status.Set("bandwidth", bwc.status)
+	status.Set("gater", gater.status)
+	status.Set("acl", acl.status)
+	status.Set("bans", bans.status)
+	if reputation != nil {
+		status.Set("reputation", reputation.status)
+	}
+	if banSync != nil {
+		status.Set("ban_sync", banSync.status)
+	}
+	status.Set("inbound", throttle.status)
+	status.Set("protected", protector.status)
+	status.Set("messages", limiter.status)
+	status.Set("diversity", diversity.status)
+	status.Set("nat", portmap.status)
+	status.Set("reachability", reachability.status)
+	if punches != nil {
+		status.Set("hole_punching", punches.status)
+	}
+	if resourceUsage != nil {
+		status.Set("resources", resourceUsage.status(h))
+	}
+	if udpResult != nil {
+		status.Set("udp", func() interface{} { return udpResult })
+	}
+	if budget != nil {
+		status.Set("setup_budget", budget.status)
+	}
+	if scorer != nil {
+		status.Set("scoring", scorer.status)
+	}
+	if dog != nil {
+		status.Set("watchdog", dog.status)
+	}
+	if observed != nil {
+		status.Set("observed_addrs", observed.status)
+	}
+	if self != nil {
+		status.Set("self_record", self.status)
+	}
+	if gossip != nil {
+		status.Set("pubsub", gossip.status)
+	}
+	if adv != nil {
+		status.Set("advertise", adv.status)
+	}
+	if wss != nil {
+		status.Set("wss", wss.status)
+	}
+	if onion != nil {
+		status.Set("onion", onion.status)
+	}
+	if mdns != nil {
+		status.Set("mdns", mdns.status)
+	}
+	if rendezvous != nil {
+		status.Set("rendezvous", rendezvous.status)
+	}
+	if exchange != nil {
+		status.Set("peer_exchange", exchange.status)
+	}
+	if clust != nil {
+		status.Set("cluster", clust.status)
+	}
+	if info != nil {
+		status.Set("info", info.status)
+	}
+	if fullrt != nil {
+		status.Set("dht_accelerated", fullrt.status)
+	}
+	if crawl != nil {
+		status.Set("crawl", crawl.status)
+	}
+	if snapshots != nil {
+		status.Set("snapshot", snapshots.status)
+	}
+	if rotation != nil {
+		status.Set("rotation", func() interface{} { return rotation.announcement(h) })
+	}
+	var pprofServer *http.Server
+	if cfg.PprofAddr != "" {
+		if e := checkPprofAddr(cfg.PprofAddr); e != nil {
+			return errConfig(e)
+		}
+		pprofServer = startPprof(cfg.PprofAddr)
+		cleanup = append(cleanup, func() { _ = pprofServer.Close() })
+	}
+	geo, e := openGeoIP(cfg.GeoIP)
+	if e != nil {
+		return errConfig(e)
+	}
+	if geo != nil {
+		status.Set("geoip", geo.status(h.Network()))
+		cleanup = append(cleanup, geo.Close)
+	}
+	var quota *relayQuota
+	var traffic *relayTraffic
+	var relayMetrics *relayMetrics
+	var relayService *relay.Relay
+	if cfg.RelayService {
+		traffic = newRelayTraffic(cfg.Relay)
+		go traffic.run(ctx)
+		quota = newRelayQuota(h, cfg.Relay, geo, acl)
+		relayMetrics = newRelayMetrics()
+		service, e := startRelay(h, cfg.Relay, relayMetrics, quota, traffic.wrap)
+		if e != nil {
+			return fmt.Errorf("启动中继服务出错: %w", e)
+		}
+		relayService = service
+		cleanup = append(cleanup, func() { _ = service.Close() })
+		logger.Infow("提供中继", "max_reservations", cfg.Relay.MaxReservations, "max_circuits", cfg.Relay.MaxCircuits)
+		status.Set("relay", relayStatus(h.Network(), relayMetrics, quota, traffic))
+	}
+	// 管理接口的控制台
+	board := newDashboard(h, idht, bwc, geo, &reachability, relayMetrics)
+	go board.run(ctx, events)
+	// 汇总统计上报
+	if e := cfg.Telemetry.validate(); e != nil {
+		return errConfig(e)
+	}
+	if cfg.Telemetry.URL != "" {
+		tele := newTelemetry(cfg.Telemetry, h, idht, bwc, &reachability)
+		status.Set("telemetry", tele.status)
+		go tele.run(ctx)
+		logger.Infow("上报汇总统计", "interval", cfg.Telemetry.Interval)
+	}
+	control := &controlServer{admin: &adminAPI{h: h, status: status, acl: acl, bans: bans, scorer: scorer, idht: idht, fullrt: fullrt, dashboard: board, relay: traffic}, events: events, reachability: &reachability}
+	var stopAdmin func()
+	e = retryStartup("admin", cfg.StartupRetry, func() (e error) {
+		stopAdmin, e = startAdmin(cfg, dir, control)
+		return e
+	})
+	if e != nil {
+		return e
+	}
+	cleanup = append(cleanup, stopAdmin)
+	ready := newReadiness(h, cfg.ReadyMinPeers)
+	var httpServer *http.Server
+	// 关闭HTTP服务前先取消httpCtx, 结束 /events 的长连接
+	httpCtx, httpCancel := context.WithCancel(ctx)
+	cleanup = append(cleanup, httpCancel)
+	// 没有启用HTTP服务时也创建, 多网络模式下挂在第一个网络的HTTP服务中
+	mux := http.NewServeMux()
+	mux.Handle("/status", status)
+	mux.HandleFunc("/healthz", healthz)
+	mux.Handle("/readyz", ready)
+	mux.Handle("/peers", peersHandler(h.Network(), geo))
+	mux.Handle("/bootstrap", bootstrapListHandler(h, protector, cfg.HTTPCluster))
+	mux.Handle("/routing-table", routingTableHandler(h, idht))
+	if adv != nil && cfg.AdvertiseFind {
+		mux.HandleFunc("/find-peers", adv.findPeersHandler)
+	}
+	mux.Handle("/bandwidth", bwc)
+	mux.Handle("/events", eventsHandler(httpCtx, events))
+	mux.Handle("/metrics", newMetricsHandler(h, idht, bwc, relayMetrics, quota, traffic, geo, throttle, dials, backoff, scorer, psgc, dhtm, punches, resourceUsage, gater))
+	// 附加的协议处理器和服务
+	pluginList, e := n.nodePlugins()
+	if e != nil {
+		return errConfig(e)
+	}
+	stopPlugins, e := startPlugins(ctx, pluginList, ServiceEnv{Host: h, DHT: idht, HTTP: mux, Status: status.Set})
+	if e != nil {
+		return e
+	}
+	cleanup = append(cleanup, func() { _ = stopPlugins() })
+	if cfg.HTTPAddr != "" {
+		httpServer = &http.Server{Addr: cfg.HTTPAddr, Handler: mux}
+		var l net.Listener
+		e = retryStartup("http", cfg.StartupRetry, func() (e error) {
+			l, e = net.Listen("tcp", cfg.HTTPAddr)
+			return e
+		})
+		if e != nil {
+			return fmt.Errorf("HTTP服务监听出错: %w", e)
+		}
+		cleanup = append(cleanup, func() { _ = httpServer.Close() })
+		go func() {
+			logger.Infow("HTTP服务地址", "addr", cfg.HTTPAddr)
+			e := httpServer.Serve(l)
+			if e != nil && e != http.ErrServerClosed {
+				logger.Errorw("HTTP服务出错", "error", e)
+			}
+		}()
+	}
+
+	// 关闭顺序: 停止接受连接, 停止HTTP服务, pprof和管理接口, 关闭附加服务和中继服务, 关闭DHT, 节点和资源管理器,
+	// 停止后台任务, 最后写入并关闭存储. 超时后仍然写入存储
+	if e := checkShutdownTimeout(cfg.ShutdownTimeout); e != nil {
+		return errConfig(e)
+	}
+	if cfg.DrainPeriod < 0 {
+		return errConfig(fmt.Errorf("drain_period不能小于0: %s", cfg.DrainPeriod))
+	}
+	var closing shutdownSequence
+	closing.add("gater", func() error {
+		gater.close()
+		return nil
+	})
+	if httpServer != nil {
+		closing.add("http", func() error {
+			httpCancel()
+			sc, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+			defer cancel()
+			return httpServer.Shutdown(sc)
+		})
+	}
+	if pprofServer != nil {
+		closing.add("pprof", pprofServer.Close)
+	}
+	closing.add("admin", func() error {
+		stopAdmin()
+		return nil
+	})
+	closing.add("plugins", stopPlugins)
+	if relayService != nil {
+		closing.add("relay", relayService.Close)
+	}
+	closing.add("subscriptions", func() error {
+		subs.Close()
+		return nil
+	})
+	closing.add("dht", idht.Close)
+	closing.add("host", h.Close)
+	closing.add("resources", resources.Close)
+	closing.add("background", func() error {
+		ctxCancel()
+		httpCancel()
+		if rotationTimer != nil {
+			rotationTimer.Stop()
+		}
+		return nil
+	})
+	closing.addFlush("peerstore", func() error {
+		closePeerstore()
+		return nil
+	})
+	if dhtStore != nil {
+		closing.addFlush("datastore", dhtStore.Close)
+	}
+	if geo != nil {
+		closing.add("geoip", func() error {
+			geo.Close()
+			return nil
+		})
+	}
+	if stopTracing != nil {
+		closing.add("tracing", func() error {
+			stopTracing()
+			return nil
+		})
+	}
+
+	// 连接引导节点
+	tierAddrs, e := cfg.bootstrapTiers(dir)
+	if e != nil {
+		return errConfig(fmt.Errorf("读取引导节点出错: %w", e))
+	}
+	if len(tierAddrs) == 0 {
+		logger.Infow("没有引导节点, 作为网络中的第一个节点运行")
+	}
+	tiers, e := parseTiers(resolveTiers(ctx, tierAddrs, cfg.TierTimeout))
+	if e != nil {
+		return errConfig(fmt.Errorf("引导节点地址错误: %w", e))
+	}
+	for _, tier := range tiers {
+		addBootstrapPeers(h, protector, tier, cfg.bootstrapAddrTTL())
+	}
+	if e := checkBootstrapQuorum(cfg.BootstrapQuorum); e != nil {
+		return errConfig(e)
+	}
+	if e := checkBootstrapRetry(cfg.BootstrapRetryMin, cfg.BootstrapRetryMax); e != nil {
+		return errConfig(e)
+	}
+	minPeers := cfg.TierMinPeers
+	if minPeers < cfg.BootstrapQuorum {
+		minPeers = cfg.BootstrapQuorum
+	}
+	connected := connectTiers(ctx, h, tiers, minPeers, cfg.TierTimeout, dials)
+	report := &reachabilityReport{h: h, reachability: &reachability, portmap: portmap, udp: udpResult, bootstrap: connected}
+	quorum := 0
+	for _, tier := range tiers {
+		quorum += len(tier)
+	}
+	report.bootstrapAll = quorum
+	if quorum > cfg.BootstrapQuorum {
+		quorum = cfg.BootstrapQuorum
+	}
+	bootstrapped := connected >= quorum
+	if bootstrapped {
+		logger.Infow("已连接引导节点", "connected", connected, "quorum", quorum)
+	} else {
+		logger.Warnw("连接的引导节点不足, 将在后台重试", "connected", connected, "quorum", quorum)
+	}
+	dhtStarted := false
+	if e := idht.Bootstrap(ctx); e != nil {
+		logger.Errorw("DHT启动出错", "error", e)
+	} else {
+		dhtStarted = true
+		if bootstrapped {
+			ready.setBootstrapped(len(tiers) == 0)
+		}
+	}
+	refresher.setTiers(tiers, cfg.TierMinPeers, cfg.TierTimeout)
+	go refresher.run(ctx)
+	// 连接不到引导节点或连接断开时按指数退避重新连接, 连接后刷新路由表
+	var keeper *bootstrapKeeper
+	keeper = newBootstrapKeeper(h, cfg.BootstrapRetryMin, cfg.BootstrapRetryMax, dials, func() {
+		if !dhtStarted {
+			return
+		}
+		if c, want := keeper.connected(); c >= want {
+			ready.setBootstrapped(false)
+		}
+		idht.RefreshRoutingTable()
+	})
+	h.Network().Notify(keeper)
+	keeper.setTiers(tiers, cfg.TierMinPeers, cfg.BootstrapQuorum, cfg.TierTimeout)
+	go keeper.run(ctx)
+	status.Set("bootstrap", keeper.status)
+
+	//显示节点数量
+	if cfg.PeerLogInterval > 0 {
+		go func() {
+			ticker := time.NewTicker(cfg.PeerLogInterval)
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					logger.Infow("节点数量", "connected", len(h.Network().Peers()), "peerstore", len(h.Peerstore().Peers()), "routing_table", idht.RoutingTable().Size())
+				}
+			}
+		}()
+	}
+
+	n.mu.Lock()
+	n.h = h
+	n.idht = idht
+	n.ready = ready
+	n.report = report
+	n.mux = mux
+	n.control = control
+	n.closing = closing
+	if cfg.DrainPeriod > 0 {
+		n.drain = func(force <-chan os.Signal) {
+			ready.setDraining()
+			announce := func(time.Time) int { return 0 }
+			if info != nil {
+				announce = func(at time.Time) int { return info.announceShutdown(ctx, at) }
+			}
+			drain(cfg.DrainPeriod, announce, force)
+		}
+	}
+	n.reloader = &reloader{ctx: ctx, configPath: n.configPath, args: n.args, network: n.network, dir: dir, cfg: cfg, tiers: tiers, h: h, cm: cm, protector: protector, refresher: refresher, keeper: keeper, tagger: tagger, acl: acl}
+	n.mu.Unlock()
+	return nil
+}
+
+// Stop 按顺序关闭节点, 所有步骤共用ShutdownTimeout, 超时后跳过其余步骤, 只写入并关闭存储. 超时或出错时返回错误
+func (n *Node) Stop() error {
+	if !n.stop(nil) {
+		return errors.New("关闭节点超时或出错")
+	}
+	return nil
+}
+
+// stop 关闭节点, force收到信号时不再等待. 返回是否按时完成, 没有启动或已关闭时返回true
+func (n *Node) stop(force <-chan os.Signal) bool {
+	n.mu.Lock()
+	if n.h == nil || n.stopped {
+		n.mu.Unlock()
+		return true
+	}
+	n.stopped = true
+	n.mu.Unlock()
+	if n.drain != nil {
+		n.drain(force)
+	}
+	return n.closing.run(n.cfg.ShutdownTimeout, force)
+}
+
+// reload 重新读取配置并应用可修改的设置
+func (n *Node) reload() {
+	n.mu.Lock()
+	r := n.reloader
+	n.mu.Unlock()
+	if r != nil {
+		r.reload()
+	}
+}
@@ -0,0 +1,102 @@
+package bootstrap_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alx696/go-libp2p-bootstrap/bootstrap"
+	"github.com/alx696/go-libp2p-bootstrap/bootstrap/bootstraptest"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// startTestNode 创建并启动节点, 测试结束时关闭
+func startTestNode(t *testing.T, cfg *bootstrap.Config) *bootstrap.Node {
+	key, _, e := crypto.GenerateEd25519Key(nil)
+	if e != nil {
+		t.Fatal(e)
+	}
+	n, e := bootstrap.New(cfg, bootstrap.WithIdentity(key))
+	if e != nil {
+		t.Fatal(e)
+	}
+	if e := n.Start(context.Background()); e != nil {
+		t.Fatal(e)
+	}
+	t.Cleanup(func() {
+		if e := n.Stop(); e != nil {
+			t.Error(e)
+		}
+	})
+	return n
+}
+
+func TestNodeStartStop(t *testing.T) {
+	key, _, e := crypto.GenerateEd25519Key(nil)
+	if e != nil {
+		t.Fatal(e)
+	}
+	n, e := bootstrap.New(bootstraptest.Config(t.TempDir()), bootstrap.WithIdentity(key))
+	if e != nil {
+		t.Fatal(e)
+	}
+	if n.Host() != nil || len(n.AddrInfo().Addrs) != 0 {
+		t.Fatal("启动前不应有libp2p节点")
+	}
+	if e := n.Start(context.Background()); e != nil {
+		t.Fatal(e)
+	}
+	id, e := peer.IDFromPrivateKey(key)
+	if e != nil {
+		t.Fatal(e)
+	}
+	info := n.AddrInfo()
+	if info.ID != id {
+		t.Fatalf("节点ID %s, 应为 %s", info.ID, id)
+	}
+	if len(info.Addrs) == 0 {
+		t.Fatal("没有监听地址")
+	}
+	if n.DHT() == nil {
+		t.Fatal("没有DHT")
+	}
+	if e := n.Start(context.Background()); e == nil {
+		t.Fatal("重复启动应该出错")
+	}
+	if e := n.Stop(); e != nil {
+		t.Fatal(e)
+	}
+	// 已关闭时再次关闭不出错
+	if e := n.Stop(); e != nil {
+		t.Fatal(e)
+	}
+}
+
+func TestNodeStopWithoutStart(t *testing.T) {
+	n, e := bootstrap.New(bootstraptest.Config(t.TempDir()))
+	if e != nil {
+		t.Fatal(e)
+	}
+	if e := n.Stop(); e != nil {
+		t.Fatal(e)
+	}
+}
+
+func TestNodeConnectsBootstrap(t *testing.T) {
+	first := startTestNode(t, bootstraptest.Config(t.TempDir()))
+	info := first.AddrInfo()
+	cfg := bootstraptest.Config(t.TempDir())
+	cfg.Genesis = false
+	cfg.Bootstrap = []string{info.Addrs[0].String() + "/p2p/" + info.ID.String()}
+	second := startTestNode(t, cfg)
+
+	deadline := time.Now().Add(time.Second * 30)
+	for second.Host().Network().Connectedness(info.ID) != network.Connected || second.DHT().RoutingTable().Find(info.ID) == "" {
+		if time.Now().After(deadline) {
+			t.Fatal("没有连接引导节点")
+		}
+		time.Sleep(time.Millisecond * 50)
+	}
+}
@@ -0,0 +1,357 @@
+package bootstrap
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/identify"
+	pb "github.com/libp2p/go-libp2p/p2p/protocol/identify/pb"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"google.golang.org/protobuf/proto"
+)
+
+// observedIdentifyMax 是identify消息的最大字节数, 与libp2p相同
+const observedIdentifyMax = 8 << 10
+
+// observedAddrTTL 是观察结果的有效期, 过期的不再计入
+const observedAddrTTL = time.Hour
+
+// observedAddrConfig 是观察地址汇总的设置. 在负载均衡或NAT后时, libp2p按出站连接的观察结果
+// 可能宣告错误的端口, 启用后只宣告被足够多不同节点确认的观察地址
+type observedAddrConfig struct {
+	// Enabled 为true时按汇总结果过滤宣告的观察地址
+	Enabled bool `yaml:"enabled"`
+	// Interval 是重新询问观察结果将要过期的节点的间隔, Sample 是每次最多询问的节点数量
+	Interval time.Duration `yaml:"interval"`
+	Sample   int           `yaml:"sample"`
+	// MinPeers 是宣告一个观察地址至少需要的确认节点数量,
+	// MinConfidence 是确认节点占同类地址(如ip4/tcp)所有观察节点的最低比例
+	MinPeers      int     `yaml:"min_peers"`
+	MinConfidence float64 `yaml:"min_confidence"`
+}
+
+// validate 检查观察地址汇总设置
+func (c observedAddrConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Interval <= 0 || c.Sample <= 0 || c.MinPeers <= 0 {
+		return fmt.Errorf("观察地址汇总设置错误: interval %s, sample %d, min_peers %d", c.Interval, c.Sample, c.MinPeers)
+	}
+	if c.Interval >= observedAddrTTL {
+		return fmt.Errorf("重新询问观察地址的间隔应小于观察结果的有效期%s: %s", observedAddrTTL, c.Interval)
+	}
+	if c.MinConfidence <= 0 || c.MinConfidence > 1 {
+		return fmt.Errorf("观察地址的最低比例必须在0和1之间: %v", c.MinConfidence)
+	}
+	return nil
+}
+
+// observation 是一个节点观察到的本节点地址
+type observation struct {
+	addr    ma.Multiaddr
+	inbound bool
+	seen    time.Time
+}
+
+// observedAddrs 收集节点观察到的本节点地址: identify完成时记录对方观察到的地址,
+// 连接较久, 观察结果将要过期的节点定期在已有连接上重新发送identify请求.
+// 每个节点对每类地址(如ip4/tcp)只计一票, 按确认的节点数量和比例给地址评分
+type observedAddrs struct {
+	cfg observedAddrConfig
+
+	mu sync.Mutex
+	h  host.Host
+	// peers 是每个节点按地址类别的最近一次观察
+	peers map[peer.ID]map[string]observation
+	// checked 是最近一次询问或收到每个节点的identify的时间, 包括观察地址不是公网地址的
+	checked map[peer.ID]time.Time
+
+	identified uint64
+	queried    uint64
+	failed     uint64
+}
+
+func newObservedAddrs(cfg observedAddrConfig) *observedAddrs {
+	return &observedAddrs{cfg: cfg, peers: make(map[peer.ID]map[string]observation), checked: make(map[peer.ID]time.Time)}
+}
+
+// setHost 设置主机, 之前宣告的地址不处理
+func (o *observedAddrs) setHost(h host.Host) {
+	o.mu.Lock()
+	o.h = h
+	o.mu.Unlock()
+}
+
+// observedGroup 返回地址的类别, 如ip4/tcp, ip6/udp/quic-v1, 不是公网地址时返回空
+func observedGroup(addr ma.Multiaddr) string {
+	if !manet.IsPublicAddr(addr) {
+		return ""
+	}
+	var names []string
+	for _, p := range addr.Protocols() {
+		if p.Code == ma.P_P2P {
+			return ""
+		}
+		names = append(names, p.Name)
+	}
+	return strings.Join(names, "/")
+}
+
+// run 记录identify完成时的观察地址, 定期重新询问观察结果将要过期的节点
+func (o *observedAddrs) run(ctx context.Context) {
+	o.mu.Lock()
+	h := o.h
+	o.mu.Unlock()
+	sub, e := h.EventBus().Subscribe(new(event.EvtPeerIdentificationCompleted))
+	if e != nil {
+		logger.Warnw("订阅identify事件出错, 不汇总观察地址", "error", e)
+		return
+	}
+	defer sub.Close()
+	ticker := time.NewTicker(o.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case evt := <-sub.Out():
+			identified := evt.(event.EvtPeerIdentificationCompleted)
+			if identified.ObservedAddr != nil {
+				atomic.AddUint64(&o.identified, 1)
+				o.observe(identified.Peer, identified.ObservedAddr, identified.Conn.Stat().Direction)
+			}
+		case <-ticker.C:
+			o.sample(ctx, h)
+		}
+	}
+}
+
+// observe 记录节点观察到的地址, 不是公网地址时只记录时间
+func (o *observedAddrs) observe(p peer.ID, addr ma.Multiaddr, dir network.Direction) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	now := time.Now()
+	o.checked[p] = now
+	group := observedGroup(addr)
+	if group == "" {
+		return
+	}
+	groups, ok := o.peers[p]
+	if !ok {
+		groups = make(map[string]observation)
+		o.peers[p] = groups
+	}
+	groups[group] = observation{addr: addr, inbound: dir == network.DirInbound, seen: now}
+}
+
+// sample 清理过期的观察结果, 向没有收到过identify或观察结果在下个间隔内过期的已连接节点重新询问, 每次最多Sample个
+func (o *observedAddrs) sample(ctx context.Context, h host.Host) {
+	now := time.Now()
+	var peers []peer.ID
+	o.mu.Lock()
+	for p, groups := range o.peers {
+		for group, ob := range groups {
+			if now.Sub(ob.seen) > observedAddrTTL {
+				delete(groups, group)
+			}
+		}
+		if len(groups) == 0 {
+			delete(o.peers, p)
+		}
+	}
+	for p, at := range o.checked {
+		if now.Sub(at) > observedAddrTTL {
+			delete(o.checked, p)
+		}
+	}
+	for _, p := range h.Network().Peers() {
+		if at, ok := o.checked[p]; !ok || now.Sub(at) > observedAddrTTL-o.cfg.Interval {
+			peers = append(peers, p)
+		}
+	}
+	rand.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+	if len(peers) > o.cfg.Sample {
+		peers = peers[:o.cfg.Sample]
+	}
+	// 不支持identify的节点不在每个间隔重复询问
+	for _, p := range peers {
+		o.checked[p] = now
+	}
+	o.mu.Unlock()
+
+	queue := make(chan peer.ID)
+	var wg sync.WaitGroup
+	for i := 0; i < scorePingWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range queue {
+				if e := o.query(ctx, h, p); e != nil {
+					atomic.AddUint64(&o.failed, 1)
+					logger.Debugw("询问观察地址出错", "peer", p, "error", e)
+				}
+			}
+		}()
+	}
+	for _, p := range peers {
+		select {
+		case <-ctx.Done():
+		case queue <- p:
+		}
+	}
+	close(queue)
+	wg.Wait()
+}
+
+// query 在已有连接上发送identify请求, 记录响应中的观察地址
+func (o *observedAddrs) query(ctx context.Context, h host.Host, p peer.ID) error {
+	ctx, cancel := context.WithTimeout(network.WithNoDial(ctx, "观察地址"), connectTimeout)
+	defer cancel()
+	atomic.AddUint64(&o.queried, 1)
+	s, e := h.NewStream(ctx, p, identify.ID)
+	if e != nil {
+		return e
+	}
+	defer s.Close()
+	_ = s.SetReadDeadline(time.Now().Add(connectTimeout))
+	b, e := readDelimited(bufio.NewReader(s), observedIdentifyMax)
+	if e != nil {
+		_ = s.Reset()
+		return e
+	}
+	var msg pb.Identify
+	if e := proto.Unmarshal(b, &msg); e != nil {
+		return e
+	}
+	if len(msg.ObservedAddr) == 0 {
+		return errors.New("响应中没有观察地址")
+	}
+	addr, e := ma.NewMultiaddrBytes(msg.ObservedAddr)
+	if e != nil {
+		return e
+	}
+	o.observe(p, addr, s.Conn().Stat().Direction)
+	return nil
+}
+
+// observedScore 是一个观察地址的评分
+type observedScore struct {
+	Addr       string  `json:"addr"`
+	Group      string  `json:"group"`
+	Peers      int     `json:"peers"`
+	Inbound    int     `json:"inbound"`
+	Confidence float64 `json:"confidence"`
+	Confident  bool    `json:"confident"`
+}
+
+// scores 返回有效的观察地址的评分和每类地址的观察节点数量, 调用时持有锁
+func (o *observedAddrs) scores() ([]observedScore, map[string]int) {
+	now := time.Now()
+	totals := make(map[string]int)
+	byAddr := make(map[string]*observedScore)
+	for _, groups := range o.peers {
+		for group, ob := range groups {
+			if now.Sub(ob.seen) > observedAddrTTL {
+				continue
+			}
+			totals[group]++
+			key := ob.addr.String()
+			s, ok := byAddr[key]
+			if !ok {
+				s = &observedScore{Addr: key, Group: group}
+				byAddr[key] = s
+			}
+			s.Peers++
+			if ob.inbound {
+				s.Inbound++
+			}
+		}
+	}
+	scores := make([]observedScore, 0, len(byAddr))
+	for _, s := range byAddr {
+		s.Confidence = float64(s.Peers) / float64(totals[s.Group])
+		s.Confident = s.Peers >= o.cfg.MinPeers && s.Confidence >= o.cfg.MinConfidence
+		scores = append(scores, *s)
+	}
+	sort.Slice(scores, func(i, j int) bool {
+		if scores[i].Peers != scores[j].Peers {
+			return scores[i].Peers > scores[j].Peers
+		}
+		return scores[i].Addr < scores[j].Addr
+	})
+	return scores, totals
+}
+
+// AddrsFactory 处理libp2p检测到的地址中不是监听地址的观察地址: 观察节点足够多的类别中
+// 只保留评分高的地址并补充libp2p还没有采用的, 观察节点不够的类别保持不变
+func (o *observedAddrs) AddrsFactory(addrs []ma.Multiaddr) []ma.Multiaddr {
+	o.mu.Lock()
+	h := o.h
+	scores, totals := o.scores()
+	o.mu.Unlock()
+	if h == nil {
+		return addrs
+	}
+	listen, e := h.Network().InterfaceListenAddresses()
+	if e != nil {
+		return addrs
+	}
+	local := make(map[string]bool, len(listen))
+	for _, addr := range listen {
+		local[string(addr.Bytes())] = true
+	}
+	confident := make(map[string]bool)
+	for _, s := range scores {
+		if s.Confident {
+			confident[s.Addr] = true
+		}
+	}
+	result := make([]ma.Multiaddr, 0, len(addrs))
+	present := make(map[string]bool)
+	for _, addr := range addrs {
+		group := observedGroup(addr)
+		if !local[string(addr.Bytes())] && group != "" && totals[group] >= o.cfg.MinPeers && !confident[addr.String()] {
+			continue
+		}
+		present[addr.String()] = true
+		result = append(result, addr)
+	}
+	for _, s := range scores {
+		if !s.Confident || present[s.Addr] {
+			continue
+		}
+		if addr, e := ma.NewMultiaddr(s.Addr); e == nil {
+			result = append(result, addr)
+		}
+	}
+	return result
+}
+
+// status 返回观察地址的评分, identify完成时记录的次数以及重新询问和失败的次数
+func (o *observedAddrs) status() interface{} {
+	o.mu.Lock()
+	scores, _ := o.scores()
+	peers := len(o.peers)
+	o.mu.Unlock()
+	return map[string]interface{}{
+		"peers":      peers,
+		"addrs":      scores,
+		"identified": atomic.LoadUint64(&o.identified),
+		"queried":    atomic.LoadUint64(&o.queried),
+		"failed":     atomic.LoadUint64(&o.failed),
+	}
+}
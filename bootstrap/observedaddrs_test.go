@@ -0,0 +1,90 @@
+package bootstrap
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestObservedAddrsScores(t *testing.T) {
+	type observed struct {
+		peer string
+		addr string
+		dir  network.Direction
+		age  time.Duration
+	}
+	tests := []struct {
+		name     string
+		observed []observed
+		want     []observedScore
+		totals   map[string]int
+	}{
+		{
+			name: "多数确认",
+			observed: []observed{
+				{"a", "/ip4/1.2.3.4/tcp/4001", network.DirInbound, 0},
+				{"b", "/ip4/1.2.3.4/tcp/4001", network.DirOutbound, 0},
+				{"c", "/ip4/1.2.3.4/tcp/4001", network.DirOutbound, 0},
+				{"d", "/ip4/1.2.3.4/tcp/5555", network.DirOutbound, 0},
+			},
+			want: []observedScore{
+				{Addr: "/ip4/1.2.3.4/tcp/4001", Group: "ip4/tcp", Peers: 3, Inbound: 1, Confidence: 0.75, Confident: true},
+				{Addr: "/ip4/1.2.3.4/tcp/5555", Group: "ip4/tcp", Peers: 1, Confidence: 0.25},
+			},
+			totals: map[string]int{"ip4/tcp": 4},
+		},
+		{
+			name: "节点不够",
+			observed: []observed{
+				{"a", "/ip4/1.2.3.4/udp/4001/quic-v1", network.DirOutbound, 0},
+			},
+			want: []observedScore{
+				{Addr: "/ip4/1.2.3.4/udp/4001/quic-v1", Group: "ip4/udp/quic-v1", Peers: 1, Confidence: 1},
+			},
+			totals: map[string]int{"ip4/udp/quic-v1": 1},
+		},
+		{
+			name: "忽略过期和内网地址",
+			observed: []observed{
+				{"a", "/ip4/1.2.3.4/tcp/4001", network.DirOutbound, observedAddrTTL + time.Minute},
+				{"b", "/ip4/192.168.1.2/tcp/4001", network.DirOutbound, 0},
+			},
+			want:   []observedScore{},
+			totals: map[string]int{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := newObservedAddrs(observedAddrConfig{Enabled: true, MinPeers: 2, MinConfidence: 0.5})
+			for _, ob := range tt.observed {
+				p := peer.ID(ob.peer)
+				o.observe(p, ma.StringCast(ob.addr), ob.dir)
+				group := observedGroup(ma.StringCast(ob.addr))
+				if v, ok := o.peers[p][group]; ok {
+					v.seen = v.seen.Add(-ob.age)
+					o.peers[p][group] = v
+				}
+			}
+			scores, totals := o.scores()
+			if len(scores) != len(tt.want) {
+				t.Fatalf("评分 %+v, 应为 %+v", scores, tt.want)
+			}
+			for i := range scores {
+				if scores[i] != tt.want[i] {
+					t.Errorf("第%d个评分 %+v, 应为 %+v", i, scores[i], tt.want[i])
+				}
+			}
+			if len(totals) != len(tt.totals) {
+				t.Fatalf("观察节点数量 %v, 应为 %v", totals, tt.totals)
+			}
+			for group, n := range tt.totals {
+				if totals[group] != n {
+					t.Errorf("%s 的观察节点数量 %d, 应为 %d", group, totals[group], n)
+				}
+			}
+		})
+	}
+}
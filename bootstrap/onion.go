@@ -0,0 +1,324 @@
+package bootstrap
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/transport"
+	ma "github.com/multiformats/go-multiaddr"
+	"golang.org/x/net/proxy"
+)
+
+// onionKeyFile 是数据目录中保存onion服务私钥的文件, 重启后onion地址不变
+const onionKeyFile = "onion.key"
+
+// onionConfig 是Tor onion服务的设置, 需要本机运行的Tor.
+// 设置控制端口时创建onion服务并宣告onion地址, 设置SOCKS端口时可以连接其他节点的onion地址
+type onionConfig struct {
+	// Control 是Tor控制端口, 如127.0.0.1:9051, 为空时不创建onion服务
+	Control         string `yaml:"control"`
+	ControlPassword string `yaml:"control_password"`
+	// SOCKS 是Tor的SOCKS端口, 如127.0.0.1:9050, 为空时不连接onion地址
+	SOCKS string `yaml:"socks"`
+	// Port 是onion地址的端口, 为0时使用TCP监听端口
+	Port int `yaml:"port"`
+}
+
+// checkOnion 检查onion服务的设置, onion服务转发到本机的TCP监听端口
+func checkOnion(cfg *Config) error {
+	c := cfg.Onion
+	if c.SOCKS != "" {
+		if _, _, e := net.SplitHostPort(c.SOCKS); e != nil {
+			return fmt.Errorf("tor-socks地址错误: %w", e)
+		}
+	}
+	if c.Control == "" {
+		return nil
+	}
+	if _, _, e := net.SplitHostPort(c.Control); e != nil {
+		return fmt.Errorf("tor-control地址错误: %w", e)
+	}
+	if !cfg.Transports.TCP || cfg.tcpPort() == 0 {
+		return errors.New("onion服务需要启用TCP并设置固定的TCP端口")
+	}
+	if c.Port < 0 || c.Port > 65535 {
+		return fmt.Errorf("onion-port错误: %d", c.Port)
+	}
+	return nil
+}
+
+// torControl 是Tor控制端口的连接, 连接关闭时Tor删除通过它创建的onion服务
+type torControl struct {
+	conn net.Conn
+	r    *bufio.Reader
+	mu   sync.Mutex
+}
+
+// dialTorControl 连接控制端口并认证: 设置了密码时使用密码, 否则使用cookie文件或无需认证
+func dialTorControl(addr, password string) (*torControl, error) {
+	conn, e := net.DialTimeout("tcp", addr, connectTimeout)
+	if e != nil {
+		return nil, e
+	}
+	c := &torControl{conn: conn, r: bufio.NewReader(conn)}
+	if e := c.authenticate(password); e != nil {
+		_ = conn.Close()
+		return nil, e
+	}
+	return c, nil
+}
+
+func (c *torControl) authenticate(password string) error {
+	if password != "" {
+		_, e := c.command("AUTHENTICATE " + strconv.Quote(password))
+		return e
+	}
+	lines, e := c.command("PROTOCOLINFO 1")
+	if e != nil {
+		return e
+	}
+	var methods, cookieFile string
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "AUTH ") {
+			continue
+		}
+		for _, field := range strings.Fields(line[len("AUTH "):]) {
+			if strings.HasPrefix(field, "METHODS=") {
+				methods = strings.TrimPrefix(field, "METHODS=")
+			}
+		}
+		if i := strings.Index(line, "COOKIEFILE="); i >= 0 {
+			if v, e := strconv.Unquote(line[i+len("COOKIEFILE="):]); e == nil {
+				cookieFile = v
+			}
+		}
+	}
+	has := func(m string) bool {
+		for _, v := range strings.Split(methods, ",") {
+			if v == m {
+				return true
+			}
+		}
+		return false
+	}
+	switch {
+	case has("NULL"):
+		_, e = c.command("AUTHENTICATE")
+	case has("COOKIE") && cookieFile != "":
+		cookie, e := ioutil.ReadFile(cookieFile)
+		if e != nil {
+			return fmt.Errorf("读取Tor cookie文件出错: %w", e)
+		}
+		_, e = c.command("AUTHENTICATE " + hex.EncodeToString(cookie))
+		return e
+	default:
+		return fmt.Errorf("不支持Tor控制端口的认证方式 %s, 请设置tor-control-password", methods)
+	}
+	return e
+}
+
+// command 发送命令, 返回250回复的每一行(去掉状态码)
+func (c *torControl) command(cmd string) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, e := fmt.Fprintf(c.conn, "%s\r\n", cmd); e != nil {
+		return nil, e
+	}
+	var lines []string
+	for {
+		line, e := c.r.ReadString('\n')
+		if e != nil {
+			return nil, e
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if len(line) < 4 {
+			return nil, fmt.Errorf("Tor控制端口回复错误: %q", line)
+		}
+		if line[:3] != "250" {
+			return nil, fmt.Errorf("Tor控制端口: %s", line)
+		}
+		lines = append(lines, line[4:])
+		if line[3] == ' ' {
+			return lines, nil
+		}
+	}
+}
+
+func (c *torControl) close() error {
+	return c.conn.Close()
+}
+
+// onionService 通过Tor控制端口创建onion服务, 把onion地址的端口转发到本机的TCP监听端口,
+// 并宣告/onion3/<服务ID>:<端口>. 没有保存私钥时由Tor生成, 保存在keyPath中
+type onionService struct {
+	cfg      onionConfig
+	target   string
+	keyPath  string
+	announce ma.Multiaddr
+}
+
+// newOnionService 创建服务, target是TCP监听的host:port, keyPath为空时不保存私钥
+func newOnionService(cfg onionConfig, keyPath, target string) *onionService {
+	if cfg.Port == 0 {
+		_, port, _ := net.SplitHostPort(target)
+		cfg.Port, _ = strconv.Atoi(port)
+	}
+	return &onionService{cfg: cfg, target: target, keyPath: keyPath}
+}
+
+// start 连接控制端口创建服务, ctx取消时关闭连接, Tor随之删除服务
+func (s *onionService) start(ctx context.Context) error {
+	control, e := dialTorControl(s.cfg.Control, s.cfg.ControlPassword)
+	if e != nil {
+		return fmt.Errorf("连接Tor控制端口出错: %w", e)
+	}
+	key := "NEW:ED25519-V3"
+	flags := ""
+	if s.keyPath == "" {
+		flags = " Flags=DiscardPK"
+	} else if b, e := ioutil.ReadFile(s.keyPath); e == nil {
+		key = strings.TrimSpace(string(b))
+		flags = " Flags=DiscardPK"
+	} else if !os.IsNotExist(e) {
+		_ = control.close()
+		return e
+	}
+	lines, e := control.command(fmt.Sprint("ADD_ONION ", key, flags, " Port=", s.cfg.Port, ",", s.target))
+	if e != nil {
+		_ = control.close()
+		return fmt.Errorf("创建onion服务出错: %w", e)
+	}
+	var serviceID, privateKey string
+	for _, line := range lines {
+		if strings.HasPrefix(line, "ServiceID=") {
+			serviceID = strings.TrimPrefix(line, "ServiceID=")
+		} else if strings.HasPrefix(line, "PrivateKey=") {
+			privateKey = strings.TrimPrefix(line, "PrivateKey=")
+		}
+	}
+	if privateKey != "" {
+		if e := ioutil.WriteFile(s.keyPath, []byte(privateKey+"\n"), 0600); e != nil {
+			_ = control.close()
+			return fmt.Errorf("保存onion私钥出错: %w", e)
+		}
+	}
+	announce, e := ma.NewMultiaddr(fmt.Sprint("/onion3/", serviceID, ":", s.cfg.Port))
+	if e != nil {
+		_ = control.close()
+		return fmt.Errorf("onion地址错误: %w", e)
+	}
+	s.announce = announce
+	go func() {
+		<-ctx.Done()
+		_ = control.close()
+	}()
+	logger.Infow("onion服务", "addr", announce, "target", s.target)
+	return nil
+}
+
+// AddrsFactory 宣告onion地址
+func (s *onionService) AddrsFactory(addrs []ma.Multiaddr) []ma.Multiaddr {
+	if s.announce == nil {
+		return addrs
+	}
+	return append(addrs, s.announce)
+}
+
+func (s *onionService) status() interface{} {
+	status := map[string]interface{}{"target": s.target, "socks": s.cfg.SOCKS}
+	if s.announce != nil {
+		status["addr"] = s.announce.String()
+	}
+	return status
+}
+
+// onionKeyPath 返回数据目录中保存onion私钥的路径
+func onionKeyPath(dir string) string {
+	return filepath.Join(dir, onionKeyFile)
+}
+
+// onionTransport 通过Tor的SOCKS端口连接/onion3地址, 不监听.
+// 入站连接由onion服务转发到TCP监听端口
+type onionTransport struct {
+	upgrader transport.Upgrader
+	rcmgr    network.ResourceManager
+	dialer   proxy.ContextDialer
+}
+
+var _ transport.Transport = (*onionTransport)(nil)
+
+// newOnionDialer 返回连接Tor SOCKS端口的拨号器
+func newOnionDialer(socks string) (proxy.ContextDialer, error) {
+	d, e := proxy.SOCKS5("tcp", socks, nil, proxy.Direct)
+	if e != nil {
+		return nil, e
+	}
+	return d.(proxy.ContextDialer), nil
+}
+
+// newOnionTransport 返回libp2p.Transport使用的构造函数
+func newOnionTransport(dialer proxy.ContextDialer) func(transport.Upgrader, network.ResourceManager) *onionTransport {
+	return func(upgrader transport.Upgrader, rcmgr network.ResourceManager) *onionTransport {
+		return &onionTransport{upgrader: upgrader, rcmgr: rcmgr, dialer: dialer}
+	}
+}
+
+// onionHostPort 把/onion3/<服务ID>:<端口>转换为<服务ID>.onion:<端口>
+func onionHostPort(addr ma.Multiaddr) (string, error) {
+	v, e := addr.ValueForProtocol(ma.P_ONION3)
+	if e != nil {
+		return "", e
+	}
+	i := strings.LastIndex(v, ":")
+	if i < 0 {
+		return "", fmt.Errorf("onion地址错误: %s", addr)
+	}
+	return net.JoinHostPort(v[:i]+".onion", v[i+1:]), nil
+}
+
+func (t *onionTransport) CanDial(addr ma.Multiaddr) bool {
+	protocols := addr.Protocols()
+	return len(protocols) == 1 && protocols[0].Code == ma.P_ONION3
+}
+
+func (t *onionTransport) Dial(ctx context.Context, raddr ma.Multiaddr, p peer.ID) (transport.CapableConn, error) {
+	addr, e := onionHostPort(raddr)
+	if e != nil {
+		return nil, e
+	}
+	return upgradeProxied(ctx, t, t.upgrader, t.rcmgr, raddr, p, func(ctx context.Context) (net.Conn, error) {
+		c, e := t.dialer.DialContext(ctx, "tcp", addr)
+		if e != nil {
+			return nil, fmt.Errorf("通过Tor连接出错: %w", e)
+		}
+		return c, nil
+	})
+}
+
+func (t *onionTransport) Listen(laddr ma.Multiaddr) (transport.Listener, error) {
+	return nil, errors.New("onion地址由Tor监听, 请使用tor-control")
+}
+
+func (t *onionTransport) Protocols() []int {
+	return []int{ma.P_ONION3}
+}
+
+func (t *onionTransport) Proxy() bool {
+	return false
+}
+
+func (t *onionTransport) String() string {
+	return "Onion"
+}
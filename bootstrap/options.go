@@ -0,0 +1,66 @@
+package bootstrap
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// Option 修改节点的设置, 由New在创建节点时按顺序应用, 在配置之后生效
+type Option func(n *Node) error
+
+// WithListenAddrs 设置监听地址(multiaddr), 代替按端口和传输生成的地址
+func WithListenAddrs(addrs ...string) Option {
+	return func(n *Node) error {
+		for _, addr := range addrs {
+			if _, e := ma.NewMultiaddr(addr); e != nil {
+				return fmt.Errorf("监听地址错误 %s: %w", addr, e)
+			}
+		}
+		n.cfg.Listen = addrs
+		return nil
+	}
+}
+
+// WithIdentity 使用指定的私钥作为节点身份, 不读取或生成私钥文件, 也不使用身份轮换
+func WithIdentity(key crypto.PrivKey) Option {
+	return func(n *Node) error {
+		if key == nil {
+			return errors.New("私钥不能为空")
+		}
+		n.identity = key
+		return nil
+	}
+}
+
+// WithDHTMode 设置DHT模式: server, client, auto, auto-server
+func WithDHTMode(mode string) Option {
+	return func(n *Node) error {
+		if _, ok := dhtModes[mode]; !ok {
+			return fmt.Errorf("不支持的DHT模式 %s", mode)
+		}
+		n.cfg.DHT.Mode = mode
+		return nil
+	}
+}
+
+// WithRelayService 为其他节点提供中继
+func WithRelayService(enabled bool) Option {
+	return func(n *Node) error {
+		n.cfg.RelayService = enabled
+		return nil
+	}
+}
+
+// WithNetSim 启用网络模拟: 按概率拒绝建立连接, 拖慢握手, 定期断开随机连接. 用于测试重连和重新引导
+func WithNetSim(c NetSimConfig) Option {
+	return func(n *Node) error {
+		if e := c.validate(); e != nil {
+			return e
+		}
+		n.netSim = &c
+		return nil
+	}
+}
@@ -0,0 +1,130 @@
+package bootstrap
+
+import (
+	"encoding/json"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// peerExchangeProtocol 是向客户端提供已知节点的协议.
+// 客户端打开流后发送一个JSON请求{"count": N}, 收到一个节点列表JSON后关闭流.
+const peerExchangeProtocol protocol.ID = "/bootstrap/peers/1.0.0"
+
+// peerExchangeTimeout 是读取请求的超时时间
+const peerExchangeTimeout = time.Second * 10
+
+// peerExchangeRequest 是客户端的请求
+type peerExchangeRequest struct {
+	Count int `json:"count"`
+}
+
+// peerExchange 从当前连接的节点中随机选择有公网地址的节点, 不包括请求者.
+// 启用集群模式时同时从其他成员报告的节点中选择, 中继负载低的公网成员排在最前
+type peerExchange struct {
+	h       host.Host
+	max     int
+	limiter *messageLimiter
+	cluster *cluster
+
+	requests uint64
+	served   uint64
+}
+
+func newPeerExchange(h host.Host, max int, limiter *messageLimiter, cluster *cluster) *peerExchange {
+	return &peerExchange{h: h, max: max, limiter: limiter, cluster: cluster}
+}
+
+func (x *peerExchange) handle(s network.Stream) {
+	atomic.AddUint64(&x.requests, 1)
+	_ = s.SetReadDeadline(time.Now().Add(peerExchangeTimeout))
+	var req peerExchangeRequest
+	if e := json.NewDecoder(x.limiter.Reader(s)).Decode(&req); e != nil {
+		_ = s.Reset()
+		return
+	}
+	list := x.sample(s.Conn().RemotePeer(), req.Count)
+	if e := json.NewEncoder(s).Encode(list); e != nil {
+		_ = s.Reset()
+		return
+	}
+	atomic.AddUint64(&x.served, uint64(len(list)))
+	_ = s.Close()
+}
+
+// sample 随机选择最多count个已连接节点, count不超过上限
+func (x *peerExchange) sample(exclude peer.ID, count int) []peer.AddrInfo {
+	if count <= 0 || count > x.max {
+		count = x.max
+	}
+	peers := x.h.Network().Peers()
+	rand.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+	list := make([]peer.AddrInfo, 0, count)
+	for _, id := range peers {
+		if len(list) >= count {
+			break
+		}
+		if id == exclude || x.h.Network().Connectedness(id) != network.Connected {
+			continue
+		}
+		addrs := publicAddrs(x.h.Peerstore().Addrs(id))
+		if len(addrs) == 0 {
+			continue
+		}
+		list = append(list, peer.AddrInfo{ID: id, Addrs: addrs})
+	}
+	if x.cluster == nil {
+		return list
+	}
+	// 本节点和其他成员的节点混在一起随机选择
+	members, remote := x.cluster.candidates(exclude)
+	pool := append(list, remote...)
+	rand.Shuffle(len(pool), func(i, j int) { pool[i], pool[j] = pool[j], pool[i] })
+	mixed := make([]peer.AddrInfo, 0, count)
+	seen := make(map[peer.ID]bool)
+	// 最多四分之一是成员自己
+	for _, m := range members {
+		if len(mixed) >= (count+3)/4 {
+			break
+		}
+		seen[m.ID] = true
+		mixed = append(mixed, m)
+	}
+	for _, p := range pool {
+		if len(mixed) >= count {
+			break
+		}
+		if !seen[p.ID] {
+			seen[p.ID] = true
+			mixed = append(mixed, p)
+		}
+	}
+	return mixed
+}
+
+// publicAddrs 只返回公网地址, 客户端通常无法连接其他节点的内网地址
+func publicAddrs(addrs []ma.Multiaddr) []ma.Multiaddr {
+	var public []ma.Multiaddr
+	for _, addr := range addrs {
+		if manet.IsPublicAddr(addr) {
+			public = append(public, addr)
+		}
+	}
+	return public
+}
+
+// status 返回请求次数和提供的节点数量
+func (x *peerExchange) status() interface{} {
+	return map[string]interface{}{
+		"max":      x.max,
+		"requests": atomic.LoadUint64(&x.requests),
+		"served":   atomic.LoadUint64(&x.served),
+	}
+}
@@ -0,0 +1,94 @@
+package bootstrap
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+)
+
+// connectedPeer 是 /peers 中的一个连接
+type connectedPeer struct {
+	ID        string    `json:"id"`
+	Addr      string    `json:"addr"`
+	Direction string    `json:"direction"`
+	Transport string    `json:"transport"`
+	Opened    time.Time `json:"opened"`
+	// Geo 是设置了GeoIP数据库时对方地址的国家和ASN
+	Geo *geoLocation `json:"geo,omitempty"`
+}
+
+// peersHandler 以JSON形式提供当前的连接, geo不为nil时包括国家和ASN
+func peersHandler(n network.Network, geo *geoIP) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		conns := n.Conns()
+		list := make([]connectedPeer, 0, len(conns))
+		for _, c := range conns {
+			stat := c.Stat()
+			p := connectedPeer{
+				ID:        c.RemotePeer().String(),
+				Addr:      c.RemoteMultiaddr().String(),
+				Direction: stat.Direction.String(),
+				Transport: connTransport(c),
+				Opened:    stat.Opened,
+			}
+			if geo != nil {
+				loc := geo.lookup(c.RemoteMultiaddr())
+				p.Geo = &loc
+			}
+			list = append(list, p)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if e := json.NewEncoder(w).Encode(list); e != nil {
+			logger.Warnw("输出连接出错", "error", e)
+		}
+	}
+}
+
+// runPeers 查询运行中节点已连接的节点: bootstrap peers [ls].
+// 设置了管理接口时通过管理接口查询, 否则通过HTTP服务查询.
+func runPeers(args []string) int {
+	if len(args) > 0 && args[0] == "ls" {
+		args = args[1:]
+	}
+	fs := flag.NewFlagSet("peers", flag.ExitOnError)
+	cfg, e := commandConfig(fs, args)
+	if e != nil {
+		logger.Errorw("读取设置出错", "error", e)
+		return 1
+	}
+	if cfg.AdminAddr != "" {
+		return runAdminPeers(cfg)
+	}
+	if cfg.HTTPAddr == "" {
+		logger.Errorw("没有设置-admin-addr或-http-addr, 无法查询节点")
+		return 1
+	}
+	client := http.Client{Timeout: time.Second * 10}
+	resp, e := client.Get("http://" + cfg.HTTPAddr + "/peers")
+	if e != nil {
+		logger.Errorw("查询节点出错", "error", e)
+		return 1
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		logger.Errorw("查询节点出错", "status", resp.Status)
+		return 1
+	}
+	var list []connectedPeer
+	if e := json.NewDecoder(resp.Body).Decode(&list); e != nil {
+		logger.Errorw("解析结果出错", "error", e)
+		return 1
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, p := range list {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", p.ID, p.Addr, p.Direction, p.Transport, time.Since(p.Opened).Truncate(time.Second))
+	}
+	_ = tw.Flush()
+	return 0
+}
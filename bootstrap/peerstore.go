@@ -0,0 +1,124 @@
+package bootstrap
+
+import (
+	"context"
+	"time"
+
+	"github.com/libp2p/go-libp2p-kad-dht/providers"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/record"
+	"github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoreds"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// peerstoreDir 是数据目录中保存地址簿的目录
+const peerstoreDir = "peerstore"
+
+// checkPeerstore 检查地址簿存储: leveldb, badger或memory
+func checkPeerstore(kind string) error {
+	return checkDatastore("地址簿存储", kind)
+}
+
+// openPeerstore 打开dir下保存在磁盘上的地址簿或可以删除节点的内存地址簿, 返回的函数在主机关闭后调用.
+// gcInterval是保存在磁盘上的地址簿清除过期地址的间隔, 0使用默认值.
+func openPeerstore(ctx context.Context, kind, dir string, gcInterval time.Duration, cfg datastoreConfig) (peerstore.Peerstore, func(), error) {
+	if kind == "memory" {
+		ps := newMemoryPeerstore()
+		return ps, func() { _ = ps.Close() }, nil
+	}
+	store, e := openDatastore(kind, dir, peerstoreDir, cfg)
+	if e != nil {
+		return nil, nil, e
+	}
+	opts := pstoreds.DefaultOpts()
+	if gcInterval > 0 {
+		opts.GCPurgeInterval = gcInterval
+	}
+	ps, e := pstoreds.NewPeerstore(ctx, store, opts)
+	if e != nil {
+		_ = store.Close()
+		return nil, nil, e
+	}
+	return ps, func() {
+		_ = ps.Close()
+		_ = store.Close()
+	}, nil
+}
+
+// ttlPeerstore 把libp2p按默认有效期添加的地址换成本节点设置的有效期.
+// libp2p的有效期是整个进程共用的变量, 多网络模式下每个节点的设置不同, 所以在地址簿中替换
+type ttlPeerstore struct {
+	peerstore.Peerstore
+	ttls map[time.Duration]time.Duration
+}
+
+// certifiedTTLPeerstore 是支持签名的节点记录的地址簿, libp2p按地址簿是否实现CertifiedAddrBook决定是否保存记录
+type certifiedTTLPeerstore struct {
+	*ttlPeerstore
+	cab peerstore.CertifiedAddrBook
+}
+
+var _ peerstore.CertifiedAddrBook = (*certifiedTTLPeerstore)(nil)
+
+// newTTLPeerstore 按设置替换临时地址, 一般地址, 最近连接的节点地址和内容提供者地址的有效期
+func newTTLPeerstore(ps peerstore.Peerstore, c peerstoreGCConfig, discovered time.Duration) peerstore.Peerstore {
+	t := &ttlPeerstore{Peerstore: ps, ttls: map[time.Duration]time.Duration{
+		peerstore.TempAddrTTL:              c.TempAddrTTL,
+		peerstore.AddressTTL:               c.AddressTTL,
+		peerstore.RecentlyConnectedAddrTTL: discovered,
+		providers.ProviderAddrTTL:          c.ProviderAddrTTL,
+	}}
+	if cab, ok := peerstore.GetCertifiedAddrBook(ps); ok {
+		return &certifiedTTLPeerstore{ttlPeerstore: t, cab: cab}
+	}
+	return t
+}
+
+func (ps *ttlPeerstore) ttl(ttl time.Duration) time.Duration {
+	if v, ok := ps.ttls[ttl]; ok {
+		return v
+	}
+	return ttl
+}
+
+func (ps *ttlPeerstore) AddAddr(p peer.ID, addr ma.Multiaddr, ttl time.Duration) {
+	ps.Peerstore.AddAddr(p, addr, ps.ttl(ttl))
+}
+
+func (ps *ttlPeerstore) AddAddrs(p peer.ID, addrs []ma.Multiaddr, ttl time.Duration) {
+	ps.Peerstore.AddAddrs(p, addrs, ps.ttl(ttl))
+}
+
+func (ps *ttlPeerstore) SetAddr(p peer.ID, addr ma.Multiaddr, ttl time.Duration) {
+	ps.Peerstore.SetAddr(p, addr, ps.ttl(ttl))
+}
+
+func (ps *ttlPeerstore) SetAddrs(p peer.ID, addrs []ma.Multiaddr, ttl time.Duration) {
+	ps.Peerstore.SetAddrs(p, addrs, ps.ttl(ttl))
+}
+
+// UpdateAddrs 按替换后的有效期查找地址, libp2p断开连接时把ConnectedAddrTTL的地址改为RecentlyConnectedAddrTTL
+func (ps *ttlPeerstore) UpdateAddrs(p peer.ID, oldTTL, newTTL time.Duration) {
+	ps.Peerstore.UpdateAddrs(p, ps.ttl(oldTTL), ps.ttl(newTTL))
+}
+
+func (ps *certifiedTTLPeerstore) ConsumePeerRecord(s *record.Envelope, ttl time.Duration) (bool, error) {
+	return ps.cab.ConsumePeerRecord(s, ps.ttl(ttl))
+}
+
+func (ps *certifiedTTLPeerstore) GetPeerRecord(p peer.ID) *record.Envelope {
+	return ps.cab.GetPeerRecord(p)
+}
+
+// memoryStore 返回节点使用的内存地址簿, 使用保存在磁盘上的地址簿时返回nil
+func memoryStore(ps peerstore.Peerstore) *memoryPeerstore {
+	switch t := ps.(type) {
+	case *ttlPeerstore:
+		ps = t.Peerstore
+	case *certifiedTTLPeerstore:
+		ps = t.Peerstore
+	}
+	mem, _ := ps.(*memoryPeerstore)
+	return mem
+}
@@ -0,0 +1,284 @@
+package bootstrap
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// peerstoreExportVersion 是导出格式的版本
+const peerstoreExportVersion = 1
+
+// peerstoreImportMax 是导入请求的大小上限
+const peerstoreImportMax = 64 << 20
+
+// peerstoreImportTTL 是导入的地址默认的有效期
+const peerstoreImportTTL = time.Hour * 24
+
+// peerstoreCommands 是peerstore的子命令
+var peerstoreCommands = map[string]func(args []string) int{
+	"export": runPeerstoreExport,
+	"import": runPeerstoreImport,
+}
+
+// peerstoreEntry 是导出的一个节点
+type peerstoreEntry struct {
+	ID           string   `json:"id"`
+	Addrs        []string `json:"addrs"`
+	Protocols    []string `json:"protocols,omitempty"`
+	AgentVersion string   `json:"agent_version,omitempty"`
+}
+
+// peerstoreExport 是导出的地址簿
+type peerstoreExport struct {
+	Version  int              `json:"version"`
+	Exported time.Time        `json:"exported"`
+	Peers    []peerstoreEntry `json:"peers"`
+}
+
+// exportPeerstore 导出地址簿中有地址的节点, 不包括本节点(地址簿中有私钥的节点)
+func exportPeerstore(ps peerstore.Peerstore) peerstoreExport {
+	export := peerstoreExport{Version: peerstoreExportVersion, Exported: time.Now().UTC(), Peers: []peerstoreEntry{}}
+	for _, p := range ps.PeersWithAddrs() {
+		if ps.PrivKey(p) != nil {
+			continue
+		}
+		addrs := ps.Addrs(p)
+		if len(addrs) == 0 {
+			continue
+		}
+		entry := peerstoreEntry{ID: p.String()}
+		for _, addr := range addrs {
+			entry.Addrs = append(entry.Addrs, addr.String())
+		}
+		protocols, _ := ps.GetProtocols(p)
+		entry.Protocols = protocol.ConvertToStrings(protocols)
+		sort.Strings(entry.Protocols)
+		if v, e := ps.Get(p, "AgentVersion"); e == nil {
+			entry.AgentVersion, _ = v.(string)
+		}
+		export.Peers = append(export.Peers, entry)
+	}
+	sort.Slice(export.Peers, func(i, j int) bool { return export.Peers[i].ID < export.Peers[j].ID })
+	return export
+}
+
+// importPeerstore 把导出的节点加入地址簿, 地址有效期为ttl, 跳过本节点和无法解析的条目.
+// 返回加入的节点数量和跳过的条目数量
+func importPeerstore(ps peerstore.Peerstore, export peerstoreExport, ttl time.Duration) (imported, skipped int, e error) {
+	if export.Version != peerstoreExportVersion {
+		return 0, 0, fmt.Errorf("不支持的导出格式版本 %d", export.Version)
+	}
+	for _, entry := range export.Peers {
+		p, e := peer.Decode(entry.ID)
+		if e != nil || ps.PrivKey(p) != nil {
+			skipped++
+			continue
+		}
+		addrs := make([]ma.Multiaddr, 0, len(entry.Addrs))
+		for _, s := range entry.Addrs {
+			if addr, e := ma.NewMultiaddr(s); e == nil {
+				addrs = append(addrs, addr)
+			}
+		}
+		if len(addrs) == 0 {
+			skipped++
+			continue
+		}
+		ps.AddAddrs(p, addrs, ttl)
+		if len(entry.Protocols) > 0 {
+			_ = ps.AddProtocols(p, protocol.ConvertFromStrings(entry.Protocols)...)
+		}
+		if entry.AgentVersion != "" {
+			_ = ps.Put(p, "AgentVersion", entry.AgentVersion)
+		}
+		imported++
+	}
+	return imported, skipped, nil
+}
+
+// runPeerstore 执行地址簿子命令
+func runPeerstore(args []string) int {
+	if len(args) == 0 || peerstoreCommands[args[0]] == nil {
+		fmt.Fprintln(os.Stderr, "用法: bootstrap peerstore [export|import] [参数]")
+		return 2
+	}
+	return peerstoreCommands[args[0]](args[1:])
+}
+
+// openOfflinePeerstore 打开没有运行的节点保存在数据目录中的地址簿
+func openOfflinePeerstore(ctx context.Context, cfg *Config) (peerstore.Peerstore, func(), error) {
+	if cfg.Peerstore == "memory" {
+		return nil, nil, errors.New("地址簿没有保存在磁盘上(-peerstore memory), 只能通过管理接口访问运行中的节点")
+	}
+	dir, e := cfg.dataDir()
+	if e != nil {
+		return nil, nil, e
+	}
+	ps, closePeerstore, e := openPeerstore(ctx, cfg.Peerstore, dir, 0, cfg.Datastore)
+	if e != nil {
+		return nil, nil, fmt.Errorf("打开地址簿出错, 节点运行中时去掉-offline: %w", e)
+	}
+	return ps, closePeerstore, nil
+}
+
+// runPeerstoreExport 导出地址簿, 默认通过管理接口访问运行中的节点, -offline时读取数据目录中的地址簿
+func runPeerstoreExport(args []string) int {
+	fs := flag.NewFlagSet("peerstore export", flag.ExitOnError)
+	format := fs.String("format", "json", "导出格式, 当前只支持json")
+	output := fs.String("o", "", "导出到文件, 为空时输出到标准输出")
+	offline := fs.Bool("offline", false, "不访问运行中的节点, 直接读取数据目录中的地址簿(地址簿保存在磁盘上, 节点没有运行)")
+	cfg, e := commandConfig(fs, args)
+	if e != nil {
+		logger.Errorw("读取设置出错", "error", e)
+		return 1
+	}
+	if *format != "json" {
+		logger.Errorw("不支持的导出格式", "format", *format)
+		return 2
+	}
+	var export peerstoreExport
+	if *offline {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		ps, closePeerstore, e := openOfflinePeerstore(ctx, cfg)
+		if e != nil {
+			logger.Errorw("打开地址簿出错", "error", e)
+			return 1
+		}
+		export = exportPeerstore(ps)
+		closePeerstore()
+	} else {
+		c, e := newAdminClient(cfg)
+		if e == nil {
+			e = c.get("/v1/peerstore", &export)
+		}
+		if e != nil {
+			logger.Errorw("导出地址簿出错", "error", e)
+			return 1
+		}
+	}
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, e := os.OpenFile(*output, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if e != nil {
+			logger.Errorw("创建文件出错", "error", e)
+			return 1
+		}
+		defer f.Close()
+		w = f
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if e := enc.Encode(export); e != nil {
+		logger.Errorw("写入导出的地址簿出错", "error", e)
+		return 1
+	}
+	logger.Infow("已导出地址簿", "peers", len(export.Peers))
+	return 0
+}
+
+// adminPeerstoreImport 是 /v1/peerstore 导入的结果
+type adminPeerstoreImport struct {
+	Imported int `json:"imported"`
+	Skipped  int `json:"skipped"`
+}
+
+// runPeerstoreImport 导入地址簿, 文件为-或为空时从标准输入读取
+func runPeerstoreImport(args []string) int {
+	fs := flag.NewFlagSet("peerstore import", flag.ExitOnError)
+	ttl := fs.Duration("ttl", peerstoreImportTTL, "导入的地址的有效期, 期间节点可以连接这些地址, 之后没有重新发现的地址被清除")
+	offline := fs.Bool("offline", false, "不访问运行中的节点, 直接写入数据目录中的地址簿(地址簿保存在磁盘上, 节点没有运行)")
+	cfg, e := commandConfig(fs, args)
+	if e != nil {
+		logger.Errorw("读取设置出错", "error", e)
+		return 1
+	}
+	if fs.NArg() > 1 || *ttl <= 0 {
+		fmt.Fprintln(os.Stderr, "用法: bootstrap peerstore import [-ttl 24h] [-offline] [文件]")
+		return 2
+	}
+	var r io.Reader = os.Stdin
+	if path := fs.Arg(0); path != "" && path != "-" {
+		f, e := os.Open(path)
+		if e != nil {
+			logger.Errorw("打开文件出错", "error", e)
+			return 1
+		}
+		defer f.Close()
+		r = f
+	}
+	var export peerstoreExport
+	if e := json.NewDecoder(io.LimitReader(r, peerstoreImportMax)).Decode(&export); e != nil {
+		logger.Errorw("读取导出的地址簿出错", "error", e)
+		return 1
+	}
+	var result adminPeerstoreImport
+	if *offline {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		ps, closePeerstore, e := openOfflinePeerstore(ctx, cfg)
+		if e != nil {
+			logger.Errorw("打开地址簿出错", "error", e)
+			return 1
+		}
+		result.Imported, result.Skipped, e = importPeerstore(ps, export, *ttl)
+		closePeerstore()
+	} else {
+		var c *adminClient
+		c, e = newAdminClient(cfg)
+		if e == nil {
+			e = c.post("/v1/peerstore?ttl="+ttl.String(), export, &result)
+		}
+	}
+	if e != nil {
+		logger.Errorw("导入地址簿出错", "error", e)
+		return 1
+	}
+	logger.Infow("已导入地址簿", "imported", result.Imported, "skipped", result.Skipped)
+	return 0
+}
+
+// peerstore 导出(GET)或导入(POST, ?ttl=24h)地址簿
+func (a *adminAPI) peerstore(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeAdminJSON(w, exportPeerstore(a.h.Peerstore()))
+	case http.MethodPost:
+		ttl := peerstoreImportTTL
+		if v := r.URL.Query().Get("ttl"); v != "" {
+			d, e := time.ParseDuration(v)
+			if e != nil || d <= 0 {
+				writeAdminError(w, http.StatusBadRequest, fmt.Errorf("ttl错误: %s", v))
+				return
+			}
+			ttl = d
+		}
+		var export peerstoreExport
+		if e := json.NewDecoder(http.MaxBytesReader(w, r.Body, peerstoreImportMax)).Decode(&export); e != nil {
+			writeAdminError(w, http.StatusBadRequest, e)
+			return
+		}
+		imported, skipped, e := importPeerstore(a.h.Peerstore(), export, ttl)
+		if e != nil {
+			writeAdminError(w, http.StatusBadRequest, e)
+			return
+		}
+		logger.Infow("导入地址簿", "imported", imported, "skipped", skipped, "ttl", ttl)
+		writeAdminJSON(w, adminPeerstoreImport{Imported: imported, Skipped: skipped})
+	default:
+		writeAdminError(w, http.StatusMethodNotAllowed, errors.New("只支持GET和POST"))
+	}
+}
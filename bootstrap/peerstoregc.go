@@ -0,0 +1,489 @@
+package bootstrap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/core/record"
+	pstore "github.com/libp2p/go-libp2p/p2p/host/peerstore"
+	"github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoremem"
+)
+
+// peerstoreGCConfig 是地址簿的清理设置和地址有效期
+type peerstoreGCConfig struct {
+	// Interval 是清理地址簿的间隔, 0表示不清理
+	Interval time.Duration `yaml:"interval"`
+	// TempAddrTTL, AddressTTL 和 ProviderAddrTTL 是libp2p的临时地址(如DHT查询结果),
+	// 一般地址和内容提供者地址的有效期
+	TempAddrTTL     time.Duration `yaml:"temp_addr_ttl"`
+	AddressTTL      time.Duration `yaml:"address_ttl"`
+	ProviderAddrTTL time.Duration `yaml:"provider_addr_ttl"`
+}
+
+// validate 检查清理间隔和地址有效期
+func (c peerstoreGCConfig) validate() error {
+	if c.Interval < 0 {
+		return fmt.Errorf("地址簿清理间隔不能小于0: %s", c.Interval)
+	}
+	if c.TempAddrTTL <= 0 || c.AddressTTL <= 0 || c.ProviderAddrTTL <= 0 {
+		return fmt.Errorf("地址有效期必须大于0: temp_addr_ttl %s, address_ttl %s, provider_addr_ttl %s", c.TempAddrTTL, c.AddressTTL, c.ProviderAddrTTL)
+	}
+	return nil
+}
+
+// internLimit 是内存地址簿共用的协议名称数量上限, 超过后不再共用
+const internLimit = 1024
+
+// peerRecord 是内存地址簿中一个节点的公钥, 协议, 元数据和延迟
+type peerRecord struct {
+	pub       crypto.PubKey
+	priv      crypto.PrivKey
+	protocols map[protocol.ID]struct{}
+	metadata  map[string]interface{}
+	latency   time.Duration
+}
+
+// memoryPeerstore 是可以删除节点的内存地址簿. libp2p的内存地址簿只能清除地址,
+// 公钥, 协议, 元数据和延迟一直保留, 连接过的节点越多占用的内存越多.
+type memoryPeerstore struct {
+	peerstore.AddrBook
+
+	mu       sync.RWMutex
+	peers    map[peer.ID]*peerRecord
+	interned map[string]string
+}
+
+var _ peerstore.Peerstore = (*memoryPeerstore)(nil)
+var _ peerstore.CertifiedAddrBook = (*memoryPeerstore)(nil)
+
+func newMemoryPeerstore() *memoryPeerstore {
+	return &memoryPeerstore{
+		AddrBook: pstoremem.NewAddrBook(),
+		peers:    make(map[peer.ID]*peerRecord),
+		interned: make(map[string]string),
+	}
+}
+
+// record 返回节点的记录, 不存在时创建. 调用时持有写锁
+func (ps *memoryPeerstore) record(p peer.ID) *peerRecord {
+	r, ok := ps.peers[p]
+	if !ok {
+		r = &peerRecord{}
+		ps.peers[p] = r
+	}
+	return r
+}
+
+func (ps *memoryPeerstore) intern(proto string) string {
+	if v, ok := ps.interned[proto]; ok {
+		return v
+	}
+	if len(ps.interned) < internLimit {
+		ps.interned[proto] = proto
+	}
+	return proto
+}
+
+// removePeer 删除节点的公钥, 协议, 元数据和延迟, 地址由ClearAddrs清除
+func (ps *memoryPeerstore) removePeer(p peer.ID) {
+	ps.mu.Lock()
+	delete(ps.peers, p)
+	ps.mu.Unlock()
+}
+
+// RemovePeer 删除节点的公钥, 协议, 元数据和延迟, 不清除地址
+func (ps *memoryPeerstore) RemovePeer(p peer.ID) {
+	ps.removePeer(p)
+}
+
+// recorded 返回有记录的节点, 包括只有协议或元数据, 不在Peers中的节点
+func (ps *memoryPeerstore) recorded() peer.IDSlice {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	out := make(peer.IDSlice, 0, len(ps.peers))
+	for p := range ps.peers {
+		out = append(out, p)
+	}
+	return out
+}
+
+// size 返回记录的节点数量和估计占用的字节数
+func (ps *memoryPeerstore) size() (int, int) {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	bytes := 0
+	for p, r := range ps.peers {
+		bytes += len(p) + 64
+		if r.pub != nil {
+			if b, e := r.pub.Raw(); e == nil {
+				bytes += len(b)
+			}
+		}
+		bytes += len(r.protocols) * 16
+		bytes += len(r.metadata) * 32
+	}
+	return len(ps.peers), bytes
+}
+
+// ConsumePeerRecord 保存签名的节点记录, libp2p要求地址簿支持签名的节点记录
+func (ps *memoryPeerstore) ConsumePeerRecord(s *record.Envelope, ttl time.Duration) (bool, error) {
+	return ps.AddrBook.(peerstore.CertifiedAddrBook).ConsumePeerRecord(s, ttl)
+}
+
+func (ps *memoryPeerstore) GetPeerRecord(p peer.ID) *record.Envelope {
+	return ps.AddrBook.(peerstore.CertifiedAddrBook).GetPeerRecord(p)
+}
+
+func (ps *memoryPeerstore) Close() error {
+	if c, ok := ps.AddrBook.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+func (ps *memoryPeerstore) Peers() peer.IDSlice {
+	set := make(map[peer.ID]struct{})
+	for _, p := range ps.PeersWithKeys() {
+		set[p] = struct{}{}
+	}
+	for _, p := range ps.PeersWithAddrs() {
+		set[p] = struct{}{}
+	}
+	out := make(peer.IDSlice, 0, len(set))
+	for p := range set {
+		out = append(out, p)
+	}
+	return out
+}
+
+func (ps *memoryPeerstore) PeerInfo(p peer.ID) peer.AddrInfo {
+	return peer.AddrInfo{ID: p, Addrs: ps.Addrs(p)}
+}
+
+func (ps *memoryPeerstore) PeersWithKeys() peer.IDSlice {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	out := make(peer.IDSlice, 0, len(ps.peers))
+	for p, r := range ps.peers {
+		if r.pub != nil || r.priv != nil {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func (ps *memoryPeerstore) PubKey(p peer.ID) crypto.PubKey {
+	ps.mu.RLock()
+	r := ps.peers[p]
+	ps.mu.RUnlock()
+	if r != nil && r.pub != nil {
+		return r.pub
+	}
+	// 内联公钥的节点ID不需要保存公钥
+	pk, e := p.ExtractPublicKey()
+	if e != nil {
+		return nil
+	}
+	return pk
+}
+
+func (ps *memoryPeerstore) AddPubKey(p peer.ID, pk crypto.PubKey) error {
+	if !p.MatchesPublicKey(pk) {
+		return errors.New("公钥与节点ID不符")
+	}
+	ps.mu.Lock()
+	ps.record(p).pub = pk
+	ps.mu.Unlock()
+	return nil
+}
+
+func (ps *memoryPeerstore) PrivKey(p peer.ID) crypto.PrivKey {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	if r := ps.peers[p]; r != nil {
+		return r.priv
+	}
+	return nil
+}
+
+func (ps *memoryPeerstore) AddPrivKey(p peer.ID, sk crypto.PrivKey) error {
+	if sk == nil {
+		return errors.New("私钥为空")
+	}
+	if !p.MatchesPrivateKey(sk) {
+		return errors.New("私钥与节点ID不符")
+	}
+	ps.mu.Lock()
+	ps.record(p).priv = sk
+	ps.mu.Unlock()
+	return nil
+}
+
+func (ps *memoryPeerstore) Get(p peer.ID, key string) (interface{}, error) {
+	if e := p.Validate(); e != nil {
+		return nil, e
+	}
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	if r := ps.peers[p]; r != nil {
+		if v, ok := r.metadata[key]; ok {
+			return v, nil
+		}
+	}
+	return nil, peerstore.ErrNotFound
+}
+
+func (ps *memoryPeerstore) Put(p peer.ID, key string, val interface{}) error {
+	if e := p.Validate(); e != nil {
+		return e
+	}
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	// 客户端版本等字符串共用, 节点很多时节省内存
+	if s, ok := val.(string); ok {
+		val = ps.intern(s)
+	}
+	r := ps.record(p)
+	if r.metadata == nil {
+		r.metadata = make(map[string]interface{})
+	}
+	r.metadata[key] = val
+	return nil
+}
+
+func (ps *memoryPeerstore) RecordLatency(p peer.ID, next time.Duration) {
+	s := pstore.LatencyEWMASmoothing
+	if s > 1 || s < 0 {
+		s = 0.1
+	}
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	r := ps.record(p)
+	if r.latency == 0 {
+		r.latency = next
+		return
+	}
+	r.latency = time.Duration((1-s)*float64(r.latency) + s*float64(next))
+}
+
+func (ps *memoryPeerstore) LatencyEWMA(p peer.ID) time.Duration {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	if r := ps.peers[p]; r != nil {
+		return r.latency
+	}
+	return 0
+}
+
+func (ps *memoryPeerstore) GetProtocols(p peer.ID) ([]protocol.ID, error) {
+	if e := p.Validate(); e != nil {
+		return nil, e
+	}
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	var out []protocol.ID
+	if r := ps.peers[p]; r != nil {
+		out = make([]protocol.ID, 0, len(r.protocols))
+		for proto := range r.protocols {
+			out = append(out, proto)
+		}
+	}
+	return out, nil
+}
+
+func (ps *memoryPeerstore) AddProtocols(p peer.ID, protos ...protocol.ID) error {
+	if e := p.Validate(); e != nil {
+		return e
+	}
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	r := ps.record(p)
+	if r.protocols == nil {
+		r.protocols = make(map[protocol.ID]struct{}, len(protos))
+	}
+	for _, proto := range protos {
+		r.protocols[protocol.ID(ps.intern(string(proto)))] = struct{}{}
+	}
+	return nil
+}
+
+func (ps *memoryPeerstore) SetProtocols(p peer.ID, protos ...protocol.ID) error {
+	if e := p.Validate(); e != nil {
+		return e
+	}
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	r := ps.record(p)
+	r.protocols = make(map[protocol.ID]struct{}, len(protos))
+	for _, proto := range protos {
+		r.protocols[protocol.ID(ps.intern(string(proto)))] = struct{}{}
+	}
+	return nil
+}
+
+func (ps *memoryPeerstore) RemoveProtocols(p peer.ID, protos ...protocol.ID) error {
+	if e := p.Validate(); e != nil {
+		return e
+	}
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if r := ps.peers[p]; r != nil {
+		for _, proto := range protos {
+			delete(r.protocols, proto)
+		}
+	}
+	return nil
+}
+
+func (ps *memoryPeerstore) SupportsProtocols(p peer.ID, protos ...protocol.ID) ([]protocol.ID, error) {
+	if e := p.Validate(); e != nil {
+		return nil, e
+	}
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	out := make([]protocol.ID, 0, len(protos))
+	if r := ps.peers[p]; r != nil {
+		for _, proto := range protos {
+			if _, ok := r.protocols[proto]; ok {
+				out = append(out, proto)
+			}
+		}
+	}
+	return out, nil
+}
+
+func (ps *memoryPeerstore) FirstSupportedProtocol(p peer.ID, protos ...protocol.ID) (protocol.ID, error) {
+	supported, e := ps.SupportsProtocols(p, protos...)
+	if e != nil || len(supported) == 0 {
+		return "", e
+	}
+	return supported[0], nil
+}
+
+// peerstoreGC 定期清理地址簿: 没有连接且地址都已过期的节点被删除. 内存地址簿同时删除公钥,
+// 协议和元数据; leveldb地址簿的这些数据在磁盘上, 只清除地址.
+type peerstoreGC struct {
+	h        host.Host
+	interval time.Duration
+
+	removed uint64
+	runs    uint64
+
+	mu      sync.Mutex
+	last    time.Time
+	peers   int
+	addrs   int
+	records int
+	bytes   int
+}
+
+func newPeerstoreGC(h host.Host, interval time.Duration) *peerstoreGC {
+	return &peerstoreGC{h: h, interval: interval}
+}
+
+func (g *peerstoreGC) run(ctx context.Context) {
+	ticker := time.NewTicker(g.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if removed := g.collect(); removed > 0 {
+				logger.Infow("已清理地址簿", "removed", removed)
+			}
+		}
+	}
+}
+
+// collect 清理一次地址簿, 返回删除的节点数量
+func (g *peerstoreGC) collect() int {
+	ps := g.h.Peerstore()
+	mem := memoryStore(ps)
+	self := g.h.ID()
+	candidates := ps.Peers()
+	if mem != nil {
+		candidates = append(candidates, mem.recorded()...)
+	}
+	seen := make(map[peer.ID]bool, len(candidates))
+	removed, addrs := 0, 0
+	for _, p := range candidates {
+		if seen[p] {
+			continue
+		}
+		seen[p] = true
+		if p == self || g.h.Network().Connectedness(p) == network.Connected {
+			addrs += len(ps.Addrs(p))
+			continue
+		}
+		n := len(ps.Addrs(p))
+		if n > 0 {
+			addrs += n
+			continue
+		}
+		// Addrs只返回未过期的地址, 清除剩下的过期地址
+		ps.ClearAddrs(p)
+		if mem != nil {
+			mem.removePeer(p)
+		}
+		removed++
+	}
+	atomic.AddUint64(&g.removed, uint64(removed))
+	atomic.AddUint64(&g.runs, 1)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.last = time.Now()
+	g.peers = len(ps.Peers())
+	g.addrs = addrs
+	if mem != nil {
+		g.records, g.bytes = mem.size()
+		g.bytes += addrs * 64
+	}
+	return removed
+}
+
+// writeMetrics 输出地址簿的Prometheus指标, 估计的内存只在使用内存地址簿时输出
+func (g *peerstoreGC) writeMetrics(w io.Writer) {
+	g.mu.Lock()
+	addrs, records, bytes := g.addrs, g.records, g.bytes
+	g.mu.Unlock()
+	writeMetric(w, "bootstrap_peerstore_addrs", "gauge", "上次清理时地址簿中未过期的地址数量", uint64(addrs))
+	writeMetric(w, "bootstrap_peerstore_gc_removed_total", "counter", "清理地址簿删除的节点数量", atomic.LoadUint64(&g.removed))
+	if memoryStore(g.h.Peerstore()) != nil {
+		writeMetric(w, "bootstrap_peerstore_records", "gauge", "上次清理时内存地址簿中有公钥, 协议或元数据的节点数量", uint64(records))
+		writeMetric(w, "bootstrap_peerstore_estimated_bytes", "gauge", "上次清理时估计的内存地址簿占用的字节数", uint64(bytes))
+	}
+}
+
+// status 返回清理设置和上次清理的结果
+func (g *peerstoreGC) status() interface{} {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	out := map[string]interface{}{
+		"interval": g.interval.String(),
+		"runs":     atomic.LoadUint64(&g.runs),
+		"removed":  atomic.LoadUint64(&g.removed),
+		"peers":    g.peers,
+		"addrs":    g.addrs,
+	}
+	if !g.last.IsZero() {
+		out["last"] = g.last
+	}
+	if memoryStore(g.h.Peerstore()) != nil {
+		out["records"] = g.records
+		out["estimated_bytes"] = g.bytes
+	}
+	return out
+}
@@ -0,0 +1,163 @@
+package bootstrap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// ServiceEnv 是附加服务可以使用的节点部分
+type ServiceEnv struct {
+	Host host.Host
+	DHT  *dht.IpfsDHT
+	// HTTP 是节点HTTP服务的路由, 没有设置http_addr时不对外提供, 多网络模式下在 /networks/<名称>/ 下
+	HTTP *http.ServeMux
+	// Status 在 /status 中加入一项
+	Status func(name string, fn func() interface{})
+}
+
+// Service 是随节点启动的附加服务. Start在节点启动时调用, 这时还没有连接引导节点,
+// 返回错误时节点启动失败. Close在节点关闭时调用, 在关闭DHT和主机之前
+type Service interface {
+	Start(ctx context.Context, env ServiceEnv) error
+	Close() error
+}
+
+// plugin 是一个附加的协议处理器或服务, 两者只有一个
+type plugin struct {
+	name     string
+	protocol protocol.ID
+	handler  network.StreamHandler
+	service  Service
+	// newService 是全局注册的服务, 每个节点创建一个
+	newService func() Service
+}
+
+var (
+	pluginsMu sync.Mutex
+	plugins   []plugin
+	// pluginNames 是已注册的协议和服务名称, 避免重复
+	pluginNames = make(map[string]bool)
+)
+
+// RegisterStreamHandler 注册所有节点都提供的协议处理器. 与database/sql的驱动一样在init中调用,
+// 程序只要导入注册的包并调用Main, 就能在同一个二进制中提供应用自己的协议. 重复注册时panic
+func RegisterStreamHandler(proto protocol.ID, handler network.StreamHandler) {
+	registerPlugin(plugin{name: string(proto), protocol: proto, handler: handler})
+}
+
+// RegisterService 注册所有节点都启动的附加服务, newService为每个节点创建服务. 重复注册时panic
+func RegisterService(name string, newService func() Service) {
+	registerPlugin(plugin{name: name, newService: newService})
+}
+
+func registerPlugin(p plugin) {
+	if p.name == "" || (p.handler == nil && p.newService == nil) {
+		panic("bootstrap: 注册的名称和处理器不能为空")
+	}
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+	if pluginNames[p.name] {
+		panic("bootstrap: 重复注册 " + p.name)
+	}
+	pluginNames[p.name] = true
+	plugins = append(plugins, p)
+}
+
+// WithStreamHandler 在本节点上提供协议处理器, 与RegisterStreamHandler相同但只用于这个节点
+func WithStreamHandler(proto protocol.ID, handler network.StreamHandler) Option {
+	return func(n *Node) error {
+		if proto == "" || handler == nil {
+			return errors.New("协议和处理器不能为空")
+		}
+		n.plugins = append(n.plugins, plugin{name: string(proto), protocol: proto, handler: handler})
+		return nil
+	}
+}
+
+// WithService 在本节点上启动附加服务, 与RegisterService相同但只用于这个节点
+func WithService(name string, service Service) Option {
+	return func(n *Node) error {
+		if name == "" || service == nil {
+			return errors.New("服务名称和服务不能为空")
+		}
+		n.plugins = append(n.plugins, plugin{name: name, service: service})
+		return nil
+	}
+}
+
+// nodePlugins 返回全局注册的和本节点的协议处理器与服务, 全局注册的服务在这里创建.
+// 名称重复时返回错误
+func (n *Node) nodePlugins() ([]plugin, error) {
+	pluginsMu.Lock()
+	list := make([]plugin, 0, len(plugins)+len(n.plugins))
+	for _, p := range plugins {
+		if p.newService != nil {
+			p.service = p.newService()
+		}
+		list = append(list, p)
+	}
+	pluginsMu.Unlock()
+	seen := make(map[string]bool, len(list))
+	for _, p := range list {
+		seen[p.name] = true
+	}
+	for _, p := range n.plugins {
+		if seen[p.name] {
+			return nil, fmt.Errorf("重复的协议或服务 %s", p.name)
+		}
+		seen[p.name] = true
+		list = append(list, p)
+	}
+	return list, nil
+}
+
+// startPlugins 设置协议处理器并按顺序启动服务. 协议已经由节点使用时返回错误.
+// 启动出错时关闭已启动的服务. 返回的函数关闭所有服务
+func startPlugins(ctx context.Context, list []plugin, env ServiceEnv) (func() error, error) {
+	used := make(map[protocol.ID]bool)
+	for _, proto := range env.Host.Mux().Protocols() {
+		used[protocol.ID(proto)] = true
+	}
+	var started []plugin
+	closeAll := func() error {
+		var errs []string
+		for i := len(started) - 1; i >= 0; i-- {
+			if e := started[i].service.Close(); e != nil {
+				errs = append(errs, fmt.Sprintf("%s: %s", started[i].name, e))
+			}
+		}
+		if len(errs) > 0 {
+			sort.Strings(errs)
+			return fmt.Errorf("关闭附加服务出错: %v", errs)
+		}
+		return nil
+	}
+	for _, p := range list {
+		if p.handler != nil {
+			if used[p.protocol] {
+				_ = closeAll()
+				return nil, fmt.Errorf("协议 %s 已经由节点使用", p.protocol)
+			}
+			used[p.protocol] = true
+			env.Host.SetStreamHandler(p.protocol, p.handler)
+			logger.Infow("附加协议", "protocol", p.protocol)
+			continue
+		}
+		if e := p.service.Start(ctx, env); e != nil {
+			_ = closeAll()
+			return nil, fmt.Errorf("启动附加服务 %s 出错: %w", p.name, e)
+		}
+		started = append(started, p)
+		logger.Infow("附加服务已启动", "name", p.name)
+	}
+	return closeAll, nil
+}
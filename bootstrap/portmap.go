@@ -0,0 +1,265 @@
+package bootstrap
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	basichost "github.com/libp2p/go-libp2p/p2p/host/basic"
+	nat "github.com/libp2p/go-nat"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+const (
+	// portMappingDuration 是端口映射的有效期, 在到期前刷新
+	portMappingDuration = time.Minute * 2
+	// portMappingRefresh 是刷新端口映射的间隔
+	portMappingRefresh = time.Minute
+	// natDiscoveryTimeout 是查找路由器的超时时间
+	natDiscoveryTimeout = time.Second * 10
+)
+
+// portMapping 是一个端口映射
+type portMapping struct {
+	Protocol     string `json:"protocol"`
+	InternalPort int    `json:"internal_port"`
+	ExternalPort int    `json:"external_port"`
+}
+
+// portMapper 通过UPnP或NAT-PMP在路由器上映射监听端口.
+// 与libp2p.NATPortMap()不同, 可以单独启用UPnP或NAT-PMP, 避开路由器上有问题的一种.
+// 映射得到的外部地址通过地址工厂加入节点地址.
+type portMapper struct {
+	enableUPnP   bool
+	enableNATPMP bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	ready  chan struct{}
+
+	mu         sync.RWMutex
+	nat        nat.NAT
+	externalIP net.IP
+	mappings   map[string]portMapping
+	addrs      []ma.Multiaddr
+}
+
+func newPortMapper(enableUPnP, enableNATPMP bool) *portMapper {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &portMapper{
+		enableUPnP:   enableUPnP,
+		enableNATPMP: enableNATPMP,
+		ctx:          ctx,
+		cancel:       cancel,
+		ready:        make(chan struct{}),
+		mappings:     make(map[string]portMapping),
+	}
+}
+
+// natType 返回映射方式的名称
+func natType(n nat.NAT) string {
+	if strings.HasPrefix(n.Type(), "UPNP") {
+		return "UPnP"
+	}
+	return n.Type()
+}
+
+// allowed 判断是否允许使用此映射方式
+func (pm *portMapper) allowed(n nat.NAT) bool {
+	if natType(n) == "UPnP" {
+		return pm.enableUPnP
+	}
+	return pm.enableNATPMP
+}
+
+// manager 是libp2p使用的NAT管理器构造函数
+func (pm *portMapper) manager(n network.Network) basichost.NATManager {
+	go pm.run(n)
+	return pm
+}
+
+func (pm *portMapper) run(n network.Network) {
+	discovered := pm.discover()
+	close(pm.ready)
+	if discovered == nil {
+		logger.Warnw("没有找到可用的端口映射路由器")
+		return
+	}
+	logger.Infow("使用端口映射", "type", natType(discovered))
+
+	ticker := time.NewTicker(portMappingRefresh)
+	defer ticker.Stop()
+	for {
+		pm.refresh(n)
+		select {
+		case <-pm.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// discover 查找允许使用的路由器, 找不到时返回nil
+func (pm *portMapper) discover() nat.NAT {
+	ctx, cancel := context.WithTimeout(pm.ctx, natDiscoveryTimeout)
+	defer cancel()
+	for n := range nat.DiscoverNATs(ctx) {
+		if !pm.allowed(n) {
+			logger.Infow("跳过已禁用的端口映射", "type", natType(n))
+			continue
+		}
+		if _, e := n.GetDeviceAddress(); e != nil {
+			continue
+		}
+		pm.mu.Lock()
+		pm.nat = n
+		pm.mu.Unlock()
+		return n
+	}
+	return nil
+}
+
+// refresh 映射所有监听端口
+func (pm *portMapper) refresh(n network.Network) {
+	pm.mu.RLock()
+	device := pm.nat
+	pm.mu.RUnlock()
+
+	externalIP, e := device.GetExternalAddress()
+	if e != nil {
+		logger.Warnw("获取路由器外部地址出错", "error", e)
+		return
+	}
+
+	var addrs []ma.Multiaddr
+	for _, listenAddr := range n.ListenAddresses() {
+		protocol, internalPort, rest, ok := splitListenAddr(listenAddr)
+		if !ok {
+			continue
+		}
+		externalPort, e := device.AddPortMapping(pm.ctx, protocol, internalPort, "libp2p-bootstrap", portMappingDuration)
+		if e != nil {
+			logger.Warnw("映射端口出错", "nat", natType(device), "protocol", protocol, "port", internalPort, "error", e)
+			continue
+		}
+
+		key := fmt.Sprint(protocol, internalPort)
+		pm.mu.Lock()
+		old, existed := pm.mappings[key]
+		pm.mappings[key] = portMapping{Protocol: protocol, InternalPort: internalPort, ExternalPort: externalPort}
+		pm.mu.Unlock()
+		if !existed || old.ExternalPort != externalPort {
+			logger.Infow("映射端口", "type", natType(device), "protocol", protocol, "internal_port", internalPort, "external_ip", externalIP, "external_port", externalPort)
+		}
+
+		addr, e := externalAddr(externalIP, protocol, externalPort, rest)
+		if e == nil {
+			addrs = append(addrs, addr)
+		}
+	}
+
+	pm.mu.Lock()
+	pm.externalIP = externalIP
+	pm.addrs = addrs
+	pm.mu.Unlock()
+}
+
+// splitListenAddr 拆分监听地址, 返回传输层协议, 端口和之后的部分(如/quic-v1)
+func splitListenAddr(addr ma.Multiaddr) (protocol string, port int, rest ma.Multiaddr, ok bool) {
+	ipPart, tail := ma.SplitFirst(addr)
+	if ipPart == nil || tail == nil || ipPart.Protocol().Code != ma.P_IP4 {
+		return "", 0, nil, false
+	}
+	transportPart, rest := ma.SplitFirst(tail)
+	if transportPart == nil {
+		return "", 0, nil, false
+	}
+	switch transportPart.Protocol().Code {
+	case ma.P_TCP:
+		protocol = "tcp"
+	case ma.P_UDP:
+		protocol = "udp"
+	default:
+		return "", 0, nil, false
+	}
+	port, e := strconv.Atoi(transportPart.Value())
+	if e != nil {
+		return "", 0, nil, false
+	}
+	return protocol, port, rest, true
+}
+
+// externalAddr 组合外部地址
+func externalAddr(ip net.IP, protocol string, port int, rest ma.Multiaddr) (ma.Multiaddr, error) {
+	addr, e := ma.NewMultiaddr(fmt.Sprintf("/ip4/%s/%s/%d", ip, protocol, port))
+	if e != nil || rest == nil {
+		return addr, e
+	}
+	return addr.Encapsulate(rest), nil
+}
+
+// AddrsFactory 在节点地址中加入映射得到的外部地址
+func (pm *portMapper) AddrsFactory(addrs []ma.Multiaddr) []ma.Multiaddr {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	return append(addrs, pm.addrs...)
+}
+
+// GetMapping 实现basichost.NATManager, 外部地址通过AddrsFactory提供, 这里不使用
+func (pm *portMapper) GetMapping(ma.Multiaddr) ma.Multiaddr {
+	return nil
+}
+
+// HasDiscoveredNAT 返回是否找到了可用的路由器
+func (pm *portMapper) HasDiscoveredNAT() bool {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	return pm.nat != nil
+}
+
+// Ready 在查找路由器结束后关闭
+func (pm *portMapper) Ready() <-chan struct{} {
+	return pm.ready
+}
+
+// Close 删除端口映射
+func (pm *portMapper) Close() error {
+	pm.cancel()
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	if pm.nat != nil {
+		for _, m := range pm.mappings {
+			_ = pm.nat.DeletePortMapping(context.Background(), m.Protocol, m.InternalPort)
+		}
+	}
+	pm.mappings = make(map[string]portMapping)
+	pm.addrs = nil
+	return nil
+}
+
+// status 返回端口映射情况
+func (pm *portMapper) status() interface{} {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	s := map[string]interface{}{
+		"upnp":    pm.enableUPnP,
+		"nat_pmp": pm.enableNATPMP,
+	}
+	if pm.nat != nil {
+		s["type"] = natType(pm.nat)
+	}
+	if pm.externalIP != nil {
+		s["external_ip"] = pm.externalIP.String()
+	}
+	mappings := make([]portMapping, 0, len(pm.mappings))
+	for _, m := range pm.mappings {
+		mappings = append(mappings, m)
+	}
+	s["mappings"] = mappings
+	return s
+}
@@ -0,0 +1,36 @@
+package bootstrap
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"time"
+)
+
+// checkPprofAddr 检查pprof地址, 只允许本机地址, 远程使用时通过SSH端口转发
+func checkPprofAddr(addr string) error {
+	if e := checkLoopbackAddr(addr); e != nil {
+		return fmt.Errorf("pprof: %w", e)
+	}
+	return nil
+}
+
+// startPprof 在单独的HTTP服务上提供 /debug/pprof/, 返回的服务在关闭节点时关闭.
+// profile和trace按参数持续采样, 不设置写入超时
+func startPprof(addr string) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	server := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: time.Second * 10}
+	go func() {
+		logger.Infow("pprof地址", "addr", addr)
+		e := server.ListenAndServe()
+		if e != nil && e != http.ErrServerClosed {
+			logger.Errorw("pprof服务出错", "error", e)
+		}
+	}()
+	return server
+}
@@ -0,0 +1,66 @@
+package bootstrap
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// profiles 是预设的设置组合, 在默认值之后, 配置文件之前应用, 各项设置仍然可以单独修改
+var profiles = map[string]func(c *Config){
+	// 有公网IP的公共引导节点: 连接数量多, 不需要端口映射, 限制建立慢的连接
+	"public-bootstrap": func(c *Config) {
+		c.ConnMgr.LowWater = 1000
+		c.ConnMgr.HighWater = 2000
+		c.ConnMgr.GracePeriod = time.Minute
+		c.NATPortMap = false
+		c.SetupBudget = time.Second * 30
+		c.RelayService = false
+		// 连接过多时先断开延迟高和丢包多的节点, 它们拖慢DHT查询
+		c.Scoring.PruneLatency = time.Second
+		c.Scoring.PruneLoss = 0.3
+		// DHT的流很短, 数据很少, 小窗口节省内存
+		c.Muxers.YamuxWindow = yamuxMinWindow
+	},
+	// 私有网络: 不连接IPFS引导节点, 没有指定引导节点时作为第一个节点
+	"private-network": func(c *Config) {
+		c.DefaultBootstrap = false
+		c.ConnMgr.LowWater = 50
+		c.ConnMgr.HighWater = 200
+		c.ConnMgr.GracePeriod = time.Minute
+		c.MinPeers = 4
+		c.AnnouncePrivate = true
+		// 内网节点通常在同一网段
+		c.Inbound.MaxPerSubnet = 0
+	},
+	// 中继节点: 为NAT后的节点提供中继
+	"relay-only": func(c *Config) {
+		c.RelayService = true
+		c.ConnMgr.LowWater = 200
+		c.ConnMgr.HighWater = 800
+		c.ConnMgr.GracePeriod = time.Minute * 2
+		c.NATPortMap = false
+	},
+}
+
+// profileNames 返回所有预设的名称
+func profileNames() []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// applyProfile 应用预设
+func (c *Config) applyProfile(name string) error {
+	apply, ok := profiles[name]
+	if !ok {
+		return fmt.Errorf("未知的预设 %s, 可用的预设: %s", name, strings.Join(profileNames(), ", "))
+	}
+	apply(c)
+	c.Profile = name
+	return nil
+}
@@ -0,0 +1,89 @@
+package bootstrap
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/connmgr"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// 保护标签
+const (
+	protectTagBootstrap = "bootstrap"
+	protectTagTrusted   = "trusted"
+	protectTagBanSync   = "ban_sync"
+)
+
+// peerProtector 记录连接管理器中受保护的节点, 受保护的节点不会被修剪
+type peerProtector struct {
+	cm connmgr.ConnManager
+
+	mu        sync.Mutex
+	protected map[peer.ID]map[string]struct{}
+}
+
+func newPeerProtector(cm connmgr.ConnManager) *peerProtector {
+	return &peerProtector{cm: cm, protected: make(map[peer.ID]map[string]struct{})}
+}
+
+// Protect 以tag保护节点
+func (p *peerProtector) Protect(id peer.ID, tag string) {
+	p.cm.Protect(id, tag)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	tags, ok := p.protected[id]
+	if !ok {
+		tags = make(map[string]struct{})
+		p.protected[id] = tags
+	}
+	tags[tag] = struct{}{}
+}
+
+// Unprotect 取消节点的tag保护
+func (p *peerProtector) Unprotect(id peer.ID, tag string) {
+	p.cm.Unprotect(id, tag)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.protected[id], tag)
+	if len(p.protected[id]) == 0 {
+		delete(p.protected, id)
+	}
+}
+
+// isProtected 判断节点是否受保护
+func (p *peerProtector) isProtected(id peer.ID) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.protected[id]
+	return ok
+}
+
+// tagged 返回以tag保护的节点
+func (p *peerProtector) tagged(tag string) []peer.ID {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var ids []peer.ID
+	for id, tags := range p.protected {
+		if _, ok := tags[tag]; ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// status 返回受保护的节点及其标签
+func (p *peerProtector) status() interface{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	m := make(map[string][]string, len(p.protected))
+	for id, tags := range p.protected {
+		list := make([]string, 0, len(tags))
+		for tag := range tags {
+			list = append(list, tag)
+		}
+		sort.Strings(list)
+		m[id.String()] = list
+	}
+	return m
+}
@@ -0,0 +1,141 @@
+package bootstrap
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/transport"
+	tcp "github.com/libp2p/go-libp2p/p2p/transport/tcp"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"golang.org/x/net/proxy"
+)
+
+// proxyConnectTimeout 是通过代理建立TCP连接的超时时间, 与TCP传输的默认值相同
+const proxyConnectTimeout = time.Second * 5
+
+// newProxyDialer 解析代理地址socks5://[用户名:密码@]主机:端口, 端口默认1080.
+// socks5h与socks5相同, 连接节点时总是使用IP地址
+func newProxyDialer(rawURL string) (proxy.ContextDialer, error) {
+	u, e := url.Parse(rawURL)
+	if e != nil {
+		return nil, fmt.Errorf("代理地址错误: %w", e)
+	}
+	if u.Scheme != "socks5" && u.Scheme != "socks5h" {
+		return nil, fmt.Errorf("只支持socks5代理: %s", u.Redacted())
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("代理地址缺少主机: %s", u.Redacted())
+	}
+	d, e := proxy.FromURL(u, proxy.Direct)
+	if e != nil {
+		return nil, e
+	}
+	cd, ok := d.(proxy.ContextDialer)
+	if !ok {
+		return nil, fmt.Errorf("代理不支持取消连接: %s", u.Redacted())
+	}
+	return cd, nil
+}
+
+// redactProxy 返回去掉密码的代理地址, 用于日志
+func redactProxy(rawURL string) string {
+	u, e := url.Parse(rawURL)
+	if e != nil {
+		return ""
+	}
+	return u.Redacted()
+}
+
+// proxyTCPTransport 是通过SOCKS5代理建立出站连接的TCP传输, 入站连接仍直接监听
+type proxyTCPTransport struct {
+	*tcp.TcpTransport
+	upgrader transport.Upgrader
+	rcmgr    network.ResourceManager
+	dialer   proxy.ContextDialer
+}
+
+var _ transport.Transport = (*proxyTCPTransport)(nil)
+
+// newProxyTCPTransport 返回libp2p.Transport使用的构造函数
+func newProxyTCPTransport(dialer proxy.ContextDialer) func(transport.Upgrader, network.ResourceManager) (*proxyTCPTransport, error) {
+	return func(upgrader transport.Upgrader, rcmgr network.ResourceManager) (*proxyTCPTransport, error) {
+		// 出站连接的源端口由代理决定, 监听也不需要复用端口
+		t, e := tcp.NewTCPTransport(upgrader, rcmgr, tcp.DisableReuseport())
+		if e != nil {
+			return nil, e
+		}
+		return &proxyTCPTransport{TcpTransport: t, upgrader: upgrader, rcmgr: rcmgr, dialer: dialer}, nil
+	}
+}
+
+// Dial 通过代理连接节点, 连接的对方地址是节点的地址而不是代理的地址
+func (t *proxyTCPTransport) Dial(ctx context.Context, raddr ma.Multiaddr, p peer.ID) (transport.CapableConn, error) {
+	return upgradeProxied(ctx, t, t.upgrader, t.rcmgr, raddr, p, func(ctx context.Context) (net.Conn, error) {
+		ctx, cancel := context.WithTimeout(ctx, proxyConnectTimeout)
+		defer cancel()
+		network, addr, e := manet.DialArgs(raddr)
+		if e != nil {
+			return nil, e
+		}
+		c, e := t.dialer.DialContext(ctx, network, addr)
+		if e != nil {
+			return nil, fmt.Errorf("通过代理连接出错: %w", e)
+		}
+		return c, nil
+	})
+}
+
+// DialWithUpdates 同样通过代理连接, 不报告拨号进度
+func (t *proxyTCPTransport) DialWithUpdates(ctx context.Context, raddr ma.Multiaddr, p peer.ID, _ chan<- transport.DialUpdate) (transport.CapableConn, error) {
+	return t.Dial(ctx, raddr, p)
+}
+
+func (t *proxyTCPTransport) String() string {
+	return "TCP(SOCKS5)"
+}
+
+// upgradeProxied 在资源管理器中登记出站连接, 用dial建立经过代理的连接并升级
+func upgradeProxied(ctx context.Context, t transport.Transport, upgrader transport.Upgrader, rcmgr network.ResourceManager, raddr ma.Multiaddr, p peer.ID, dial func(context.Context) (net.Conn, error)) (transport.CapableConn, error) {
+	scope, e := rcmgr.OpenConnection(network.DirOutbound, true, raddr)
+	if e != nil {
+		return nil, e
+	}
+	if e := scope.SetPeer(p); e != nil {
+		scope.Done()
+		return nil, e
+	}
+	c, e := dial(ctx)
+	if e != nil {
+		scope.Done()
+		return nil, e
+	}
+	laddr, e := manet.FromNetAddr(c.LocalAddr())
+	if e != nil {
+		_ = c.Close()
+		scope.Done()
+		return nil, e
+	}
+	// 升级失败时由upgrader释放scope
+	return upgrader.Upgrade(ctx, t, &proxiedConn{Conn: c, laddr: laddr, raddr: raddr}, network.DirOutbound, p, scope)
+}
+
+// proxiedConn 是经过代理的连接, RemoteMultiaddr是节点的地址
+type proxiedConn struct {
+	net.Conn
+	laddr ma.Multiaddr
+	raddr ma.Multiaddr
+}
+
+func (c *proxiedConn) LocalMultiaddr() ma.Multiaddr {
+	return c.laddr
+}
+
+func (c *proxiedConn) RemoteMultiaddr() ma.Multiaddr {
+	return c.raddr
+}
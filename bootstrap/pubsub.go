@@ -0,0 +1,158 @@
+package bootstrap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// pubsubConfig 是GossipSub路由的设置
+type pubsubConfig struct {
+	// Enabled 启用GossipSub路由
+	Enabled bool `yaml:"enabled"`
+	// Topics 是允许的主题, 本节点加入这些主题并转发消息, 其他主题的订阅被忽略.
+	// 为空时不限制主题, 本节点不加入任何主题, 只交换订阅和节点信息.
+	Topics []string `yaml:"topics"`
+	// PeerExchange 在修剪mesh时向对方提供其他节点(PX), 帮助新节点找到同一主题的节点
+	PeerExchange bool `yaml:"peer_exchange"`
+	// Scoring 启用GossipSub节点评分, 评分低的节点收不到gossip, 发布的消息被忽略
+	Scoring bool `yaml:"scoring"`
+}
+
+// validate 检查GossipSub设置
+func (c pubsubConfig) validate() error {
+	seen := make(map[string]bool)
+	for _, t := range c.Topics {
+		if t == "" {
+			return errors.New("pubsub主题不能为空")
+		}
+		if seen[t] {
+			return fmt.Errorf("pubsub主题重复: %s", t)
+		}
+		seen[t] = true
+	}
+	return nil
+}
+
+// gossipScoreThresholds 是GossipSub评分阈值, 与go-ipfs相近
+var gossipScoreThresholds = &pubsub.PeerScoreThresholds{
+	GossipThreshold:             -500,
+	PublishThreshold:            -1000,
+	GraylistThreshold:           -2500,
+	AcceptPXThreshold:           10,
+	OpportunisticGraftThreshold: 5,
+}
+
+// gossipScoreParams 返回GossipSub评分参数: 受保护的节点加分, 同一IP的节点过多,
+// 违反协议和发送无效消息时减分. 允许的主题按在mesh中的时间和首先送达的消息加分.
+func gossipScoreParams(h host.Host, topics []string) *pubsub.PeerScoreParams {
+	params := &pubsub.PeerScoreParams{
+		Topics:        make(map[string]*pubsub.TopicScoreParams),
+		TopicScoreCap: 50,
+		AppSpecificScore: func(p peer.ID) float64 {
+			if h.ConnManager().IsProtected(p, "") {
+				return 100
+			}
+			return 0
+		},
+		AppSpecificWeight:           1,
+		IPColocationFactorWeight:    -100,
+		IPColocationFactorThreshold: 5,
+		BehaviourPenaltyWeight:      -10,
+		BehaviourPenaltyThreshold:   6,
+		BehaviourPenaltyDecay:       pubsub.ScoreParameterDecay(time.Hour),
+		DecayInterval:               pubsub.DefaultDecayInterval,
+		DecayToZero:                 pubsub.DefaultDecayToZero,
+		RetainScore:                 time.Hour * 6,
+	}
+	for _, t := range topics {
+		params.Topics[t] = &pubsub.TopicScoreParams{
+			TopicWeight:                    1,
+			TimeInMeshWeight:               0.0027,
+			TimeInMeshQuantum:              time.Second,
+			TimeInMeshCap:                  3600,
+			FirstMessageDeliveriesWeight:   0.5,
+			FirstMessageDeliveriesDecay:    pubsub.ScoreParameterDecay(time.Hour),
+			FirstMessageDeliveriesCap:      100,
+			InvalidMessageDeliveriesWeight: -1000,
+			InvalidMessageDeliveriesDecay:  pubsub.ScoreParameterDecay(time.Hour),
+		}
+	}
+	return params
+}
+
+// gossipRouter 在主机上运行GossipSub, 作为稳定的pubsub骨干节点.
+// 本节点加入允许的主题转发消息, 不处理消息内容.
+type gossipRouter struct {
+	ps     *pubsub.PubSub
+	topics []string
+
+	mu       sync.Mutex
+	received map[string]uint64
+}
+
+// newGossipRouter 创建GossipSub路由并加入允许的主题, ctx取消时停止
+func newGossipRouter(ctx context.Context, h host.Host, cfg pubsubConfig) (*gossipRouter, error) {
+	opts := []pubsub.Option{pubsub.WithPeerExchange(cfg.PeerExchange)}
+	if len(cfg.Topics) > 0 {
+		opts = append(opts, pubsub.WithSubscriptionFilter(pubsub.NewAllowlistSubscriptionFilter(cfg.Topics...)))
+	}
+	if cfg.Scoring {
+		opts = append(opts, pubsub.WithPeerScore(gossipScoreParams(h, cfg.Topics), gossipScoreThresholds))
+	}
+	ps, e := pubsub.NewGossipSub(ctx, h, opts...)
+	if e != nil {
+		return nil, fmt.Errorf("创建GossipSub出错: %w", e)
+	}
+	r := &gossipRouter{ps: ps, topics: cfg.Topics, received: make(map[string]uint64)}
+	for _, name := range cfg.Topics {
+		t, e := ps.Join(name)
+		if e != nil {
+			return nil, fmt.Errorf("加入pubsub主题 %s 出错: %w", name, e)
+		}
+		sub, e := t.Subscribe()
+		if e != nil {
+			return nil, fmt.Errorf("订阅pubsub主题 %s 出错: %w", name, e)
+		}
+		go r.drain(ctx, name, sub)
+	}
+	return r, nil
+}
+
+// drain 读取并丢弃主题的消息, 只计数. 订阅后本节点才会加入主题的mesh转发消息.
+func (r *gossipRouter) drain(ctx context.Context, topic string, sub *pubsub.Subscription) {
+	defer sub.Cancel()
+	for {
+		if _, e := sub.Next(ctx); e != nil {
+			return
+		}
+		r.mu.Lock()
+		r.received[topic]++
+		r.mu.Unlock()
+	}
+}
+
+// status 返回每个主题的节点数量和收到的消息数量
+func (r *gossipRouter) status() interface{} {
+	topics := make(map[string]interface{})
+	for _, t := range r.ps.GetTopics() {
+		r.mu.Lock()
+		received := r.received[t]
+		r.mu.Unlock()
+		topics[t] = map[string]interface{}{
+			"peers":    len(r.ps.ListPeers(t)),
+			"received": received,
+		}
+	}
+	return map[string]interface{}{
+		"peers":     len(r.ps.ListPeers("")),
+		"allowlist": r.topics,
+		"topics":    topics,
+	}
+}
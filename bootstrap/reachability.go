@@ -0,0 +1,50 @@
+package bootstrap
+
+import (
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+)
+
+// reachabilityTracker 记录AutoNAT得出的当前可达性.
+// AutoNAT客户端由libp2p.New创建, 结果通过事件总线得到.
+type reachabilityTracker struct {
+	mu          sync.RWMutex
+	current     network.Reachability
+	previous    network.Reachability
+	changed     time.Time
+	transitions int
+}
+
+// Set 更新可达性, 返回之前的可达性
+func (t *reachabilityTracker) Set(r network.Reachability) network.Reachability {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	previous := t.current
+	if r != previous {
+		t.previous = previous
+		t.transitions++
+	}
+	t.current = r
+	t.changed = time.Now()
+	return previous
+}
+
+// Get 返回当前可达性
+func (t *reachabilityTracker) Get() network.Reachability {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.current
+}
+
+func (t *reachabilityTracker) status() interface{} {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	s := map[string]interface{}{"current": t.current.String(), "transitions": t.transitions}
+	if !t.changed.IsZero() {
+		s["changed"] = t.changed
+		s["previous"] = t.previous.String()
+	}
+	return s
+}
@@ -0,0 +1,292 @@
+package bootstrap
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	pbv2 "github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/pb"
+	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/proto"
+	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// relayConfig 是中继服务(circuit relay v2)的设置
+type relayConfig struct {
+	// MaxCircuits 是同时中继的电路数量上限
+	MaxCircuits int `yaml:"max_circuits"`
+	// MaxReservations 是同时预约中继的节点数量上限, 只有预约的节点可以通过中继被连接
+	MaxReservations int `yaml:"max_reservations"`
+	// MaxReservationsPerIP 是同一个IP同时保持的预约数量上限, 访问控制列表allow中的IP不受限制
+	MaxReservationsPerIP int `yaml:"max_reservations_per_ip"`
+	// MaxCircuitsPerPeer 是每个节点作为来源或目标同时使用的电路数量上限
+	MaxCircuitsPerPeer int `yaml:"max_circuits_per_peer"`
+	// ReservationTTL 是预约的有效期, 节点需要在到期前续约
+	ReservationTTL time.Duration `yaml:"reservation_ttl"`
+	// CircuitDuration 和 CircuitData 是每个电路的时间和每个方向的数据量上限, 超过时关闭电路.
+	// 都为0时不限制
+	CircuitDuration time.Duration `yaml:"circuit_duration"`
+	CircuitData     int64         `yaml:"circuit_data"`
+	// MaxPerASN 是同一个ASN的来源同时保持的预约数量上限, 需要ASN数据库, 0表示不限制
+	MaxPerASN int `yaml:"max_per_asn"`
+	// MaxPeerBytes 和 MaxPeerTime 是每个节点每天(UTC)通过中继的流量和电路时间上限, 0表示不限制
+	MaxPeerBytes int64         `yaml:"max_peer_bytes"`
+	MaxPeerTime  time.Duration `yaml:"max_peer_time"`
+	// Static 是AutoRelay使用的中继节点地址, 为空时通过DHT发现中继
+	Static []string `yaml:"static"`
+}
+
+// validate 检查中继设置
+func (c relayConfig) validate() error {
+	if c.MaxCircuits <= 0 {
+		return fmt.Errorf("中继电路数量上限必须大于0: %d", c.MaxCircuits)
+	}
+	if c.MaxReservations <= 0 || c.MaxReservationsPerIP <= 0 || c.MaxCircuitsPerPeer <= 0 {
+		return fmt.Errorf("中继预约和每个节点的电路数量上限必须大于0: max_reservations %d, max_reservations_per_ip %d, max_circuits_per_peer %d", c.MaxReservations, c.MaxReservationsPerIP, c.MaxCircuitsPerPeer)
+	}
+	if c.ReservationTTL <= 0 {
+		return fmt.Errorf("中继预约有效期必须大于0: %s", c.ReservationTTL)
+	}
+	if c.CircuitDuration < 0 || c.CircuitData < 0 {
+		return fmt.Errorf("中继电路的时间和数据量上限不能小于0: %s, %d", c.CircuitDuration, c.CircuitData)
+	}
+	if c.MaxPerASN < 0 {
+		return fmt.Errorf("中继来源限制不能小于0: %d", c.MaxPerASN)
+	}
+	if c.MaxPeerBytes < 0 || c.MaxPeerTime < 0 {
+		return fmt.Errorf("中继每日上限不能小于0: %d, %s", c.MaxPeerBytes, c.MaxPeerTime)
+	}
+	return nil
+}
+
+// staticRelays 解析AutoRelay使用的中继节点
+func (c relayConfig) staticRelays() ([]peer.AddrInfo, error) {
+	relays, e := parseAddrInfos(c.Static)
+	if e != nil {
+		return nil, fmt.Errorf("中继节点地址错误: %w", e)
+	}
+	return relays, nil
+}
+
+// relayCandidates 为AutoRelay提供候选的中继节点: DHT路由表中的节点, AutoRelay检查它们是否提供中继服务
+type relayCandidates struct {
+	mu   sync.Mutex
+	h    host.Host
+	idht *dht.IpfsDHT
+}
+
+// set 在创建节点后设置主机和DHT, 之前没有候选
+func (c *relayCandidates) set(h host.Host, idht *dht.IpfsDHT) {
+	c.mu.Lock()
+	c.h, c.idht = h, idht
+	c.mu.Unlock()
+}
+
+// source 随机返回路由表中最多num个节点
+func (c *relayCandidates) source(_ context.Context, num int) <-chan peer.AddrInfo {
+	c.mu.Lock()
+	h, idht := c.h, c.idht
+	c.mu.Unlock()
+	out := make(chan peer.AddrInfo, num)
+	defer close(out)
+	if h == nil || idht == nil {
+		return out
+	}
+	peers := idht.RoutingTable().ListPeers()
+	rand.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+	for _, p := range peers {
+		if len(out) == num {
+			break
+		}
+		if addrs := h.Peerstore().Addrs(p); len(addrs) > 0 {
+			out <- peer.AddrInfo{ID: p, Addrs: addrs}
+		}
+	}
+	return out
+}
+
+// relayUnlimited 是只限制了时间或数据量之一时另一项使用的上限
+const (
+	relayUnlimitedDuration = time.Hour * 24 * 365
+	relayUnlimitedData     = math.MaxInt64
+)
+
+// resources 返回中继服务的资源限制
+func (c relayConfig) resources() relay.Resources {
+	rc := relay.DefaultResources()
+	rc.MaxReservations = c.MaxReservations
+	// 来源IP和ASN由relayQuota按当前的预约限制, libp2p的限制统计的是30分钟内的预约次数
+	rc.MaxReservationsPerIP = c.MaxReservations
+	rc.MaxReservationsPerASN = c.MaxReservations
+	rc.MaxCircuits = c.MaxCircuitsPerPeer
+	rc.ReservationTTL = c.ReservationTTL
+	if c.CircuitDuration == 0 && c.CircuitData == 0 {
+		rc.Limit = nil
+		return rc
+	}
+	rc.Limit = &relay.RelayLimit{Duration: c.CircuitDuration, Data: c.CircuitData}
+	if rc.Limit.Duration == 0 {
+		rc.Limit.Duration = relayUnlimitedDuration
+	}
+	if rc.Limit.Data == 0 {
+		rc.Limit.Data = relayUnlimitedData
+	}
+	return rc
+}
+
+// relayMetrics 实现relay.MetricsTracer, 统计当前的预约和电路以及按结果统计的请求
+type relayMetrics struct {
+	reservations int64
+	circuits     int64
+
+	mu       sync.Mutex
+	reserves map[string]uint64
+	connects map[string]uint64
+}
+
+func newRelayMetrics() *relayMetrics {
+	return &relayMetrics{reserves: make(map[string]uint64), connects: make(map[string]uint64)}
+}
+
+func (m *relayMetrics) RelayStatus(bool) {}
+
+func (m *relayMetrics) ConnectionOpened() {
+	atomic.AddInt64(&m.circuits, 1)
+}
+
+func (m *relayMetrics) ConnectionClosed(time.Duration) {
+	atomic.AddInt64(&m.circuits, -1)
+}
+
+func (m *relayMetrics) ConnectionRequestHandled(status pbv2.Status) {
+	m.mu.Lock()
+	m.connects[strings.ToLower(status.String())]++
+	m.mu.Unlock()
+}
+
+func (m *relayMetrics) ReservationAllowed(isRenewal bool) {
+	if !isRenewal {
+		atomic.AddInt64(&m.reservations, 1)
+	}
+}
+
+func (m *relayMetrics) ReservationClosed(cnt int) {
+	atomic.AddInt64(&m.reservations, -int64(cnt))
+}
+
+func (m *relayMetrics) ReservationRequestHandled(status pbv2.Status) {
+	m.mu.Lock()
+	m.reserves[strings.ToLower(status.String())]++
+	m.mu.Unlock()
+}
+
+func (m *relayMetrics) BytesTransferred(int) {}
+
+// active 返回当前的预约和电路数量
+func (m *relayMetrics) active() (reservations, circuits int64) {
+	return atomic.LoadInt64(&m.reservations), atomic.LoadInt64(&m.circuits)
+}
+
+// requests 返回按结果统计的预约请求和连接请求
+func (m *relayMetrics) requests() (reserves, connects map[string]uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	reserves, connects = make(map[string]uint64, len(m.reserves)), make(map[string]uint64, len(m.connects))
+	for k, v := range m.reserves {
+		reserves[k] = v
+	}
+	for k, v := range m.connects {
+		connects[k] = v
+	}
+	return reserves, connects
+}
+
+// relayACL 按来源限制预约, 并限制同时中继的电路总数, libp2p的中继只限制每个节点的电路
+type relayACL struct {
+	metrics         *relayMetrics
+	quota           *relayQuota
+	maxReservations int64
+	maxCircuits     int64
+}
+
+func (a *relayACL) AllowReserve(p peer.ID, addr ma.Multiaddr) bool {
+	// 预约已满时中继会拒绝新的预约, 提前拒绝以免计入来源限制
+	if reservations, _ := a.metrics.active(); reservations >= a.maxReservations && !a.quota.reserved(p) {
+		return false
+	}
+	return a.quota.allowReserve(p, addr)
+}
+
+func (a *relayACL) AllowConnect(_ peer.ID, _ ma.Multiaddr, _ peer.ID) bool {
+	_, circuits := a.metrics.active()
+	return circuits < a.maxCircuits
+}
+
+// relayHost 在中继服务设置hop协议的处理器时依次用wrap包装, 用于统计和限制中继的流
+type relayHost struct {
+	host.Host
+	wrap []func(network.StreamHandler) network.StreamHandler
+}
+
+func (h *relayHost) SetStreamHandler(pid protocol.ID, handler network.StreamHandler) {
+	if pid == proto.ProtoIDv2Hop {
+		for _, wrap := range h.wrap {
+			handler = wrap(handler)
+		}
+	}
+	h.Host.SetStreamHandler(pid, handler)
+}
+
+// startRelay 启动中继服务, wrap依次包装hop协议的处理器
+func startRelay(h host.Host, cfg relayConfig, metrics *relayMetrics, quota *relayQuota, wrap ...func(network.StreamHandler) network.StreamHandler) (*relay.Relay, error) {
+	acl := &relayACL{metrics: metrics, quota: quota, maxReservations: int64(cfg.MaxReservations), maxCircuits: int64(cfg.MaxCircuits)}
+	return relay.New(&relayHost{Host: h, wrap: wrap}, relay.WithResources(cfg.resources()), relay.WithACL(acl), relay.WithMetricsTracer(metrics))
+}
+
+// isRelayProtocol 判断是否是中继协议: 来源打开的hop流和中继打开到目标的stop流
+func isRelayProtocol(pid protocol.ID) bool {
+	return pid == proto.ProtoIDv2Hop || pid == proto.ProtoIDv2Stop
+}
+
+// relayStreams 统计中继协议的流, 每个电路在中继节点上有来源和目标两个流
+func relayStreams(n network.Network) int {
+	streams := 0
+	for _, c := range n.Conns() {
+		for _, s := range c.GetStreams() {
+			if isRelayProtocol(s.Protocol()) {
+				streams++
+			}
+		}
+	}
+	return streams
+}
+
+// relayStatus 返回中继的流, 预约和电路数量, 请求结果, 流量以及来源限制和拒绝次数
+func relayStatus(n network.Network, metrics *relayMetrics, quota *relayQuota, traffic *relayTraffic) func() interface{} {
+	return func() interface{} {
+		reservations, circuits := metrics.active()
+		reserves, connects := metrics.requests()
+		result := map[string]interface{}{
+			"streams":          relayStreams(n),
+			"reservations":     reservations,
+			"circuits":         circuits,
+			"reserve_requests": reserves,
+			"connect_requests": connects,
+		}
+		result["quota"] = quota.status()
+		if traffic != nil {
+			result["traffic"] = traffic.totals()
+		}
+		return result
+	}
+}
@@ -0,0 +1,167 @@
+package bootstrap
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// relayQuotaKey 是一个预约计入的来源IP和ASN, ASN为0时不计入ASN
+type relayQuotaKey struct {
+	ip  string
+	asn uint
+}
+
+// relayReservation 是一个节点的预约, exempt表示来源不受限制, 不计入来源数量
+type relayReservation struct {
+	key    relayQuotaKey
+	exempt bool
+	expire time.Time
+}
+
+// relayQuota 按来源IP和ASN限制同时保持的中继预约, 避免同一个主机商的大量节点占满中继.
+// 作为中继的访问控制在预约时检查, 节点断开或预约到期时释放. 访问控制列表allow中的IP不受限制
+type relayQuota struct {
+	maxPerIP  int
+	maxPerASN int
+	ttl       time.Duration
+	geo       *geoIP
+	acl       *accessList
+
+	mu    sync.Mutex
+	peers map[peer.ID]*relayReservation
+	ips   map[string]int
+	asns  map[uint]int
+
+	rejectedIP  uint64
+	rejectedASN uint64
+}
+
+// newRelayQuota 创建来源限制, 在节点断开时释放它的预约
+func newRelayQuota(h host.Host, cfg relayConfig, geo *geoIP, acl *accessList) *relayQuota {
+	q := &relayQuota{maxPerIP: cfg.MaxReservationsPerIP, maxPerASN: cfg.MaxPerASN, ttl: cfg.ReservationTTL, geo: geo, acl: acl,
+		peers: make(map[peer.ID]*relayReservation), ips: make(map[string]int), asns: make(map[uint]int)}
+	if q.maxPerASN > 0 && (geo == nil || geo.asn == nil) {
+		logger.Warnw("没有设置ASN数据库, 不按ASN限制中继")
+		q.maxPerASN = 0
+	}
+	h.Network().Notify(&network.NotifyBundle{DisconnectedF: q.disconnected})
+	return q
+}
+
+// reserved 判断节点是否有预约, 有预约时是续约
+func (q *relayQuota) reserved(p peer.ID) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	_, ok := q.peers[p]
+	return ok
+}
+
+// allowReserve 计入一个预约, 来源IP或ASN的预约已经达到上限时返回false. 续约时只更新有效期
+func (q *relayQuota) allowReserve(p peer.ID, addr ma.Multiaddr) bool {
+	now := time.Now()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if r, ok := q.peers[p]; ok {
+		r.expire = now.Add(q.ttl)
+		return true
+	}
+	ip, e := manet.ToIP(addr)
+	if e != nil || (q.acl != nil && q.acl.allowedIP(ip)) {
+		q.peers[p] = &relayReservation{exempt: true, expire: now.Add(q.ttl)}
+		return true
+	}
+	key := relayQuotaKey{ip: ip.String()}
+	if q.maxPerASN > 0 {
+		key.asn = q.geo.lookup(addr).ASN
+	}
+	if q.full(key) {
+		// 到期的预约在节点断开前不会释放, 达到上限时先清理
+		q.prune(now)
+		if q.full(key) {
+			if q.ips[key.ip] >= q.maxPerIP {
+				atomic.AddUint64(&q.rejectedIP, 1)
+			} else {
+				atomic.AddUint64(&q.rejectedASN, 1)
+			}
+			logger.Debugw("超过中继来源限制", "peer", p, "ip", key.ip, "asn", key.asn)
+			return false
+		}
+	}
+	q.peers[p] = &relayReservation{key: key, expire: now.Add(q.ttl)}
+	q.ips[key.ip]++
+	if key.asn != 0 {
+		q.asns[key.asn]++
+	}
+	return true
+}
+
+func (q *relayQuota) full(key relayQuotaKey) bool {
+	return q.ips[key.ip] >= q.maxPerIP || (key.asn != 0 && q.asns[key.asn] >= q.maxPerASN)
+}
+
+// prune 释放已经到期的预约, 调用时持有锁
+func (q *relayQuota) prune(now time.Time) {
+	for p, r := range q.peers {
+		if r.expire.Before(now) {
+			q.release(p)
+		}
+	}
+}
+
+// release 释放节点的预约, 调用时持有锁
+func (q *relayQuota) release(p peer.ID) {
+	r, ok := q.peers[p]
+	if !ok {
+		return
+	}
+	delete(q.peers, p)
+	if r.exempt {
+		return
+	}
+	if q.ips[r.key.ip]--; q.ips[r.key.ip] <= 0 {
+		delete(q.ips, r.key.ip)
+	}
+	if r.key.asn != 0 {
+		if q.asns[r.key.asn]--; q.asns[r.key.asn] <= 0 {
+			delete(q.asns, r.key.asn)
+		}
+	}
+}
+
+// disconnected 与中继一样, 节点的连接全部断开时释放预约
+func (q *relayQuota) disconnected(n network.Network, c network.Conn) {
+	p := c.RemotePeer()
+	if n.Connectedness(p) == network.Connected {
+		return
+	}
+	q.mu.Lock()
+	q.release(p)
+	q.mu.Unlock()
+}
+
+// rejected 返回按原因统计的拒绝次数
+func (q *relayQuota) rejected() map[string]uint64 {
+	return map[string]uint64{"ip": atomic.LoadUint64(&q.rejectedIP), "asn": atomic.LoadUint64(&q.rejectedASN)}
+}
+
+// status 返回限制, 当前计入的预约和来源数量以及拒绝次数
+func (q *relayQuota) status() map[string]interface{} {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.prune(time.Now())
+	return map[string]interface{}{
+		"max_per_ip":   q.maxPerIP,
+		"max_per_asn":  q.maxPerASN,
+		"reservations": len(q.peers),
+		"ips":          len(q.ips),
+		"asns":         len(q.asns),
+		"rejected":     q.rejected(),
+	}
+}
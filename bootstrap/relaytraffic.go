@@ -0,0 +1,292 @@
+package bootstrap
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// relayTrafficInterval 是累计电路时间和检查每日上限的间隔
+const relayTrafficInterval = time.Second * 10
+
+// relayCircuit 是一个正在中继的流, 由打开电路的节点发起
+type relayCircuit struct {
+	peer    peer.ID
+	started time.Time
+	// in 是从来源读取的字节数(转发给目标), out 是写给来源的字节数
+	in, out uint64
+	// counted 是已计入每日用量的时间
+	counted time.Duration
+}
+
+// relayUsage 是一个节点当天的中继用量
+type relayUsage struct {
+	Bytes    uint64
+	Duration time.Duration
+	Circuits int
+	Capped   bool
+}
+
+// relayStream 统计中继流的读写字节数, 超过每日上限时重置
+type relayStream struct {
+	network.Stream
+	t       *relayTraffic
+	circuit *relayCircuit
+	// done 表示流已关闭, 由t.mu保护
+	done bool
+}
+
+func (s *relayStream) Close() error {
+	s.t.finish(s)
+	return s.Stream.Close()
+}
+
+func (s *relayStream) Reset() error {
+	s.t.finish(s)
+	return s.Stream.Reset()
+}
+
+func (s *relayStream) Read(b []byte) (int, error) {
+	n, e := s.Stream.Read(b)
+	if n > 0 {
+		atomic.AddUint64(&s.circuit.in, uint64(n))
+		atomic.AddUint64(&s.t.bytesIn, uint64(n))
+		s.t.add(s, uint64(n))
+	}
+	return n, e
+}
+
+func (s *relayStream) Write(b []byte) (int, error) {
+	n, e := s.Stream.Write(b)
+	if n > 0 {
+		atomic.AddUint64(&s.circuit.out, uint64(n))
+		atomic.AddUint64(&s.t.bytesOut, uint64(n))
+		s.t.add(s, uint64(n))
+	}
+	return n, e
+}
+
+// relayTraffic 按电路和来源节点统计中继的流量和时间, 按节点限制每天(UTC)的流量和时间.
+// 电路在来源打开的hop流上统计, 用量计入打开电路的节点. 处理器返回时还没有关闭的hop流是电路,
+// 预约和失败的连接请求在处理器中就关闭了
+type relayTraffic struct {
+	cfg  relayConfig
+	next network.StreamHandler
+
+	mu      sync.Mutex
+	day     string
+	usage   map[peer.ID]*relayUsage
+	streams map[network.Stream]*relayStream
+
+	circuits uint64
+	bytesIn  uint64
+	bytesOut uint64
+	rejected uint64
+	reset    uint64
+}
+
+// newRelayTraffic 创建统计, 用wrap包装中继协议的处理器
+func newRelayTraffic(cfg relayConfig) *relayTraffic {
+	t := &relayTraffic{cfg: cfg, day: relayDay(time.Now()), usage: make(map[peer.ID]*relayUsage), streams: make(map[network.Stream]*relayStream)}
+	return t
+}
+
+// wrap 返回统计流量的处理器
+func (t *relayTraffic) wrap(next network.StreamHandler) network.StreamHandler {
+	t.next = next
+	return t.handle
+}
+
+// relayDay 返回用量所属的日期
+func relayDay(now time.Time) string {
+	return now.UTC().Format("2006-01-02")
+}
+
+// rollover 在日期变化时清空用量, 调用时持有锁
+func (t *relayTraffic) rollover() {
+	if day := relayDay(time.Now()); day != t.day {
+		t.day = day
+		t.usage = make(map[peer.ID]*relayUsage)
+	}
+}
+
+// get 返回节点当天的用量, 调用时持有锁
+func (t *relayTraffic) get(p peer.ID) *relayUsage {
+	t.rollover()
+	u, ok := t.usage[p]
+	if !ok {
+		u = &relayUsage{}
+		t.usage[p] = u
+	}
+	return u
+}
+
+// over 判断用量是否超过每日上限
+func (t *relayTraffic) over(u *relayUsage) bool {
+	return (t.cfg.MaxPeerBytes > 0 && u.Bytes >= uint64(t.cfg.MaxPeerBytes)) || (t.cfg.MaxPeerTime > 0 && u.Duration >= t.cfg.MaxPeerTime)
+}
+
+func (t *relayTraffic) handle(s network.Stream) {
+	p := s.Conn().RemotePeer()
+	t.mu.Lock()
+	u := t.get(p)
+	if t.over(u) {
+		u.Capped = true
+		t.mu.Unlock()
+		atomic.AddUint64(&t.rejected, 1)
+		_ = s.Reset()
+		return
+	}
+	rs := &relayStream{Stream: s, t: t, circuit: &relayCircuit{peer: p, started: time.Now()}}
+	t.mu.Unlock()
+	t.next(rs)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if rs.done {
+		return
+	}
+	t.get(p).Circuits++
+	t.streams[s] = rs
+	atomic.AddUint64(&t.circuits, 1)
+}
+
+// add 计入流量, 超过每日上限时重置流
+func (t *relayTraffic) add(s *relayStream, n uint64) {
+	t.mu.Lock()
+	u := t.get(s.circuit.peer)
+	u.Bytes += n
+	over := t.over(u)
+	if over {
+		u.Capped = true
+	}
+	t.mu.Unlock()
+	if over {
+		t.capped(s)
+	}
+}
+
+// capped 重置超过每日上限的节点的电路
+func (t *relayTraffic) capped(s *relayStream) {
+	atomic.AddUint64(&t.reset, 1)
+	logger.Infow("中继用量超过每日上限, 关闭电路", "peer", s.circuit.peer)
+	_ = s.Reset()
+}
+
+// finish 在流关闭时计入电路剩余的时间
+func (t *relayTraffic) finish(rs *relayStream) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if rs.done {
+		return
+	}
+	rs.done = true
+	if _, ok := t.streams[rs.Stream]; ok {
+		delete(t.streams, rs.Stream)
+		elapsed := time.Since(rs.circuit.started)
+		t.get(rs.circuit.peer).Duration += elapsed - rs.circuit.counted
+	}
+}
+
+// run 定期把正在中继的电路的时间计入每日用量, 重置超过上限的电路
+func (t *relayTraffic) run(ctx context.Context) {
+	ticker := time.NewTicker(relayTrafficInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		var over []*relayStream
+		t.mu.Lock()
+		for _, rs := range t.streams {
+			elapsed := time.Since(rs.circuit.started)
+			u := t.get(rs.circuit.peer)
+			u.Duration += elapsed - rs.circuit.counted
+			rs.circuit.counted = elapsed
+			if t.over(u) {
+				u.Capped = true
+				over = append(over, rs)
+			}
+		}
+		t.mu.Unlock()
+		for _, rs := range over {
+			t.capped(rs)
+		}
+	}
+}
+
+// relayPeerUsage 是 /v1/relay 中一个节点当天的用量
+type relayPeerUsage struct {
+	Peer            string  `json:"peer"`
+	Bytes           uint64  `json:"bytes"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	Circuits        int     `json:"circuits"`
+	Capped          bool    `json:"capped,omitempty"`
+}
+
+// relayActiveCircuit 是 /v1/relay 中一个正在中继的电路
+type relayActiveCircuit struct {
+	Peer            string  `json:"peer"`
+	BytesIn         uint64  `json:"bytes_in"`
+	BytesOut        uint64  `json:"bytes_out"`
+	DurationSeconds float64 `json:"duration_seconds"`
+}
+
+// relayTrafficReport 是 /v1/relay 的内容
+type relayTrafficReport struct {
+	Day          string               `json:"day"`
+	MaxPeerBytes int64                `json:"max_peer_bytes"`
+	MaxPeerTime  string               `json:"max_peer_time"`
+	Peers        []relayPeerUsage     `json:"peers"`
+	Circuits     []relayActiveCircuit `json:"circuits"`
+}
+
+// report 返回当天每个节点的用量(用量大的在前)和正在中继的电路
+func (t *relayTraffic) report() relayTrafficReport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rollover()
+	r := relayTrafficReport{Day: t.day, MaxPeerBytes: t.cfg.MaxPeerBytes, MaxPeerTime: t.cfg.MaxPeerTime.String(), Peers: []relayPeerUsage{}, Circuits: []relayActiveCircuit{}}
+	for p, u := range t.usage {
+		r.Peers = append(r.Peers, relayPeerUsage{Peer: p.String(), Bytes: u.Bytes, DurationSeconds: u.Duration.Seconds(), Circuits: u.Circuits, Capped: u.Capped})
+	}
+	sort.Slice(r.Peers, func(i, j int) bool { return r.Peers[i].Bytes > r.Peers[j].Bytes })
+	for _, rs := range t.streams {
+		r.Circuits = append(r.Circuits, relayActiveCircuit{Peer: rs.circuit.peer.String(), BytesIn: atomic.LoadUint64(&rs.circuit.in), BytesOut: atomic.LoadUint64(&rs.circuit.out), DurationSeconds: time.Since(rs.circuit.started).Seconds()})
+	}
+	sort.Slice(r.Circuits, func(i, j int) bool { return r.Circuits[i].DurationSeconds > r.Circuits[j].DurationSeconds })
+	return r
+}
+
+// totals 返回中继的流量以及电路, 拒绝和重置的次数
+func (t *relayTraffic) totals() map[string]uint64 {
+	return map[string]uint64{
+		"circuits":  atomic.LoadUint64(&t.circuits),
+		"bytes_in":  atomic.LoadUint64(&t.bytesIn),
+		"bytes_out": atomic.LoadUint64(&t.bytesOut),
+		"rejected":  atomic.LoadUint64(&t.rejected),
+		"reset":     atomic.LoadUint64(&t.reset),
+	}
+}
+
+// relayTraffic 返回中继用量
+func (a *adminAPI) relayTraffic(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAdminError(w, http.StatusMethodNotAllowed, errors.New("只支持GET"))
+		return
+	}
+	if a.relay == nil {
+		writeAdminError(w, http.StatusNotFound, errors.New("没有提供中继(-relay-service)"))
+		return
+	}
+	writeAdminJSON(w, a.relay.report())
+}
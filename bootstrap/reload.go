@@ -0,0 +1,152 @@
+package bootstrap
+
+import (
+	"context"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// reloader 在收到SIGHUP时重新读取配置, 不重启节点即可应用可修改的设置:
+// 引导节点, 受保护的节点, 连接管理器的水位线, 日志和访问控制列表文件.
+// 路由表过小时重新连接的也是新的引导节点. tiers是当前使用的引导节点, 重新加载时与新的引导节点比较.
+type reloader struct {
+	ctx        context.Context
+	configPath string
+	args       []string
+	network    string
+	dir        string
+	cfg        *Config
+	tiers      [][]peer.AddrInfo
+	h          host.Host
+	cm         *reloadableConnMgr
+	protector  *peerProtector
+	refresher  *dhtRefresher
+	keeper     *bootstrapKeeper
+	tagger     *peerTagger
+	acl        *accessList
+}
+
+func (r *reloader) reload() {
+	if r.configPath == "" {
+		logger.Warnw("没有指定配置文件, 忽略重新加载")
+		return
+	}
+	next, e := reloadConfig(r.configPath, r.args)
+	if e == nil && r.network != "" {
+		next, e = next.networkConfig(r.network)
+	}
+	if e != nil {
+		logger.Warnw("重新读取配置出错, 保持原有设置", "error", e)
+		return
+	}
+	nextAddrs, e := next.bootstrapTiers(r.dir)
+	if e != nil {
+		logger.Warnw("读取引导节点出错, 保持原有设置", "error", e)
+		return
+	}
+	tiers, e := parseTiers(resolveTiers(r.ctx, nextAddrs, next.TierTimeout))
+	if e != nil {
+		logger.Warnw("引导节点地址错误, 保持原有设置", "error", e)
+		return
+	}
+	if e := next.ConnMgr.validate(); e != nil {
+		logger.Warnw("连接管理器设置错误, 保持原有设置", "error", e)
+		return
+	}
+	if e := next.Log.validate(); e != nil {
+		logger.Warnw("日志设置错误, 保持原有设置", "error", e)
+		return
+	}
+	trusted, e := decodePeerIDs(next.Protect)
+	if e != nil {
+		logger.Warnw("受保护节点ID错误, 保持原有设置", "error", e)
+		return
+	}
+	prev := r.cfg
+
+	if next.Log != prev.Log {
+		if e := next.Log.apply(); e != nil {
+			logger.Warnw("设置日志出错", "error", e)
+		}
+	}
+
+	if e := r.acl.load(); e != nil {
+		logger.Warnw("读取访问控制列表出错, 保持原有列表", "error", e)
+	} else if closed := r.acl.closeDenied(r.h.Network()); closed > 0 {
+		logger.Infow("已关闭访问控制列表拒绝的连接", "conns", closed)
+	}
+
+	if next.ConnMgr != prev.ConnMgr {
+		if e := r.cm.Reload(next.ConnMgr.LowWater, next.ConnMgr.HighWater, next.ConnMgr.GracePeriod); e != nil {
+			logger.Warnw("修改连接管理器出错", "error", e)
+		} else {
+			logger.Infow("连接管理器", "low_water", next.ConnMgr.LowWater, "high_water", next.ConnMgr.HighWater, "grace_period", next.ConnMgr.GracePeriod)
+		}
+		r.tagger.setWeights(next.ConnMgr.RelayWeight, next.ConnMgr.DHTWeight, next.ConnMgr.UsefulWeight)
+	}
+
+	keep := make(map[peer.ID]bool, len(trusted))
+	for _, id := range trusted {
+		keep[id] = true
+		r.protector.Protect(id, protectTagTrusted)
+	}
+	prevTrusted, _ := decodePeerIDs(prev.Protect)
+	for _, id := range prevTrusted {
+		if !keep[id] {
+			r.protector.Unprotect(id, protectTagTrusted)
+		}
+	}
+
+	// 取消保护被移除的引导节点, 连接新增的引导节点
+	known := make(map[peer.ID]bool)
+	for _, tier := range r.tiers {
+		for _, addrInfo := range tier {
+			known[addrInfo.ID] = true
+		}
+	}
+	var added []peer.AddrInfo
+	for _, tier := range tiers {
+		for _, addrInfo := range tier {
+			if known[addrInfo.ID] {
+				delete(known, addrInfo.ID)
+				continue
+			}
+			added = append(added, addrInfo)
+		}
+	}
+	for id := range known {
+		r.protector.Unprotect(id, protectTagBootstrap)
+	}
+	for _, tier := range tiers {
+		addBootstrapPeers(r.h, r.protector, tier, next.bootstrapAddrTTL())
+	}
+	r.refresher.setTiers(tiers, next.TierMinPeers, next.TierTimeout)
+	r.keeper.setTiers(tiers, next.TierMinPeers, next.BootstrapQuorum, next.TierTimeout)
+	if len(added) > 0 {
+		go func() {
+			n := connectAll(r.ctx, r.h, added, next.TierTimeout, r.keeper.dials)
+			logger.Infow("连接新增的引导节点", "connected", n, "added", len(added))
+		}()
+	}
+
+	r.cfg, r.tiers = next, tiers
+	if r.network != "" {
+		logger.Infow("已重新加载配置", "network", r.network, "config", r.configPath)
+		return
+	}
+	logger.Infow("已重新加载配置", "config", r.configPath)
+}
+
+// decodePeerIDs 解析节点ID列表
+func decodePeerIDs(list []string) ([]peer.ID, error) {
+	ids := make([]peer.ID, 0, len(list))
+	for _, v := range list {
+		id, e := peer.Decode(v)
+		if e != nil {
+			return nil, e
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
@@ -0,0 +1,322 @@
+package bootstrap
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	pb "github.com/alx696/go-libp2p-bootstrap/rendezvouspb"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/core/record"
+	"google.golang.org/protobuf/proto"
+)
+
+// rendezvousProtocol 是libp2p rendezvous协议
+const rendezvousProtocol protocol.ID = "/rendezvous/1.0.0"
+
+// rendezvous协议的限制, 与规范相同
+const (
+	rendezvousDefaultTTL     = time.Hour * 2
+	rendezvousMaxNamespace   = 255
+	rendezvousDefaultLimit   = 100
+	rendezvousMaxLimit       = 1000
+	rendezvousStreamIdleTime = time.Minute
+)
+
+// rendezvousConfig 是rendezvous服务的设置
+type rendezvousConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// MaxTTL 是注册的最长有效期
+	MaxTTL time.Duration `yaml:"max_ttl"`
+	// PeerLimit 是每个节点的注册数量上限
+	PeerLimit int `yaml:"peer_limit"`
+	// MaxRegistrations 是所有节点的注册数量上限
+	MaxRegistrations int `yaml:"max_registrations"`
+}
+
+// validate 检查rendezvous设置
+func (c rendezvousConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.MaxTTL < rendezvousDefaultTTL {
+		return fmt.Errorf("rendezvous最长有效期不能小于%s: %s", rendezvousDefaultTTL, c.MaxTTL)
+	}
+	if c.PeerLimit <= 0 || c.MaxRegistrations < c.PeerLimit {
+		return fmt.Errorf("rendezvous注册数量上限错误: peer_limit %d, max_registrations %d", c.PeerLimit, c.MaxRegistrations)
+	}
+	return nil
+}
+
+// rendezvousEntry 是一个节点在一个名称空间中的注册, seq用于分页
+type rendezvousEntry struct {
+	record  []byte
+	expires time.Time
+	seq     uint64
+}
+
+// rendezvousServer 在内存中保存注册, 重启后客户端需要重新注册
+type rendezvousServer struct {
+	cfg     rendezvousConfig
+	limiter *messageLimiter
+
+	mu         sync.Mutex
+	namespaces map[string]map[peer.ID]*rendezvousEntry
+	perPeer    map[peer.ID]int
+	total      int
+	seq        uint64
+	registers  uint64
+	discovers  uint64
+	rejected   uint64
+}
+
+func newRendezvousServer(cfg rendezvousConfig, limiter *messageLimiter) *rendezvousServer {
+	return &rendezvousServer{
+		cfg:        cfg,
+		limiter:    limiter,
+		namespaces: make(map[string]map[peer.ID]*rendezvousEntry),
+		perPeer:    make(map[peer.ID]int),
+	}
+}
+
+// run 定期删除过期的注册
+func (s *rendezvousServer) run(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.mu.Lock()
+			for ns, entries := range s.namespaces {
+				for id, entry := range entries {
+					if now.After(entry.expires) {
+						s.remove(ns, id)
+					}
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// remove 删除注册, 调用时需持有锁
+func (s *rendezvousServer) remove(ns string, id peer.ID) {
+	entries := s.namespaces[ns]
+	if _, ok := entries[id]; !ok {
+		return
+	}
+	delete(entries, id)
+	if len(entries) == 0 {
+		delete(s.namespaces, ns)
+	}
+	s.total--
+	if s.perPeer[id]--; s.perPeer[id] <= 0 {
+		delete(s.perPeer, id)
+	}
+}
+
+// handle 处理rendezvous流, 一个流上可以依次发送多个请求
+func (s *rendezvousServer) handle(stream network.Stream) {
+	remote := stream.Conn().RemotePeer()
+	r := bufio.NewReader(stream)
+	for {
+		_ = stream.SetReadDeadline(time.Now().Add(rendezvousStreamIdleTime))
+		b, e := readDelimited(r, s.limiter.max)
+		if e == errMessageTooLarge {
+			s.limiter.reject(stream)
+			return
+		}
+		if e != nil {
+			_ = stream.Close()
+			return
+		}
+		req := &pb.Message{}
+		if e := proto.Unmarshal(b, req); e != nil {
+			logger.Debugw("rendezvous消息错误", "peer", remote, "error", e)
+			_ = stream.Reset()
+			return
+		}
+		var resp *pb.Message
+		switch req.GetType() {
+		case pb.Message_REGISTER:
+			resp = s.register(remote, req.GetRegister())
+		case pb.Message_UNREGISTER:
+			s.unregister(remote, req.GetUnregister().GetNs())
+			continue
+		case pb.Message_DISCOVER:
+			resp = s.discover(req.GetDiscover())
+		default:
+			_ = stream.Reset()
+			return
+		}
+		b, e = proto.Marshal(resp)
+		if e != nil {
+			_ = stream.Reset()
+			return
+		}
+		if e := writeDelimited(stream, b); e != nil {
+			_ = stream.Reset()
+			return
+		}
+	}
+}
+
+// register 保存注册, 签名的节点记录必须属于对方节点
+func (s *rendezvousServer) register(remote peer.ID, reg *pb.Message_Register) *pb.Message {
+	ns := reg.GetNs()
+	if ns == "" || len(ns) > rendezvousMaxNamespace {
+		return s.rejectRegister(pb.Message_E_INVALID_NAMESPACE, "名称空间为空或过长")
+	}
+	ttl := rendezvousDefaultTTL
+	if reg.GetTtl() > 0 {
+		ttl = time.Duration(reg.GetTtl()) * time.Second
+	}
+	if ttl > s.cfg.MaxTTL || reg.GetTtl() > uint64(s.cfg.MaxTTL/time.Second) {
+		return s.rejectRegister(pb.Message_E_INVALID_TTL, "有效期超过上限")
+	}
+	_, rec, e := record.ConsumeEnvelope(reg.GetSignedPeerRecord(), peer.PeerRecordEnvelopeDomain)
+	if e != nil {
+		return s.rejectRegister(pb.Message_E_INVALID_SIGNED_PEER_RECORD, e.Error())
+	}
+	peerRecord, ok := rec.(*peer.PeerRecord)
+	if !ok || len(peerRecord.Addrs) == 0 {
+		return s.rejectRegister(pb.Message_E_INVALID_SIGNED_PEER_RECORD, "没有节点地址")
+	}
+	if peerRecord.PeerID != remote {
+		return s.rejectRegister(pb.Message_E_NOT_AUTHORIZED, "节点记录不属于发送者")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries := s.namespaces[ns]
+	if _, exists := entries[remote]; !exists {
+		if s.perPeer[remote] >= s.cfg.PeerLimit {
+			s.rejected++
+			return registerResponse(pb.Message_E_NOT_AUTHORIZED, "注册数量超过上限", 0)
+		}
+		if s.total >= s.cfg.MaxRegistrations {
+			s.rejected++
+			return registerResponse(pb.Message_E_UNAVAILABLE, "服务的注册数量已满", 0)
+		}
+		if entries == nil {
+			entries = make(map[peer.ID]*rendezvousEntry)
+			s.namespaces[ns] = entries
+		}
+		s.perPeer[remote]++
+		s.total++
+	}
+	s.seq++
+	entries[remote] = &rendezvousEntry{record: reg.GetSignedPeerRecord(), expires: time.Now().Add(ttl), seq: s.seq}
+	s.registers++
+	return registerResponse(pb.Message_OK, "", uint64(ttl/time.Second))
+}
+
+func (s *rendezvousServer) rejectRegister(status pb.Message_ResponseStatus, text string) *pb.Message {
+	s.mu.Lock()
+	s.rejected++
+	s.mu.Unlock()
+	return registerResponse(status, text, 0)
+}
+
+// registerResponse 返回RegisterResponse, ttl为实际使用的有效期(秒), 只在成功时返回
+func registerResponse(status pb.Message_ResponseStatus, text string, ttl uint64) *pb.Message {
+	resp := &pb.Message_RegisterResponse{Status: status.Enum()}
+	if text != "" {
+		resp.StatusText = proto.String(text)
+	}
+	if status == pb.Message_OK {
+		resp.Ttl = proto.Uint64(ttl)
+	}
+	return &pb.Message{Type: pb.Message_REGISTER_RESPONSE.Enum(), RegisterResponse: resp}
+}
+
+// discoverResponse 返回DiscoverResponse, cookie为nil时不返回cookie
+func discoverResponse(status pb.Message_ResponseStatus, text string, regs []*pb.Message_Register, cookie []byte) *pb.Message {
+	resp := &pb.Message_DiscoverResponse{Registrations: regs, Cookie: cookie, Status: status.Enum()}
+	if text != "" {
+		resp.StatusText = proto.String(text)
+	}
+	return &pb.Message{Type: pb.Message_DISCOVER_RESPONSE.Enum(), DiscoverResponse: resp}
+}
+
+// unregister 删除对方节点在名称空间中的注册
+func (s *rendezvousServer) unregister(remote peer.ID, ns string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(ns, remote)
+}
+
+// discover 返回名称空间中的注册, 名称空间为空时返回所有注册.
+// cookie是上次返回的最大序号, 下次只返回之后的注册.
+func (s *rendezvousServer) discover(req *pb.Message_Discover) *pb.Message {
+	ns, reqCookie := req.GetNs(), req.GetCookie()
+	var after uint64
+	if len(reqCookie) > 0 {
+		if len(reqCookie) < 8 || string(reqCookie[8:]) != ns {
+			return discoverResponse(pb.Message_E_INVALID_COOKIE, "cookie错误", nil, nil)
+		}
+		after = binary.BigEndian.Uint64(reqCookie)
+	}
+	limit := int(req.GetLimit())
+	if limit <= 0 {
+		limit = rendezvousDefaultLimit
+	}
+	if limit > rendezvousMaxLimit {
+		limit = rendezvousMaxLimit
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.discovers++
+	type found struct {
+		ns    string
+		entry *rendezvousEntry
+	}
+	var list []found
+	now := time.Now()
+	for name, entries := range s.namespaces {
+		if ns != "" && name != ns {
+			continue
+		}
+		for _, entry := range entries {
+			if entry.seq > after && now.Before(entry.expires) {
+				list = append(list, found{name, entry})
+			}
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].entry.seq < list[j].entry.seq })
+	if len(list) > limit {
+		list = list[:limit]
+	}
+	regs := make([]*pb.Message_Register, 0, len(list))
+	for _, f := range list {
+		regs = append(regs, &pb.Message_Register{Ns: proto.String(f.ns), SignedPeerRecord: f.entry.record, Ttl: proto.Uint64(uint64(f.entry.expires.Sub(now) / time.Second))})
+		after = f.entry.seq
+	}
+	cookie := make([]byte, 8, 8+len(ns))
+	binary.BigEndian.PutUint64(cookie, after)
+	cookie = append(cookie, ns...)
+	return discoverResponse(pb.Message_OK, "", regs, cookie)
+}
+
+// status 返回注册数量和请求计数
+func (s *rendezvousServer) status() interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return map[string]interface{}{
+		"namespaces":    len(s.namespaces),
+		"registrations": s.total,
+		"peers":         len(s.perPeer),
+		"registers":     s.registers,
+		"discovers":     s.discovers,
+		"rejected":      s.rejected,
+	}
+}
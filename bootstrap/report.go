@@ -0,0 +1,116 @@
+package bootstrap
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// defaultSTUNServer 是网络报告默认使用的STUN服务器
+const defaultSTUNServer = "stun.l.google.com:19302"
+
+// reachabilityReport 汇总节点的网络情况
+type reachabilityReport struct {
+	h            host.Host
+	reachability *reachabilityTracker
+	portmap      *portMapper
+	udp          *udpProbeResult
+	bootstrap    int
+	bootstrapAll int
+}
+
+// wait 等待AutoNAT得出结果和端口映射完成, 最长等待timeout
+func (r *reachabilityReport) wait(ctx context.Context, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	select {
+	case <-ctx.Done():
+		return
+	case <-r.portmap.Ready():
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for r.reachability.Get() == network.ReachabilityUnknown {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// print 输出可读的网络报告
+func (r *reachabilityReport) print(w io.Writer) {
+	fmt.Fprintln(w, "===== 网络报告 =====")
+	fmt.Fprintln(w, "节点ID:", r.h.ID())
+
+	reachability := r.reachability.Get()
+	fmt.Fprintln(w, "AutoNAT可达性:", reachability)
+
+	pm := r.portmap.status().(map[string]interface{})
+	if t, ok := pm["type"]; ok {
+		fmt.Fprintln(w, "端口映射:", t, "外部IP:", pm["external_ip"])
+		for _, m := range pm["mappings"].([]portMapping) {
+			fmt.Fprintf(w, "  %s %d -> %d\n", m.Protocol, m.InternalPort, m.ExternalPort)
+		}
+	} else if r.portmap.enableUPnP || r.portmap.enableNATPMP {
+		fmt.Fprintln(w, "端口映射: 没有找到支持UPnP/NAT-PMP的路由器")
+	} else {
+		fmt.Fprintln(w, "端口映射: 已禁用")
+	}
+
+	if r.udp != nil {
+		if r.udp.Usable {
+			fmt.Fprintln(w, "出站UDP: 可用, STUN映射地址", r.udp.Mapped)
+		} else {
+			fmt.Fprintln(w, "出站UDP: 不可用,", r.udp.Error)
+		}
+	}
+
+	fmt.Fprintf(w, "引导节点: 连接 %d / %d\n", r.bootstrap, r.bootstrapAll)
+	transports := make(map[string]int)
+	for _, c := range r.h.Network().Conns() {
+		transports[connTransport(c)]++
+	}
+	names := make([]string, 0, len(transports))
+	for name := range transports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  传输 %s: %d 个连接\n", name, transports[name])
+	}
+
+	fmt.Fprintln(w, "公网地址:")
+	public := 0
+	for _, addr := range r.h.Addrs() {
+		if manet.IsPublicAddr(addr) {
+			fmt.Fprintln(w, " ", addr)
+			public++
+		}
+	}
+	if public == 0 {
+		fmt.Fprintln(w, "  无")
+	}
+
+	fmt.Fprint(w, "结论: ")
+	switch {
+	case reachability == network.ReachabilityPublic:
+		fmt.Fprintln(w, "公网可达, 可以作为引导节点")
+	case reachability == network.ReachabilityPrivate:
+		fmt.Fprintln(w, "位于NAT后, 其他节点无法直接连接, 需要中继或在路由器上转发端口")
+	case r.bootstrap == 0:
+		fmt.Fprintln(w, "无法连接任何引导节点, 请检查出站网络和防火墙")
+	default:
+		fmt.Fprintln(w, "AutoNAT尚未得出结果, 可能连接的节点不足, 请稍后重试")
+	}
+	if r.udp != nil && !r.udp.Usable && transports["udp/quic-v1"] == 0 {
+		fmt.Fprintln(w, "提示: 出站UDP不可用, QUIC无法工作, 只能使用TCP")
+	}
+}
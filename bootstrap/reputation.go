@@ -0,0 +1,267 @@
+package bootstrap
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// reputationMaxSize 是一个信誉列表的最大字节数
+const reputationMaxSize = 64 << 20
+
+// reputationTimeout 是下载一个信誉列表的超时时间
+const reputationTimeout = time.Minute
+
+// reputationConfig 是IP信誉列表的设置. 列表中的IP和网段被连接过滤器拒绝, 用于自动阻止已知的扫描器和滥用者
+type reputationConfig struct {
+	// Feeds 是列表的文件路径或http(s) URL, 每行一个IP或CIDR, #和;之后是注释,
+	// 与FireHOL, Spamhaus DROP等常见格式兼容
+	Feeds []string `yaml:"feeds"`
+	// Interval 是重新读取列表的间隔
+	Interval time.Duration `yaml:"interval"`
+}
+
+// validate 检查信誉列表设置
+func (c reputationConfig) validate() error {
+	if len(c.Feeds) > 0 && c.Interval <= 0 {
+		return fmt.Errorf("信誉列表更新间隔必须大于0: %s", c.Interval)
+	}
+	return nil
+}
+
+// ipSet 是按前缀长度分组的IP网段集合, 查询时每个出现过的前缀长度查一次map
+type ipSet struct {
+	// v4, v6 的键是前缀长度, 值是掩码后的IP(IPv4为4字节), v4Len和v6Len是出现过的前缀长度
+	v4, v6 map[int]map[string]bool
+	v4Len  []int
+	v6Len  []int
+	size   int
+}
+
+func newIPSet() *ipSet {
+	return &ipSet{v4: make(map[int]map[string]bool), v6: make(map[int]map[string]bool)}
+}
+
+func (s *ipSet) add(n *net.IPNet) {
+	ones, bits := n.Mask.Size()
+	nets, lens := s.v6, &s.v6Len
+	ip := n.IP.To16()
+	if bits == 32 {
+		nets, lens, ip = s.v4, &s.v4Len, n.IP.To4()
+	}
+	group, ok := nets[ones]
+	if !ok {
+		group = make(map[string]bool)
+		nets[ones] = group
+		*lens = append(*lens, ones)
+	}
+	key := string(ip.Mask(n.Mask))
+	if !group[key] {
+		group[key] = true
+		s.size++
+	}
+}
+
+func (s *ipSet) contains(ip net.IP) bool {
+	nets, lens, bits := s.v6, s.v6Len, 128
+	if v4 := ip.To4(); v4 != nil {
+		nets, lens, bits, ip = s.v4, s.v4Len, 32, v4
+	}
+	for _, ones := range lens {
+		if nets[ones][string(ip.Mask(net.CIDRMask(ones, bits)))] {
+			return true
+		}
+	}
+	return false
+}
+
+// parseReputation 读取列表, 每行第一项是IP或CIDR, 无法解析的行被忽略, 返回忽略的行数
+func parseReputation(r io.Reader, set *ipSet) (int, error) {
+	skipped := 0
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexAny(line, "#;"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		_, n, e := parseACLEntry(fields[0])
+		if e != nil || n == nil {
+			skipped++
+			continue
+		}
+		set.add(n)
+	}
+	return skipped, scanner.Err()
+}
+
+// reputationFeed 是一个列表最近一次读取的结果
+type reputationFeed struct {
+	Entries int       `json:"entries"`
+	Skipped int       `json:"skipped"`
+	Updated time.Time `json:"updated"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// reputationList 定期读取信誉列表, 由连接过滤器拒绝列表中的地址. 访问控制列表allow中的IP不受影响
+type reputationList struct {
+	cfg     reputationConfig
+	acl     *accessList
+	client  http.Client
+	feedSet map[string]*ipSet
+
+	mu    sync.RWMutex
+	set   []*ipSet
+	feeds map[string]*reputationFeed
+
+	rejected uint64
+}
+
+func newReputationList(cfg reputationConfig, acl *accessList) *reputationList {
+	return &reputationList{cfg: cfg, acl: acl, client: http.Client{Timeout: reputationTimeout}, feedSet: make(map[string]*ipSet), feeds: make(map[string]*reputationFeed)}
+}
+
+// fetch 读取一个列表
+func (l *reputationList) fetch(ctx context.Context, feed string) (*ipSet, int, error) {
+	var r io.ReadCloser
+	if strings.HasPrefix(feed, "http://") || strings.HasPrefix(feed, "https://") {
+		req, e := http.NewRequestWithContext(ctx, http.MethodGet, feed, nil)
+		if e != nil {
+			return nil, 0, e
+		}
+		resp, e := l.client.Do(req)
+		if e != nil {
+			return nil, 0, e
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, 0, errors.New(resp.Status)
+		}
+		r = resp.Body
+	} else {
+		f, e := os.Open(feed)
+		if e != nil {
+			return nil, 0, e
+		}
+		r = f
+	}
+	defer r.Close()
+	set := newIPSet()
+	skipped, e := parseReputation(io.LimitReader(r, reputationMaxSize), set)
+	return set, skipped, e
+}
+
+// refresh 读取所有列表, 读取失败的列表保留上次的内容
+func (l *reputationList) refresh(ctx context.Context) {
+	for _, feed := range l.cfg.Feeds {
+		set, skipped, e := l.fetch(ctx, feed)
+		l.mu.Lock()
+		state, ok := l.feeds[feed]
+		if !ok {
+			state = &reputationFeed{}
+			l.feeds[feed] = state
+		}
+		if e != nil {
+			state.Error = e.Error()
+		} else {
+			l.feedSet[feed] = set
+			state.Entries, state.Skipped, state.Updated, state.Error = set.size, skipped, time.Now(), ""
+		}
+		l.mu.Unlock()
+		if e != nil {
+			logger.Warnw("读取信誉列表出错, 保留上次的内容", "feed", feed, "error", e)
+		} else {
+			logger.Infow("信誉列表已更新", "feed", feed, "entries", set.size, "skipped", skipped)
+		}
+	}
+	sets := make([]*ipSet, 0, len(l.feedSet))
+	for _, set := range l.feedSet {
+		sets = append(sets, set)
+	}
+	l.mu.Lock()
+	l.set = sets
+	l.mu.Unlock()
+}
+
+// blocked 判断地址是否在信誉列表中
+func (l *reputationList) blocked(addr ma.Multiaddr) bool {
+	if addr == nil {
+		return false
+	}
+	ip, e := manet.ToIP(addr)
+	if e != nil {
+		return false
+	}
+	l.mu.RLock()
+	sets := l.set
+	l.mu.RUnlock()
+	for _, set := range sets {
+		if set.contains(ip) {
+			if l.acl != nil && l.acl.allowedIP(ip) {
+				return false
+			}
+			atomic.AddUint64(&l.rejected, 1)
+			return true
+		}
+	}
+	return false
+}
+
+// closeBlocked 关闭地址在信誉列表中的已有连接, 返回关闭的连接数量
+func (l *reputationList) closeBlocked(n network.Network) int {
+	closed := 0
+	for _, c := range n.Conns() {
+		if l.blocked(c.RemoteMultiaddr()) {
+			_ = c.Close()
+			closed++
+		}
+	}
+	return closed
+}
+
+// run 立即读取列表, 之后每Interval重新读取, 每次读取后关闭新阻止的连接
+func (l *reputationList) run(ctx context.Context, n network.Network) {
+	ticker := time.NewTicker(l.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		l.refresh(ctx)
+		if closed := l.closeBlocked(n); closed > 0 {
+			logger.Infow("关闭信誉列表中的连接", "closed", closed)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// status 返回每个列表的条目数量, 更新时间和错误, 以及拒绝次数
+func (l *reputationList) status() interface{} {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	feeds := make(map[string]reputationFeed, len(l.feeds))
+	for feed, state := range l.feeds {
+		feeds[feed] = *state
+	}
+	return map[string]interface{}{
+		"feeds":    feeds,
+		"rejected": atomic.LoadUint64(&l.rejected),
+	}
+}
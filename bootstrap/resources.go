@@ -0,0 +1,252 @@
+package bootstrap
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sync/atomic"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
+)
+
+// resourcesConfig 是libp2p资源管理器的设置. 资源管理器按系统, 节点和协议限制连接, 流和内存,
+// 超过限制时拒绝新的连接或流. 为0的项使用libp2p按可用内存计算的默认值, -1表示不限制
+type resourcesConfig struct {
+	// Enabled 为false时不限制资源, 连接只由连接管理器和入站限制控制
+	Enabled bool `yaml:"enabled"`
+	// MaxMemory 是计算默认值使用的内存(字节), 0表示系统内存的1/8
+	MaxMemory int64 `yaml:"max_memory"`
+	// System 是整个节点的限制, 默认的连接数量不少于连接管理器上限的2倍
+	System resourceLimit `yaml:"system"`
+	// Peer 是每个节点的限制
+	Peer resourceLimit `yaml:"peer"`
+	// Protocols 是每个协议的限制, 键为协议ID, 如/ipfs/kad/1.0.0. 协议只限制流和内存
+	Protocols map[string]resourceLimit `yaml:"protocols"`
+}
+
+// resourceLimit 是一个范围内的连接, 流, 文件描述符和内存(字节)上限
+type resourceLimit struct {
+	Conns           int   `yaml:"conns"`
+	ConnsInbound    int   `yaml:"conns_inbound"`
+	ConnsOutbound   int   `yaml:"conns_outbound"`
+	Streams         int   `yaml:"streams"`
+	StreamsInbound  int   `yaml:"streams_inbound"`
+	StreamsOutbound int   `yaml:"streams_outbound"`
+	FD              int   `yaml:"fd"`
+	Memory          int64 `yaml:"memory"`
+}
+
+// validate 检查资源限制
+func (c resourcesConfig) validate() error {
+	if c.MaxMemory < 0 {
+		return fmt.Errorf("资源管理器的内存不能小于0: %d", c.MaxMemory)
+	}
+	if e := c.System.validate(); e != nil {
+		return fmt.Errorf("系统资源限制: %w", e)
+	}
+	if e := c.Peer.validate(); e != nil {
+		return fmt.Errorf("节点资源限制: %w", e)
+	}
+	for id, l := range c.Protocols {
+		if e := l.validate(); e != nil {
+			return fmt.Errorf("协议 %s 的资源限制: %w", id, e)
+		}
+		if l.Conns != 0 || l.ConnsInbound != 0 || l.ConnsOutbound != 0 || l.FD != 0 {
+			return fmt.Errorf("协议 %s 只能限制流和内存", id)
+		}
+	}
+	return nil
+}
+
+func (l resourceLimit) validate() error {
+	for _, v := range []int64{int64(l.Conns), int64(l.ConnsInbound), int64(l.ConnsOutbound), int64(l.Streams), int64(l.StreamsInbound), int64(l.StreamsOutbound), int64(l.FD), l.Memory} {
+		if v < -1 {
+			return fmt.Errorf("限制不能小于-1: %d", v)
+		}
+	}
+	return nil
+}
+
+// limits 转换为libp2p的限制, 0和-1与libp2p的DefaultLimit和Unlimited相同
+func (l resourceLimit) limits() rcmgr.ResourceLimits {
+	return rcmgr.ResourceLimits{
+		Conns:           rcmgr.LimitVal(l.Conns),
+		ConnsInbound:    rcmgr.LimitVal(l.ConnsInbound),
+		ConnsOutbound:   rcmgr.LimitVal(l.ConnsOutbound),
+		Streams:         rcmgr.LimitVal(l.Streams),
+		StreamsInbound:  rcmgr.LimitVal(l.StreamsInbound),
+		StreamsOutbound: rcmgr.LimitVal(l.StreamsOutbound),
+		FD:              rcmgr.LimitVal(l.FD),
+		Memory:          rcmgr.LimitVal64(l.Memory),
+	}
+}
+
+// concrete 返回生效的限制. 默认值按内存计算, libp2p的默认连接数量适合普通节点, 引导节点不少于连接管理器上限的2倍
+func (c resourcesConfig) concrete(highWater int) rcmgr.ConcreteLimitConfig {
+	scaling := rcmgr.DefaultLimits
+	libp2p.SetDefaultServiceLimits(&scaling)
+	defaults := scaling.AutoScale()
+	if c.MaxMemory > 0 {
+		// 系统的文件描述符上限是进程上限的一半, 与AutoScale相同
+		fd := defaults.ToPartialLimitConfig().System.FD
+		defaults = scaling.Scale(c.MaxMemory, int(fd))
+	}
+	partial := rcmgr.PartialLimitConfig{System: c.System.limits(), PeerDefault: c.Peer.limits()}
+	system := defaults.ToPartialLimitConfig().System
+	if minConns := rcmgr.LimitVal(highWater * 2); c.System.Conns == 0 && system.Conns < minConns {
+		partial.System.Conns = minConns
+	}
+	if minConns := rcmgr.LimitVal(highWater * 2); c.System.ConnsInbound == 0 && system.ConnsInbound < minConns {
+		partial.System.ConnsInbound = minConns
+	}
+	if len(c.Protocols) > 0 {
+		partial.Protocol = make(map[protocol.ID]rcmgr.ResourceLimits, len(c.Protocols))
+		for id, l := range c.Protocols {
+			partial.Protocol[protocol.ID(id)] = l.limits()
+		}
+	}
+	return partial.Build(defaults)
+}
+
+// newResourceManager 创建资源管理器. 每个IP和网段的连接数量由入站限制控制, 不使用资源管理器按网段的限制
+func newResourceManager(c resourcesConfig, highWater int) (network.ResourceManager, *resourceStats, error) {
+	limits := c.concrete(highWater)
+	stats := &resourceStats{limits: limits.ToPartialLimitConfig()}
+	unlimited := []rcmgr.ConnLimitPerSubnet{{PrefixLength: 0, ConnCount: math.MaxInt}}
+	rm, e := rcmgr.NewResourceManager(rcmgr.NewFixedLimiter(limits), rcmgr.WithMetrics(stats), rcmgr.WithLimitPerSubnet(unlimited, unlimited))
+	if e != nil {
+		return nil, nil, fmt.Errorf("创建资源管理器出错: %w", e)
+	}
+	stats.rm = rm
+	return rm, stats, nil
+}
+
+// resourceStats 实现rcmgr.MetricsReporter, 按资源统计被拒绝的次数, 并读取资源管理器的当前用量
+type resourceStats struct {
+	rm     network.ResourceManager
+	limits rcmgr.PartialLimitConfig
+
+	blockedConns     uint64
+	blockedStreams   uint64
+	blockedPeers     uint64
+	blockedProtocols uint64
+	blockedServices  uint64
+	blockedMemory    uint64
+}
+
+func (s *resourceStats) AllowConn(network.Direction, bool)      {}
+func (s *resourceStats) AllowStream(peer.ID, network.Direction) {}
+func (s *resourceStats) AllowPeer(peer.ID)                      {}
+func (s *resourceStats) AllowProtocol(protocol.ID)              {}
+func (s *resourceStats) AllowService(string)                    {}
+func (s *resourceStats) AllowMemory(int)                        {}
+
+func (s *resourceStats) BlockConn(network.Direction, bool) {
+	atomic.AddUint64(&s.blockedConns, 1)
+}
+
+func (s *resourceStats) BlockStream(peer.ID, network.Direction) {
+	atomic.AddUint64(&s.blockedStreams, 1)
+}
+
+func (s *resourceStats) BlockPeer(peer.ID) {
+	atomic.AddUint64(&s.blockedPeers, 1)
+}
+
+func (s *resourceStats) BlockProtocol(protocol.ID) {
+	atomic.AddUint64(&s.blockedProtocols, 1)
+}
+
+func (s *resourceStats) BlockProtocolPeer(protocol.ID, peer.ID) {
+	atomic.AddUint64(&s.blockedProtocols, 1)
+}
+
+func (s *resourceStats) BlockService(string) {
+	atomic.AddUint64(&s.blockedServices, 1)
+}
+
+func (s *resourceStats) BlockServicePeer(string, peer.ID) {
+	atomic.AddUint64(&s.blockedServices, 1)
+}
+
+func (s *resourceStats) BlockMemory(int) {
+	atomic.AddUint64(&s.blockedMemory, 1)
+}
+
+// blocked 返回按资源统计的拒绝次数
+func (s *resourceStats) blocked() map[string]uint64 {
+	return map[string]uint64{
+		"conn":     atomic.LoadUint64(&s.blockedConns),
+		"stream":   atomic.LoadUint64(&s.blockedStreams),
+		"peer":     atomic.LoadUint64(&s.blockedPeers),
+		"protocol": atomic.LoadUint64(&s.blockedProtocols),
+		"service":  atomic.LoadUint64(&s.blockedServices),
+		"memory":   atomic.LoadUint64(&s.blockedMemory),
+	}
+}
+
+// system 返回整个节点和未完成握手(transient)的当前用量
+func (s *resourceStats) system() (system, transient network.ScopeStat) {
+	_ = s.rm.ViewSystem(func(scope network.ResourceScope) error {
+		system = scope.Stat()
+		return nil
+	})
+	_ = s.rm.ViewTransient(func(scope network.ResourceScope) error {
+		transient = scope.Stat()
+		return nil
+	})
+	return system, transient
+}
+
+// protocols 返回节点支持的每个协议的当前用量
+func (s *resourceStats) protocols(h host.Host) map[protocol.ID]network.ScopeStat {
+	stats := make(map[protocol.ID]network.ScopeStat)
+	for _, id := range h.Mux().Protocols() {
+		_ = s.rm.ViewProtocol(id, func(scope network.ProtocolScope) error {
+			stats[id] = scope.Stat()
+			return nil
+		})
+	}
+	return stats
+}
+
+// status 返回系统的限制和用量, 拒绝次数以及每个协议的流数量
+func (s *resourceStats) status(h host.Host) func() interface{} {
+	return func() interface{} {
+		system, transient := s.system()
+		protocols := make(map[string]int)
+		for id, stat := range s.protocols(h) {
+			if streams := stat.NumStreamsInbound + stat.NumStreamsOutbound; streams > 0 {
+				protocols[string(id)] = streams
+			}
+		}
+		return map[string]interface{}{
+			"limits":    s.limits.System,
+			"system":    system,
+			"transient": transient,
+			"blocked":   s.blocked(),
+			"protocols": protocols,
+		}
+	}
+}
+
+// writeMetrics 输出资源管理器的用量和拒绝次数
+func (s *resourceStats) writeMetrics(w io.Writer, h host.Host) {
+	system, transient := s.system()
+	writeLabeledMetric(w, "bootstrap_rcmgr_conns", "gauge", "资源管理器计入的连接数量", "direction", map[string]uint64{"inbound": uint64(system.NumConnsInbound), "outbound": uint64(system.NumConnsOutbound)})
+	writeLabeledMetric(w, "bootstrap_rcmgr_streams", "gauge", "资源管理器计入的流数量", "direction", map[string]uint64{"inbound": uint64(system.NumStreamsInbound), "outbound": uint64(system.NumStreamsOutbound)})
+	writeMetric(w, "bootstrap_rcmgr_memory_bytes", "gauge", "资源管理器计入的内存", uint64(system.Memory))
+	writeMetric(w, "bootstrap_rcmgr_fds", "gauge", "资源管理器计入的文件描述符数量", uint64(system.NumFD))
+	writeMetric(w, "bootstrap_rcmgr_transient_conns", "gauge", "还没有完成握手的连接数量", uint64(transient.NumConnsInbound+transient.NumConnsOutbound))
+	writeLabeledMetric(w, "bootstrap_rcmgr_blocked_total", "counter", "按资源统计的超过资源限制被拒绝的次数", "resource", s.blocked())
+	streams := make(map[string]uint64)
+	for id, stat := range s.protocols(h) {
+		streams[string(id)] = uint64(stat.NumStreamsInbound + stat.NumStreamsOutbound)
+	}
+	writeLabeledMetric(w, "bootstrap_rcmgr_protocol_streams", "gauge", "按协议统计的流数量", "protocol", streams)
+}
@@ -0,0 +1,25 @@
+package bootstrap
+
+import (
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/p2p/transport/quicreuse"
+	tcp "github.com/libp2p/go-libp2p/p2p/transport/tcp"
+)
+
+// tcpTransportOption 返回TCP传输的设置, reuse为false时出站连接不使用监听端口.
+// reuse为true时仍可以用环境变量LIBP2P_TCP_REUSEPORT=false关闭
+func tcpTransportOption(reuse bool) libp2p.Option {
+	if !reuse {
+		return libp2p.Transport(tcp.NewTCPTransport, tcp.DisableReuseport())
+	}
+	return libp2p.Transport(tcp.NewTCPTransport)
+}
+
+// quicReuseOption 返回QUIC连接复用的设置, reuse为false时监听和出站连接使用不同的UDP端口,
+// 没有监听的出站连接从一个随机端口拨号
+func quicReuseOption(reuse bool) libp2p.Option {
+	if !reuse {
+		return libp2p.QUICReuse(quicreuse.NewConnManager, quicreuse.DisableReuseport())
+	}
+	return libp2p.QUICReuse(quicreuse.NewConnManager)
+}
@@ -0,0 +1,94 @@
+package bootstrap
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// rotationFileName 记录正在进行的身份轮换, 保存在数据目录中
+const rotationFileName = "rotation.json"
+
+// rotationProtocol 是告知其他节点新身份的协议, 对方打开流后发送一个JSON并关闭流
+const rotationProtocol protocol.ID = "/bootstrap/rotate/1.0.0"
+
+// keyRotation 是身份轮换记录. 宽限期内节点继续使用旧身份, 并告知其他节点新身份
+type keyRotation struct {
+	OldKeyPath string    `json:"old_key_path"`
+	OldID      string    `json:"old_id"`
+	NewID      string    `json:"new_id"`
+	Until      time.Time `json:"until"`
+}
+
+// rotationAnnouncement 是发送给其他节点的新身份
+type rotationAnnouncement struct {
+	OldID string    `json:"old_id"`
+	NewID string    `json:"new_id"`
+	Addrs []string  `json:"addrs"`
+	Until time.Time `json:"until"`
+}
+
+// readRotation 读取身份轮换记录, 没有时返回nil
+func readRotation(dir string) (*keyRotation, error) {
+	b, e := ioutil.ReadFile(filepath.Join(dir, rotationFileName))
+	if os.IsNotExist(e) {
+		return nil, nil
+	}
+	if e != nil {
+		return nil, e
+	}
+	r := &keyRotation{}
+	if e := json.Unmarshal(b, r); e != nil {
+		return nil, e
+	}
+	return r, nil
+}
+
+// save 保存身份轮换记录
+func (r *keyRotation) save(dir string) error {
+	b, e := json.MarshalIndent(r, "", "  ")
+	if e != nil {
+		return e
+	}
+	return ioutil.WriteFile(filepath.Join(dir, rotationFileName), b, 0600)
+}
+
+// removeRotation 删除身份轮换记录
+func removeRotation(dir string) error {
+	e := os.Remove(filepath.Join(dir, rotationFileName))
+	if os.IsNotExist(e) {
+		return nil
+	}
+	return e
+}
+
+// announcement 返回新身份的地址, 监听地址不变, 只替换节点ID
+func (r *keyRotation) announcement(h host.Host) rotationAnnouncement {
+	ann := rotationAnnouncement{OldID: r.OldID, NewID: r.NewID, Until: r.Until}
+	p2pAddr, e := ma.NewMultiaddr("/p2p/" + r.NewID)
+	if e != nil {
+		return ann
+	}
+	for _, addr := range h.Addrs() {
+		ann.Addrs = append(ann.Addrs, addr.Encapsulate(p2pAddr).String())
+	}
+	return ann
+}
+
+// newRotationHandler 返回轮换协议处理器, 对方打开流时发送新身份
+func newRotationHandler(h host.Host, r *keyRotation) network.StreamHandler {
+	return func(s network.Stream) {
+		if e := json.NewEncoder(s).Encode(r.announcement(h)); e != nil {
+			_ = s.Reset()
+			return
+		}
+		_ = s.Close()
+	}
+}
@@ -0,0 +1,125 @@
+package bootstrap
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	kb "github.com/libp2p/go-libp2p-kbucket"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+)
+
+// routingTablePeer 是路由表中的一个节点
+type routingTablePeer struct {
+	ID        string    `json:"id"`
+	Addrs     []string  `json:"addrs"`
+	Connected bool      `json:"connected"`
+	AddedAt   time.Time `json:"added_at"`
+	// LastUsefulAt 是对方最后一次对查询有用的时间
+	LastUsefulAt time.Time `json:"last_useful_at"`
+	// LastQueryAt 是最后一次成功查询对方的时间
+	LastQueryAt time.Time `json:"last_query_at"`
+}
+
+// routingTableBucket 是路由表中与本节点ID共同前缀长度(cpl)相同的节点
+type routingTableBucket struct {
+	Cpl   int                `json:"cpl"`
+	Peers []routingTablePeer `json:"peers"`
+}
+
+// routingTableDump 是 /routing-table 的内容
+type routingTableDump struct {
+	Size    int                  `json:"size"`
+	Buckets []routingTableBucket `json:"buckets"`
+}
+
+// dumpRoutingTable 按桶列出DHT路由表中的节点
+func dumpRoutingTable(h host.Host, idht *dht.IpfsDHT) routingTableDump {
+	self := kb.ConvertPeerID(h.ID())
+	buckets := make(map[int][]routingTablePeer)
+	infos := idht.RoutingTable().GetPeerInfos()
+	for _, info := range infos {
+		p := routingTablePeer{
+			ID:           info.Id.String(),
+			Connected:    h.Network().Connectedness(info.Id) == network.Connected,
+			AddedAt:      info.AddedAt,
+			LastUsefulAt: info.LastUsefulAt,
+			LastQueryAt:  info.LastSuccessfulOutboundQueryAt,
+		}
+		for _, addr := range h.Peerstore().Addrs(info.Id) {
+			p.Addrs = append(p.Addrs, addr.String())
+		}
+		cpl := kb.CommonPrefixLen(self, kb.ConvertPeerID(info.Id))
+		buckets[cpl] = append(buckets[cpl], p)
+	}
+	dump := routingTableDump{Size: len(infos), Buckets: make([]routingTableBucket, 0, len(buckets))}
+	for cpl, peers := range buckets {
+		dump.Buckets = append(dump.Buckets, routingTableBucket{Cpl: cpl, Peers: peers})
+	}
+	sort.Slice(dump.Buckets, func(i, j int) bool { return dump.Buckets[i].Cpl < dump.Buckets[j].Cpl })
+	return dump
+}
+
+// routingTableHandler 以JSON形式提供DHT路由表
+func routingTableHandler(h host.Host, idht *dht.IpfsDHT) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if e := json.NewEncoder(w).Encode(dumpRoutingTable(h, idht)); e != nil {
+			logger.Warnw("输出路由表出错", "error", e)
+		}
+	}
+}
+
+// runRoutingTable 从运行中节点的HTTP服务查询DHT路由表
+func runRoutingTable(args []string) int {
+	fs := flag.NewFlagSet("routing-table", flag.ExitOnError)
+	cfg, e := commandConfig(fs, args)
+	if e != nil {
+		logger.Errorw("读取设置出错", "error", e)
+		return 1
+	}
+	if cfg.HTTPAddr == "" {
+		logger.Errorw("没有设置-http-addr, 无法查询节点")
+		return 1
+	}
+	client := http.Client{Timeout: time.Second * 10}
+	resp, e := client.Get("http://" + cfg.HTTPAddr + "/routing-table")
+	if e != nil {
+		logger.Errorw("查询路由表出错", "error", e)
+		return 1
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		logger.Errorw("查询路由表出错", "status", resp.Status)
+		return 1
+	}
+	var dump routingTableDump
+	if e := json.NewDecoder(resp.Body).Decode(&dump); e != nil {
+		logger.Errorw("解析结果出错", "error", e)
+		return 1
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, b := range dump.Buckets {
+		for _, p := range b.Peers {
+			fmt.Fprintf(tw, "%d\t%s\t%v\t%s\t%s\n", b.Cpl, p.ID, p.Connected, since(p.LastUsefulAt), since(p.LastQueryAt))
+		}
+	}
+	_ = tw.Flush()
+	logger.Infow("路由表", "size", dump.Size, "buckets", len(dump.Buckets))
+	return 0
+}
+
+// since 返回距离t的时间, t为零值时返回-
+func since(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return time.Since(t).Truncate(time.Second).String()
+}
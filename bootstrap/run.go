@@ -0,0 +1,166 @@
+package bootstrap
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/pnet"
+)
+
+// runNode 运行引导节点, 是默认的子命令
+func runNode(args []string) int {
+	configPath := configPathFromArgs(args)
+	cfg, e := baseConfig(configPath, args)
+	if e != nil {
+		fatalConfig("读取设置出错", e)
+	}
+	flag.String("config", "", "YAML配置文件路径, 也可以使用环境变量BOOTSTRAP_CONFIG. 优先级: 命令行参数 > 环境变量 > 配置文件")
+	cfg.registerFlags(flag.CommandLine)
+	checkAddrFlag := flag.String("check-addr", "", "检查节点地址(如/ip4/.../p2p/Qm...)是否能连接后退出, 不启动节点")
+	reportFlag := flag.Bool("reachability-report", false, "启动节点运行各项网络检查, 输出网络报告后退出")
+	reportTimeout := flag.Duration("report-timeout", time.Minute, "网络报告等待AutoNAT结果的最长时间")
+	_ = flag.CommandLine.Parse(args)
+	if e := checkEphemeral(cfg); e != nil {
+		fatalConfig("临时模式设置错误", e)
+	}
+	// 临时模式在设置日志之前去掉日志文件
+	cfg.applyEphemeral()
+	if e := cfg.Log.validate(); e != nil {
+		fatalConfig("日志设置错误", e)
+	}
+	if cfg.Daemon && !isDaemonChild() {
+		pid, e := startDaemon(cfg.Log.File)
+		if e != nil {
+			fatalStartup("后台运行出错", e)
+		}
+		logger.Infow("已在后台运行", "pid", pid)
+		return 0
+	}
+	if e := cfg.Log.apply(); e != nil {
+		fatalConfig("日志设置错误", e)
+	}
+
+	if *checkAddrFlag != "" {
+		var psk pnet.PSK
+		if cfg.SwarmKey != "" {
+			if psk, e = loadSwarmKey(cfg.SwarmKey); e != nil {
+				fatalConfig("读取私有网络密钥出错", e)
+			}
+		}
+		return runCheckAddr(context.Background(), *checkAddrFlag, psk)
+	}
+
+	// 网络报告检查出站UDP
+	if *reportFlag && cfg.UDPProbe == "" {
+		cfg.UDPProbe = defaultSTUNServer
+	}
+	names, nodes, e := newNodes(cfg)
+	if e != nil {
+		fatalStartup("启动节点出错", e)
+	}
+	n := nodes[0]
+	for i, node := range nodes {
+		node.configPath, node.args, node.network = configPath, args, names[i]
+	}
+	if cfg.PIDFile != "" {
+		if e := writePIDFile(cfg.PIDFile); e != nil {
+			fatalStartup("写PID文件出错", e)
+		}
+		defer func() {
+			if e := removePIDFile(cfg.PIDFile); e != nil {
+				logger.Warnw("删除PID文件出错", "error", e)
+			}
+		}()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for i, node := range nodes {
+		if e := node.Start(ctx); e != nil {
+			for _, started := range nodes[:i] {
+				started.stop(nil)
+			}
+			if cfg.PIDFile != "" {
+				_ = removePIDFile(cfg.PIDFile)
+			}
+			if names[i] != "" {
+				logger.Errorw("网络启动失败", "network", names[i])
+			}
+			fatalStartup("启动节点出错", e)
+		}
+	}
+	if len(nodes) > 1 {
+		mountNetworks(names, nodes)
+	}
+	if *reportFlag {
+		var wg sync.WaitGroup
+		for _, node := range nodes {
+			wg.Add(1)
+			go func(node *Node) {
+				defer wg.Done()
+				node.report.wait(ctx, *reportTimeout)
+			}(node)
+		}
+		wg.Wait()
+		for i, node := range nodes {
+			if names[i] != "" {
+				fmt.Println("网络:", names[i])
+			}
+			node.report.print(os.Stdout)
+			node.stop(nil)
+		}
+		return 0
+	}
+
+	// systemd: 就绪后发送READY=1, 启用watchdog时在事件总线正常时回应
+	go sdNotifyReady(ctx, n.ready)
+	watchdog, e := sdWatchdogInterval()
+	if e != nil {
+		logger.Warnw("不回应systemd watchdog", "error", e)
+	} else if watchdog > 0 {
+		if e := sdWatchdog(ctx, n.Host().EventBus(), watchdog); e != nil {
+			fatalStartup("启动systemd watchdog出错", e)
+		}
+		logger.Infow("systemd watchdog", "interval", watchdog)
+	}
+
+	// wait for a SIGINT or SIGTERM signal, SIGHUP重新加载配置, SIGUSR1输出运行状态
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, append([]os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP}, dumpSignals...)...)
+	notifyServiceStop(signalChan)
+	for sig := range signalChan {
+		if isDumpSignal(sig) {
+			for i, node := range nodes {
+				if names[i] != "" {
+					logger.Infow("运行状态", "network", names[i])
+				}
+				node.dumpState()
+			}
+			continue
+		}
+		if sig == syscall.SIGHUP {
+			logger.Infow("收到SIGHUP, 重新加载配置")
+			_, _ = sdNotify("RELOADING=1")
+			for _, node := range nodes {
+				node.reload()
+			}
+			_, _ = sdNotify("READY=1")
+			continue
+		}
+		break
+	}
+	logger.Infow("收到信号, 关闭程序", "timeout", cfg.ShutdownTimeout)
+	_, _ = sdNotify("STOPPING=1")
+	code := 0
+	for _, node := range nodes {
+		if !node.stop(signalChan) {
+			code = 1
+		}
+	}
+	return code
+}
@@ -0,0 +1,490 @@
+package bootstrap
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	connmgr "github.com/libp2p/go-libp2p/p2p/net/connmgr"
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// tagScore 是节点评分的连接管理器标签
+const tagScore = "score"
+
+// 评分的范围和各项的分值
+const (
+	scoreMax             = 20
+	scoreMin             = -50
+	scoreFastLatency     = 100 * time.Millisecond
+	scoreSlowLatency     = 500 * time.Millisecond
+	scorePingFailure     = 5
+	scoreIdentifyFailure = 10
+)
+
+// scorePingWorkers 是同时ping的节点数量
+const scorePingWorkers = 8
+
+// scoreLatencyWindow 是每个节点保留的最近ping结果数量, 用于计算延迟统计和失败比例
+const scoreLatencyWindow = 10
+
+// scoreTrimMinSamples 是按延迟修剪前至少需要的ping结果数量, 刚连接的节点不修剪
+const scoreTrimMinSamples = 3
+
+// pingBuckets 是ping延迟直方图的上界(秒)
+var pingBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// scoringConfig 是节点评分的设置
+type scoringConfig struct {
+	// Interval 是ping已连接节点并更新评分的间隔, 0表示不评分
+	Interval time.Duration `yaml:"interval"`
+	// PruneAfter 是连续ping失败多少次后断开连接, 受保护的节点除外, 0表示不断开
+	PruneAfter int `yaml:"prune_after"`
+	// PruneLatency 和 PruneLoss 不为0时, 连接的节点超过上限(high water)后先断开最近平均延迟超过PruneLatency
+	// 或最近ping失败比例超过PruneLoss的节点, 失败最多和延迟最高的优先, 最多断开到下限(low water)
+	PruneLatency time.Duration `yaml:"prune_latency"`
+	PruneLoss    float64       `yaml:"prune_loss"`
+}
+
+// validate 检查评分设置
+func (c scoringConfig) validate() error {
+	if c.Interval < 0 || c.PruneAfter < 0 {
+		return fmt.Errorf("节点评分设置错误: interval %s, prune_after %d", c.Interval, c.PruneAfter)
+	}
+	if c.PruneLatency < 0 || c.PruneLoss < 0 || c.PruneLoss > 1 {
+		return fmt.Errorf("节点评分设置错误: prune_latency %s 不能小于0, prune_loss %g 应在0到1之间", c.PruneLatency, c.PruneLoss)
+	}
+	return nil
+}
+
+// peerScore 是一个节点的表现
+type peerScore struct {
+	latency          time.Duration
+	pingFailures     int
+	identifyFailures int
+	score            int
+	// window 是最近的ping结果, 0表示失败
+	window []time.Duration
+}
+
+// record 记录一次ping结果, rtt为0表示失败
+func (ps *peerScore) record(rtt time.Duration) {
+	if rtt == 0 {
+		ps.pingFailures++
+	} else {
+		ps.pingFailures = 0
+		ps.latency = rtt
+	}
+	ps.window = append(ps.window, rtt)
+	if len(ps.window) > scoreLatencyWindow {
+		ps.window = ps.window[1:]
+	}
+}
+
+// lossy 判断最近的ping是否有一半以上失败, 时好时坏的节点连续失败次数达不到上限
+func (ps *peerScore) lossy() bool {
+	if len(ps.window) < scoreLatencyWindow {
+		return false
+	}
+	failures := 0
+	for _, rtt := range ps.window {
+		if rtt == 0 {
+			failures++
+		}
+	}
+	return failures*2 > len(ps.window)
+}
+
+// recent 返回最近成功的ping的平均延迟, 失败比例和ping次数
+func (ps *peerScore) recent() (avg time.Duration, loss float64, n int) {
+	var sum time.Duration
+	failures := 0
+	for _, rtt := range ps.window {
+		if rtt == 0 {
+			failures++
+		} else {
+			sum += rtt
+		}
+	}
+	n = len(ps.window)
+	if n == 0 {
+		return 0, 0, 0
+	}
+	if n > failures {
+		avg = sum / time.Duration(n-failures)
+	}
+	return avg, float64(failures) / float64(n), n
+}
+
+// peerLatency 是节点最近ping的延迟统计, 延迟单位为毫秒
+type peerLatency struct {
+	Peer                string  `json:"peer"`
+	LastMs              float64 `json:"last_ms"`
+	MinMs               float64 `json:"min_ms"`
+	AvgMs               float64 `json:"avg_ms"`
+	MaxMs               float64 `json:"max_ms"`
+	Samples             int     `json:"samples"`
+	Failures            int     `json:"failures"`
+	ConsecutiveFailures int     `json:"consecutive_failures"`
+	Score               int     `json:"score"`
+}
+
+// stats 计算最近ping的延迟统计, 调用时持有锁
+func (ps *peerScore) stats(p peer.ID) peerLatency {
+	l := peerLatency{Peer: p.String(), ConsecutiveFailures: ps.pingFailures, Score: ps.score}
+	var sum, min, max time.Duration
+	for _, rtt := range ps.window {
+		if rtt == 0 {
+			l.Failures++
+			continue
+		}
+		l.Samples++
+		sum += rtt
+		if min == 0 || rtt < min {
+			min = rtt
+		}
+		if rtt > max {
+			max = rtt
+		}
+	}
+	if l.Samples > 0 {
+		l.LastMs = milliseconds(ps.latency)
+		l.MinMs = milliseconds(min)
+		l.AvgMs = milliseconds(sum / time.Duration(l.Samples))
+		l.MaxMs = milliseconds(max)
+	}
+	return l
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// peerScorer 定期ping已连接的节点, 按最近的平均延迟, ping失败和identify失败计算评分,
+// 评分作为连接管理器的标签权重, 连接过多时优先修剪评分低的节点.
+// 连续ping失败达到上限或最近一半以上ping失败的节点被认为没有响应, 断开连接.
+type peerScorer struct {
+	h   host.Host
+	cfg scoringConfig
+
+	mu     sync.Mutex
+	peers  map[peer.ID]*peerScore
+	pruned uint64
+	// trimmed 是超过连接上限时因为延迟高或失败多断开的节点数量
+	trimmed uint64
+	// 所有ping的直方图和次数
+	buckets   []uint64
+	rttSum    time.Duration
+	successes uint64
+	failures  uint64
+}
+
+func newPeerScorer(h host.Host, cfg scoringConfig) *peerScorer {
+	return &peerScorer{h: h, cfg: cfg, peers: make(map[peer.ID]*peerScore), buckets: make([]uint64, len(pingBuckets))}
+}
+
+// subscribe 注册网络通知和identify失败事件
+func (s *peerScorer) subscribe(subs *subscriptions) error {
+	s.h.Network().Notify(s)
+	return subs.Subscribe(s.h.EventBus(), new(event.EvtPeerIdentificationFailed), func(evt interface{}) {
+		p := evt.(event.EvtPeerIdentificationFailed).Peer
+		s.mu.Lock()
+		s.get(p).identifyFailures++
+		s.mu.Unlock()
+	})
+}
+
+// get 返回节点的记录, 调用时持有锁
+func (s *peerScorer) get(p peer.ID) *peerScore {
+	ps, ok := s.peers[p]
+	if !ok {
+		ps = &peerScore{}
+		s.peers[p] = ps
+	}
+	return ps
+}
+
+func (s *peerScorer) run(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.pingAll(ctx)
+			s.update()
+			s.trim()
+		}
+	}
+}
+
+// pingAll ping所有已连接的节点, 记录延迟和连续失败次数
+func (s *peerScorer) pingAll(ctx context.Context) {
+	peers := s.h.Network().Peers()
+	queue := make(chan peer.ID)
+	var wg sync.WaitGroup
+	for i := 0; i < scorePingWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range queue {
+				rtt, e := s.ping(ctx, p)
+				s.mu.Lock()
+				s.observe(rtt, e)
+				s.get(p).record(rtt)
+				s.mu.Unlock()
+			}
+		}()
+	}
+	for _, p := range peers {
+		select {
+		case <-ctx.Done():
+		case queue <- p:
+		}
+	}
+	close(queue)
+	wg.Wait()
+}
+
+// observe 把一次ping计入直方图, 调用时持有锁
+func (s *peerScorer) observe(rtt time.Duration, e error) {
+	if e != nil {
+		s.failures++
+		return
+	}
+	s.successes++
+	s.rttSum += rtt
+	for i, le := range pingBuckets {
+		if rtt.Seconds() <= le {
+			s.buckets[i]++
+		}
+	}
+}
+
+// ping 发送一次ping, 成功时记录到地址簿的延迟
+func (s *peerScorer) ping(ctx context.Context, p peer.ID) (time.Duration, error) {
+	pc, cancel := context.WithTimeout(ctx, connectTimeout)
+	defer cancel()
+	result := <-ping.Ping(pc, s.h, p)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	// 本机连接的延迟可能为0, 记为最小的可表示值以区别于失败
+	if result.RTT <= 0 {
+		result.RTT = time.Nanosecond
+	}
+	s.h.Peerstore().RecordLatency(p, result.RTT)
+	return result.RTT, nil
+}
+
+// healthy 判断节点是否健康: 评分不低于0且最近的ping失败不多, 还没有ping过的节点也算健康
+func (s *peerScorer) healthy(p peer.ID) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ps, ok := s.peers[p]
+	return !ok || (ps.score >= 0 && !ps.lossy())
+}
+
+// scoreOf 计算评分: 最近的平均延迟低加分, 最近的ping失败和identify失败减分
+func scoreOf(ps *peerScore) int {
+	score := 0
+	var sum time.Duration
+	samples, failures := 0, 0
+	for _, rtt := range ps.window {
+		if rtt == 0 {
+			failures++
+		} else {
+			sum += rtt
+			samples++
+		}
+	}
+	if samples > 0 {
+		switch avg := sum / time.Duration(samples); {
+		case avg < scoreFastLatency:
+			score += scoreMax
+		case avg < scoreSlowLatency:
+			score += scoreMax / 2
+		}
+	}
+	score -= failures * scorePingFailure
+	score -= ps.identifyFailures * scoreIdentifyFailure
+	if score > scoreMax {
+		score = scoreMax
+	} else if score < scoreMin {
+		score = scoreMin
+	}
+	return score
+}
+
+// update 更新连接管理器标签并断开没有响应的节点. identify失败每次减半, 逐渐恢复评分
+func (s *peerScorer) update() {
+	cm := s.h.ConnManager()
+	var prune []peer.ID
+	s.mu.Lock()
+	for p, ps := range s.peers {
+		ps.score = scoreOf(ps)
+		ps.identifyFailures /= 2
+		cm.TagPeer(p, tagScore, ps.score)
+		if s.cfg.PruneAfter > 0 && (ps.pingFailures >= s.cfg.PruneAfter || ps.lossy()) && !cm.IsProtected(p, "") {
+			prune = append(prune, p)
+		}
+	}
+	s.mu.Unlock()
+	for _, p := range prune {
+		s.mu.Lock()
+		stats := s.get(p).stats(p)
+		s.pruned++
+		s.mu.Unlock()
+		logger.Infow("断开没有响应的节点", "peer", p, "ping_failures", stats.ConsecutiveFailures, "recent_failures", stats.Failures)
+		_ = s.h.Network().ClosePeer(p)
+	}
+}
+
+// trim 在连接的节点超过连接管理器的上限时, 断开延迟或失败比例超过阈值的节点, 最多断开到下限.
+// 连接管理器只按标签权重修剪, 权重相同时断开哪个节点是任意的
+func (s *peerScorer) trim() {
+	if s.cfg.PruneLatency <= 0 && s.cfg.PruneLoss <= 0 {
+		return
+	}
+	cm := s.h.ConnManager()
+	withInfo, ok := cm.(interface{ Info() connmgr.CMInfo })
+	if !ok {
+		return
+	}
+	info := withInfo.Info()
+	connected := len(s.h.Network().Peers())
+	if info.HighWater <= 0 || connected <= info.HighWater {
+		return
+	}
+	type candidate struct {
+		p    peer.ID
+		avg  time.Duration
+		loss float64
+	}
+	var candidates []candidate
+	s.mu.Lock()
+	for p, ps := range s.peers {
+		avg, loss, n := ps.recent()
+		if n < scoreTrimMinSamples || cm.IsProtected(p, "") {
+			continue
+		}
+		slow := s.cfg.PruneLatency > 0 && avg > s.cfg.PruneLatency
+		lossy := s.cfg.PruneLoss > 0 && loss > s.cfg.PruneLoss
+		if slow || lossy {
+			candidates = append(candidates, candidate{p, avg, loss})
+		}
+	}
+	s.mu.Unlock()
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].loss != candidates[j].loss {
+			return candidates[i].loss > candidates[j].loss
+		}
+		return candidates[i].avg > candidates[j].avg
+	})
+	if excess := connected - info.LowWater; len(candidates) > excess {
+		candidates = candidates[:excess]
+	}
+	for _, c := range candidates {
+		logger.Infow("连接过多, 断开延迟高或失败多的节点", "peer", c.p, "avg_latency", c.avg, "loss", c.loss)
+		_ = s.h.Network().ClosePeer(c.p)
+	}
+	s.mu.Lock()
+	s.trimmed += uint64(len(candidates))
+	s.mu.Unlock()
+}
+
+func (s *peerScorer) Connected(network.Network, network.Conn) {}
+
+// Disconnected 在与节点的所有连接断开后删除记录
+func (s *peerScorer) Disconnected(n network.Network, c network.Conn) {
+	p := c.RemotePeer()
+	if n.Connectedness(p) == network.Connected {
+		return
+	}
+	s.mu.Lock()
+	delete(s.peers, p)
+	s.mu.Unlock()
+}
+
+func (s *peerScorer) Listen(network.Network, ma.Multiaddr)         {}
+func (s *peerScorer) ListenClose(network.Network, ma.Multiaddr)    {}
+func (s *peerScorer) OpenedStream(network.Network, network.Stream) {}
+func (s *peerScorer) ClosedStream(network.Network, network.Stream) {}
+
+// status 返回评分分布和断开的节点数量
+func (s *peerScorer) status() interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	distribution := map[string]int{"positive": 0, "zero": 0, "negative": 0}
+	unresponsive := 0
+	for _, ps := range s.peers {
+		switch {
+		case ps.score > 0:
+			distribution["positive"]++
+		case ps.score < 0:
+			distribution["negative"]++
+		default:
+			distribution["zero"]++
+		}
+		if ps.pingFailures > 0 || ps.lossy() {
+			unresponsive++
+		}
+	}
+	return map[string]interface{}{
+		"interval":     s.cfg.Interval.String(),
+		"peers":        len(s.peers),
+		"scores":       distribution,
+		"unresponsive": unresponsive,
+		"pruned":       s.pruned,
+		"trimmed":      s.trimmed,
+	}
+}
+
+// latencies 返回已连接节点最近ping的延迟统计, 按平均延迟排序, 没有成功的ping的节点在最后
+func (s *peerScorer) latencies() []peerLatency {
+	s.mu.Lock()
+	list := make([]peerLatency, 0, len(s.peers))
+	for p, ps := range s.peers {
+		list = append(list, ps.stats(p))
+	}
+	s.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		if (list[i].Samples == 0) != (list[j].Samples == 0) {
+			return list[j].Samples == 0
+		}
+		return list[i].AvgMs < list[j].AvgMs
+	})
+	return list
+}
+
+// writeMetrics 输出ping延迟直方图, ping次数和没有响应的节点数量
+func (s *peerScorer) writeMetrics(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	const name = "bootstrap_ping_rtt_seconds"
+	fmt.Fprintf(w, "# HELP %s ping已连接节点的延迟\n# TYPE %s histogram\n", name, name)
+	for i, le := range pingBuckets {
+		fmt.Fprintf(w, "%s_bucket{le=\"%g\"} %d\n", name, le, s.buckets[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n%s_sum %g\n%s_count %d\n", name, s.successes, name, s.rttSum.Seconds(), name, s.successes)
+	writeLabeledMetric(w, "bootstrap_pings_total", "counter", "按结果统计的ping次数", "result", map[string]uint64{
+		"success": s.successes,
+		"failure": s.failures,
+	})
+	unresponsive := 0
+	for _, ps := range s.peers {
+		if ps.pingFailures > 0 || ps.lossy() {
+			unresponsive++
+		}
+	}
+	writeMetric(w, "bootstrap_unresponsive_peers", "gauge", "最近ping失败的已连接节点数量", uint64(unresponsive))
+}
@@ -0,0 +1,264 @@
+package bootstrap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	record "github.com/libp2p/go-libp2p-record"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	corerecord "github.com/libp2p/go-libp2p/core/record"
+	"github.com/libp2p/go-libp2p/core/routing"
+)
+
+// PeerRecordNamespace 是DHT中签名节点记录的命名空间, 键为 /peer/<节点ID>
+const PeerRecordNamespace = "peer"
+
+// selfRecordDelay 是地址变化后等待地址稳定再发布的时间
+const selfRecordDelay = time.Second * 10
+
+// selfRecordTimeout 是一次发布的超时时间
+const selfRecordTimeout = time.Minute * 2
+
+// selfRecordConfig 是定期发布本节点地址的设置. 只知道节点ID的客户端(如扫描二维码)
+// 在节点IP变化后仍能通过DHT查到当前的地址
+type selfRecordConfig struct {
+	// Enabled 为true时定期发布, 地址变化时立即发布
+	Enabled bool `yaml:"enabled"`
+	// Interval 是重新发布的间隔, DHT最多保存记录36小时, 离节点ID最近的节点的地址簿中的地址也会过期
+	Interval time.Duration `yaml:"interval"`
+}
+
+// validate 检查发布设置
+func (c selfRecordConfig) validate() error {
+	if c.Enabled && c.Interval <= 0 {
+		return fmt.Errorf("发布本节点地址的间隔必须大于0: %s", c.Interval)
+	}
+	return nil
+}
+
+// PeerRecordKey 返回节点在DHT中的签名节点记录的键
+func PeerRecordKey(id peer.ID) string {
+	return "/" + PeerRecordNamespace + "/" + id.String()
+}
+
+// PeerRecordValidator 验证 /peer/<节点ID> 下的签名节点记录(libp2p的peer record), 记录必须由键中的节点签名,
+// 选择序号最大的记录. 客户端查询记录时也需要用dht.NamespacedValidator设置
+type PeerRecordValidator struct{}
+
+// parse 解析并验证记录
+func (PeerRecordValidator) parse(key string, value []byte) (*peer.PeerRecord, error) {
+	id, e := recordOwner(key)
+	if e != nil {
+		return nil, fmt.Errorf("键中没有节点ID: %w", e)
+	}
+	envelope, rec, e := corerecord.ConsumeEnvelope(value, peer.PeerRecordEnvelopeDomain)
+	if e != nil {
+		return nil, e
+	}
+	peerRecord, ok := rec.(*peer.PeerRecord)
+	if !ok {
+		return nil, errors.New("记录不是节点记录")
+	}
+	signer, e := peer.IDFromPublicKey(envelope.PublicKey)
+	if e != nil {
+		return nil, e
+	}
+	if signer != id || peerRecord.PeerID != id {
+		return nil, errors.New("记录不是键中的节点签名的")
+	}
+	return peerRecord, nil
+}
+
+func (v PeerRecordValidator) Validate(key string, value []byte) error {
+	_, e := v.parse(key, value)
+	return e
+}
+
+// Select 选择序号最大的记录
+func (v PeerRecordValidator) Select(key string, values [][]byte) (int, error) {
+	best := -1
+	var seq uint64
+	for i, value := range values {
+		rec, e := v.parse(key, value)
+		if e != nil {
+			continue
+		}
+		if best < 0 || rec.Seq > seq {
+			best, seq = i, rec.Seq
+		}
+	}
+	if best < 0 {
+		return 0, errors.New("没有有效的节点记录")
+	}
+	return best, nil
+}
+
+// LookupPeerRecord 在DHT中查询节点的签名节点记录, 返回记录中的地址.
+// DHT需要用dht.NamespacedValidator(PeerRecordNamespace, PeerRecordValidator{})设置验证器
+func LookupPeerRecord(ctx context.Context, vs routing.ValueStore, id peer.ID) (peer.AddrInfo, error) {
+	value, e := vs.GetValue(ctx, PeerRecordKey(id))
+	if e != nil {
+		return peer.AddrInfo{}, e
+	}
+	rec, e := PeerRecordValidator{}.parse(PeerRecordKey(id), value)
+	if e != nil {
+		return peer.AddrInfo{}, e
+	}
+	return peer.AddrInfo{ID: rec.PeerID, Addrs: rec.Addrs}, nil
+}
+
+// selfRecord 定期发布本节点的地址: 连接DHT中离本节点ID最近的节点, 让它们通过identify记录当前的地址,
+// FindPeer查询时由它们返回; 使用DHT协议前缀时还写入签名节点记录
+type selfRecord struct {
+	h         host.Host
+	idht      *dht.IpfsDHT
+	cfg       selfRecordConfig
+	putRecord bool
+	update    chan struct{}
+
+	mu        sync.Mutex
+	published time.Time
+	closest   int
+	stored    bool
+	lastErr   string
+	count     uint64
+}
+
+// newSelfRecord 创建发布任务, putRecord为true时DHT中注册了节点记录的验证器, 同时写入签名节点记录
+func newSelfRecord(h host.Host, idht *dht.IpfsDHT, cfg selfRecordConfig, putRecord bool) *selfRecord {
+	return &selfRecord{h: h, idht: idht, cfg: cfg, putRecord: putRecord, update: make(chan struct{}, 1)}
+}
+
+// subscribe 在本节点地址变化时重新发布
+func (s *selfRecord) subscribe(subs *subscriptions) error {
+	return subs.Subscribe(s.h.EventBus(), new(event.EvtLocalAddressesUpdated), func(interface{}) {
+		select {
+		case s.update <- struct{}{}:
+		default:
+		}
+	})
+}
+
+func (s *selfRecord) run(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.update:
+			// 等待地址稳定, 期间的变化合并为一次发布
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(selfRecordDelay):
+			}
+			select {
+			case <-s.update:
+			default:
+			}
+		}
+		s.publish(ctx)
+	}
+}
+
+// publish 发布一次, 结果记录在状态中. 路由表为空(还没有连接引导节点)时跳过
+func (s *selfRecord) publish(ctx context.Context) {
+	if s.idht.RoutingTable().Size() == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, selfRecordTimeout)
+	defer cancel()
+	closest, e := s.announce(ctx)
+	stored := false
+	if e == nil && s.putRecord {
+		if e = s.put(ctx); e == nil {
+			stored = true
+		}
+	}
+	s.mu.Lock()
+	s.closest, s.stored = closest, stored
+	if e != nil {
+		s.lastErr = e.Error()
+	} else {
+		s.published, s.lastErr = time.Now(), ""
+		s.count++
+	}
+	s.mu.Unlock()
+	if e != nil && ctx.Err() == nil {
+		logger.Warnw("发布本节点地址出错", "error", e)
+		return
+	}
+	logger.Debugw("发布本节点地址", "closest", closest, "record", stored, "addrs", s.h.Addrs())
+}
+
+// announce 查找离本节点ID最近的节点并连接, 返回连接成功的数量
+func (s *selfRecord) announce(ctx context.Context) (int, error) {
+	peers, e := s.idht.GetClosestPeers(ctx, string(s.h.ID()))
+	if e != nil {
+		return 0, e
+	}
+	connected := 0
+	for _, p := range peers {
+		if p == s.h.ID() {
+			continue
+		}
+		if e := s.h.Connect(ctx, peer.AddrInfo{ID: p}); e == nil {
+			connected++
+		}
+	}
+	if connected == 0 {
+		return 0, errors.New("没有连接到离本节点ID最近的节点")
+	}
+	return connected, nil
+}
+
+// put 把签名节点记录写入DHT
+func (s *selfRecord) put(ctx context.Context) error {
+	key := s.h.Peerstore().PrivKey(s.h.ID())
+	if key == nil {
+		return errors.New("没有本节点的私钥")
+	}
+	rec := peer.PeerRecordFromAddrInfo(peer.AddrInfo{ID: s.h.ID(), Addrs: s.h.Addrs()})
+	envelope, e := corerecord.Seal(rec, key)
+	if e != nil {
+		return e
+	}
+	value, e := envelope.Marshal()
+	if e != nil {
+		return e
+	}
+	return s.idht.PutValue(ctx, PeerRecordKey(s.h.ID()), value)
+}
+
+// status 返回最近一次发布的时间和结果
+func (s *selfRecord) status() interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return map[string]interface{}{
+		"published": s.published,
+		"count":     s.count,
+		"closest":   s.closest,
+		"record":    s.stored,
+		"error":     s.lastErr,
+	}
+}
+
+// peerRecordValidators 在使用DHT协议前缀且发布本节点地址时加入节点记录的验证器
+func peerRecordValidators(cfg *Config, validators map[string]record.Validator) bool {
+	if !cfg.SelfRecord.Enabled || cfg.DHT.ProtocolPrefix == "" {
+		return false
+	}
+	if _, ok := validators[PeerRecordNamespace]; ok {
+		return false
+	}
+	validators[PeerRecordNamespace] = PeerRecordValidator{}
+	return true
+}
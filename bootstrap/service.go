@@ -0,0 +1,34 @@
+package bootstrap
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// defaultServiceName 是Windows服务的默认名称
+const defaultServiceName = "bootstrap"
+
+// serviceCommands 是service的子命令
+var serviceCommands = map[string]func(name string, args []string) error{
+	"install":   installService,
+	"uninstall": uninstallService,
+	"start":     startService,
+	"stop":      stopService,
+}
+
+// runServiceCommand 管理Windows服务: install, uninstall, start, stop
+func runServiceCommand(args []string) int {
+	if len(args) == 0 || serviceCommands[args[0]] == nil {
+		fmt.Fprintln(os.Stderr, "用法: bootstrap service [install|uninstall|start|stop] [-name 服务名] [-- 节点参数]")
+		return 2
+	}
+	fs := flag.NewFlagSet("service "+args[0], flag.ExitOnError)
+	name := fs.String("name", defaultServiceName, "服务名称")
+	_ = fs.Parse(args[1:])
+	if e := serviceCommands[args[0]](*name, fs.Args()); e != nil {
+		logger.Errorw("服务命令出错", "error", e)
+		return 1
+	}
+	return 0
+}
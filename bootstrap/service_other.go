@@ -0,0 +1,25 @@
+//go:build !windows
+// +build !windows
+
+package bootstrap
+
+import (
+	"errors"
+	"os"
+)
+
+// errServiceUnsupported 表示当前系统不支持Windows服务
+var errServiceUnsupported = errors.New("只有Windows支持service命令, 其他系统请使用systemd等进程管理器")
+
+func installService(string, []string) error   { return errServiceUnsupported }
+func uninstallService(string, []string) error { return errServiceUnsupported }
+func startService(string, []string) error     { return errServiceUnsupported }
+func stopService(string, []string) error      { return errServiceUnsupported }
+
+// runAsService 在作为Windows服务启动时运行节点并返回true, 其他系统总是返回false
+func runAsService([]string) bool {
+	return false
+}
+
+// notifyServiceStop 其他系统没有服务控制请求
+func notifyServiceStop(chan<- os.Signal) {}
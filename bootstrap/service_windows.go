@@ -0,0 +1,193 @@
+//go:build windows
+// +build windows
+
+package bootstrap
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// serviceStopTimeout 是等待服务停止的最长时间
+const serviceStopTimeout = time.Second * 30
+
+// installService 把当前程序安装为自动启动的服务, args是运行节点的参数
+func installService(name string, args []string) error {
+	exe, e := os.Executable()
+	if e != nil {
+		return e
+	}
+	if exe, e = filepath.Abs(exe); e != nil {
+		return e
+	}
+	m, e := mgr.Connect()
+	if e != nil {
+		return fmt.Errorf("连接服务管理器出错: %w", e)
+	}
+	defer m.Disconnect()
+	if s, e := m.OpenService(name); e == nil {
+		s.Close()
+		return fmt.Errorf("服务 %s 已存在", name)
+	}
+	s, e := m.CreateService(name, exe, mgr.Config{
+		DisplayName: "libp2p bootstrap " + name,
+		Description: "libp2p 引导节点",
+		StartType:   mgr.StartAutomatic,
+	}, append([]string{"run"}, args...)...)
+	if e != nil {
+		return fmt.Errorf("创建服务出错: %w", e)
+	}
+	defer s.Close()
+	fmt.Println("已安装服务", name, exe)
+	return nil
+}
+
+// uninstallService 删除服务, 正在运行的服务停止后才会被删除
+func uninstallService(name string, _ []string) error {
+	m, e := mgr.Connect()
+	if e != nil {
+		return fmt.Errorf("连接服务管理器出错: %w", e)
+	}
+	defer m.Disconnect()
+	s, e := m.OpenService(name)
+	if e != nil {
+		return fmt.Errorf("服务 %s 不存在: %w", name, e)
+	}
+	defer s.Close()
+	if e := s.Delete(); e != nil {
+		return fmt.Errorf("删除服务出错: %w", e)
+	}
+	fmt.Println("已删除服务", name)
+	return nil
+}
+
+// startService 启动服务
+func startService(name string, _ []string) error {
+	m, e := mgr.Connect()
+	if e != nil {
+		return fmt.Errorf("连接服务管理器出错: %w", e)
+	}
+	defer m.Disconnect()
+	s, e := m.OpenService(name)
+	if e != nil {
+		return fmt.Errorf("服务 %s 不存在: %w", name, e)
+	}
+	defer s.Close()
+	if e := s.Start(); e != nil {
+		return fmt.Errorf("启动服务出错: %w", e)
+	}
+	fmt.Println("已启动服务", name)
+	return nil
+}
+
+// stopService 停止服务并等待停止完成
+func stopService(name string, _ []string) error {
+	m, e := mgr.Connect()
+	if e != nil {
+		return fmt.Errorf("连接服务管理器出错: %w", e)
+	}
+	defer m.Disconnect()
+	s, e := m.OpenService(name)
+	if e != nil {
+		return fmt.Errorf("服务 %s 不存在: %w", name, e)
+	}
+	defer s.Close()
+	status, e := s.Control(svc.Stop)
+	if e != nil {
+		return fmt.Errorf("停止服务出错: %w", e)
+	}
+	deadline := time.Now().Add(serviceStopTimeout)
+	for status.State != svc.Stopped {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("等待服务停止超时, 当前状态 %d", status.State)
+		}
+		time.Sleep(time.Millisecond * 300)
+		if status, e = s.Query(); e != nil {
+			return fmt.Errorf("查询服务状态出错: %w", e)
+		}
+	}
+	fmt.Println("已停止服务", name)
+	return nil
+}
+
+// serviceStop 是运行中的节点等待退出信号的通道, 服务收到停止请求时发送SIGTERM
+var serviceStop struct {
+	mu sync.Mutex
+	c  chan<- os.Signal
+}
+
+// notifyServiceStop 让服务的停止和关机请求像SIGTERM一样送到c
+func notifyServiceStop(c chan<- os.Signal) {
+	serviceStop.mu.Lock()
+	serviceStop.c = c
+	serviceStop.mu.Unlock()
+}
+
+// serviceHandler 在服务中运行节点
+type serviceHandler struct {
+	args []string
+}
+
+func (h *serviceHandler) Execute(_ []string, requests <-chan svc.ChangeRequest, changes chan<- svc.Status) (bool, uint32) {
+	changes <- svc.Status{State: svc.StartPending}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runNode(h.args)
+	}()
+	const accepts = svc.AcceptStop | svc.AcceptShutdown
+	changes <- svc.Status{State: svc.Running, Accepts: accepts}
+	for {
+		select {
+		case <-done:
+			changes <- svc.Status{State: svc.StopPending}
+			return false, 0
+		case r := <-requests:
+			switch r.Cmd {
+			case svc.Interrogate:
+				changes <- r.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				changes <- svc.Status{State: svc.StopPending}
+				serviceStop.mu.Lock()
+				c := serviceStop.c
+				serviceStop.mu.Unlock()
+				// 节点还在启动时没有等待信号, 直接退出
+				if c == nil {
+					return false, 0
+				}
+				select {
+				case c <- syscall.SIGTERM:
+				default:
+				}
+				select {
+				case <-done:
+				case <-time.After(serviceStopTimeout):
+				}
+				return false, 0
+			}
+		}
+	}
+}
+
+// runAsService 在由服务管理器启动时按服务运行节点并返回true
+func runAsService(args []string) bool {
+	isService, e := svc.IsWindowsService()
+	if e != nil || !isService {
+		return false
+	}
+	if len(args) > 0 && args[0] == "run" {
+		args = args[1:]
+	}
+	if e := svc.Run(defaultServiceName, &serviceHandler{args: args}); e != nil {
+		fmt.Fprintln(os.Stderr, "运行服务出错", e)
+		os.Exit(1)
+	}
+	return true
+}
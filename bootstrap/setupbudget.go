@@ -0,0 +1,125 @@
+package bootstrap
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// setupBudget 关闭从开始建立起在限定时间内没有完成identify的连接.
+// 这类节点每一步都不超时, 但一直不可用, 白白占用资源.
+// 计时从连接管理器接受入站连接或拨号时开始, 这时还不知道节点ID, 按远端地址记录, 连接建立后转到连接上.
+type setupBudget struct {
+	budget time.Duration
+
+	mu         sync.Mutex
+	starts     map[string]time.Time
+	swept      time.Time
+	pending    map[network.Conn]*time.Timer
+	identified map[peer.ID]bool
+	closed     uint64
+}
+
+func newSetupBudget(budget time.Duration) *setupBudget {
+	return &setupBudget{
+		budget:     budget,
+		starts:     make(map[string]time.Time),
+		swept:      time.Now(),
+		pending:    make(map[network.Conn]*time.Timer),
+		identified: make(map[peer.ID]bool),
+	}
+}
+
+// start 在接受入站连接或拨号时由connectionGater调用, 记录开始建立连接的时间
+func (b *setupBudget) start(addr ma.Multiaddr) {
+	now := time.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.starts[addr.String()] = now
+	// 没有建立成功的连接不会调用Connected, 定期删除已经超过限定时间的记录
+	if now.Sub(b.swept) > b.budget {
+		for k, t := range b.starts {
+			if now.Sub(t) > b.budget {
+				delete(b.starts, k)
+			}
+		}
+		b.swept = now
+	}
+}
+
+// Identified 在节点完成identify时调用
+func (b *setupBudget) Identified(p peer.ID) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.identified[p] = true
+	for c, timer := range b.pending {
+		if c.RemotePeer() == p {
+			timer.Stop()
+			delete(b.pending, c)
+		}
+	}
+}
+
+func (b *setupBudget) Connected(_ network.Network, c network.Conn) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	key := c.RemoteMultiaddr().String()
+	started, ok := b.starts[key]
+	delete(b.starts, key)
+	if b.identified[c.RemotePeer()] {
+		return
+	}
+	remaining := b.budget
+	if ok {
+		remaining -= time.Since(started)
+	}
+	if remaining < 0 {
+		remaining = 0
+	}
+	b.pending[c] = time.AfterFunc(remaining, func() {
+		b.mu.Lock()
+		_, ok := b.pending[c]
+		delete(b.pending, c)
+		b.mu.Unlock()
+		if !ok {
+			return
+		}
+		atomic.AddUint64(&b.closed, 1)
+		logger.Debugw("连接超时未完成identify, 关闭", "peer", c.RemotePeer(), "addr", c.RemoteMultiaddr())
+		_ = c.Close()
+	})
+}
+
+func (b *setupBudget) Disconnected(net network.Network, c network.Conn) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if timer, ok := b.pending[c]; ok {
+		timer.Stop()
+		delete(b.pending, c)
+	}
+	if net.Connectedness(c.RemotePeer()) != network.Connected {
+		delete(b.identified, c.RemotePeer())
+	}
+}
+
+func (b *setupBudget) Listen(network.Network, ma.Multiaddr)         {}
+func (b *setupBudget) ListenClose(network.Network, ma.Multiaddr)    {}
+func (b *setupBudget) OpenedStream(network.Network, network.Stream) {}
+func (b *setupBudget) ClosedStream(network.Network, network.Stream) {}
+
+// status 返回因超时关闭的连接数量
+func (b *setupBudget) status() interface{} {
+	b.mu.Lock()
+	pending, starting := len(b.pending), len(b.starts)
+	b.mu.Unlock()
+	return map[string]interface{}{
+		"budget":   b.budget.String(),
+		"starting": starting,
+		"pending":  pending,
+		"closed":   atomic.LoadUint64(&b.closed),
+	}
+}
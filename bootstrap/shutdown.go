@@ -0,0 +1,126 @@
+package bootstrap
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// shutdownStep 是关闭过程中的一步
+type shutdownStep struct {
+	name string
+	fn   func() error
+	// flush 为true时超时后也执行, 用于写入存储
+	flush bool
+}
+
+// shutdownFlushTimeout 是超时后执行写入存储的步骤的最长时间
+const shutdownFlushTimeout = time.Second * 5
+
+// shutdownSequence 按加入的顺序关闭节点的各个部分
+type shutdownSequence []shutdownStep
+
+// add 加入一步, fn为nil时忽略
+func (s *shutdownSequence) add(name string, fn func() error) {
+	if fn != nil {
+		*s = append(*s, shutdownStep{name: name, fn: fn})
+	}
+}
+
+// addFlush 加入写入存储的一步, 超时后也执行
+func (s *shutdownSequence) addFlush(name string, fn func() error) {
+	if fn != nil {
+		*s = append(*s, shutdownStep{name: name, fn: fn, flush: true})
+	}
+}
+
+// checkShutdownTimeout 检查关闭的最长时间
+func checkShutdownTimeout(timeout time.Duration) error {
+	if timeout <= 0 {
+		return fmt.Errorf("shutdown_timeout必须大于0: %s", timeout)
+	}
+	return nil
+}
+
+// drain 在关闭前通知已连接的节点并继续服务period, 收到force时提前结束. announce推送关闭通知, 返回通知的节点数量
+func drain(period time.Duration, announce func(at time.Time) int, force <-chan os.Signal) {
+	at := time.Now().Add(period)
+	logger.Infow("计划关闭, 继续服务", "period", period)
+	timer := time.NewTimer(period)
+	defer timer.Stop()
+	done := make(chan int, 1)
+	go func() { done <- announce(at) }()
+	for {
+		select {
+		case pushed := <-done:
+			logger.Infow("已推送关闭通知", "peers", pushed)
+		case <-timer.C:
+			return
+		case sig := <-force:
+			logger.Warnw("再次收到信号, 不再等待", "signal", sig)
+			return
+		}
+	}
+}
+
+// run 依次执行各步, 所有步骤共用timeout, 不是每一步单独计时.
+// 超时后不再等待当前步骤, 跳过其余步骤, 只执行写入存储的步骤, 最长再等待shutdownFlushTimeout.
+// 收到force时立即返回. 全部按时完成时返回true.
+func (s shutdownSequence) run(timeout time.Duration, force <-chan os.Signal) bool {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	start := time.Now()
+	for i, step := range s {
+		select {
+		case e := <-s.step(step):
+			logShutdownStep(step, e)
+		case <-deadline.C:
+			logger.Errorw("关闭超时, 不再等待", "step", step.name, "timeout", timeout)
+			s[i+1:].flush(force)
+			return false
+		case sig := <-force:
+			logger.Errorw("再次收到信号, 立即退出", "signal", sig, "step", step.name)
+			return false
+		}
+	}
+	logger.Infow("已关闭节点", "duration", time.Since(start).Truncate(time.Millisecond))
+	return true
+}
+
+// flush 在超时后执行剩余步骤中写入存储的步骤
+func (s shutdownSequence) flush(force <-chan os.Signal) {
+	deadline := time.NewTimer(shutdownFlushTimeout)
+	defer deadline.Stop()
+	for _, step := range s {
+		if !step.flush {
+			continue
+		}
+		select {
+		case e := <-s.step(step):
+			logShutdownStep(step, e)
+		case <-deadline.C:
+			logger.Errorw("写入存储超时, 不再等待", "step", step.name, "timeout", shutdownFlushTimeout)
+			return
+		case sig := <-force:
+			logger.Errorw("再次收到信号, 立即退出", "signal", sig, "step", step.name)
+			return
+		}
+	}
+}
+
+// step 在新协程中执行一步, 返回接收结果的通道
+func (s shutdownSequence) step(step shutdownStep) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		done <- step.fn()
+	}()
+	return done
+}
+
+func logShutdownStep(step shutdownStep, e error) {
+	if e != nil {
+		logger.Warnw("关闭出错", "step", step.name, "error", e)
+	} else {
+		logger.Debugw("已关闭", "step", step.name)
+	}
+}
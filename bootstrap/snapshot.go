@@ -0,0 +1,183 @@
+package bootstrap
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// snapshotDirName 是数据目录中保存连接快照的目录
+const snapshotDirName = "snapshots"
+
+// snapshotJSONLFile 是jsonl格式时追加写入的文件
+const snapshotJSONLFile = "peers.jsonl"
+
+// snapshotConfig 是定期保存已连接节点快照的设置, 用于离线分析网络状况
+type snapshotConfig struct {
+	// Interval 是保存间隔, 0表示不保存
+	Interval time.Duration `yaml:"interval"`
+	// Format 是json(每次一个文件, 文件名为时间)或jsonl(追加到peers.jsonl, 每行一次)
+	Format string `yaml:"format"`
+	// Dir 是保存的目录, 为空时使用数据目录下的snapshots
+	Dir string `yaml:"dir"`
+}
+
+// validate 检查快照设置
+func (c snapshotConfig) validate() error {
+	if c.Interval == 0 {
+		return nil
+	}
+	if c.Interval < 0 {
+		return fmt.Errorf("snapshot-interval不能小于0: %s", c.Interval)
+	}
+	if c.Format != "json" && c.Format != "jsonl" {
+		return fmt.Errorf("不支持的连接快照格式 %s, 可用的有: json, jsonl", c.Format)
+	}
+	return nil
+}
+
+// snapshotConn 是快照中的一个连接
+type snapshotConn struct {
+	Addr      string    `json:"addr"`
+	Direction string    `json:"direction"`
+	Transport string    `json:"transport"`
+	Opened    time.Time `json:"opened"`
+}
+
+// snapshotPeer 是快照中的一个已连接节点, Addrs 是地址簿中的地址
+type snapshotPeer struct {
+	ID           string         `json:"id"`
+	AgentVersion string         `json:"agent_version,omitempty"`
+	Addrs        []string       `json:"addrs"`
+	Conns        []snapshotConn `json:"conns"`
+}
+
+// peerSnapshot 是一次快照
+type peerSnapshot struct {
+	Time  time.Time      `json:"time"`
+	ID    string         `json:"id"`
+	Peers []snapshotPeer `json:"peers"`
+}
+
+// peerSnapshotter 定期把已连接的节点, 地址, 客户端版本和连接方向保存到文件
+type peerSnapshotter struct {
+	h   host.Host
+	cfg snapshotConfig
+	dir string
+
+	mu    sync.Mutex
+	last  time.Time
+	peers int
+	path  string
+	err   string
+}
+
+func newPeerSnapshotter(h host.Host, cfg snapshotConfig, dataDir string) *peerSnapshotter {
+	dir := cfg.Dir
+	if dir == "" {
+		dir = filepath.Join(dataDir, snapshotDirName)
+	}
+	return &peerSnapshotter{h: h, cfg: cfg, dir: dir}
+}
+
+func (s *peerSnapshotter) run(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			snapshot := s.snapshot()
+			path, e := s.save(snapshot)
+			s.mu.Lock()
+			s.last, s.peers, s.path, s.err = snapshot.Time, len(snapshot.Peers), path, ""
+			if e != nil {
+				s.err = e.Error()
+			}
+			s.mu.Unlock()
+			if e != nil {
+				logger.Warnw("保存连接快照出错", "error", e)
+			}
+		}
+	}
+}
+
+// snapshot 返回当前的连接, 按节点ID排序
+func (s *peerSnapshotter) snapshot() *peerSnapshot {
+	ps := s.h.Peerstore()
+	peers := make(map[peer.ID]*snapshotPeer)
+	for _, c := range s.h.Network().Conns() {
+		id := c.RemotePeer()
+		p, ok := peers[id]
+		if !ok {
+			p = &snapshotPeer{ID: id.String(), Addrs: []string{}}
+			if v, e := ps.Get(id, "AgentVersion"); e == nil {
+				p.AgentVersion, _ = v.(string)
+			}
+			for _, addr := range ps.Addrs(id) {
+				p.Addrs = append(p.Addrs, addr.String())
+			}
+			peers[id] = p
+		}
+		stat := c.Stat()
+		p.Conns = append(p.Conns, snapshotConn{
+			Addr:      c.RemoteMultiaddr().String(),
+			Direction: stat.Direction.String(),
+			Transport: connTransport(c),
+			Opened:    stat.Opened,
+		})
+	}
+	snapshot := &peerSnapshot{Time: time.Now(), ID: s.h.ID().String(), Peers: make([]snapshotPeer, 0, len(peers))}
+	for _, p := range peers {
+		snapshot.Peers = append(snapshot.Peers, *p)
+	}
+	sort.Slice(snapshot.Peers, func(i, j int) bool { return snapshot.Peers[i].ID < snapshot.Peers[j].ID })
+	return snapshot
+}
+
+// save 保存快照, 返回文件路径
+func (s *peerSnapshotter) save(snapshot *peerSnapshot) (string, error) {
+	if e := os.MkdirAll(s.dir, 0700); e != nil {
+		return "", e
+	}
+	if s.cfg.Format == "jsonl" {
+		path := filepath.Join(s.dir, snapshotJSONLFile)
+		f, e := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if e != nil {
+			return "", e
+		}
+		defer f.Close()
+		return path, json.NewEncoder(f).Encode(snapshot)
+	}
+	path := filepath.Join(s.dir, snapshot.Time.UTC().Format("20060102T150405Z")+".json")
+	f, e := os.Create(path)
+	if e != nil {
+		return "", e
+	}
+	defer f.Close()
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+	return path, encoder.Encode(snapshot)
+}
+
+// status 返回最近一次快照的结果
+func (s *peerSnapshotter) status() interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return map[string]interface{}{
+		"interval": s.cfg.Interval.String(),
+		"last":     s.last,
+		"peers":    s.peers,
+		"path":     s.path,
+		"error":    s.err,
+	}
+}
@@ -0,0 +1,77 @@
+package bootstrap
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// sseHeartbeat 是没有事件时发送注释行的间隔, 避免代理关闭空闲连接
+const sseHeartbeat = time.Second * 30
+
+// eventsHandler 以Server-Sent Events推送事件, 每个事件的event是类型, data是JSON.
+// ?types=a,b 只推送指定类型的事件, 处理不及时时推送dropped事件.
+func eventsHandler(ctx context.Context, hub *eventHub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "不支持流式输出", http.StatusInternalServerError)
+			return
+		}
+		types := make(map[string]bool)
+		if v := r.URL.Query().Get("types"); v != "" {
+			for _, t := range strings.Split(v, ",") {
+				types[strings.TrimSpace(t)] = true
+			}
+		}
+		sub := hub.Subscribe(eventStreamBuffer)
+		defer sub.Close()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		ticker := time.NewTicker(sseHeartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-r.Context().Done():
+				return
+			case <-ticker.C:
+				if _, e := fmt.Fprint(w, ": heartbeat\n\n"); e != nil {
+					return
+				}
+			case evt := <-sub.C:
+				if dropped := sub.TakeDropped(); dropped > 0 {
+					if e := writeSSE(w, nodeEvent{Time: time.Now(), Type: eventDropped, Dropped: dropped}); e != nil {
+						return
+					}
+					flusher.Flush()
+				}
+				if len(types) > 0 && !types[evt.Type] {
+					continue
+				}
+				if e := writeSSE(w, evt); e != nil {
+					return
+				}
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// writeSSE 写出一个事件
+func writeSSE(w http.ResponseWriter, evt nodeEvent) error {
+	b, e := json.Marshal(evt)
+	if e != nil {
+		return e
+	}
+	_, e = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", evt.Type, b)
+	return e
+}
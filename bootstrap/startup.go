@@ -0,0 +1,100 @@
+package bootstrap
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// 启动失败时的退出码, 取自sysexits.h. 进程管理器可以据此决定是否重启,
+// 如systemd的RestartPreventExitStatus=78在配置错误时不再重启.
+const (
+	// exitTransient 是重试后仍然失败的暂时性错误, 如端口被占用, 稍后重启可能成功
+	exitTransient = 75
+	// exitConfig 是配置错误, 修改配置前重启不会成功
+	exitConfig = 78
+)
+
+// startupRetryMax 是启动重试的最长等待间隔
+const startupRetryMax = time.Second * 8
+
+// fatalConfig 输出配置错误并以exitConfig退出
+func fatalConfig(msg string, e error) {
+	logger.Errorw(msg, "error", e)
+	os.Exit(exitConfig)
+}
+
+// fatalStartup 输出启动错误并按exitCode退出
+func fatalStartup(msg string, e error) {
+	logger.Errorw(msg, "error", e)
+	os.Exit(exitCode(e))
+}
+
+// configError 是配置错误, 修改配置前重试不会成功
+type configError struct {
+	e error
+}
+
+func (c *configError) Error() string { return c.e.Error() }
+func (c *configError) Unwrap() error { return c.e }
+
+// errConfig 返回配置错误, 参数与fmt.Sprintln相同, 只有一个error参数时包装它
+func errConfig(v ...interface{}) error {
+	if len(v) == 1 {
+		if e, ok := v[0].(error); ok {
+			return &configError{e: e}
+		}
+	}
+	return &configError{e: errors.New(strings.TrimSuffix(fmt.Sprintln(v...), "\n"))}
+}
+
+// exitCode 返回启动错误的退出码: 配置错误为exitConfig, 暂时性错误为exitTransient, 否则为1
+func exitCode(e error) int {
+	var ce *configError
+	if errors.As(e, &ce) {
+		return exitConfig
+	}
+	if isTransient(e) {
+		return exitTransient
+	}
+	return 1
+}
+
+// isTransient 判断错误是否是暂时性的: 地址被占用, 文件被锁定, 资源暂时不可用或超时
+func isTransient(e error) bool {
+	if e == nil {
+		return false
+	}
+	for _, errno := range transientErrnos {
+		if errors.Is(e, errno) {
+			return true
+		}
+	}
+	var ne net.Error
+	return errors.As(e, &ne) && ne.Timeout()
+}
+
+// retryStartup 执行fn, 遇到暂时性错误时按指数退避重试, 总的等待时间不超过budget.
+// budget为0时不重试. 返回最后一次的错误.
+func retryStartup(name string, budget time.Duration, fn func() error) error {
+	deadline := time.Now().Add(budget)
+	backoff := time.Second
+	for {
+		e := fn()
+		if e == nil || !isTransient(e) || budget <= 0 {
+			return e
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			logger.Errorw("启动重试时间已用完", "step", name, "error", e, "budget", budget)
+			return e
+		}
+		logger.Warnw("启动时遇到暂时性错误, 等待后重试", "step", name, "error", e, "backoff", backoff)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > startupRetryMax {
+			backoff = startupRetryMax
+		}
+	}
+}
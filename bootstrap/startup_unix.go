@@ -0,0 +1,18 @@
+//go:build !windows
+// +build !windows
+
+package bootstrap
+
+import (
+	"syscall"
+)
+
+// transientErrnos 是视为暂时性错误的系统错误
+var transientErrnos = []error{
+	syscall.EADDRINUSE,
+	syscall.EADDRNOTAVAIL,
+	syscall.EAGAIN,
+	syscall.EBUSY,
+	syscall.EINTR,
+	syscall.ETXTBSY,
+}
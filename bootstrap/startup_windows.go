@@ -0,0 +1,18 @@
+//go:build windows
+// +build windows
+
+package bootstrap
+
+import (
+	"syscall"
+)
+
+// transientErrnos 是视为暂时性错误的系统错误, 包括Windows的套接字和文件锁定错误
+var transientErrnos = []error{
+	syscall.Errno(10048), // WSAEADDRINUSE
+	syscall.Errno(10049), // WSAEADDRNOTAVAIL
+	syscall.Errno(32),    // ERROR_SHARING_VIOLATION
+	syscall.Errno(33),    // ERROR_LOCK_VIOLATION
+	syscall.EADDRINUSE,
+	syscall.EAGAIN,
+}
@@ -0,0 +1,85 @@
+package bootstrap
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+	"time"
+)
+
+// isDumpSignal 判断是否是输出运行状态的信号
+func isDumpSignal(sig os.Signal) bool {
+	for _, s := range dumpSignals {
+		if sig == s {
+			return true
+		}
+	}
+	return false
+}
+
+// dumpState 把运行状态输出到日志, 用于不重启排查没有响应的节点
+func (n *Node) dumpState() {
+	var b bytes.Buffer
+	n.writeState(&b)
+	logger.Infow("运行状态\n" + b.String())
+}
+
+// writeState 输出可读的运行状态: 地址, 可达性, 按传输统计的连接, 路由表, 协程数量和内存
+func (n *Node) writeState(w io.Writer) {
+	n.mu.Lock()
+	h, idht, report := n.h, n.idht, n.report
+	n.mu.Unlock()
+	fmt.Fprintln(w, "===== 运行状态 =====")
+	fmt.Fprintln(w, "时间:", time.Now().Format(time.RFC3339))
+	if h == nil {
+		fmt.Fprintln(w, "节点尚未启动")
+	} else {
+		fmt.Fprintln(w, "节点ID:", h.ID())
+		fmt.Fprintln(w, "监听地址:")
+		for _, addr := range h.Network().ListenAddresses() {
+			fmt.Fprintln(w, " ", addr)
+		}
+		fmt.Fprintln(w, "宣告地址:")
+		for _, addr := range h.Addrs() {
+			fmt.Fprintln(w, " ", addr)
+		}
+		if report != nil {
+			fmt.Fprintln(w, "AutoNAT可达性:", report.reachability.Get())
+		}
+
+		conns := h.Network().Conns()
+		fmt.Fprintf(w, "连接: %d 个节点, %d 个连接\n", len(h.Network().Peers()), len(conns))
+		transports := make(map[string]int)
+		for _, c := range conns {
+			transports[connTransport(c)]++
+		}
+		names := make([]string, 0, len(transports))
+		for name := range transports {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(w, "  传输 %s: %d 个连接\n", name, transports[name])
+		}
+
+		fmt.Fprintln(w, "路由表:", idht.RoutingTable().Size(), "个节点")
+		buckets := routingTableBuckets(idht)
+		cpls := make([]int, 0, len(buckets))
+		for cpl := range buckets {
+			cpls = append(cpls, cpl)
+		}
+		sort.Ints(cpls)
+		for _, cpl := range cpls {
+			fmt.Fprintf(w, "  公共前缀 %d: %d 个节点\n", cpl, buckets[cpl])
+		}
+	}
+
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	fmt.Fprintln(w, "协程数量:", runtime.NumGoroutine())
+	fmt.Fprintf(w, "内存: 堆 %d KiB, 堆占用 %d KiB, 系统 %d KiB, GC %d 次, 上次GC %s\n",
+		m.HeapAlloc/1024, m.HeapInuse/1024, m.Sys/1024, m.NumGC, time.Unix(0, int64(m.LastGC)).Format(time.RFC3339))
+}
@@ -0,0 +1,12 @@
+//go:build !windows
+// +build !windows
+
+package bootstrap
+
+import (
+	"os"
+	"syscall"
+)
+
+// dumpSignals 是输出运行状态的信号
+var dumpSignals = []os.Signal{syscall.SIGUSR1}
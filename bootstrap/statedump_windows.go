@@ -0,0 +1,11 @@
+//go:build windows
+// +build windows
+
+package bootstrap
+
+import (
+	"os"
+)
+
+// dumpSignals 是输出运行状态的信号, Windows没有SIGUSR1
+var dumpSignals []os.Signal
@@ -0,0 +1,42 @@
+package bootstrap
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// statusRegistry 汇总各部分的状态, 以JSON形式提供给 /status
+type statusRegistry struct {
+	mu     sync.RWMutex
+	fields map[string]func() interface{}
+}
+
+func newStatusRegistry() *statusRegistry {
+	return &statusRegistry{fields: make(map[string]func() interface{})}
+}
+
+// Set 注册状态字段, 每次请求时调用fn获取当前值
+func (r *statusRegistry) Set(name string, fn func() interface{}) {
+	r.mu.Lock()
+	r.fields[name] = fn
+	r.mu.Unlock()
+}
+
+func (r *statusRegistry) snapshot() map[string]interface{} {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	m := make(map[string]interface{}, len(r.fields))
+	for name, fn := range r.fields {
+		m[name] = fn()
+	}
+	return m
+}
+
+func (r *statusRegistry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w).Encode(r.snapshot())
+	if e != nil {
+		logger.Warnw("输出状态出错", "error", e)
+	}
+}
@@ -0,0 +1,142 @@
+package bootstrap
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/event"
+)
+
+// sdNotify 按sd_notify协议向systemd发送状态, 如READY=1.
+// 不是由systemd以Type=notify启动(没有NOTIFY_SOCKET)时返回false.
+func sdNotify(state string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+	// @开头的是抽象命名空间中的socket
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+	conn, e := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if e != nil {
+		return false, e
+	}
+	defer conn.Close()
+	if _, e := conn.Write([]byte(state)); e != nil {
+		return false, e
+	}
+	return true, nil
+}
+
+// sdWatchdogInterval 返回systemd要求的watchdog间隔(WATCHDOG_USEC), 没有启用时返回0
+func sdWatchdogInterval() (time.Duration, error) {
+	usec := os.Getenv("WATCHDOG_USEC")
+	if usec == "" {
+		return 0, nil
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0, nil
+	}
+	n, e := strconv.ParseInt(usec, 10, 64)
+	if e != nil || n <= 0 {
+		return 0, fmt.Errorf("WATCHDOG_USEC错误: %s", usec)
+	}
+	return time.Duration(n) * time.Microsecond, nil
+}
+
+// sdNotifyReady 等到节点正在监听并且DHT已启动后通知systemd就绪
+func sdNotifyReady(ctx context.Context, ready *readiness) {
+	if os.Getenv("NOTIFY_SOCKET") == "" {
+		return
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		s := ready.check()
+		if s.Listening && s.DHTBootstrapped {
+			if _, e := sdNotify(fmt.Sprintf("READY=1\nSTATUS=已连接 %d 个节点", s.Peers)); e != nil {
+				logger.Warnw("通知systemd就绪出错", "error", e)
+			} else {
+				logger.Infow("已通知systemd就绪")
+			}
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// evtLiveness 是watchdog检查事件总线时发送的事件
+type evtLiveness struct {
+	seq uint64
+}
+
+// sdWatchdog 每半个间隔向事件总线发送一个事件, 收到后才回应systemd的watchdog.
+// 事件总线或处理协程卡住时不再回应, systemd在间隔到期后重启节点.
+func sdWatchdog(ctx context.Context, bus event.Bus, interval time.Duration) error {
+	emitter, e := bus.Emitter(new(evtLiveness))
+	if e != nil {
+		return e
+	}
+	sub, e := bus.Subscribe(new(evtLiveness))
+	if e != nil {
+		_ = emitter.Close()
+		return e
+	}
+	go func() {
+		defer emitter.Close()
+		defer sub.Close()
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+		var seq uint64
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			seq++
+			if e := emitter.Emit(evtLiveness{seq: seq}); e != nil {
+				logger.Warnw("watchdog发送事件出错", "error", e)
+				continue
+			}
+			if !waitLiveness(ctx, sub, seq, interval/2) {
+				logger.Warnw("事件总线没有响应, 不回应systemd watchdog", "timeout", interval/2)
+				continue
+			}
+			if _, e := sdNotify("WATCHDOG=1"); e != nil {
+				logger.Warnw("回应systemd watchdog出错", "error", e)
+			}
+		}
+	}()
+	return nil
+}
+
+// waitLiveness 等待收到序号为seq的事件
+func waitLiveness(ctx context.Context, sub event.Subscription, seq uint64, timeout time.Duration) bool {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-deadline.C:
+			return false
+		case evt, ok := <-sub.Out():
+			if !ok {
+				return false
+			}
+			if evt.(evtLiveness).seq >= seq {
+				return true
+			}
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package bootstrap
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// throttleWindow 是统计每个IP新连接数量的时间窗口
+const throttleWindow = time.Minute
+
+// 统计同时连接数量的网段, IPv4取/24, IPv6取/48
+const (
+	throttleIPv4Prefix = 24
+	throttleIPv6Prefix = 48
+)
+
+// inboundConfig 是入站连接的限制, 0表示不限制
+type inboundConfig struct {
+	// RatePerIP 是每个IP每分钟的新连接数量上限
+	RatePerIP int `yaml:"rate_per_ip"`
+	// MaxPerIP 和 MaxPerSubnet 是每个IP和每个网段的同时连接数量上限
+	MaxPerIP     int `yaml:"max_per_ip"`
+	MaxPerSubnet int `yaml:"max_per_subnet"`
+}
+
+// validate 检查入站连接限制
+func (c inboundConfig) validate() error {
+	if c.RatePerIP < 0 || c.MaxPerIP < 0 || c.MaxPerSubnet < 0 {
+		return fmt.Errorf("入站连接限制不能小于0: rate_per_ip %d, max_per_ip %d, max_per_subnet %d", c.RatePerIP, c.MaxPerIP, c.MaxPerSubnet)
+	}
+	return nil
+}
+
+// ipWindow 是一个IP在当前窗口内的新连接数量
+type ipWindow struct {
+	start time.Time
+	count int
+}
+
+// inboundThrottle 按来源IP限制新入站连接的速率和同时连接数量.
+// 同时连接数量通过网络通知统计, 只包括入站连接.
+type inboundThrottle struct {
+	cfg inboundConfig
+	// exempt 不为nil时跳过返回true的地址, 如访问控制列表允许的地址
+	exempt func(net.IP) bool
+
+	mu      sync.Mutex
+	windows map[string]*ipWindow
+	perIP   map[string]int
+	perNet  map[string]int
+
+	rejectedRate   uint64
+	rejectedIP     uint64
+	rejectedSubnet uint64
+}
+
+func newInboundThrottle(cfg inboundConfig, exempt func(net.IP) bool) *inboundThrottle {
+	return &inboundThrottle{
+		cfg:     cfg,
+		exempt:  exempt,
+		windows: make(map[string]*ipWindow),
+		perIP:   make(map[string]int),
+		perNet:  make(map[string]int),
+	}
+}
+
+// subnet 返回IP所属的网段
+func subnet(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(throttleIPv4Prefix, 32)).String() + fmt.Sprintf("/%d", throttleIPv4Prefix)
+	}
+	return ip.Mask(net.CIDRMask(throttleIPv6Prefix, 128)).String() + fmt.Sprintf("/%d", throttleIPv6Prefix)
+}
+
+// allow 判断是否接受来自addr的新连接
+func (t *inboundThrottle) allow(addr ma.Multiaddr) bool {
+	ip, e := manet.ToIP(addr)
+	if e != nil || ip.IsLoopback() || (t.exempt != nil && t.exempt(ip)) {
+		return true
+	}
+	key := ip.String()
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.cfg.MaxPerIP > 0 && t.perIP[key] >= t.cfg.MaxPerIP {
+		atomic.AddUint64(&t.rejectedIP, 1)
+		return false
+	}
+	if t.cfg.MaxPerSubnet > 0 && t.perNet[subnet(ip)] >= t.cfg.MaxPerSubnet {
+		atomic.AddUint64(&t.rejectedSubnet, 1)
+		return false
+	}
+	if t.cfg.RatePerIP > 0 {
+		w := t.windows[key]
+		if w == nil || now.Sub(w.start) >= throttleWindow {
+			w = &ipWindow{start: now}
+			t.windows[key] = w
+		}
+		if w.count >= t.cfg.RatePerIP {
+			atomic.AddUint64(&t.rejectedRate, 1)
+			return false
+		}
+		w.count++
+	}
+	return true
+}
+
+// run 定期清理过期的窗口
+func (t *inboundThrottle) run(ctx context.Context) {
+	ticker := time.NewTicker(throttleWindow)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			t.mu.Lock()
+			for key, w := range t.windows {
+				if now.Sub(w.start) >= throttleWindow {
+					delete(t.windows, key)
+				}
+			}
+			t.mu.Unlock()
+		}
+	}
+}
+
+// track 在入站连接建立和关闭时更新同时连接数量
+func (t *inboundThrottle) track(c network.Conn, delta int) {
+	if c.Stat().Direction != network.DirInbound {
+		return
+	}
+	ip, e := manet.ToIP(c.RemoteMultiaddr())
+	if e != nil {
+		return
+	}
+	key, sub := ip.String(), subnet(ip)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.perIP[key] += delta
+	if t.perIP[key] <= 0 {
+		delete(t.perIP, key)
+	}
+	t.perNet[sub] += delta
+	if t.perNet[sub] <= 0 {
+		delete(t.perNet, sub)
+	}
+}
+
+func (t *inboundThrottle) Connected(_ network.Network, c network.Conn) {
+	t.track(c, 1)
+}
+
+func (t *inboundThrottle) Disconnected(_ network.Network, c network.Conn) {
+	t.track(c, -1)
+}
+
+func (t *inboundThrottle) Listen(network.Network, ma.Multiaddr)         {}
+func (t *inboundThrottle) ListenClose(network.Network, ma.Multiaddr)    {}
+func (t *inboundThrottle) OpenedStream(network.Network, network.Stream) {}
+func (t *inboundThrottle) ClosedStream(network.Network, network.Stream) {}
+
+// rejected 返回按原因统计的拒绝次数
+func (t *inboundThrottle) rejected() map[string]uint64 {
+	return map[string]uint64{
+		"rate":   atomic.LoadUint64(&t.rejectedRate),
+		"ip":     atomic.LoadUint64(&t.rejectedIP),
+		"subnet": atomic.LoadUint64(&t.rejectedSubnet),
+	}
+}
+
+// status 返回限制, 拒绝次数和有入站连接的IP数量
+func (t *inboundThrottle) status() interface{} {
+	t.mu.Lock()
+	ips, subnets := len(t.perIP), len(t.perNet)
+	t.mu.Unlock()
+	return map[string]interface{}{
+		"rate_per_ip":    t.cfg.RatePerIP,
+		"max_per_ip":     t.cfg.MaxPerIP,
+		"max_per_subnet": t.cfg.MaxPerSubnet,
+		"rejected":       t.rejected(),
+		"ips":            ips,
+		"subnets":        subnets,
+	}
+}
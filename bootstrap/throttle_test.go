@@ -0,0 +1,53 @@
+package bootstrap
+
+import (
+	"net"
+	"testing"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestInboundThrottle(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  inboundConfig
+		// perIP 和 perNet 是已有的同时入站连接数量
+		perIP, perNet map[string]int
+		addr          string
+		exempt        bool
+		attempts      int
+		// allowed 是attempts次中被接受的次数
+		allowed int
+	}{
+		{name: "不限制", addr: "/ip4/203.0.113.1/tcp/4001", attempts: 100, allowed: 100},
+		{name: "速率", cfg: inboundConfig{RatePerIP: 3}, addr: "/ip4/203.0.113.1/tcp/4001", attempts: 5, allowed: 3},
+		{name: "每个IP", cfg: inboundConfig{MaxPerIP: 2}, perIP: map[string]int{"203.0.113.1": 2}, addr: "/ip4/203.0.113.1/tcp/4001", attempts: 1, allowed: 0},
+		{name: "每个IP未满", cfg: inboundConfig{MaxPerIP: 2}, perIP: map[string]int{"203.0.113.1": 1}, addr: "/ip4/203.0.113.1/tcp/4001", attempts: 1, allowed: 1},
+		{name: "每个网段", cfg: inboundConfig{MaxPerSubnet: 4}, perNet: map[string]int{"203.0.113.0/24": 4}, addr: "/ip4/203.0.113.9/tcp/4001", attempts: 1, allowed: 0},
+		{name: "IPv6网段", cfg: inboundConfig{MaxPerSubnet: 1}, perNet: map[string]int{"2001:db8::/48": 1}, addr: "/ip6/2001:db8::1:2/udp/4001/quic-v1", attempts: 1, allowed: 0},
+		{name: "本机地址", cfg: inboundConfig{RatePerIP: 1}, addr: "/ip4/127.0.0.1/tcp/4001", attempts: 5, allowed: 5},
+		{name: "例外", cfg: inboundConfig{RatePerIP: 1}, addr: "/ip4/203.0.113.1/tcp/4001", exempt: true, attempts: 5, allowed: 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exempt := func(net.IP) bool { return tt.exempt }
+			throttle := newInboundThrottle(tt.cfg, exempt)
+			for k, v := range tt.perIP {
+				throttle.perIP[k] = v
+			}
+			for k, v := range tt.perNet {
+				throttle.perNet[k] = v
+			}
+			addr := ma.StringCast(tt.addr)
+			allowed := 0
+			for i := 0; i < tt.attempts; i++ {
+				if throttle.allow(addr) {
+					allowed++
+				}
+			}
+			if allowed != tt.allowed {
+				t.Errorf("接受 %d 次, 应为 %d 次", allowed, tt.allowed)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package bootstrap
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	ma "github.com/multiformats/go-multiaddr"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracingShutdownTimeout 是退出时发送剩余span的最长时间
+const tracingShutdownTimeout = time.Second * 5
+
+// tracingConfig 是OpenTelemetry追踪的设置
+type tracingConfig struct {
+	// Endpoint 是OTLP/HTTP接收地址, 如http://127.0.0.1:4318, 为空时不追踪
+	Endpoint string `yaml:"endpoint"`
+	// SampleRatio 是采样比例, 0到1
+	SampleRatio float64 `yaml:"sample_ratio"`
+}
+
+// validate 检查追踪设置
+func (c tracingConfig) validate() error {
+	if c.Endpoint == "" {
+		return nil
+	}
+	if _, e := c.clientOptions(); e != nil {
+		return e
+	}
+	if c.SampleRatio < 0 || c.SampleRatio > 1 {
+		return fmt.Errorf("采样比例必须在0到1之间: %v", c.SampleRatio)
+	}
+	return nil
+}
+
+// clientOptions 根据地址生成OTLP/HTTP选项, http地址不使用TLS
+func (c tracingConfig) clientOptions() ([]otlptracehttp.Option, error) {
+	u, e := url.Parse(c.Endpoint)
+	if e != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("OTLP地址错误, 应为http(s)://主机:端口: %s", c.Endpoint)
+	}
+	options := []otlptracehttp.Option{otlptracehttp.WithEndpoint(u.Host)}
+	if u.Scheme == "http" {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+	if u.Path != "" && u.Path != "/" {
+		options = append(options, otlptracehttp.WithURLPath(u.Path))
+	}
+	return options, nil
+}
+
+// tracer 把连接, 流和identify记录为span.
+// libp2p没有追踪接口, span在连接或流关闭时根据打开时间补记, identify的span从连接打开到identify完成.
+type tracer struct {
+	provider *sdktrace.TracerProvider
+	tracer   trace.Tracer
+}
+
+func newTracer(ctx context.Context, c tracingConfig, h host.Host) (*tracer, error) {
+	options, e := c.clientOptions()
+	if e != nil {
+		return nil, e
+	}
+	exporter, e := otlptrace.New(ctx, otlptracehttp.NewClient(options...))
+	if e != nil {
+		return nil, e
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.TraceIDRatioBased(c.SampleRatio)),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceNameKey.String("go-libp2p-bootstrap"),
+			semconv.ServiceInstanceIDKey.String(h.ID().String()),
+		)),
+	)
+	t := &tracer{provider: provider, tracer: provider.Tracer("bootstrap")}
+	h.Network().Notify(t)
+	return t, nil
+}
+
+// identified 在identify完成时记录span
+func (t *tracer) identified(h host.Host) func(interface{}) {
+	return func(evt interface{}) {
+		p := evt.(event.EvtPeerIdentificationCompleted).Peer
+		conns := h.Network().ConnsToPeer(p)
+		if len(conns) == 0 {
+			return
+		}
+		c := conns[0]
+		agent, _ := h.Peerstore().Get(p, "AgentVersion")
+		protocols, _ := h.Peerstore().GetProtocols(p)
+		attrs := append(connAttributes(c),
+			attribute.String("libp2p.agent_version", fmt.Sprint(agent)),
+			attribute.Int("libp2p.protocols", len(protocols)),
+		)
+		t.record("identify", c.Stat().Opened, attrs)
+	}
+}
+
+// record 补记一个从start到现在的span
+func (t *tracer) record(name string, start time.Time, attrs []attribute.KeyValue) {
+	_, span := t.tracer.Start(context.Background(), name, trace.WithTimestamp(start), trace.WithAttributes(attrs...))
+	span.End()
+}
+
+// shutdown 发送剩余的span
+func (t *tracer) shutdown() {
+	ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+	defer cancel()
+	if e := t.provider.Shutdown(ctx); e != nil {
+		logger.Warnw("发送追踪数据出错", "error", e)
+	}
+}
+
+func (t *tracer) Disconnected(_ network.Network, c network.Conn) {
+	t.record("connection", c.Stat().Opened, connAttributes(c))
+}
+
+func (t *tracer) ClosedStream(_ network.Network, s network.Stream) {
+	name := string(s.Protocol())
+	if name == "" {
+		name = "stream"
+	}
+	attrs := append(connAttributes(s.Conn()), attribute.String("libp2p.stream_direction", s.Stat().Direction.String()))
+	t.record(name, s.Stat().Opened, attrs)
+}
+
+func (t *tracer) Connected(network.Network, network.Conn)      {}
+func (t *tracer) Listen(network.Network, ma.Multiaddr)         {}
+func (t *tracer) ListenClose(network.Network, ma.Multiaddr)    {}
+func (t *tracer) OpenedStream(network.Network, network.Stream) {}
+
+// connAttributes 返回连接的对方节点, 方向, 地址和传输
+func connAttributes(c network.Conn) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("libp2p.peer", c.RemotePeer().String()),
+		attribute.String("libp2p.direction", c.Stat().Direction.String()),
+		attribute.String("libp2p.remote_addr", c.RemoteMultiaddr().String()),
+		attribute.String("libp2p.transport", addrTransport(c.RemoteMultiaddr())),
+	}
+}
@@ -0,0 +1,157 @@
+package bootstrap
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	stunBindingRequest  = 0x0001
+	stunBindingResponse = 0x0101
+	stunMagicCookie     = 0x2112A442
+
+	stunAttrMappedAddress    = 0x0001
+	stunAttrXorMappedAddress = 0x0020
+)
+
+// udpProbeResult 是出站UDP探测结果
+type udpProbeResult struct {
+	Server string `json:"server"`
+	Usable bool   `json:"usable"`
+	// Mapped 是STUN服务器看到的本机地址
+	Mapped string `json:"mapped,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// probeUDP 向STUN服务器发送绑定请求, 检查出站UDP是否可用.
+// 很多环境会静默丢弃出站UDP, 此时QUIC看似已配置但无法使用.
+func probeUDP(ctx context.Context, server string, timeout time.Duration) udpProbeResult {
+	result := udpProbeResult{Server: server}
+	mapped, e := stunBinding(ctx, server, timeout)
+	if e != nil {
+		result.Error = e.Error()
+		return result
+	}
+	result.Usable = true
+	result.Mapped = mapped.String()
+	return result
+}
+
+// stunBinding 执行一次STUN绑定请求(RFC 5389), 返回映射地址. 请求会重发3次.
+func stunBinding(ctx context.Context, server string, timeout time.Duration) (*net.UDPAddr, error) {
+	conn, e := net.ListenPacket("udp", ":0")
+	if e != nil {
+		return nil, e
+	}
+	defer conn.Close()
+	return stunBindingFrom(ctx, conn, server, timeout)
+}
+
+// stunBindingFrom 从conn发送STUN绑定请求, 从同一个端口向多个服务器请求可以判断NAT的映射方式
+func stunBindingFrom(ctx context.Context, conn net.PacketConn, server string, timeout time.Duration) (*net.UDPAddr, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ips, e := net.DefaultResolver.LookupIPAddr(ctx, stunHost(server))
+	if e != nil {
+		return nil, e
+	}
+	// 优先使用IPv4, NAT一般只存在于IPv4
+	ip := ips[0].IP
+	for _, v := range ips {
+		if v.IP.To4() != nil {
+			ip = v.IP
+			break
+		}
+	}
+	addr, e := net.ResolveUDPAddr("udp", net.JoinHostPort(ip.String(), stunPort(server)))
+	if e != nil {
+		return nil, e
+	}
+
+	request := make([]byte, 20)
+	binary.BigEndian.PutUint16(request[0:], stunBindingRequest)
+	binary.BigEndian.PutUint32(request[4:], stunMagicCookie)
+	if _, e = rand.Read(request[8:20]); e != nil {
+		return nil, e
+	}
+
+	response := make([]byte, 1500)
+	deadline, _ := ctx.Deadline()
+	interval := time.Until(deadline) / 3
+	for attempt := 0; attempt < 3; attempt++ {
+		if _, e = conn.WriteTo(request, addr); e != nil {
+			return nil, e
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(interval))
+		for {
+			n, from, e := conn.ReadFrom(response)
+			if e != nil {
+				var netErr net.Error
+				if errors.As(e, &netErr) && netErr.Timeout() {
+					break
+				}
+				return nil, e
+			}
+			if from.String() != addr.String() || n < 20 || binary.BigEndian.Uint16(response[0:]) != stunBindingResponse || !bytes.Equal(response[8:20], request[8:20]) {
+				continue
+			}
+			return parseStunMappedAddress(response[20:n], request[4:20])
+		}
+	}
+	return nil, fmt.Errorf("STUN服务器%s没有响应", server)
+}
+
+// stunHost 和 stunPort 拆分STUN服务器地址, 没有端口时使用3478
+func stunHost(server string) string {
+	if host, _, e := net.SplitHostPort(server); e == nil {
+		return host
+	}
+	return server
+}
+
+func stunPort(server string) string {
+	if _, port, e := net.SplitHostPort(server); e == nil {
+		return port
+	}
+	return "3478"
+}
+
+// parseStunMappedAddress 从STUN响应属性中解析映射地址, key是魔术字和事务ID
+func parseStunMappedAddress(attrs []byte, key []byte) (*net.UDPAddr, error) {
+	var mapped *net.UDPAddr
+	for len(attrs) >= 4 {
+		typ := binary.BigEndian.Uint16(attrs[0:])
+		length := int(binary.BigEndian.Uint16(attrs[2:]))
+		if len(attrs) < 4+length {
+			break
+		}
+		value := attrs[4 : 4+length]
+		// 属性按4字节对齐
+		attrs = attrs[4+(length+3)&^3:]
+		if len(value) < 8 || (typ != stunAttrXorMappedAddress && typ != stunAttrMappedAddress) {
+			continue
+		}
+		port := binary.BigEndian.Uint16(value[2:])
+		ip := make(net.IP, len(value)-4)
+		copy(ip, value[4:])
+		if typ == stunAttrXorMappedAddress {
+			port ^= stunMagicCookie >> 16
+			for i := range ip {
+				ip[i] ^= key[i]
+			}
+			return &net.UDPAddr{IP: ip, Port: int(port)}, nil
+		}
+		mapped = &net.UDPAddr{IP: ip, Port: int(port)}
+	}
+	if mapped == nil {
+		return nil, errors.New("STUN响应中没有映射地址")
+	}
+	return mapped, nil
+}
@@ -0,0 +1,363 @@
+package bootstrap
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"gopkg.in/yaml.v2"
+)
+
+// validateConfig 检查设置, 返回发现的所有问题. 不修改磁盘上的文件
+func validateConfig(cfg *Config) []error {
+	var errs []error
+	if !cfg.Transports.TCP && !cfg.Transports.QUIC && cfg.WSPort == 0 {
+		errs = append(errs, errors.New("没有启用任何传输"))
+	}
+	if !cfg.Security.TLS && !cfg.Security.Noise {
+		errs = append(errs, errors.New("没有启用任何安全协议"))
+	}
+	if e := cfg.Muxers.validate(); e != nil {
+		errs = append(errs, e)
+	}
+	if e := cfg.validateWebRTC(cfg.Transports.QUIC); e != nil {
+		errs = append(errs, e)
+	}
+	if e := cfg.Resources.validate(); e != nil {
+		errs = append(errs, e)
+	}
+	if e := cfg.Log.validate(); e != nil {
+		errs = append(errs, e)
+	}
+	if e := cfg.Tracing.validate(); e != nil {
+		errs = append(errs, e)
+	}
+	if geo, e := openGeoIP(cfg.GeoIP); e != nil {
+		errs = append(errs, e)
+	} else if geo != nil {
+		geo.Close()
+	}
+	if e := cfg.DHT.validate(); e != nil {
+		errs = append(errs, e)
+	}
+	if e := cfg.Rendezvous.validate(); e != nil {
+		errs = append(errs, e)
+	}
+	if e := cfg.Crawl.validate(); e != nil {
+		errs = append(errs, e)
+	}
+	if e := cfg.Snapshot.validate(); e != nil {
+		errs = append(errs, e)
+	}
+	if _, e := newDialFilter(cfg.blockPrivateDials(), cfg.DialDeny); e != nil {
+		errs = append(errs, e)
+	}
+	if _, e := newAnnounceFilter(cfg.Announce, cfg.NoAnnounce, cfg.AnnouncePrivate); e != nil {
+		errs = append(errs, e)
+	}
+	if e := cfg.AutoNAT.validate(); e != nil {
+		errs = append(errs, e)
+	}
+	if _, e := cfg.Relay.staticRelays(); e != nil {
+		errs = append(errs, e)
+	}
+	if cfg.RelayService {
+		if e := cfg.Relay.validate(); e != nil {
+			errs = append(errs, e)
+		}
+	}
+	if cfg.ReadyMinPeers < 0 {
+		errs = append(errs, fmt.Errorf("ready-min-peers不能小于0: %d", cfg.ReadyMinPeers))
+	}
+	if cfg.PeerLogInterval < 0 {
+		errs = append(errs, fmt.Errorf("peer-log-interval不能小于0: %s", cfg.PeerLogInterval))
+	}
+	if cfg.PeerExchange && cfg.PeerExchangeMax <= 0 {
+		errs = append(errs, fmt.Errorf("peer-exchange-max必须大于0: %d", cfg.PeerExchangeMax))
+	}
+	if e := cfg.ConnMgr.validate(); e != nil {
+		errs = append(errs, e)
+	}
+	if e := cfg.Admin.validate(); e != nil {
+		errs = append(errs, e)
+	} else if _, e := cfg.Admin.serverTLS(); e != nil {
+		errs = append(errs, e)
+	}
+	if cfg.AdminAddr != "" {
+		if e := cfg.Admin.checkAddr(cfg.AdminAddr); e != nil {
+			errs = append(errs, fmt.Errorf("admin-addr: %w", e))
+		}
+	}
+	if cfg.GRPCAddr != "" {
+		if e := cfg.Admin.checkAddr(cfg.GRPCAddr); e != nil {
+			errs = append(errs, fmt.Errorf("grpc-addr: %w", e))
+		}
+	}
+	if cfg.PprofAddr != "" {
+		if e := checkPprofAddr(cfg.PprofAddr); e != nil {
+			errs = append(errs, e)
+		}
+	}
+	if cfg.SwarmKey != "" {
+		if _, e := loadSwarmKey(cfg.SwarmKey); e != nil {
+			errs = append(errs, fmt.Errorf("私有网络密钥 %s: %w", cfg.SwarmKey, e))
+		}
+		if !cfg.Transports.TCP && cfg.WSPort == 0 {
+			errs = append(errs, errors.New("私有网络不支持QUIC, 需要启用TCP或WebSocket"))
+		}
+		if cfg.Transports.WebRTC {
+			errs = append(errs, errors.New("私有网络不支持WebRTC"))
+		}
+	}
+
+	// 私钥
+	if _, ok := keyTypes[cfg.KeyType]; !ok {
+		errs = append(errs, fmt.Errorf("不支持的私钥类型 %s", cfg.KeyType))
+	}
+	if cfg.KeyType == "rsa" && cfg.KeyBits < 2048 {
+		errs = append(errs, fmt.Errorf("RSA私钥位数至少为2048: %d", cfg.KeyBits))
+	}
+	dir, e := cfg.dataDir()
+	if e != nil {
+		errs = append(errs, e)
+	} else if cfg.Ephemeral {
+		// 临时模式的私钥在启动时生成
+	} else if cfg.IdentityFromHostname {
+		if cfg.IdentitySalt == "" {
+			errs = append(errs, errors.New("派生身份必须设置盐"))
+		}
+		if cfg.KeyType != "ed25519" {
+			errs = append(errs, errors.New("派生身份只支持ed25519私钥"))
+		}
+	} else if requireFileKeystore(cfg) != nil {
+		if ks, e := newKeystore(cfg, dir); e != nil {
+			errs = append(errs, e)
+		} else if _, e := ks.Load(); e != nil {
+			errs = append(errs, e)
+		}
+	} else if e := checkPrivateKey(keyPath(cfg, dir)); e != nil {
+		errs = append(errs, e)
+	}
+
+	// 地址
+	listenAddrs, e := cfg.listenAddrs(cfg.listenIPs(nil), cfg.Transports.QUIC, cfg.enableWebRTC(nil))
+	if e != nil {
+		errs = append(errs, e)
+	}
+	for _, addr := range listenAddrs {
+		e := checkListenAddr(addr)
+		// 系统不支持IPv6时节点只在IPv4上监听, 不算错误
+		if e != nil && len(cfg.Listen) == 0 && strings.HasPrefix(addr, "/ip6/::/") {
+			logger.Warnw("系统不支持IPv6, 只在IPv4上监听", "addr", addr, "error", e)
+			continue
+		}
+		if e != nil {
+			errs = append(errs, e)
+		}
+	}
+	if tiers, e := cfg.bootstrapTiers(dir); e != nil {
+		errs = append(errs, e)
+	} else if _, e := parseTiers(resolveTiers(context.Background(), tiers, cfg.TierTimeout)); e != nil {
+		errs = append(errs, fmt.Errorf("引导节点地址错误: %w", e))
+	}
+	if e := cfg.Inbound.validate(); e != nil {
+		errs = append(errs, e)
+	}
+	if e := checkShutdownTimeout(cfg.ShutdownTimeout); e != nil {
+		errs = append(errs, e)
+	}
+	if cfg.StartupRetry < 0 {
+		errs = append(errs, fmt.Errorf("startup_retry不能小于0: %s", cfg.StartupRetry))
+	}
+	if e := checkEphemeral(cfg); e != nil {
+		errs = append(errs, e)
+	}
+	if cfg.Daemon && cfg.Log.File == "" {
+		errs = append(errs, errors.New("-daemon需要设置-log-file"))
+	}
+	if e := checkBootstrapQuorum(cfg.BootstrapQuorum); e != nil {
+		errs = append(errs, e)
+	}
+	if e := checkBootstrapRetry(cfg.BootstrapRetryMin, cfg.BootstrapRetryMax); e != nil {
+		errs = append(errs, e)
+	}
+	if e := cfg.Scoring.validate(); e != nil {
+		errs = append(errs, e)
+	}
+	if e := cfg.Watchdog.validate(); e != nil {
+		errs = append(errs, e)
+	}
+	if e := cfg.ObservedAddrs.validate(); e != nil {
+		errs = append(errs, e)
+	}
+	if e := cfg.SelfRecord.validate(); e != nil {
+		errs = append(errs, e)
+	}
+	if e := cfg.DialBackoff.validate(); e != nil {
+		errs = append(errs, e)
+	}
+	if e := cfg.Cluster.validate(); e != nil {
+		errs = append(errs, e)
+	}
+	if e := cfg.Info.validate(); e != nil {
+		errs = append(errs, e)
+	}
+	if cfg.DrainPeriod < 0 {
+		errs = append(errs, fmt.Errorf("drain_period不能小于0: %s", cfg.DrainPeriod))
+	}
+	if e := cfg.Telemetry.validate(); e != nil {
+		errs = append(errs, e)
+	}
+	if e := cfg.BanSync.validate(); e != nil {
+		errs = append(errs, e)
+	}
+	if e := cfg.Reputation.validate(); e != nil {
+		errs = append(errs, e)
+	}
+	if e := cfg.PubSub.validate(); e != nil {
+		errs = append(errs, e)
+	}
+	if e := checkAgentVersion(cfg.AgentVersion); e != nil {
+		errs = append(errs, e)
+	}
+	if e := checkPeerstore(cfg.Peerstore); e != nil {
+		errs = append(errs, e)
+	}
+	if e := cfg.Datastore.validate(cfg.DHT.Datastore, cfg.Peerstore); e != nil {
+		errs = append(errs, e)
+	}
+	if e := checkOnion(cfg); e != nil {
+		errs = append(errs, e)
+	}
+	if cfg.Proxy != "" {
+		if _, e := newProxyDialer(cfg.Proxy); e != nil {
+			errs = append(errs, e)
+		}
+	}
+	if e := checkAdvertise(cfg.Advertise, cfg.AdvertiseFind); e != nil {
+		errs = append(errs, e)
+	}
+	if e := cfg.PeerstoreGC.validate(); e != nil {
+		errs = append(errs, e)
+	}
+	if _, e := loadAccessList(aclPath(cfg, dir)); e != nil {
+		errs = append(errs, fmt.Errorf("访问控制列表错误: %w", e))
+	}
+	if _, e := decodePeerIDs(cfg.Protect); e != nil {
+		errs = append(errs, fmt.Errorf("受保护节点ID错误: %w", e))
+	}
+	if _, e := decodePeerIDs(cfg.EventsAllow); e != nil {
+		errs = append(errs, fmt.Errorf("events-allow节点ID错误: %w", e))
+	}
+	if cfg.WebhookURL != "" {
+		if _, e := url.ParseRequestURI(cfg.WebhookURL); e != nil {
+			errs = append(errs, fmt.Errorf("Webhook地址错误: %w", e))
+		}
+	}
+	if len(cfg.Networks) > 0 {
+		names, cfgs, e := cfg.networkConfigs()
+		if e != nil {
+			return append(errs, e)
+		}
+		for i, c := range cfgs {
+			for _, e := range validateConfig(c) {
+				errs = append(errs, fmt.Errorf("网络 %s: %w", names[i], e))
+			}
+		}
+	}
+	return errs
+}
+
+// checkPrivateKey 检查私钥文件能否解析, 文件不存在时启动会生成, 不算错误
+func checkPrivateKey(path string) error {
+	b, e := ioutil.ReadFile(path)
+	if os.IsNotExist(e) {
+		logger.Infow("私钥文件不存在, 启动时生成", "path", path)
+		return nil
+	}
+	if e != nil {
+		return e
+	}
+	if isEncryptedKey(b) {
+		if _, ok := os.LookupEnv(keyPasswordEnv); !ok {
+			logger.Warnw("私钥已加密, 没有设置密码环境变量, 不检查密码", "env", keyPasswordEnv)
+			return nil
+		}
+		password, e := readPassword(false)
+		if e != nil {
+			return e
+		}
+		if b, e = decryptKey(b, password); e != nil {
+			return e
+		}
+	}
+	if _, e := crypto.UnmarshalPrivateKey(b); e != nil {
+		return fmt.Errorf("私钥文件错误 %s: %w", path, e)
+	}
+	return nil
+}
+
+// checkListenAddr 尝试监听地址以确认端口可用
+func checkListenAddr(addr string) error {
+	listenAddr, e := ma.NewMultiaddr(addr)
+	if e != nil {
+		return e
+	}
+	// QUIC监听的是UDP端口
+	if _, e := listenAddr.ValueForProtocol(ma.P_QUIC_V1); e == nil {
+		listenAddr = listenAddr.Decapsulate(ma.StringCast("/quic-v1"))
+	}
+	if _, e := listenAddr.ValueForProtocol(ma.P_WEBRTC_DIRECT); e == nil {
+		listenAddr = listenAddr.Decapsulate(ma.StringCast("/webrtc-direct"))
+	}
+	if _, e := listenAddr.ValueForProtocol(ma.P_UDP); e == nil {
+		c, e := manet.ListenPacket(listenAddr)
+		if e != nil {
+			return fmt.Errorf("无法监听 %s: %w", addr, e)
+		}
+		return c.Close()
+	}
+	l, e := manet.Listen(listenAddr)
+	if e != nil {
+		return fmt.Errorf("无法监听 %s: %w", addr, e)
+	}
+	return l.Close()
+}
+
+// runValidate 检查设置并输出生效的配置, 不启动节点. 有问题时退出码为1
+func runValidate(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	cfg, e := commandConfig(fs, args)
+	if e != nil {
+		logger.Errorw("读取设置出错", "error", e)
+		return 1
+	}
+	effective := *cfg
+	if effective.IdentitySalt != "" {
+		effective.IdentitySalt = "***"
+	}
+	b, e := yaml.Marshal(&effective)
+	if e != nil {
+		logger.Errorw("输出配置出错", "error", e)
+		return 1
+	}
+	fmt.Print(string(b))
+
+	errs := validateConfig(cfg)
+	for _, e := range errs {
+		logger.Errorw("配置错误", "error", e)
+	}
+	if len(errs) > 0 {
+		return 1
+	}
+	logger.Infow("配置正确")
+	return 0
+}
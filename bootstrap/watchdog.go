@@ -0,0 +1,182 @@
+package bootstrap
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
+)
+
+// watchdogConfig 是空闲连接检查的设置. NAT映射失效后连接可能一直保留, 占用连接管理器的名额,
+// 长时间空闲的连接被ping, 连续失败的被关闭
+type watchdogConfig struct {
+	// Idle 是连接上没有流多久后开始ping, 0表示不检查
+	Idle time.Duration `yaml:"idle"`
+	// Interval 是检查的间隔
+	Interval time.Duration `yaml:"interval"`
+	// MaxFailures 是连续ping失败多少次后关闭连接
+	MaxFailures int `yaml:"max_failures"`
+}
+
+// validate 检查空闲连接检查设置
+func (c watchdogConfig) validate() error {
+	if c.Idle < 0 {
+		return fmt.Errorf("空闲时间不能小于0: %s", c.Idle)
+	}
+	if c.Idle > 0 && (c.Interval <= 0 || c.MaxFailures <= 0) {
+		return fmt.Errorf("空闲连接检查设置错误: interval %s, max_failures %d", c.Interval, c.MaxFailures)
+	}
+	return nil
+}
+
+// watchdogPeer 是一个空闲节点的状态
+type watchdogPeer struct {
+	idleSince time.Time
+	failures  int
+}
+
+// watchdog 定期检查连接的节点, 所有连接上都没有流超过Idle的节点被ping,
+// 成功后重新计算空闲时间, 连续失败MaxFailures次后关闭连接. 受保护的节点不检查
+type watchdog struct {
+	h   host.Host
+	cfg watchdogConfig
+
+	mu    sync.Mutex
+	peers map[peer.ID]*watchdogPeer
+
+	pinged uint64
+	failed uint64
+	closed uint64
+}
+
+func newWatchdog(h host.Host, cfg watchdogConfig) *watchdog {
+	return &watchdog{h: h, cfg: cfg, peers: make(map[peer.ID]*watchdogPeer)}
+}
+
+func (w *watchdog) run(ctx context.Context) {
+	ticker := time.NewTicker(w.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.check(ctx)
+		}
+	}
+}
+
+// peerIdle 判断节点的所有连接上都没有流
+func peerIdle(n network.Network, p peer.ID) bool {
+	for _, c := range n.ConnsToPeer(p) {
+		if len(c.GetStreams()) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// check 更新空闲时间, ping空闲超过Idle的节点
+func (w *watchdog) check(ctx context.Context) {
+	n := w.h.Network()
+	cm := w.h.ConnManager()
+	now := time.Now()
+	connected := make(map[peer.ID]bool)
+	var due []peer.ID
+	w.mu.Lock()
+	for _, p := range n.Peers() {
+		connected[p] = true
+		if cm.IsProtected(p, "") || !peerIdle(n, p) {
+			delete(w.peers, p)
+			continue
+		}
+		wp, ok := w.peers[p]
+		if !ok {
+			wp = &watchdogPeer{idleSince: now}
+			w.peers[p] = wp
+		}
+		if now.Sub(wp.idleSince) >= w.cfg.Idle {
+			due = append(due, p)
+		}
+	}
+	for p := range w.peers {
+		if !connected[p] {
+			delete(w.peers, p)
+		}
+	}
+	w.mu.Unlock()
+
+	queue := make(chan peer.ID)
+	var wg sync.WaitGroup
+	for i := 0; i < scorePingWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range queue {
+				w.ping(ctx, p)
+			}
+		}()
+	}
+	for _, p := range due {
+		select {
+		case <-ctx.Done():
+		case queue <- p:
+		}
+	}
+	close(queue)
+	wg.Wait()
+}
+
+// ping 在已有连接上ping一次, 成功时重新计算空闲时间, 连续失败达到上限时关闭连接
+func (w *watchdog) ping(ctx context.Context, p peer.ID) {
+	pc, cancel := context.WithTimeout(network.WithNoDial(ctx, "空闲连接检查"), connectTimeout)
+	defer cancel()
+	atomic.AddUint64(&w.pinged, 1)
+	result := <-ping.Ping(pc, w.h, p)
+	if ctx.Err() != nil {
+		return
+	}
+	w.mu.Lock()
+	wp, ok := w.peers[p]
+	if !ok {
+		w.mu.Unlock()
+		return
+	}
+	if result.Error == nil {
+		wp.idleSince, wp.failures = time.Now(), 0
+		w.mu.Unlock()
+		return
+	}
+	atomic.AddUint64(&w.failed, 1)
+	wp.failures++
+	failures := wp.failures
+	if failures >= w.cfg.MaxFailures {
+		delete(w.peers, p)
+	}
+	w.mu.Unlock()
+	if failures < w.cfg.MaxFailures {
+		return
+	}
+	atomic.AddUint64(&w.closed, 1)
+	logger.Infow("关闭空闲且没有响应的连接", "peer", p, "failures", failures, "error", result.Error)
+	_ = w.h.Network().ClosePeer(p)
+}
+
+// status 返回空闲的节点数量以及ping, 失败和关闭的次数
+func (w *watchdog) status() interface{} {
+	w.mu.Lock()
+	idlePeers := len(w.peers)
+	w.mu.Unlock()
+	return map[string]interface{}{
+		"idle_peers": idlePeers,
+		"pinged":     atomic.LoadUint64(&w.pinged),
+		"failed":     atomic.LoadUint64(&w.failed),
+		"closed":     atomic.LoadUint64(&w.closed),
+	}
+}
@@ -0,0 +1,98 @@
+package bootstrap
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	// webhookBuffer 是等待发送的事件数量上限, 超过时丢弃
+	webhookBuffer = 64
+	// webhookAttempts 是每个事件的最多发送次数
+	webhookAttempts = 5
+	// webhookMaxBackoff 是重试的最长等待时间
+	webhookMaxBackoff = time.Second * 30
+)
+
+// webhook 将事件以JSON形式POST到指定地址
+type webhook struct {
+	url    string
+	types  map[string]bool
+	client *http.Client
+}
+
+func newWebhook(url string, types []string) *webhook {
+	m := make(map[string]bool)
+	for _, t := range types {
+		m[t] = true
+	}
+	return &webhook{url: url, types: m, client: &http.Client{Timeout: time.Second * 10}}
+}
+
+// run 订阅事件并逐个发送, 直到ctx结束
+func (w *webhook) run(ctx context.Context, hub *eventHub) {
+	sub := hub.Subscribe(webhookBuffer)
+	defer sub.Close()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case evt := <-sub.C:
+			if dropped := sub.TakeDropped(); dropped > 0 {
+				logger.Warnw("Webhook发送不及时, 丢弃事件", "dropped", dropped)
+			}
+			if !w.types[evt.Type] {
+				continue
+			}
+			e := w.send(ctx, evt)
+			if e != nil {
+				logger.Warnw("Webhook发送失败", "event", evt.Type, "error", e)
+			}
+		}
+	}
+}
+
+// send 发送事件, 失败时退避重试
+func (w *webhook) send(ctx context.Context, evt nodeEvent) error {
+	body, e := json.Marshal(evt)
+	if e != nil {
+		return e
+	}
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		e = w.post(ctx, body)
+		if e == nil || attempt == webhookAttempts {
+			return e
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > webhookMaxBackoff {
+			backoff = webhookMaxBackoff
+		}
+	}
+}
+
+func (w *webhook) post(ctx context.Context, body []byte) error {
+	req, e := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if e != nil {
+		return e
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, e := w.client.Do(req)
+	if e != nil {
+		return e
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("响应状态%s", resp.Status)
+	}
+	return nil
+}
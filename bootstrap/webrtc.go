@@ -0,0 +1,219 @@
+package bootstrap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/connmgr"
+	ic "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	tpt "github.com/libp2p/go-libp2p/core/transport"
+	"github.com/libp2p/go-libp2p/p2p/net/swarm"
+	libp2pwebrtc "github.com/libp2p/go-libp2p/p2p/transport/webrtc"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// webrtcMaxCertLifetime 是证书最长的使用时间. libp2p生成的证书有效期约29天, 不能设置
+const webrtcMaxCertLifetime = time.Hour * 24 * 28
+
+// webrtcTransport 包装libp2p的WebRTC direct传输. libp2p在创建传输时生成自签名证书并一直使用,
+// 更换证书时创建新的传输, 之后监听的地址使用新证书, 已经建立的出站连接不受影响
+type webrtcTransport struct {
+	privKey   ic.PrivKey
+	gater     connmgr.ConnectionGater
+	rcmgr     network.ResourceManager
+	listenUDP libp2pwebrtc.ListenUDPFn
+
+	mu      sync.RWMutex
+	current *libp2pwebrtc.WebRTCTransport
+	created time.Time
+}
+
+var _ tpt.Transport = &webrtcTransport{}
+
+// newWebRTCTransport 是libp2p.Transport的构造函数, 参数由libp2p提供. 私有网络不支持WebRTC
+func newWebRTCTransport(privKey ic.PrivKey, gater connmgr.ConnectionGater, rcmgr network.ResourceManager, listenUDP libp2pwebrtc.ListenUDPFn) (*webrtcTransport, error) {
+	t := &webrtcTransport{privKey: privKey, gater: gater, rcmgr: rcmgr, listenUDP: listenUDP}
+	if e := t.renew(); e != nil {
+		return nil, e
+	}
+	return t, nil
+}
+
+// renew 创建使用新证书的传输
+func (t *webrtcTransport) renew() error {
+	current, e := libp2pwebrtc.New(t.privKey, nil, t.gater, t.rcmgr, t.listenUDP)
+	if e != nil {
+		return fmt.Errorf("创建WebRTC传输出错: %w", e)
+	}
+	t.mu.Lock()
+	t.current, t.created = current, time.Now()
+	t.mu.Unlock()
+	return nil
+}
+
+func (t *webrtcTransport) transport() *libp2pwebrtc.WebRTCTransport {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.current
+}
+
+// age 返回当前证书的使用时间
+func (t *webrtcTransport) age() time.Duration {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return time.Since(t.created)
+}
+
+func (t *webrtcTransport) Dial(ctx context.Context, raddr ma.Multiaddr, p peer.ID) (tpt.CapableConn, error) {
+	return t.transport().Dial(ctx, raddr, p)
+}
+
+func (t *webrtcTransport) CanDial(addr ma.Multiaddr) bool {
+	return t.transport().CanDial(addr)
+}
+
+func (t *webrtcTransport) Listen(laddr ma.Multiaddr) (tpt.Listener, error) {
+	return t.transport().Listen(laddr)
+}
+
+func (t *webrtcTransport) Protocols() []int {
+	return t.transport().Protocols()
+}
+
+func (t *webrtcTransport) Proxy() bool {
+	return false
+}
+
+func (t *webrtcTransport) ListenOrder() int {
+	return t.transport().ListenOrder()
+}
+
+// AddCertHashes 由libp2p调用, 在公布的地址中加入当前证书的哈希
+func (t *webrtcTransport) AddCertHashes(addr ma.Multiaddr) (ma.Multiaddr, bool) {
+	return t.transport().AddCertHashes(addr)
+}
+
+// webrtc证书更换或重新监听失败后的重试间隔
+const (
+	webrtcRetryMin = time.Minute
+	webrtcRetryMax = time.Hour
+)
+
+// webrtcCerts 按设置的使用时间更换WebRTC证书
+type webrtcCerts struct {
+	h        host.Host
+	lifetime time.Duration
+
+	// pending 是更换证书时已关闭但还没有重新监听成功的地址
+	pending []ma.Multiaddr
+}
+
+// run 定期检查证书的使用时间, 到期时更换. 出错时按退避间隔重试, 有没有重新监听成功的地址时只重试监听
+func (c *webrtcCerts) run(ctx context.Context) {
+	t, ok := findWebRTCTransport(c.h)
+	if !ok {
+		return
+	}
+	var retry time.Duration
+	for {
+		wait := c.lifetime - t.age()
+		if retry > 0 {
+			wait = retry
+		}
+		if wait < 0 {
+			wait = 0
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		var e error
+		if len(c.pending) > 0 {
+			e = c.listen()
+		} else {
+			e = c.rotate(t)
+		}
+		if e == nil {
+			retry = 0
+			continue
+		}
+		if retry *= 2; retry < webrtcRetryMin {
+			retry = webrtcRetryMin
+		} else if retry > webrtcRetryMax {
+			retry = webrtcRetryMax
+		}
+		logger.Warnw("更换WebRTC证书出错", "error", e, "retry", retry)
+	}
+}
+
+// rotate 创建使用新证书的传输并重新监听. 关闭旧的监听时断开其上的入站连接, 浏览器需要使用新的地址重新连接.
+// 新旧传输使用同一个UDP端口, 只能先关闭旧的监听, 重新监听失败的地址留在pending中重试
+func (c *webrtcCerts) rotate(t *webrtcTransport) error {
+	s := c.h.Network().(*swarm.Swarm)
+	var listening []ma.Multiaddr
+	for _, addr := range s.ListenAddresses() {
+		if ok, _ := libp2pwebrtc.IsWebRTCDirectMultiaddr(addr); ok {
+			listening = append(listening, addr)
+		}
+	}
+	if e := t.renew(); e != nil {
+		return e
+	}
+	s.ListenClose(listening...)
+	for _, addr := range listening {
+		laddr, _ := ma.SplitFunc(addr, func(c ma.Component) bool { return c.Protocol().Code == ma.P_CERTHASH })
+		c.pending = append(c.pending, laddr)
+	}
+	if e := c.listen(); e != nil {
+		return e
+	}
+	logger.Infow("已更换WebRTC证书", "addrs", len(listening))
+	return nil
+}
+
+// listen 监听pending中的地址, 失败的地址留在pending中
+func (c *webrtcCerts) listen() error {
+	s := c.h.Network().(*swarm.Swarm)
+	var failed []ma.Multiaddr
+	var errs []error
+	for _, laddr := range c.pending {
+		if e := s.Listen(laddr); e != nil {
+			failed = append(failed, laddr)
+			errs = append(errs, fmt.Errorf("监听 %s 出错: %w", laddr, e))
+		}
+	}
+	c.pending = failed
+	return errors.Join(errs...)
+}
+
+// findWebRTCTransport 返回节点使用的WebRTC传输
+func findWebRTCTransport(h host.Host) (*webrtcTransport, bool) {
+	s, ok := h.Network().(*swarm.Swarm)
+	if !ok {
+		return nil, false
+	}
+	t, ok := s.TransportForListening(ma.StringCast("/ip4/127.0.0.1/udp/0/webrtc-direct")).(*webrtcTransport)
+	return t, ok
+}
+
+// validateWebRTC 检查WebRTC设置
+func (c *Config) validateWebRTC(enableQUIC bool) error {
+	if !c.enableWebRTC(nil) {
+		return nil
+	}
+	if c.Transports.WebRTCCertLifetime < time.Hour || c.Transports.WebRTCCertLifetime > webrtcMaxCertLifetime {
+		return fmt.Errorf("WebRTC证书使用时间应在1小时到%s之间: %s", webrtcMaxCertLifetime, c.Transports.WebRTCCertLifetime)
+	}
+	// 与QUIC共用端口时从QUIC的监听端口收发非QUIC的包, 需要复用端口
+	if enableQUIC && !c.Transports.QUICReusePort && c.webrtcPort() != 0 && c.webrtcPort() == c.quicPort() {
+		return errors.New("WebRTC与QUIC共用UDP端口时需要quic_reuse_port, 或者用webrtc_port设置另一个端口")
+	}
+	return nil
+}
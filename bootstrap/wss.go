@@ -0,0 +1,129 @@
+package bootstrap
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// wssConfig 是安全WebSocket的设置, 证书通过ACME(Let's Encrypt)自动申请和续期
+type wssConfig struct {
+	Domain string `yaml:"domain"`
+	Port   int    `yaml:"port"`
+	Email  string `yaml:"email"`
+}
+
+// wssProxy 在wss端口上终止TLS, 把连接转发到本机的ws监听地址.
+// 当前版本的WebSocket传输不支持TLS, 所以在传输前面加一层.
+// ACME使用TLS-ALPN-01验证, 只需要wss端口(通常是443)可以从公网访问.
+type wssProxy struct {
+	cfg      wssConfig
+	target   string
+	manager  *autocert.Manager
+	listener net.Listener
+	announce ma.Multiaddr
+	accepted int64
+	failed   int64
+}
+
+// newWSSProxy 创建代理, 证书缓存在dir/acme中, dir为空时不缓存. target是ws监听的host:port
+func newWSSProxy(cfg wssConfig, dir, target string) (*wssProxy, error) {
+	announce, e := ma.NewMultiaddr(fmt.Sprint("/dns4/", cfg.Domain, "/tcp/", cfg.Port, "/wss"))
+	if e != nil {
+		return nil, e
+	}
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(cfg.Domain),
+		Email:      cfg.Email,
+	}
+	if dir != "" {
+		manager.Cache = autocert.DirCache(filepath.Join(dir, "acme"))
+	}
+	return &wssProxy{
+		cfg:      cfg,
+		target:   target,
+		manager:  manager,
+		announce: announce,
+	}, nil
+}
+
+// start 监听wss端口, ctx取消时关闭
+func (p *wssProxy) start(ctx context.Context) error {
+	l, e := tls.Listen("tcp", fmt.Sprint(":", p.cfg.Port), p.manager.TLSConfig())
+	if e != nil {
+		return e
+	}
+	p.listener = l
+	go func() {
+		<-ctx.Done()
+		_ = l.Close()
+	}()
+	go func() {
+		for {
+			c, e := l.Accept()
+			if e != nil {
+				if ctx.Err() == nil {
+					logger.Errorw("wss监听出错", "error", e)
+				}
+				return
+			}
+			go p.forward(c)
+		}
+	}()
+	logger.Infow("wss监听端口", "port", p.cfg.Port, "domain", p.cfg.Domain)
+	return nil
+}
+
+func (p *wssProxy) forward(c net.Conn) {
+	defer c.Close()
+	// 先完成握手, ACME验证连接到这里就结束了
+	_ = c.SetDeadline(time.Now().Add(connectTimeout))
+	if e := c.(*tls.Conn).Handshake(); e != nil {
+		atomic.AddInt64(&p.failed, 1)
+		return
+	}
+	_ = c.SetDeadline(time.Time{})
+	if c.(*tls.Conn).ConnectionState().NegotiatedProtocol == "acme-tls/1" {
+		return
+	}
+	target, e := net.DialTimeout("tcp", p.target, connectTimeout)
+	if e != nil {
+		atomic.AddInt64(&p.failed, 1)
+		logger.Debugw("wss转发出错", "error", e)
+		return
+	}
+	defer target.Close()
+	atomic.AddInt64(&p.accepted, 1)
+	done := make(chan struct{}, 2)
+	go func() {
+		_, _ = io.Copy(target, c)
+		done <- struct{}{}
+	}()
+	go func() {
+		_, _ = io.Copy(c, target)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+// AddrsFactory 宣告wss地址
+func (p *wssProxy) AddrsFactory(addrs []ma.Multiaddr) []ma.Multiaddr {
+	return append(addrs, p.announce)
+}
+
+func (p *wssProxy) status() interface{} {
+	return map[string]interface{}{
+		"addr":     p.announce.String(),
+		"accepted": atomic.LoadInt64(&p.accepted),
+		"failed":   atomic.LoadInt64(&p.failed),
+	}
+}
@@ -0,0 +1,4 @@
+// Package controlpb 是引导节点gRPC控制接口的消息和服务定义, 其他Go服务可以用它控制引导节点.
+package controlpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative control.proto
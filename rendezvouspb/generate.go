@@ -0,0 +1,4 @@
+// Package rendezvouspb 是rendezvous服务使用的libp2p rendezvous协议的消息.
+package rendezvouspb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative rendezvous.proto